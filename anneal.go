@@ -0,0 +1,138 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"image"
+	"math"
+)
+
+// number of moves tried by the simulated annealing search for each block channel
+const annealSteps = 256
+
+// splitMix is a splitmix64 random number generator. It is small enough to create one for every block,
+// which keeps stochastic searches reproducible whatever order the blocks are compressed in.
+type splitMix uint64
+
+// returns a generator for block i of a search started with seed
+func newSplitMix(seed int64, i int) *splitMix {
+
+	s := splitMix(uint64(seed) ^ uint64(i)*0xd1b54a32d192ed03)
+	return &s
+}
+
+// returns the next 64 random bits
+func (s *splitMix) next() uint64 {
+
+	*s += 0x9e3779b97f4a7c15
+	z := uint64(*s)
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// returns a random value in [0,1)
+func (s *splitMix) float() float64 {
+
+	return float64(s.next()>>11) / (1 << 53)
+}
+
+// returns a random value in [0,n)
+func (s *splitMix) intn(n int) int {
+
+	return int(s.next() % uint64(n))
+}
+
+// returns the compressed bytes of block i of an image, refining them with a seeded simulated annealing
+// search of the reference values when quality is QualityAnneal. Time spent is added to timer.
+func encodeBlock(block *image.RGBA, i int, quality Quality, opts *EncodeOptions, timer *stageTimer) []byte {
+
+	var values [2][16]byte
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			c := block.RGBAAt(x, y)
+			values[0][y*4+x], values[1][y*4+x] = c.R, c.G
+		}
+	}
+	timer.mark(StageSplit)
+
+	allowed := newEndpointSet(opts.Endpoints)
+	var rng *splitMix
+	if quality >= QualityAnneal {
+		rng = newSplitMix(opts.Seed, i)
+	}
+	compressed := make([]byte, 16)
+	for ch, half := range [][]byte{compressed[:8], compressed[8:]} {
+		encodeChannel(values, ch, half, i, quality, opts, allowed, rng, timer)
+	}
+	return compressed
+}
+
+// writes the 8 byte channel half of block i encoding channel ch of values, the red and green values of
+// the block, searching the reference values as quality and opts direct. rng is only used, and must
+// only be set, for QualityAnneal.
+func encodeChannel(values [2][16]byte, ch int, half []byte, i int, quality Quality, opts *EncodeOptions, allowed endpointSet, rng *splitMix, timer *stageTimer) {
+
+	fit := modelFit(opts.ErrorModel, values, RedChannel+Channel(ch))
+	var c0, c1 byte
+	if allowed != nil {
+		c0, c1 = allowed.search(values[ch], quality, fit)
+	} else if hint := opts.mipHint(i, ch); hint != nil && quality >= QualityHigh {
+		c0, c1 = seededSearch(values[ch], fit, hint[0], hint[1])
+	} else {
+		c0, c1 = searchEndpoints(values[ch], quality, fit)
+	}
+	timer.mark(StageEndpoints)
+	assignIndices(values[ch], c0, c1, half)
+	timer.mark(StageIndices)
+	if rng != nil {
+		annealChannel(values[ch], half, rng, allowed, fit)
+		timer.mark(StageEndpoints)
+	}
+}
+
+// improves the 8 byte channel half of a block encoding values by simulated annealing over its
+// reference values, starting from the current encoding. Moves nudge one reference value at a time,
+// and worse encodings are accepted with a probability that falls as the search cools, letting it
+// escape the local minima the exhaustive inset search settles in. Encodings are measured by fit. If
+// allowed is not nil, moves land on the closest value it holds.
+func annealChannel(values [16]byte, half []byte, rng *splitMix, allowed endpointSet, fit channelFit) {
+
+	if half[0] == half[1] {
+		return
+	}
+
+	c0, c1 := int(half[0]), int(half[1])
+	_, cur := fit(values, byte(c0), byte(c1))
+	best0, best1, bestErr := c0, c1, cur
+	start := cur/16 + 1
+	for step := 0; step < annealSteps && bestErr > 0; step++ {
+		n0, n1 := c0, c1
+		delta := rng.intn(17) - 8
+		if rng.intn(2) == 0 {
+			n0 = clampInt(n0+delta, 0, 255)
+		} else {
+			n1 = clampInt(n1+delta, 0, 255)
+		}
+		if allowed != nil {
+			n0, n1 = int(allowed.nearest(n0)), int(allowed.nearest(n1))
+		}
+		if n0 == n1 {
+			continue
+		}
+
+		_, e := fit(values, byte(n0), byte(n1))
+		temp := start * (1 - float64(step)/annealSteps)
+		if e <= cur || rng.float() < math.Exp((cur-e)/temp) {
+			c0, c1, cur = n0, n1, e
+			if e < bestErr {
+				best0, best1, bestErr = n0, n1, e
+			}
+		}
+	}
+
+	indices, _ := fitChannel(values, byte(best0), byte(best1))
+	half[0], half[1] = byte(best0), byte(best1)
+	putIndices(indices, half[2:8])
+}
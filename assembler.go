@@ -0,0 +1,136 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"sync"
+)
+
+// Assembler composes compressed tiles placed in world space into images of any viewport, decoding
+// only the blocks each viewport overlaps. Where tiles overlap, the most recently added is drawn on
+// top. It is safe for concurrent use, so tiles can be streamed in while frames are rendered.
+type Assembler struct {
+	Background color.RGBA //Color of areas of a viewport no tile covers.
+
+	mu    sync.RWMutex
+	tiles []placedTile
+}
+
+// a tile and the world space rectangle it covers
+type placedTile struct {
+	rect image.Rectangle
+	tile *BC5
+}
+
+// Add places tile to cover rect in world space. A rect the size of the tile draws it one pixel per
+// world unit, any other size scales it with nearest neighbour sampling, as for a tile of a coarser
+// level of detail.
+func (a *Assembler) Add(rect image.Rectangle, tile *BC5) error {
+
+	if rect.Empty() || tile.Rect.Empty() {
+		return errors.New("tile and rectangle must not be empty")
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.tiles = append(a.tiles, placedTile{rect, tile})
+	return nil
+}
+
+// Remove removes every placement of tile, reporting whether there were any.
+func (a *Assembler) Remove(tile *BC5) bool {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	kept := a.tiles[:0]
+	for _, t := range a.tiles {
+		if t.tile != tile {
+			kept = append(kept, t)
+		}
+	}
+	removed := len(kept) < len(a.tiles)
+	for i := len(kept); i < len(a.tiles); i++ {
+		a.tiles[i] = placedTile{}
+	}
+	a.tiles = kept
+	return removed
+}
+
+// Render returns an image with bounds viewport, in world space, composed from the tiles overlapping it.
+// It also returns the areas of the viewport no tile covers, which are filled with a.Background, so
+// the caller can request the missing tiles.
+func (a *Assembler) Render(viewport image.Rectangle) (*image.RGBA, []image.Rectangle) {
+
+	dst := image.NewRGBA(viewport)
+	draw.Draw(dst, viewport, &image.Uniform{a.Background}, image.Point{}, draw.Src)
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	missing := []image.Rectangle{viewport}
+	for _, t := range a.tiles {
+		area := t.rect.Intersect(viewport)
+		if area.Empty() {
+			continue
+		}
+		t.draw(dst, area)
+		missing = subtractRect(missing, t.rect)
+	}
+	return dst, missing
+}
+
+// draws the part of t within area, in world space, into dst
+func (t placedTile) draw(dst *image.RGBA, area image.Rectangle) {
+
+	size := t.tile.Rect.Size()
+	if t.rect.Size() == size {
+		local := area.Sub(t.rect.Min)
+		decoded := t.tile.decompressRect(local)
+		draw.Draw(dst, area, decoded, local.Min, draw.Src)
+		return
+	}
+
+	//Decode only the source pixels the scaled area samples from
+	src := image.Rect(
+		(area.Min.X-t.rect.Min.X)*size.X/t.rect.Dx(),
+		(area.Min.Y-t.rect.Min.Y)*size.Y/t.rect.Dy(),
+		(area.Max.X-1-t.rect.Min.X)*size.X/t.rect.Dx()+1,
+		(area.Max.Y-1-t.rect.Min.Y)*size.Y/t.rect.Dy()+1,
+	)
+	decoded := t.tile.decompressRect(src)
+	for y := area.Min.Y; y < area.Max.Y; y++ {
+		sy := (y - t.rect.Min.Y) * size.Y / t.rect.Dy()
+		for x := area.Min.X; x < area.Max.X; x++ {
+			sx := (x - t.rect.Min.X) * size.X / t.rect.Dx()
+			dst.SetRGBA(x, y, decoded.RGBAAt(sx, sy))
+		}
+	}
+}
+
+// returns the parts of rects outside r
+func subtractRect(rects []image.Rectangle, r image.Rectangle) []image.Rectangle {
+
+	var out []image.Rectangle
+	for _, m := range rects {
+		overlap := m.Intersect(r)
+		if overlap.Empty() {
+			out = append(out, m)
+			continue
+		}
+		//Bands above and below the overlap span the full width, those beside it only its height
+		for _, part := range []image.Rectangle{
+			image.Rect(m.Min.X, m.Min.Y, m.Max.X, overlap.Min.Y),
+			image.Rect(m.Min.X, overlap.Max.Y, m.Max.X, m.Max.Y),
+			image.Rect(m.Min.X, overlap.Min.Y, overlap.Min.X, overlap.Max.Y),
+			image.Rect(overlap.Max.X, overlap.Min.Y, m.Max.X, overlap.Max.Y),
+		} {
+			if !part.Empty() {
+				out = append(out, part)
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,87 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"context"
+	"image"
+	"runtime"
+)
+
+// slots limiting the number of asynchronous encodes running at once, so that queuing many does not
+// oversubscribe the CPUs
+var encodeSlots = make(chan struct{}, runtime.GOMAXPROCS(0))
+
+// EncodeHandle tracks a compression started by EncodeAsync.
+type EncodeHandle struct {
+	done   chan struct{}
+	cancel context.CancelFunc
+	result *BC5
+	err    error
+}
+
+// EncodeAsync compresses rgba with opts in the background and returns a handle to the result, so that
+// callers such as editor UIs can stay responsive without managing goroutines themselves. At most
+// GOMAXPROCS encodes run at once, later ones waiting for a slot. The encode stops early with the
+// context's error if ctx is done or the handle is cancelled; it checks between rows of blocks.
+func EncodeAsync(ctx context.Context, rgba *image.RGBA, opts *EncodeOptions) *EncodeHandle {
+
+	ctx, cancel := context.WithCancel(ctx)
+	h := &EncodeHandle{done: make(chan struct{}), cancel: cancel}
+
+	var o EncodeOptions
+	if opts != nil {
+		o = *opts
+	}
+	o.ctx = ctx
+
+	go func() {
+
+		defer close(h.done)
+		defer cancel()
+		select {
+		case encodeSlots <- struct{}{}:
+		case <-ctx.Done():
+			h.err = ctx.Err()
+			return
+		}
+		defer func() { <-encodeSlots }()
+
+		b := new(BC5)
+		if h.err = b.SetFromRGBAWithOptions(rgba, &o); h.err == nil {
+			h.result = b
+		}
+	}()
+	return h
+}
+
+// Done returns a channel that is closed once the encode has finished, failed or been cancelled.
+func (h *EncodeHandle) Done() <-chan struct{} {
+
+	return h.done
+}
+
+// Err returns the error the encode failed with, or nil if it succeeded or has not finished.
+func (h *EncodeHandle) Err() error {
+
+	select {
+	case <-h.done:
+		return h.err
+	default:
+		return nil
+	}
+}
+
+// Result waits for the encode to finish and returns the compressed image, or the error it failed with.
+func (h *EncodeHandle) Result() (*BC5, error) {
+
+	<-h.done
+	return h.result, h.err
+}
+
+// Cancel stops the encode if it has not finished. Result then returns context.Canceled.
+func (h *EncodeHandle) Cancel() {
+
+	h.cancel()
+}
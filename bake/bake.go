@@ -0,0 +1,414 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+// Package bake converts source images into BC5 files as described by a manifest, skipping
+// outputs whose sources and settings have not changed since they were last baked.
+//
+// A manifest is a JSON or YAML document of the form:
+//
+//	{
+//		"state": ".bake-state.json",
+//		"entries": [
+//			{"source": "src/rock_n.png", "output": "out/rock_n.bc5", "options": {"profile": "normal", "convention": "opengl"}},
+//			{"source": "src/rock_orm.png", "output": "out/rock_rm.ktx2", "options": {"swizzle": "gb", "mips": true}}
+//		]
+//	}
+//
+// or, in YAML:
+//
+//	state: .bake-state.json
+//	entries:
+//	  - source: src/rock_n.png
+//	    output: out/rock_n.bc5
+//	    options: {profile: normal, convention: opengl}
+//	  - source: src/rock_orm.png
+//	    output: out/rock_rm.ktx2
+//	    options: {swizzle: gb, mips: true}
+//
+// A manifest starting with '{' is read as JSON and any other as YAML, whatever its file extension.
+// YAML is read without a dependency outside the standard library, so only the block and single line
+// flow styles shown above are supported, along with comments and quoted strings. Anchors, tags and
+// multi-line strings are reported as errors.
+//
+// Outputs ending in ".ktx2" are written as KTX2 files, which can hold a mip chain, and any others as
+// BC5 files. Build systems can load a manifest with Load or Parse and execute it with Plan.Run.
+package bake
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	bc5 "github.com/leylandski/go-bc5"
+	"github.com/leylandski/go-bc5/internal/yaml"
+)
+
+// Plan maps source images to BC5 outputs. Relative paths are resolved against Dir.
+type Plan struct {
+	Dir     string  `json:"-"`
+	State   string  `json:"state,omitempty"` //File recording the content hashes of baked outputs, defaults to ".bake-state.json".
+	Entries []Entry `json:"entries"`
+}
+
+// Entry describes a single texture to bake.
+type Entry struct {
+	Source  string  `json:"source"`
+	Output  string  `json:"output"`
+	Options Options `json:"options"`
+}
+
+// Options holds the per-entry encode settings.
+type Options struct {
+	Profile      string `json:"profile,omitempty"`      //Name of a bc5.Profiles entry to encode with.
+	Quality      string `json:"quality,omitempty"`      //Compression quality, "fast", "normal", "high" or "anneal".
+	Convention   string `json:"convention,omitempty"`   //Green channel convention of the source, "opengl" or "directx".
+	InvertGreen  bool   `json:"invertGreen,omitempty"`  //Flip the green channel convention after compression.
+	Checksum     bool   `json:"checksum,omitempty"`     //Store a checksum of the block data in the output.
+	ChannelStats bool   `json:"channelStats,omitempty"` //Stretch red and green to their full range, recording their statistics so it can be reversed.
+	Swizzle      string `json:"swizzle,omitempty"`      //Source channels stored in red and green, two of "r", "g", "b" and "a" such as "ga", defaults to "rg".
+	Mips         bool   `json:"mips,omitempty"`         //Store a mip chain from bc5.NewMipChain, which needs a ".ktx2" output.
+}
+
+// Result lists the outputs handled by a run of a Plan.
+type Result struct {
+	Baked   []string //Outputs that were converted.
+	Skipped []string //Outputs that were up to date.
+}
+
+// Load reads a JSON or YAML manifest from path. Relative paths in the manifest are resolved against the
+// directory containing it.
+func Load(path string) (*Plan, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Parse(f, filepath.Dir(path))
+}
+
+// Parse reads a JSON or YAML manifest from r. Relative paths in the manifest are resolved against dir.
+func Parse(r io.Reader, dir string) (*Plan, error) {
+
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	b, err = yaml.ToJSON(b)
+	if err != nil {
+		return nil, err
+	}
+
+	m := new(Plan)
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(m); err != nil {
+		return nil, err
+	}
+	for i, e := range m.Entries {
+		if e.Source == "" || e.Output == "" {
+			return nil, fmt.Errorf("entry %d: source and output are required", i)
+		}
+	}
+	m.Dir = dir
+	return m, nil
+}
+
+// Run converts every entry whose source or options changed since the last run, or whose output is
+// missing. It stops between entries if ctx is done, saving the progress made so far and returning
+// the context's error along with the partial result. Each baked entry is also appended to a journal
+// next to the state file as soon as it is written, so if the process is killed before the state is
+// saved the next run resumes where it left off rather than baking everything again.
+func (m *Plan) Run(ctx context.Context) (*Result, error) {
+
+	state := make(map[string]string)
+	if b, err := ioutil.ReadFile(m.path(m.statePath())); err == nil {
+		if err := json.Unmarshal(b, &state); err != nil {
+			return nil, err
+		}
+	}
+	if err := m.replayJournal(state); err != nil {
+		return nil, err
+	}
+	journal, err := os.OpenFile(m.path(m.journalPath()), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer journal.Close()
+
+	result := &Result{}
+	var runErr error
+	for _, e := range m.Entries {
+		if runErr = ctx.Err(); runErr != nil {
+			break
+		}
+
+		hash, err := m.hash(e)
+		if err != nil {
+			runErr = err
+			break
+		}
+		if _, err := os.Stat(m.path(e.Output)); err == nil && state[e.Output] == hash {
+			result.Skipped = append(result.Skipped, e.Output)
+			continue
+		}
+
+		if err := m.bakeEntry(e); err != nil {
+			runErr = fmt.Errorf("%s: %v", e.Output, err)
+			break
+		}
+		state[e.Output] = hash
+		result.Baked = append(result.Baked, e.Output)
+		if err := writeJournal(journal, e.Output, hash); err != nil {
+			runErr = err
+			break
+		}
+	}
+
+	b, err := json.MarshalIndent(state, "", "\t")
+	if err != nil {
+		return result, err
+	}
+	if err := ioutil.WriteFile(m.path(m.statePath()), b, 0644); err != nil {
+		return result, err
+	}
+	journal.Close()
+	if err := os.Remove(m.path(m.journalPath())); err != nil && !os.IsNotExist(err) {
+		return result, err
+	}
+	return result, runErr
+}
+
+// a line of the journal, recording that an output was baked from sources with the given hash
+type journalEntry struct {
+	Output string `json:"output"`
+	Hash   string `json:"hash"`
+}
+
+// adds the entries of the journal left by an interrupted run to state. A line cut short by a crash
+// is ignored, so its output is baked again.
+func (m *Plan) replayJournal(state map[string]string) error {
+
+	f, err := os.Open(m.path(m.journalPath()))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil || e.Output == "" {
+			continue
+		}
+		state[e.Output] = e.Hash
+	}
+	return scanner.Err()
+}
+
+// appends a line recording output to the journal and flushes it to disk
+func writeJournal(f *os.File, output, hash string) error {
+
+	line, err := json.Marshal(journalEntry{output, hash})
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// Watch runs the plan every interval until ctx is done, passing the results of each run to report,
+// which may be nil.
+func (m *Plan) Watch(ctx context.Context, interval time.Duration, report func(*Result, error)) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		result, err := m.Run(ctx)
+		if report != nil {
+			report(result, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// compresses the source of e and writes it to the output of e
+func (m *Plan) bakeEntry(e Entry) error {
+
+	f, err := os.Open(m.path(e.Source))
+	if err != nil {
+		return err
+	}
+	src, _, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	rgba := image.NewRGBA(image.Rect(0, 0, src.Bounds().Dx(), src.Bounds().Dy()))
+	draw.Draw(rgba, rgba.Rect, src, src.Bounds().Min, draw.Src)
+	if err := swizzle(rgba, e.Options.Swizzle); err != nil {
+		return err
+	}
+	opts := &bc5.EncodeOptions{Profile: e.Options.Profile, ChannelStats: e.Options.ChannelStats}
+	switch e.Options.Quality {
+	case "", "fast":
+	case "normal":
+		opts.Quality = bc5.QualityNormal
+	case "high":
+		opts.Quality = bc5.QualityHigh
+	case "anneal":
+		opts.Quality = bc5.QualityAnneal
+	default:
+		return fmt.Errorf("unknown quality %q", e.Options.Quality)
+	}
+	ktx2 := strings.EqualFold(filepath.Ext(e.Output), ".ktx2")
+	if e.Options.Mips && !ktx2 {
+		return errors.New("mips need a .ktx2 output, as a .bc5 file holds a single level")
+	}
+
+	var levels []*bc5.BC5
+	if e.Options.Mips {
+		if levels, err = bc5.NewMipChain(rgba, opts); err != nil {
+			return err
+		}
+	} else {
+		img := new(bc5.BC5)
+		if err := img.SetFromRGBAWithOptions(rgba, opts); err != nil {
+			return err
+		}
+		levels = []*bc5.BC5{img}
+	}
+
+	for _, img := range levels {
+		switch e.Options.Convention {
+		case bc5.OpenGL.String():
+			img.SetConvention(bc5.OpenGL)
+		case bc5.DirectX.String():
+			img.SetConvention(bc5.DirectX)
+		}
+		if e.Options.InvertGreen {
+			img.InvertGreen()
+		}
+		if e.Options.Checksum {
+			img.SetChecksum()
+		}
+	}
+
+	out := m.path(e.Output)
+	if err := os.MkdirAll(filepath.Dir(out), 0755); err != nil {
+		return err
+	}
+	w, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	if ktx2 {
+		err = writeKTX2(w, levels)
+	} else {
+		err = bc5.Encode(levels[0], w)
+	}
+	if err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// writes levels, largest first, to w as a KTX2 file
+func writeKTX2(w io.Writer, levels []*bc5.BC5) error {
+
+	size := levels[0].Rect.Size()
+	k, err := bc5.NewKTX2Builder(size.X, size.Y, bc5.KTX2Options{Levels: len(levels)})
+	if err != nil {
+		return err
+	}
+	if err := k.SetMipChain(0, 0, levels); err != nil {
+		return err
+	}
+	return k.Write(w)
+}
+
+// moves the source channels named by the two letters of channels into red and green of rgba, leaving
+// it unchanged if channels is empty or "rg"
+func swizzle(rgba *image.RGBA, channels string) error {
+
+	if channels == "" || channels == "rg" {
+		return nil
+	}
+	if len(channels) != 2 || !strings.Contains("rgba", channels[:1]) || !strings.Contains("rgba", channels[1:]) {
+		return fmt.Errorf("invalid swizzle %q", channels)
+	}
+	r, g := strings.Index("rgba", channels[:1]), strings.Index("rgba", channels[1:])
+	for i := 0; i < len(rgba.Pix); i += 4 {
+		rgba.Pix[i], rgba.Pix[i+1] = rgba.Pix[i+r], rgba.Pix[i+g]
+	}
+	return nil
+}
+
+// returns the content hash of the source and options of e
+func (m *Plan) hash(e Entry) (string, error) {
+
+	src, err := ioutil.ReadFile(m.path(e.Source))
+	if err != nil {
+		return "", err
+	}
+	opts, err := json.Marshal(e.Options)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	h.Write(src)
+	h.Write(opts)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// returns the state file path
+func (m *Plan) statePath() string {
+
+	if m.State != "" {
+		return m.State
+	}
+	return ".bake-state.json"
+}
+
+// returns the journal path, next to the state file
+func (m *Plan) journalPath() string {
+
+	return m.statePath() + ".journal"
+}
+
+// resolves p against the manifest directory
+func (m *Plan) path(p string) string {
+
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(m.Dir, p)
+}
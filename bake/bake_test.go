@@ -0,0 +1,84 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bake
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+
+	want := &Plan{
+		Dir:   "assets",
+		State: ".bake-state.json",
+		Entries: []Entry{
+			{Source: "src/rock_n.png", Output: "out/rock_n.bc5", Options: Options{Profile: "normal", Convention: "opengl"}},
+			{Source: "src/rock_orm.png", Output: "out/rock_rm.ktx2", Options: Options{Swizzle: "gb", Mips: true}},
+		},
+	}
+	tests := []struct {
+		name, manifest string
+	}{
+		{"json", `{
+	"state": ".bake-state.json",
+	"entries": [
+		{"source": "src/rock_n.png", "output": "out/rock_n.bc5", "options": {"profile": "normal", "convention": "opengl"}},
+		{"source": "src/rock_orm.png", "output": "out/rock_rm.ktx2", "options": {"swizzle": "gb", "mips": true}}
+	]
+}`},
+		{"yaml", `state: .bake-state.json
+entries:
+  - source: src/rock_n.png
+    output: out/rock_n.bc5
+    options: {profile: normal, convention: opengl}
+  - source: src/rock_orm.png
+    output: out/rock_rm.ktx2
+    options: {swizzle: gb, mips: true}
+`},
+		{"yaml block options", `# normal maps
+state: ".bake-state.json"
+entries:
+- source: src/rock_n.png
+  output: out/rock_n.bc5
+  options:
+    profile: normal
+    convention: opengl
+- source: src/rock_orm.png
+  output: out/rock_rm.ktx2
+  options:
+    swizzle: gb
+    mips: true
+`},
+	}
+	for _, test := range tests {
+		got, err := Parse(strings.NewReader(test.manifest), "assets")
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+
+	tests := []struct {
+		name, manifest, err string
+	}{
+		{"unknown json field", `{"entries": [], "extra": 1}`, "unknown field"},
+		{"unknown yaml field", "entries: []\nextra: 1", "unknown field"},
+		{"missing output", "entries:\n  - source: a.png", "entry 0: source and output are required"},
+		{"invalid yaml", "entries:\n\t- source: a.png", "yaml: line 2"},
+	}
+	for _, test := range tests {
+		_, err := Parse(strings.NewReader(test.manifest), "")
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: got error %v, want one containing %q", test.name, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bake
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+)
+
+// Shard returns the part of m that worker index of count should bake, so that several machines can
+// split one manifest without baking any entry twice. Entries are assigned by a hash of their output
+// path, so every worker computes the same split without coordinating, and adding or removing entries
+// does not move the others between shards. Unless m sets its own state file, each shard records its
+// state in a separate file, so shards sharing a directory do not overwrite each other's progress.
+// Moving data between the machines and merging their results with Merge is left to the caller.
+func (m *Plan) Shard(index, count int) (*Plan, error) {
+
+	if count <= 0 {
+		return nil, errors.New("shard count must be positive")
+	}
+	if index < 0 || index >= count {
+		return nil, fmt.Errorf("shard index %d out of range for %d shards", index, count)
+	}
+
+	shard := &Plan{Dir: m.Dir, State: m.State}
+	if shard.State == "" {
+		shard.State = fmt.Sprintf(".bake-state.%d-of-%d.json", index, count)
+	}
+	for _, e := range m.Entries {
+		if ShardOf(e.Output, count) == index {
+			shard.Entries = append(shard.Entries, e)
+		}
+	}
+	return shard, nil
+}
+
+// ShardOf returns the shard of count that the entry with the given output path belongs to.
+func ShardOf(output string, count int) int {
+
+	h := fnv.New64a()
+	h.Write([]byte(output))
+	return int(h.Sum64() % uint64(count))
+}
+
+// Merge combines the results of the shards of a plan into one, with outputs sorted by path. An error
+// is returned if any output appears in more than one result, which means the shards overlapped.
+func Merge(results ...*Result) (*Result, error) {
+
+	merged := &Result{}
+	seen := make(map[string]bool)
+	var duplicates []string
+	add := func(list []string, outputs []string) []string {
+		for _, o := range outputs {
+			if seen[o] {
+				duplicates = append(duplicates, o)
+				continue
+			}
+			seen[o] = true
+			list = append(list, o)
+		}
+		return list
+	}
+	for _, r := range results {
+		if r == nil {
+			continue
+		}
+		merged.Baked = add(merged.Baked, r.Baked)
+		merged.Skipped = add(merged.Skipped, r.Skipped)
+	}
+	sort.Strings(merged.Baked)
+	sort.Strings(merged.Skipped)
+
+	if len(duplicates) > 0 {
+		return merged, fmt.Errorf("%d outputs handled by more than one shard:\n%s", len(duplicates), strings.Join(duplicates, "\n"))
+	}
+	return merged, nil
+}
@@ -0,0 +1,83 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bake
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestShard(t *testing.T) {
+
+	m := &Plan{Dir: "assets"}
+	for i := 0; i < 50; i++ {
+		m.Entries = append(m.Entries, Entry{Source: fmt.Sprintf("src/%d.png", i), Output: fmt.Sprintf("out/%d.bc5", i)})
+	}
+
+	for _, count := range []int{1, 2, 3, 7} {
+		seen := make(map[string]int)
+		for index := 0; index < count; index++ {
+			shard, err := m.Shard(index, count)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := fmt.Sprintf(".bake-state.%d-of-%d.json", index, count); shard.State != want || shard.Dir != m.Dir {
+				t.Errorf("%d of %d: state %q in %q, want %q in %q", index, count, shard.State, shard.Dir, want, m.Dir)
+			}
+			for _, e := range shard.Entries {
+				if ShardOf(e.Output, count) != index {
+					t.Errorf("%d of %d: holds %s of shard %d", index, count, e.Output, ShardOf(e.Output, count))
+				}
+				seen[e.Output]++
+			}
+		}
+		for _, e := range m.Entries {
+			if seen[e.Output] != 1 {
+				t.Errorf("%d shards: %s is in %d shards", count, e.Output, seen[e.Output])
+			}
+		}
+	}
+
+	m.State = "shared.json"
+	if shard, _ := m.Shard(0, 2); shard.State != "shared.json" {
+		t.Errorf("state %q, want the plan's own", shard.State)
+	}
+	for _, c := range []struct{ index, count int }{{0, 0}, {-1, 2}, {2, 2}} {
+		if _, err := m.Shard(c.index, c.count); err == nil {
+			t.Errorf("Shard(%d, %d) gave no error", c.index, c.count)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		results []*Result
+		want    *Result
+		err     string
+	}{
+		{"empty", nil, &Result{}, ""},
+		{"disjoint", []*Result{
+			{Baked: []string{"c", "a"}, Skipped: []string{"e"}},
+			nil,
+			{Baked: []string{"b"}, Skipped: []string{"f", "d"}},
+		}, &Result{Baked: []string{"a", "b", "c"}, Skipped: []string{"d", "e", "f"}}, ""},
+		{"overlap", []*Result{
+			{Baked: []string{"a"}},
+			{Skipped: []string{"a", "b"}},
+		}, &Result{Baked: []string{"a"}, Skipped: []string{"b"}}, "1 outputs handled by more than one shard:\na"},
+	}
+	for _, test := range tests {
+		got, err := Merge(test.results...)
+		if test.err == "" && err != nil || test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: error %v, want %q", test.name, err, test.err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
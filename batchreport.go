@@ -0,0 +1,156 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+)
+
+// BatchReport aggregates the EncodeReports of a batch of textures, for tracking memory budgets and
+// finding the textures most in need of attention.
+type BatchReport struct {
+	Reports      []*EncodeReport           `json:"reports"`
+	TotalBytes   int                       `json:"totalBytes"`
+	Categories   map[string]*CategoryTotal `json:"categories,omitempty"`
+	WorstByError []string                  `json:"worstByError,omitempty"` //Names of the textures with the lowest PSNR, worst first.
+	WorstBySize  []string                  `json:"worstBySize,omitempty"`  //Names of the largest textures, largest first.
+	Previous     *BatchTrend               `json:"previous,omitempty"`     //Changes since an earlier report, set by Compare.
+}
+
+// CategoryTotal holds the totals of one category of a BatchReport.
+type CategoryTotal struct {
+	Count      int  `json:"count"`
+	Bytes      int  `json:"bytes"`
+	Budget     int  `json:"budget,omitempty"` //Bytes the category may use, zero if unlimited.
+	OverBudget bool `json:"overBudget,omitempty"`
+}
+
+// BatchTrend holds the differences between a BatchReport and an earlier one.
+type BatchTrend struct {
+	TotalBytes int             `json:"totalBytes"` //Change in total compressed bytes.
+	Changes    []TextureChange `json:"changes,omitempty"`
+}
+
+// TextureChange holds the differences in a single texture between two BatchReports. Textures whose
+// size and PSNR are unchanged are not listed.
+type TextureChange struct {
+	Name    string  `json:"name"`
+	Added   bool    `json:"added,omitempty"`
+	Removed bool    `json:"removed,omitempty"`
+	Bytes   int     `json:"bytes"` //Change in compressed bytes.
+	PSNR    float64 `json:"psnr"`  //Change in PSNR, positive if quality improved.
+}
+
+// NewBatchReport totals reports, checks each category against its entry in budgets, which may be nil,
+// and lists the worst textures by error and by size, up to worst of each.
+func NewBatchReport(reports []*EncodeReport, budgets map[string]int, worst int) *BatchReport {
+
+	batch := &BatchReport{Reports: reports}
+	for _, r := range reports {
+		batch.TotalBytes += r.CompressedBytes
+		if r.Category == "" && budgets[r.Category] == 0 {
+			continue
+		}
+		if batch.Categories == nil {
+			batch.Categories = make(map[string]*CategoryTotal)
+		}
+		c, ok := batch.Categories[r.Category]
+		if !ok {
+			c = &CategoryTotal{Budget: budgets[r.Category]}
+			batch.Categories[r.Category] = c
+		}
+		c.Count++
+		c.Bytes += r.CompressedBytes
+	}
+	for _, c := range batch.Categories {
+		c.OverBudget = c.Budget > 0 && c.Bytes > c.Budget
+	}
+
+	byError := append([]*EncodeReport(nil), reports...)
+	sort.SliceStable(byError, func(i, j int) bool {
+		return reportPSNR(byError[i]) < reportPSNR(byError[j])
+	})
+	bySize := append([]*EncodeReport(nil), reports...)
+	sort.SliceStable(bySize, func(i, j int) bool {
+		return bySize[i].CompressedBytes > bySize[j].CompressedBytes
+	})
+	for i := 0; i < worst && i < len(reports); i++ {
+		batch.WorstByError = append(batch.WorstByError, byError[i].Name)
+		batch.WorstBySize = append(batch.WorstBySize, bySize[i].Name)
+	}
+	return batch
+}
+
+// ReadBatchReport reads a BatchReport written by WriteJSON.
+func ReadBatchReport(r io.Reader) (*BatchReport, error) {
+
+	batch := new(BatchReport)
+	if err := json.NewDecoder(r).Decode(batch); err != nil {
+		return nil, err
+	}
+	return batch, nil
+}
+
+// Compare records the changes in b since previous, matching textures by name.
+func (b *BatchReport) Compare(previous *BatchReport) {
+
+	trend := &BatchTrend{TotalBytes: b.TotalBytes - previous.TotalBytes}
+	old := make(map[string]*EncodeReport, len(previous.Reports))
+	for _, r := range previous.Reports {
+		old[r.Name] = r
+	}
+
+	for _, r := range b.Reports {
+		p, ok := old[r.Name]
+		if !ok {
+			trend.Changes = append(trend.Changes, TextureChange{Name: r.Name, Added: true, Bytes: r.CompressedBytes})
+			continue
+		}
+		delete(old, r.Name)
+		change := TextureChange{Name: r.Name, Bytes: r.CompressedBytes - p.CompressedBytes}
+		if r.PSNR != p.PSNR && r.PSNR != 0 && p.PSNR != 0 {
+			change.PSNR = r.PSNR - p.PSNR
+		}
+		if change.Bytes != 0 || change.PSNR != 0 {
+			trend.Changes = append(trend.Changes, change)
+		}
+	}
+	for _, r := range previous.Reports {
+		if _, ok := old[r.Name]; ok {
+			trend.Changes = append(trend.Changes, TextureChange{Name: r.Name, Removed: true, Bytes: -r.CompressedBytes})
+		}
+	}
+	b.Previous = trend
+}
+
+// WriteJSON writes b to w as indented JSON.
+func (b *BatchReport) WriteJSON(w io.Writer) error {
+
+	out, err := json.MarshalIndent(b, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+// WriteHTML writes b to w as a single HTML page, with a summary followed by the report of each texture.
+func (b *BatchReport) WriteHTML(w io.Writer) error {
+
+	return reportTemplate.Execute(w, struct {
+		Batch   *BatchReport
+		Reports []*EncodeReport
+	}{b, b.Reports})
+}
+
+// returns the PSNR of r for ranking, treating lossless as the best possible
+func reportPSNR(r *EncodeReport) float64 {
+
+	if r.PSNR == 0 {
+		return 1e9
+	}
+	return r.PSNR
+}
@@ -0,0 +1,416 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+	"math"
+)
+
+// VulkanFormatBC1 is the Vulkan format of BC1 data, VK_FORMAT_BC1_RGBA_UNORM_BLOCK, as blocks may use
+// the transparent palette entry.
+const VulkanFormatBC1 = 133
+
+// BC1 holds color data compressed in the BC1 format, also known as DXT1, such as albedo textures to go
+// alongside BC5 normal maps. Each 4x4 block takes 8 bytes: two little endian RGB565 reference colors
+// followed by a 2-bit palette index for each pixel, from the first pixel in the lowest bits. If the
+// first reference color is not greater than the second, the palette has three colors and the last
+// index is transparent black.
+type BC1 struct {
+	Rect image.Rectangle
+	Data []byte
+}
+
+// NewBC1FromRGBA returns a BC1 containing the compressed form of rgba.
+func NewBC1FromRGBA(rgba *image.RGBA) (*BC1, error) {
+
+	img := new(BC1)
+	if err := img.SetFromRGBAWithOptions(rgba, nil); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// SetFromRGBA encodes rgba into this BC1 image using the default options.
+func (b *BC1) SetFromRGBA(rgba *image.RGBA) error {
+
+	return b.SetFromRGBAWithOptions(rgba, nil)
+}
+
+// SetFromRGBAWithOptions encodes rgba into this BC1 image using the settings in opts, which may be nil
+// to use the defaults. The width and height of rgba must be multiples of 4. Blocks with any pixel whose
+// alpha is below 128 use the three color palette, with those pixels transparent. Quality, Profile and
+// OnStage are used as they are for BC5, the other options have no effect.
+func (b *BC1) SetFromRGBAWithOptions(rgba *image.RGBA, opts *EncodeOptions) error {
+
+	if opts == nil {
+		opts = &EncodeOptions{}
+	}
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	size := rgba.Rect.Size()
+	if size.X%4 != 0 || size.Y%4 != 0 {
+		return errors.New("width and height must be multiples of 4")
+	}
+	quality, _, _ := opts.resolve()
+
+	timer := newStageTimer(opts.OnStage)
+	block := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	blocksPerRow := size.X / 4
+	data := make([]byte, blocksPerRow*(size.Y/4)*8)
+	for i := 0; i < len(data)/8; i++ {
+		loadBlock(block, rgba, rgba.Rect.Min.X+i%blocksPerRow*4, rgba.Rect.Min.Y+i/blocksPerRow*4)
+		timer.mark(StageSplit)
+		compressBC1Block(block, data[i*8:i*8+8], quality, true, timer)
+	}
+	timer.report()
+
+	b.Rect = rgba.Rect
+	b.Data = data
+	return nil
+}
+
+// Decompress returns an RGBA image containing the decompressed contents of b. Its bounds are b.Rect.
+func (b BC1) Decompress() *image.RGBA {
+
+	rgba := image.NewRGBA(b.Rect)
+	blocksPerRow := b.Rect.Size().X / 4
+	for i := 0; i < len(b.Data)/8; i++ {
+		block := b.Data[i*8 : i*8+8]
+		c0, c1 := binary.LittleEndian.Uint16(block), binary.LittleEndian.Uint16(block[2:])
+		pal := bc1Palette(c0, c1, c0 > c1)
+		bits := binary.LittleEndian.Uint32(block[4:])
+		x, y := b.Rect.Min.X+i%blocksPerRow*4, b.Rect.Min.Y+i/blocksPerRow*4
+		for p := 0; p < 16; p++ {
+			rgba.SetRGBA(x+p%4, y+p/4, pal[(bits>>uint(2*p))&3])
+		}
+	}
+	return rgba
+}
+
+// RGBAAt returns the decompressed color at (x,y), relative to the top left of b.Rect, decoding only
+// the block holding it. Coordinates outside b return transparent black.
+func (b BC1) RGBAAt(x, y int) color.RGBA {
+
+	if !image.Pt(x, y).In(image.Rect(0, 0, b.Rect.Size().X, b.Rect.Size().Y)) {
+		return color.RGBA{}
+	}
+	pos := ((y/4)*(b.Rect.Size().X/4) + x/4) * 8
+	block := b.Data[pos : pos+8]
+	c0, c1 := binary.LittleEndian.Uint16(block), binary.LittleEndian.Uint16(block[2:])
+	pal := bc1Palette(c0, c1, c0 > c1)
+	return pal[(binary.LittleEndian.Uint32(block[4:])>>uint(2*((y%4)*4+x%4)))&3]
+}
+
+// At returns the decompressed color at (x,y) as RGBAAt does, so that b can be used as an image.Image.
+func (b BC1) At(x, y int) color.Color {
+
+	return b.RGBAAt(x, y)
+}
+
+// Bounds returns the bounds At accepts, starting at the origin.
+func (b BC1) Bounds() image.Rectangle {
+
+	return image.Rectangle{Max: b.Rect.Size()}
+}
+
+// ColorModel returns color.RGBAModel.
+func (b BC1) ColorModel() color.Model {
+
+	return color.RGBAModel
+}
+
+// EncodeBC1 writes the contents of img to w, along with a 12 byte header containing the uint32
+// encoding of "BC1 " followed by two more uint32 values for width and height.
+func EncodeBC1(img *BC1, w io.Writer) error {
+
+	header := make([]byte, 12)
+	binary.BigEndian.PutUint32(header[:4], strToDword("BC1 "))
+	binary.BigEndian.PutUint32(header[4:8], uint32(img.Rect.Size().X))
+	binary.BigEndian.PutUint32(header[8:12], uint32(img.Rect.Size().Y))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(img.Data)
+	return err
+}
+
+// DecodeBC1 reads data written by EncodeBC1 from r into a new BC1. An error is returned if the header
+// is invalid or the block data does not match the size it gives.
+func DecodeBC1(r io.Reader) (*BC1, error) {
+
+	readBytes, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(readBytes) < 12 {
+		return nil, errors.New("not enough data for BC1")
+	}
+
+	buf := bytes.NewBuffer(readBytes)
+	if binary.BigEndian.Uint32(buf.Next(4)) != strToDword("BC1 ") {
+		return nil, errors.New("invalid file signature")
+	}
+	width := int(binary.BigEndian.Uint32(buf.Next(4)))
+	height := int(binary.BigEndian.Uint32(buf.Next(4)))
+	if width%4 != 0 || height%4 != 0 || width > MaxDimension || height > MaxDimension {
+		return nil, errors.New("invalid image size")
+	}
+	if buf.Len() != width/4*height/4*8 {
+		return nil, errors.New("block data does not match image size")
+	}
+	return &BC1{Rect: image.Rect(0, 0, width, height), Data: buf.Bytes()}, nil
+}
+
+// returns the palette of a BC1 block with reference colors c0 and c1, with four colors if four is set
+// or three and transparent black otherwise
+func bc1Palette(c0, c1 uint16, four bool) [4]color.RGBA {
+
+	a, b := unpack565(c0), unpack565(c1)
+	pal := [4]color.RGBA{a, b}
+	if four {
+		pal[2] = color.RGBA{uint8((2*int(a.R) + int(b.R)) / 3), uint8((2*int(a.G) + int(b.G)) / 3), uint8((2*int(a.B) + int(b.B)) / 3), 255}
+		pal[3] = color.RGBA{uint8((int(a.R) + 2*int(b.R)) / 3), uint8((int(a.G) + 2*int(b.G)) / 3), uint8((int(a.B) + 2*int(b.B)) / 3), 255}
+	} else {
+		pal[2] = color.RGBA{uint8((int(a.R) + int(b.R)) / 2), uint8((int(a.G) + int(b.G)) / 2), uint8((int(a.B) + int(b.B)) / 2), 255}
+	}
+	return pal
+}
+
+// returns the color of an RGB565 value, with each component widened to 8 bits
+func unpack565(v uint16) color.RGBA {
+
+	r, g, b := uint8(v>>11), uint8(v>>5)&63, uint8(v)&31
+	return color.RGBA{r<<3 | r>>2, g<<2 | g>>4, b<<3 | b>>2, 255}
+}
+
+// returns the RGB565 value closest to the color (r,g,b), given as floats from 0 to 255
+func pack565(r, g, b float64) uint16 {
+
+	q := func(v float64, max int) uint16 {
+		return uint16(clampInt(int(math.Floor(v*float64(max)/255+0.5)), 0, max))
+	}
+	return q(r, 31)<<11 | q(g, 63)<<5 | q(b, 31)
+}
+
+// writes the 8 compressed bytes of block into dst. The reference colors are the extremes of the
+// pixels along their principal axis, inset at QualityNormal and above, and refined by least squares at
+// QualityHigh and above. If punch is not set, every pixel is taken as opaque and only the four color
+// palette is used, as the color half of a BC3 block needs. Time spent is added to timer.
+func compressBC1Block(block *image.RGBA, dst []byte, quality Quality, punch bool, timer *stageTimer) {
+
+	var px [16][3]float64
+	var opaque [16]bool
+	transparent := false
+	for i := 0; i < 16; i++ {
+		c := block.RGBAAt(i%4, i/4)
+		px[i] = [3]float64{float64(c.R), float64(c.G), float64(c.B)}
+		opaque[i] = !punch || c.A >= 128
+		transparent = transparent || !opaque[i]
+	}
+
+	lo, hi := colorExtremes(px, opaque, 0, 255)
+	candidates := [][2][3]float64{{lo, hi}}
+	if quality >= QualityNormal {
+		var inLo, inHi [3]float64
+		for k := 0; k < 3; k++ {
+			inset := (hi[k] - lo[k]) / 16
+			inLo[k], inHi[k] = lo[k]+inset, hi[k]-inset
+		}
+		candidates = append(candidates, [2][3]float64{inLo, inHi})
+	}
+	timer.mark(StageEndpoints)
+
+	best, bestErr := make([]byte, 8), math.Inf(1)
+	try := func(c0, c1 uint16, three bool) {
+		cand := make([]byte, 8)
+		e := fitBC1(px, opaque, c0, c1, three, cand)
+		if e < bestErr {
+			best, bestErr = cand, e
+		}
+	}
+	for _, c := range candidates {
+		c0, c1 := pack565(c[1][0], c[1][1], c[1][2]), pack565(c[0][0], c[0][1], c[0][2])
+		if !transparent {
+			try(c0, c1, false)
+		}
+		if transparent || (punch && quality >= QualityHigh) {
+			try(c0, c1, true)
+		}
+	}
+	if quality >= QualityHigh && !transparent {
+		//Refine the four color palette by least squares against the indices chosen so far
+		for iter := 0; iter < 2; iter++ {
+			c0, c1, ok := refineBC1(px, best)
+			if !ok {
+				break
+			}
+			try(c0, c1, false)
+		}
+	}
+	timer.mark(StageIndices)
+	copy(dst, best)
+}
+
+// returns the extremes of the pixels of px marked in use along their principal axis, found by power
+// iteration on their covariance, with each component limited to the range min to max
+func colorExtremes(px [16][3]float64, use [16]bool, min, max float64) (lo, hi [3]float64) {
+
+	var mean [3]float64
+	n := 0.0
+	for i, p := range px {
+		if use[i] {
+			for k := range mean {
+				mean[k] += p[k]
+			}
+			n++
+		}
+	}
+	if n == 0 {
+		return
+	}
+	for k := range mean {
+		mean[k] /= n
+	}
+
+	var cov [3][3]float64
+	for i, p := range px {
+		if !use[i] {
+			continue
+		}
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				cov[j][k] += (p[j] - mean[j]) * (p[k] - mean[k])
+			}
+		}
+	}
+	axis := [3]float64{1, 1, 1}
+	for iter := 0; iter < 8; iter++ {
+		var next [3]float64
+		for j := 0; j < 3; j++ {
+			next[j] = cov[j][0]*axis[0] + cov[j][1]*axis[1] + cov[j][2]*axis[2]
+		}
+		l := math.Sqrt(next[0]*next[0] + next[1]*next[1] + next[2]*next[2])
+		if l == 0 {
+			break
+		}
+		axis = [3]float64{next[0] / l, next[1] / l, next[2] / l}
+	}
+
+	minT, maxT := math.Inf(1), math.Inf(-1)
+	for i, p := range px {
+		if !use[i] {
+			continue
+		}
+		t := (p[0]-mean[0])*axis[0] + (p[1]-mean[1])*axis[1] + (p[2]-mean[2])*axis[2]
+		minT, maxT = math.Min(minT, t), math.Max(maxT, t)
+	}
+	for k := 0; k < 3; k++ {
+		lo[k] = math.Max(min, math.Min(max, mean[k]+axis[k]*minT))
+		hi[k] = math.Max(min, math.Min(max, mean[k]+axis[k]*maxT))
+	}
+	return lo, hi
+}
+
+// writes the block encoding px with reference colors c0 and c1 into dst, choosing the closest palette
+// entry for each pixel, and returns its squared error. The colors are ordered to select the three color
+// palette if three is set, or the four color one otherwise. Pixels not opaque use the transparent
+// entry of the three color palette, and count as an infinite error in the four color one.
+func fitBC1(px [16][3]float64, opaque [16]bool, c0, c1 uint16, three bool, dst []byte) float64 {
+
+	if three == (c0 > c1) {
+		c0, c1 = c1, c0
+	}
+	pal := bc1Palette(c0, c1, !three)
+	entries := 4
+	if three || c0 == c1 {
+		//Equal colors always select the three color palette, whose first three entries are then equal
+		entries = 3
+	}
+
+	var bits uint32
+	sum := 0.0
+	for i, p := range px {
+		if !opaque[i] {
+			if !three {
+				return math.Inf(1)
+			}
+			bits |= 3 << uint(2*i)
+			continue
+		}
+		best, bestErr := 0, math.Inf(1)
+		for j := 0; j < entries; j++ {
+			dr, dg, db := p[0]-float64(pal[j].R), p[1]-float64(pal[j].G), p[2]-float64(pal[j].B)
+			if e := dr*dr + dg*dg + db*db; e < bestErr {
+				best, bestErr = j, e
+			}
+		}
+		bits |= uint32(best) << uint(2*i)
+		sum += bestErr
+	}
+
+	binary.LittleEndian.PutUint16(dst, c0)
+	binary.LittleEndian.PutUint16(dst[2:], c1)
+	binary.LittleEndian.PutUint32(dst[4:], bits)
+	return sum
+}
+
+// returns the reference colors minimizing the squared error of px for the indices of the four color
+// block enc, or false if the indices do not determine them
+func refineBC1(px [16][3]float64, enc []byte) (uint16, uint16, bool) {
+
+	if binary.LittleEndian.Uint16(enc) <= binary.LittleEndian.Uint16(enc[2:]) {
+		return 0, 0, false
+	}
+	palWeights := [4]float64{1, 0, 2.0 / 3, 1.0 / 3}
+	bits := binary.LittleEndian.Uint32(enc[4:])
+	var weights [16]float64
+	for i := range weights {
+		weights[i] = palWeights[(bits>>uint(2*i))&3]
+	}
+	a, b, ok := solveEndpoints(px, weights)
+	if !ok {
+		return 0, 0, false
+	}
+	for k := 0; k < 3; k++ {
+		a[k] = math.Max(0, math.Min(255, a[k]))
+		b[k] = math.Max(0, math.Min(255, b[k]))
+	}
+	return pack565(a[0], a[1], a[2]), pack565(b[0], b[1], b[2]), true
+}
+
+// returns the endpoints a and b minimizing the squared error of px when each pixel is w*a + (1-w)*b
+// for its weight w, or false if the weights do not determine them
+func solveEndpoints(px [16][3]float64, weights [16]float64) (a, b [3]float64, ok bool) {
+
+	//Solve the normal equations for a and b
+	var aa, ab, bb float64
+	var ap, bp [3]float64
+	for i, p := range px {
+		w := weights[i]
+		aa += w * w
+		ab += w * (1 - w)
+		bb += (1 - w) * (1 - w)
+		for k := 0; k < 3; k++ {
+			ap[k] += w * p[k]
+			bp[k] += (1 - w) * p[k]
+		}
+	}
+	det := aa*bb - ab*ab
+	if math.Abs(det) < 1e-9 {
+		return a, b, false
+	}
+	for k := 0; k < 3; k++ {
+		a[k] = (ap[k]*bb - bp[k]*ab) / det
+		b[k] = (bp[k]*aa - ap[k]*ab) / det
+	}
+	return a, b, true
+}
@@ -0,0 +1,189 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+)
+
+// VulkanFormatBC3 is the Vulkan format of BC3 data, VK_FORMAT_BC3_UNORM_BLOCK.
+const VulkanFormatBC3 = 137
+
+// BC3 holds color data with alpha compressed in the BC3 format, also known as DXT5. Each 4x4 block
+// takes 16 bytes: a BC4 block holding alpha, followed by a BC1 block holding color which always uses
+// the four color palette, whatever the order of its reference colors.
+type BC3 struct {
+	Rect image.Rectangle
+	Data []byte
+}
+
+// NewBC3FromRGBA returns a BC3 containing the compressed form of rgba.
+func NewBC3FromRGBA(rgba *image.RGBA) (*BC3, error) {
+
+	img := new(BC3)
+	if err := img.SetFromRGBAWithOptions(rgba, nil); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// SetFromRGBA encodes rgba into this BC3 image using the default options.
+func (b *BC3) SetFromRGBA(rgba *image.RGBA) error {
+
+	return b.SetFromRGBAWithOptions(rgba, nil)
+}
+
+// SetFromRGBAWithOptions encodes rgba into this BC3 image using the settings in opts, which may be nil
+// to use the defaults. The width and height of rgba must be multiples of 4. Alpha is encoded as BC4
+// encodes gray, so Quality, Profile, Seed, Endpoints, ErrorModel and OnStage are used as they are for
+// BC4, with alpha passed to ErrorModel as the red channel. Color is encoded as BC1 encodes it, without
+// transparency. The other options have no effect.
+func (b *BC3) SetFromRGBAWithOptions(rgba *image.RGBA, opts *EncodeOptions) error {
+
+	if opts == nil {
+		opts = &EncodeOptions{}
+	}
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	size := rgba.Rect.Size()
+	if size.X%4 != 0 || size.Y%4 != 0 {
+		return errors.New("width and height must be multiples of 4")
+	}
+	quality, _, _ := opts.resolve()
+
+	timer := newStageTimer(opts.OnStage)
+	allowed := newEndpointSet(opts.Endpoints)
+	block := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	blocksPerRow := size.X / 4
+	data := make([]byte, blocksPerRow*(size.Y/4)*16)
+	for i := 0; i < len(data)/16; i++ {
+		loadBlock(block, rgba, rgba.Rect.Min.X+i%blocksPerRow*4, rgba.Rect.Min.Y+i/blocksPerRow*4)
+		var values [2][16]byte
+		for p := 0; p < 16; p++ {
+			values[0][p] = block.Pix[p*4+3]
+		}
+		timer.mark(StageSplit)
+
+		var rng *splitMix
+		if quality >= QualityAnneal {
+			rng = newSplitMix(opts.Seed, i)
+		}
+		encodeChannel(values, 0, data[i*16:i*16+8], i, quality, opts, allowed, rng, timer)
+		compressBC1Block(block, data[i*16+8:i*16+16], quality, false, timer)
+	}
+	timer.report()
+
+	b.Rect = rgba.Rect
+	b.Data = data
+	return nil
+}
+
+// Decompress returns an RGBA image containing the decompressed contents of b. Its bounds are b.Rect.
+// Color is not premultiplied by alpha, so the pixels are as they were given to the encoder rather
+// than in the premultiplied form image.RGBA documents.
+func (b BC3) Decompress() *image.RGBA {
+
+	rgba := image.NewRGBA(b.Rect)
+	blocksPerRow := b.Rect.Size().X / 4
+	for i := 0; i < len(b.Data)/16; i++ {
+		pixels := bc3Pixels(b.Data[i*16 : i*16+16])
+		x, y := b.Rect.Min.X+i%blocksPerRow*4, b.Rect.Min.Y+i/blocksPerRow*4
+		for p := 0; p < 16; p++ {
+			rgba.SetRGBA(x+p%4, y+p/4, pixels[p])
+		}
+	}
+	return rgba
+}
+
+// RGBAAt returns the decompressed color at (x,y), relative to the top left of b.Rect, decoding only
+// the block holding it. Coordinates outside b return transparent black.
+func (b BC3) RGBAAt(x, y int) color.RGBA {
+
+	if !image.Pt(x, y).In(image.Rect(0, 0, b.Rect.Size().X, b.Rect.Size().Y)) {
+		return color.RGBA{}
+	}
+	pos := ((y/4)*(b.Rect.Size().X/4) + x/4) * 16
+	return bc3Pixels(b.Data[pos : pos+16])[(y%4)*4+x%4]
+}
+
+// At returns the decompressed color at (x,y) as RGBAAt does, so that b can be used as an image.Image.
+func (b BC3) At(x, y int) color.Color {
+
+	return color.NRGBA(b.RGBAAt(x, y))
+}
+
+// Bounds returns the bounds At accepts, starting at the origin.
+func (b BC3) Bounds() image.Rectangle {
+
+	return image.Rectangle{Max: b.Rect.Size()}
+}
+
+// ColorModel returns color.NRGBAModel, as color is not premultiplied by alpha.
+func (b BC3) ColorModel() color.Model {
+
+	return color.NRGBAModel
+}
+
+// EncodeBC3 writes the contents of img to w, along with a 12 byte header containing the uint32
+// encoding of "BC3 " followed by two more uint32 values for width and height.
+func EncodeBC3(img *BC3, w io.Writer) error {
+
+	header := make([]byte, 12)
+	binary.BigEndian.PutUint32(header[:4], strToDword("BC3 "))
+	binary.BigEndian.PutUint32(header[4:8], uint32(img.Rect.Size().X))
+	binary.BigEndian.PutUint32(header[8:12], uint32(img.Rect.Size().Y))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(img.Data)
+	return err
+}
+
+// DecodeBC3 reads data written by EncodeBC3 from r into a new BC3. An error is returned if the header
+// is invalid or the block data does not match the size it gives.
+func DecodeBC3(r io.Reader) (*BC3, error) {
+
+	readBytes, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(readBytes) < 12 {
+		return nil, errors.New("not enough data for BC3")
+	}
+
+	buf := bytes.NewBuffer(readBytes)
+	if binary.BigEndian.Uint32(buf.Next(4)) != strToDword("BC3 ") {
+		return nil, errors.New("invalid file signature")
+	}
+	width := int(binary.BigEndian.Uint32(buf.Next(4)))
+	height := int(binary.BigEndian.Uint32(buf.Next(4)))
+	if width%4 != 0 || height%4 != 0 || width > MaxDimension || height > MaxDimension {
+		return nil, errors.New("invalid image size")
+	}
+	if buf.Len() != width/4*height/4*16 {
+		return nil, errors.New("block data does not match image size")
+	}
+	return &BC3{Rect: image.Rect(0, 0, width, height), Data: buf.Bytes()}, nil
+}
+
+// returns the 16 pixels encoded by the 16 byte BC3 block, from the top left
+func bc3Pixels(block []byte) [16]color.RGBA {
+
+	alpha := bc4Values(block[:8])
+	pal := bc1Palette(binary.LittleEndian.Uint16(block[8:]), binary.LittleEndian.Uint16(block[10:]), true)
+	bits := binary.LittleEndian.Uint32(block[12:])
+	var pixels [16]color.RGBA
+	for p := range pixels {
+		pixels[p] = pal[(bits>>uint(2*p))&3]
+		pixels[p].A = alpha[p]
+	}
+	return pixels
+}
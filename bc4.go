@@ -0,0 +1,182 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+)
+
+// VulkanFormatBC4 is the Vulkan format of BC4 data, VK_FORMAT_BC4_UNORM_BLOCK.
+const VulkanFormatBC4 = 139
+
+// BC4 holds single channel data compressed in the BC4 format, such as heightmaps, roughness or ambient
+// occlusion. Each 4x4 block takes 8 bytes, laid out as each channel of a BC5 block is.
+type BC4 struct {
+	Rect image.Rectangle
+	Data []byte
+}
+
+// NewBC4FromGray returns a BC4 containing the compressed form of gray.
+func NewBC4FromGray(gray *image.Gray) (*BC4, error) {
+
+	img := new(BC4)
+	if err := img.SetFromGrayWithOptions(gray, nil); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// SetFromGray encodes gray into this BC4 image using the default options.
+func (b *BC4) SetFromGray(gray *image.Gray) error {
+
+	return b.SetFromGrayWithOptions(gray, nil)
+}
+
+// SetFromGrayWithOptions encodes gray into this BC4 image using the settings in opts, which may be nil
+// to use the defaults. The width and height of gray must be multiples of 4. Quality, Profile, Seed,
+// Endpoints, ErrorModel and OnStage are used as they are for BC5, with the values of gray passed to
+// ErrorModel as the red channel and green left at zero. The other options have no effect.
+func (b *BC4) SetFromGrayWithOptions(gray *image.Gray, opts *EncodeOptions) error {
+
+	if opts == nil {
+		opts = &EncodeOptions{}
+	}
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	size := gray.Rect.Size()
+	if size.X%4 != 0 || size.Y%4 != 0 {
+		return errors.New("width and height must be multiples of 4")
+	}
+	quality, _, _ := opts.resolve()
+
+	timer := newStageTimer(opts.OnStage)
+	allowed := newEndpointSet(opts.Endpoints)
+	blocksPerRow := size.X / 4
+	data := make([]byte, blocksPerRow*(size.Y/4)*8)
+	for i := 0; i < len(data)/8; i++ {
+		var values [2][16]byte
+		x, y := gray.Rect.Min.X+i%blocksPerRow*4, gray.Rect.Min.Y+i/blocksPerRow*4
+		for row := 0; row < 4; row++ {
+			pos := gray.PixOffset(x, y+row)
+			copy(values[0][row*4:row*4+4], gray.Pix[pos:pos+4])
+		}
+		timer.mark(StageSplit)
+
+		var rng *splitMix
+		if quality >= QualityAnneal {
+			rng = newSplitMix(opts.Seed, i)
+		}
+		encodeChannel(values, 0, data[i*8:i*8+8], i, quality, opts, allowed, rng, timer)
+	}
+	timer.report()
+
+	b.Rect = gray.Rect
+	b.Data = data
+	return nil
+}
+
+// Decompress returns a grayscale image containing the decompressed contents of b. Its bounds are b.Rect.
+func (b BC4) Decompress() *image.Gray {
+
+	gray := image.NewGray(b.Rect)
+	blocksPerRow := b.Rect.Size().X / 4
+	for i := 0; i < len(b.Data)/8; i++ {
+		values := bc4Values(b.Data[i*8 : i*8+8])
+		x, y := b.Rect.Min.X+i%blocksPerRow*4, b.Rect.Min.Y+i/blocksPerRow*4
+		for p := 0; p < 16; p++ {
+			gray.Pix[gray.PixOffset(x+p%4, y+p/4)] = values[p]
+		}
+	}
+	return gray
+}
+
+// GrayAt returns the decompressed value at (x,y), relative to the top left of b.Rect, decoding only
+// the block holding it. Coordinates outside b return zero.
+func (b BC4) GrayAt(x, y int) color.Gray {
+
+	if !image.Pt(x, y).In(image.Rect(0, 0, b.Rect.Size().X, b.Rect.Size().Y)) {
+		return color.Gray{}
+	}
+	pos := ((y/4)*(b.Rect.Size().X/4) + x/4) * 8
+	return color.Gray{Y: bc4Values(b.Data[pos : pos+8])[(y%4)*4+x%4]}
+}
+
+// returns the 16 values encoded by the 8 byte BC4 block half, from the top left
+func bc4Values(half []byte) [16]byte {
+
+	var values [16]byte
+	pal := generatePalette(normalize(half[0]), normalize(half[1]))
+	indices := getIndices(half[2:8])
+	for p := range values {
+		values[p] = denormalize(pal[indices[p]])
+	}
+	return values
+}
+
+// At returns the decompressed value at (x,y) as GrayAt does, so that b can be used as an image.Image.
+func (b BC4) At(x, y int) color.Color {
+
+	return b.GrayAt(x, y)
+}
+
+// Bounds returns the bounds At accepts, starting at the origin.
+func (b BC4) Bounds() image.Rectangle {
+
+	return image.Rectangle{Max: b.Rect.Size()}
+}
+
+// ColorModel returns color.GrayModel.
+func (b BC4) ColorModel() color.Model {
+
+	return color.GrayModel
+}
+
+// EncodeBC4 writes the contents of img to w, along with a 12 byte header containing the uint32
+// encoding of "BC4 " followed by two more uint32 values for width and height.
+func EncodeBC4(img *BC4, w io.Writer) error {
+
+	header := make([]byte, 12)
+	binary.BigEndian.PutUint32(header[:4], strToDword("BC4 "))
+	binary.BigEndian.PutUint32(header[4:8], uint32(img.Rect.Size().X))
+	binary.BigEndian.PutUint32(header[8:12], uint32(img.Rect.Size().Y))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(img.Data)
+	return err
+}
+
+// DecodeBC4 reads data written by EncodeBC4 from r into a new BC4. An error is returned if the header
+// is invalid or the block data does not match the size it gives.
+func DecodeBC4(r io.Reader) (*BC4, error) {
+
+	readBytes, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(readBytes) < 12 {
+		return nil, errors.New("not enough data for BC4")
+	}
+
+	buf := bytes.NewBuffer(readBytes)
+	if binary.BigEndian.Uint32(buf.Next(4)) != strToDword("BC4 ") {
+		return nil, errors.New("invalid file signature")
+	}
+	width := int(binary.BigEndian.Uint32(buf.Next(4)))
+	height := int(binary.BigEndian.Uint32(buf.Next(4)))
+	if width%4 != 0 || height%4 != 0 || width > MaxDimension || height > MaxDimension {
+		return nil, errors.New("invalid image size")
+	}
+	if buf.Len() != width/4*height/4*8 {
+		return nil, errors.New("block data does not match image size")
+	}
+	return &BC4{Rect: image.Rect(0, 0, width, height), Data: buf.Bytes()}, nil
+}
@@ -6,15 +6,17 @@ package bc5
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"image"
 	"image/color"
-	"image/draw"
 	"io"
 	"io/ioutil"
 	"math"
 	"os"
+	"time"
 )
 
 // Alias for decompression blue computation constants.
@@ -27,12 +29,160 @@ const (
 	Greyscale                     //Computes the blue component to be identical to the red component per pixel.
 )
 
+// Alias for image color channel constants.
+type Channel int
+
+const (
+	DefaultChannel Channel = iota //Leave the component where it would normally be placed.
+	RedChannel                    //The red component.
+	GreenChannel                  //The green component.
+	BlueChannel                   //The blue component.
+	AlphaChannel                  //The alpha component.
+)
+
+// Swizzle maps the red and green block channels into destination channels during decompression.
+// A channel moved elsewhere leaves a zero in its original position unless the other channel is
+// moved there. The zero value leaves the output unchanged, and destinations other than RedChannel to
+// AlphaChannel are treated as DefaultChannel.
+type Swizzle struct {
+	R Channel //Destination of the red block channel.
+	G Channel //Destination of the green block channel.
+}
+
+// Alias for constants selecting the bounds of decompressed images.
+type Origin int
+
+const (
+	ImageOrigin Origin = iota //Keep the bounds of Rect, including any offset of its Min, so tiles can be drawn straight back into a larger image.
+	ZeroOrigin                //Start at the origin, in the coordinates At uses.
+)
+
 // BC5 holds BC5-compressed red/green image data.
 // The spec can be found here: https://docs.microsoft.com/en-us/windows/win32/direct3d10/d3d10-graphics-programming-guide-resources-block-compression#bc5
 type BC5 struct {
 	Data []byte
 	Rect image.Rectangle
 	BlueMode
+	AddressMode
+	Swizzle Swizzle
+
+	//Origin selects the bounds of the images returned by Decompress and DecompressRect.
+	Origin Origin
+
+	//Metadata holds key/value pairs stored alongside the image data by Encode and Decode.
+	Metadata map[string]string
+
+	//Number of blocks per row in Data when b is a view of a larger image, zero otherwise.
+	stride int
+}
+
+// Alias for compression quality constants.
+type Quality int
+
+const (
+	QualityFast   Quality = iota //Use the lowest and highest value of each block channel as reference values.
+	QualityNormal                //Also try the eight value palette mode, keeping whichever encoding has the lower error.
+	QualityHigh                  //Also search reference values inset from the block's range.
+	QualityAnneal                //Also refine the reference values with a simulated annealing search seeded by EncodeOptions.Seed.
+)
+
+// String returns the name of q.
+func (q Quality) String() string {
+
+	switch q {
+	case QualityFast:
+		return "fast"
+	case QualityNormal:
+		return "normal"
+	case QualityHigh:
+		return "high"
+	case QualityAnneal:
+		return "anneal"
+	default:
+		return "unknown"
+	}
+}
+
+// EncodeOptions holds optional settings used when compressing RGBA data into a BC5.
+type EncodeOptions struct {
+
+	//Quality trades compression speed for accuracy. The default is QualityFast.
+	Quality Quality `json:"quality"`
+
+	//Profile names an entry of Profiles to apply. Its quality is used if Quality is QualityFast, and its
+	//blue mode, swizzle and convention are set on the compressed image.
+	Profile string `json:"profile,omitempty"`
+
+	//Previous is an earlier compression of the same texture. When set, each source block is compared
+	//against the decoded block at the same position in Previous and its compressed bytes are reused
+	//if no red or green value differs by more than Tolerance. Previous must have the same size.
+	Previous  *BC5 `json:"-"`
+	Tolerance int  `json:"tolerance,omitempty"`
+
+	//MemoryBudget limits the memory in bytes used by the compression, zero for no limit. Work is done in
+	//smaller pieces to stay within it where possible, otherwise ErrBudgetExceeded is returned.
+	MemoryBudget int64 `json:"-"`
+
+	//RDO enables rate-distortion optimization when greater than zero. Each block channel may then reuse
+	//the bytes of one of the preceding RDOWindow blocks, 64 if zero, as long as its mean squared error
+	//grows by no more than RDO. The output is the same size, but repeats itself far more often, so it
+	//shrinks further under zstd or deflate.
+	RDO       float64 `json:"rdo,omitempty"`
+	RDOWindow int     `json:"rdoWindow,omitempty"`
+
+	//Seed drives the random choices of stochastic searches such as QualityAnneal. Each block draws from
+	//its own generator derived from Seed and its position, so the same seed, source and options always
+	//give the same output.
+	Seed int64 `json:"seed,omitempty"`
+
+	//OnStage, if not nil, is called once for each Stage after compressing with the total time spent in
+	//it. Time is accumulated across blocks as the stages interleave. Whether or not it is set, each
+	//pass over the image is wrapped in a runtime/trace region named after the stage or pass, such as
+	//"bc5.split" or "bc5.compress", for use with go tool trace.
+	OnStage func(stage Stage, d time.Duration) `json:"-"`
+
+	//Endpoints, if not nil, limits the reference values chosen for each block channel to those it holds,
+	//for decoders with quirks at some values or to help palettize the output. See EndpointMultiples and
+	//EndpointsExcept. Blocks reused from Previous are kept as they are.
+	Endpoints []byte `json:"endpoints,omitempty"`
+
+	//ErrorModel, if not nil, replaces the squared error used to compare candidate encodings of each block
+	//channel in the endpoint search of every quality. See AngularError. Rate-distortion optimization and
+	//the Tolerance of Previous still measure plain differences in value. As a function it cannot be
+	//serialized, so it is not part of Hash or Record.
+	ErrorModel ErrorModel `json:"-"`
+
+	//ShareMipSearch speeds up NewMipChain at QualityHigh and above. The levels are compressed from the
+	//smallest up, and rather than trying every pair of reference values, the search for each block
+	//starts from those chosen for the block covering it in the level below and refines them, at a
+	//small cost in accuracy. Other functions ignore it.
+	ShareMipSearch bool `json:"shareMipSearch,omitempty"`
+
+	//Workers is the number of goroutines compressing blocks at once, GOMAXPROCS if zero or less. The
+	//output is the same whatever the number.
+	Workers int `json:"-"`
+
+	//FixTiling recompresses the right and bottom edge blocks so that seams between repeats of a tiling
+	//texture decode as they were in the source, at some cost to the accuracy inside those blocks. See
+	//ValidateTiling for measuring seams.
+	FixTiling bool `json:"fixTiling,omitempty"`
+
+	//PadEdges accepts images whose width or height is not a multiple of 4, extending the partial blocks
+	//on the right and bottom edges by repeating the last column and row. The size of the source is
+	//recorded in the metadata, and Decompress crops to it. See ContentSize.
+	PadEdges bool `json:"padEdges,omitempty"`
+
+	//ChannelStats stretches the red and green values of the source each to the full range of 0 to 255
+	//before compression, for the most precision, and records the minimum, maximum and mean of each
+	//beforehand in the metadata, so decoders can reverse the stretch. See BC5.ChannelStats.
+	ChannelStats bool `json:"channelStats,omitempty"`
+
+	//Record stores the options and their hash in the metadata of the compressed image, so it can be
+	//traced to the settings that produced it and compressed again identically.
+	Record bool `json:"-"`
+
+	ctx       context.Context //Checked once per row of blocks, stopping the compression when done.
+	mipParent *BC5            //The next smaller level, whose blocks seed the search when ShareMipSearch is set.
 }
 
 // Load reads BC5 encoded image data from imgfile into a BC5 and
@@ -59,75 +209,310 @@ func NewBC5FromRGBA(rgba *image.RGBA) (*BC5, error) {
 	return img, nil
 }
 
-// At performs on-the-fly decompression of b and returns the RGBA color at (x,y).
-func (b BC5) At(x, y int) color.RGBA {
+// At performs on-the-fly decompression of b and returns the color at (x,y), so that b can be used as
+// an image.Image. Coordinates outside the image are handled according to b.AddressMode.
+func (b BC5) At(x, y int) color.Color {
 
-	if x < 0 || x >= b.Rect.Size().X || y < 0 || y >= b.Rect.Size().Y {
+	return b.RGBAAt(x, y)
+}
+
+// RGBAAt performs on-the-fly decompression of b and returns the RGBA color at (x,y).
+// Coordinates outside the ContentSize of b are handled according to b.AddressMode.
+func (b BC5) RGBAAt(x, y int) color.RGBA {
+
+	x, y, ok := b.AddressMode.resolve(x, y, b.ContentSize())
+	if !ok {
 		//Out of bounds
 		return color.RGBA{}
 	}
 
-	blockIx := (int(float32(y)/4) * b.Rect.Size().Y) + int(float32(x)/4)*16
-	block := decompressBlock(b.Data[blockIx:blockIx+16], b.BlueMode)
+	blockIx := b.blockOffset(x, y)
+	block := decompressBlock(b.Data[blockIx:blockIx+16], b.BlueMode, b.Swizzle)
 	return block.RGBAAt(x%4, y%4)
 }
 
+// Bounds returns the area of b that At covers, starting at the origin as At does and cropped to the
+// ContentSize of b. Unlike Decompress, it is not offset by b.Rect.Min.
+func (b BC5) Bounds() image.Rectangle {
+
+	return image.Rectangle{Max: b.ContentSize()}
+}
+
+// ColorModel returns color.RGBAModel, the model of the colors returned by At.
+func (b BC5) ColorModel() color.Model {
+
+	return color.RGBAModel
+}
+
+// AtOK is like At but reports whether (x,y) lies within the image instead of applying b.AddressMode.
+// It returns the zero color and false for coordinates out of bounds, including those in the padding.
+func (b BC5) AtOK(x, y int) (color.RGBA, bool) {
+
+	if !image.Pt(x, y).In(b.Bounds()) {
+		return color.RGBA{}, false
+	}
+	return b.RGBAAt(x, y), true
+}
+
+// At16 performs on-the-fly decompression of b and returns the RGBA64 color at (x,y).
+// The red and green components are expanded from the interpolated palette values before
+// any truncation to bytes, so they keep the full precision of the block interpolation. Coordinates
+// outside the ContentSize of b are handled according to b.AddressMode, as for RGBAAt.
+func (b BC5) At16(x, y int) color.RGBA64 {
+
+	x, y, ok := b.AddressMode.resolve(x, y, b.ContentSize())
+	if !ok {
+		//Out of bounds
+		return color.RGBA64{}
+	}
+
+	blockIx := b.blockOffset(x, y)
+	block := b.Data[blockIx : blockIx+16]
+	pxIndex := (y%4)*4 + x%4
+
+	r := generatePalette(normalize(block[0]), normalize(block[1]))[getIndices(block[2:8])[pxIndex]]
+	g := generatePalette(normalize(block[8]), normalize(block[9]))[getIndices(block[10:])[pxIndex]]
+	px := [4]uint16{expand16(r), expand16(g), expand16(computeBlue(r, g, b.BlueMode)), 0xffff}
+	b.Swizzle.apply(&px)
+	return color.RGBA64{R: px[0], G: px[1], B: px[2], A: px[3]}
+}
+
 // Size returns the number of bytes of pixel data b holds
 func (b BC5) Size() int32 {
 
 	return int32(b.Rect.Size().X) * int32(b.Rect.Size().Y)
 }
 
-// SetFromRGBA encodes RGBA data into this BC5 image.
+// SetFromRGBA encodes RGBA data into this BC5 image. Its width and height must be multiples of 4, but
+// need not be equal.
 // As this is a red/green compression scheme, the blue and alpha components of the source are discarded.
 func (b *BC5) SetFromRGBA(rgba *image.RGBA) error {
 
-	if rgba.Rect.Size().X != rgba.Rect.Size().Y {
-		return errors.New("image must be square")
+	return b.SetFromRGBAWithOptions(rgba, nil)
+}
+
+// SetFromRGBAWithOptions encodes RGBA data into this BC5 image using the settings in opts,
+// which may be nil to use the defaults. The options are checked with ValidateFor first.
+func (b *BC5) SetFromRGBAWithOptions(rgba *image.RGBA, opts *EncodeOptions) error {
+
+	if opts == nil {
+		opts = &EncodeOptions{}
 	}
 
-	if rgba.Rect.Size().X%4 != 0 {
-		return errors.New("size must be a multiple of 4")
+	if err := opts.ValidateFor(rgba); err != nil {
+		return err
+	}
+	var stats [2]ChannelStats
+	if opts.ChannelStats {
+		rgba, stats = stretchChannels(rgba)
 	}
+	size := rgba.Rect.Size()
+	if size.X%4 != 0 || size.Y%4 != 0 {
+		rgba = padEdges(rgba)
+	}
+	quality, profile, _ := opts.resolve()
+
+	blocksPerRow := rgba.Rect.Size().X / 4
+	numBlocks := blocksPerRow * (rgba.Rect.Size().Y / 4)
 
-	blocks := makeBlocks(rgba)
+	//Blocks are copied out of rgba as they are compressed, so only the output counts against the budget
+	if opts.MemoryBudget > 0 && int64(numBlocks)*16 > opts.MemoryBudget {
+		return ErrBudgetExceeded
+	}
+	timer := newStageTimer(opts.OnStage)
 
-	b.Data = make([]byte, len(blocks)*16)
-	for i := 0; i < len(blocks); i++ {
-		pos := i * 16
-		c := compressBlock(blocks[i])
-		copy(b.Data[pos:pos+16], c)
+	region := traceRegion("compress")
+	data := make([]byte, numBlocks*16)
+	reused, err := compressBlocks(data, rgba, quality, opts, timer)
+	if err != nil {
+		region.End()
+		return err
+	}
+	if opts.RDO > 0 {
+		//Each block may take bytes from those before it, so this pass runs in order
+		block := image.NewRGBA(image.Rect(0, 0, 4, 4))
+		for i := 0; i < numBlocks; i++ {
+			if reused[i] {
+				continue
+			}
+			loadBlock(block, rgba, rgba.Rect.Min.X+(i%blocksPerRow)*4, rgba.Rect.Min.Y+(i/blocksPerRow)*4)
+			timer.mark(StageSplit)
+			rdoBlock(data, i*16, block, opts.RDO, opts.rdoWindow())
+			timer.mark(StageIndices)
+		}
+	}
+	if opts.FixTiling {
+		fixTiling(data, rgba, quality, opts)
 	}
+	region.End()
+	timer.report()
+	b.Data = data
 	b.Rect = rgba.Rect
+	b.stride = 0
+	b.recordPadding(size, rgba.Rect.Size())
+	if opts.ChannelStats {
+		b.SetChannelStats(stats[0], stats[1])
+	} else {
+		delete(b.Metadata, MetaChannelStats)
+	}
+
+	if profile != nil {
+		b.BlueMode, b.Swizzle = profile.BlueMode, profile.Swizzle
+		b.recordProfile(opts.Profile, profile)
+	}
+	if opts.Record {
+		return b.recordOptions(opts)
+	}
 	return nil
 }
 
-// Decompress returns an RGBA image containing the decompressed contents of b.
+// Decompress returns an RGBA image containing the decompressed contents of b. Its bounds are b.Rect,
+// or start at the origin if b.Origin is ZeroOrigin, cropped to the ContentSize of b.
 func (b BC5) Decompress() *image.RGBA {
 
-	blocks := make([]*image.RGBA, len(b.Data)/16)
-	for i := 0; i < len(blocks); i++ {
-		pos := i * 16
-		blocks[i] = decompressBlock(b.Data[pos:pos+16], b.BlueMode)
+	return b.DecompressRect(image.Rectangle{Max: b.ContentSize()})
+}
+
+// DecompressRect returns an RGBA image containing the decompressed pixels of b within r, given in the
+// coordinates At uses, decoding only the blocks that r overlaps, so showing a small part of a huge
+// texture costs no more than the part shown. The returned image has bounds r clipped to the
+// ContentSize of b and offset by b.Rect.Min, so a tile compressed from part of a larger image can be
+// drawn back into it in place. If b.Origin is ZeroOrigin the bounds are not offset, so pixels keep the
+// coordinates they have in At.
+func (b BC5) DecompressRect(r image.Rectangle) *image.RGBA {
+
+	rgba := b.decompressRect(r.Intersect(image.Rectangle{Max: b.ContentSize()}))
+	if b.Origin == ImageOrigin {
+		rgba.Rect = rgba.Rect.Add(b.Rect.Min)
 	}
+	return rgba
+}
 
-	rgba := image.NewRGBA(b.Rect)
-	for y := 0; y < rgba.Rect.Size().Y; y++ {
-		for x := 0; x < b.Rect.Size().X; x++ {
+// DecompressInto decompresses b into dst instead of allocating a new image, so that code decoding
+// every frame can reuse a single buffer. The bounds of dst must be those Decompress would return.
+func (b BC5) DecompressInto(dst *image.RGBA) error {
 
-			blockIx := (int(float32(y)/4) * b.Rect.Size().X / 4) + int(float32(x)/4)
-			rgba.SetRGBA(x, y, blocks[blockIx].RGBAAt(x%4, y%4))
-		}
+	local := image.Rectangle{Max: b.ContentSize()}
+	want := local
+	if b.Origin == ImageOrigin {
+		want = want.Add(b.Rect.Min)
 	}
+	if dst.Rect != want {
+		return fmt.Errorf("destination bounds %v do not match image bounds %v", dst.Rect, want)
+	}
+
+	//Decode through a view of dst in the coordinates At uses, sharing its pixels
+	view := *dst
+	view.Rect = local
+	b.decompressBlocks(&view, local)
+	return nil
+}
+
+// returns the decompressed pixels of b within r clipped to its size, in the coordinates At uses
+func (b BC5) decompressRect(r image.Rectangle) *image.RGBA {
+
+	r = r.Intersect(image.Rect(0, 0, b.Rect.Size().X, b.Rect.Size().Y))
+	rgba := image.NewRGBA(r)
+	b.decompressBlocks(rgba, r)
 	return rgba
 }
 
+// SubImage returns a view of the pixels of b within r which shares the block data of b. The view has
+// its origin at r.Min, so At(0,0) on the view is At(r.Min.X, r.Min.Y) on b. The rectangle must lie
+// within b and be aligned to 4x4 blocks. Changes to the data of either are visible through both.
+func (b BC5) SubImage(r image.Rectangle) (*BC5, error) {
+
+	if !r.In(image.Rect(0, 0, b.Rect.Size().X, b.Rect.Size().Y)) || r.Empty() {
+		return nil, errors.New("rectangle must lie within the image")
+	}
+	if r.Min.X%4 != 0 || r.Min.Y%4 != 0 || r.Max.X%4 != 0 || r.Max.Y%4 != 0 {
+		return nil, errors.New("rectangle must be aligned to 4x4 blocks")
+	}
+
+	view := b
+	view.Data = b.Data[b.blockOffset(r.Min.X, r.Min.Y):]
+	view.Rect = r.Sub(r.Min)
+	view.stride = b.blocksPerRow()
+	return &view, nil
+}
+
+// DecompressGray16 returns a Gray16 image containing the decompressed contents of a single
+// channel of b, which must be RedChannel or GreenChannel. The palette values are expanded
+// directly to 16 bits rather than through bytes, preserving the precision of the interpolation.
+// Its bounds are b.Rect cropped to the ContentSize of b.
+func (b BC5) DecompressGray16(channel Channel) (*image.Gray16, error) {
+
+	pos, err := channelOffset(channel)
+	if err != nil {
+		return nil, err
+	}
+
+	gray := image.NewGray16(image.Rectangle{Min: b.Rect.Min, Max: b.Rect.Min.Add(b.ContentSize())})
+	for y := 0; y < gray.Rect.Size().Y; y += 4 {
+		for x := 0; x < gray.Rect.Size().X; x += 4 {
+
+			blockIx := b.blockOffset(x, y) + pos
+			pal := generatePalette(normalize(b.Data[blockIx]), normalize(b.Data[blockIx+1]))
+			indices := getIndices(b.Data[blockIx+2 : blockIx+8])
+			for i := 0; i < 16; i++ {
+				//Pixels in the padding fall outside gray and are not set
+				gray.SetGray16(gray.Rect.Min.X+x+i%4, gray.Rect.Min.Y+y+i/4, color.Gray16{Y: expand16(pal[indices[i]])})
+			}
+		}
+	}
+	return gray, nil
+}
+
+// DecompressHalf returns the decompressed red and green values of b as IEEE 754 half precision floats
+// from 0 to 1, two per pixel in rows from the top left, ready for uploading as an RG16F texture. The
+// values are converted directly from the palette rather than through bytes. Only the ContentSize of b
+// is returned, without any padding.
+func (b BC5) DecompressHalf() []uint16 {
+
+	size := b.ContentSize()
+	out := make([]uint16, size.X*size.Y*2)
+	b.eachBlock(func(x, y int, block []byte) {
+		r := generatePalette(normalize(block[0]), normalize(block[1]))
+		g := generatePalette(normalize(block[8]), normalize(block[9]))
+		rIndices, gIndices := getIndices(block[2:8]), getIndices(block[10:])
+		for i := 0; i < 16; i++ {
+			if x+i%4 >= size.X || y+i/4 >= size.Y {
+				continue
+			}
+			pos := ((y+i/4)*size.X + x + i%4) * 2
+			out[pos] = floatToHalf(float32(r[rIndices[i]]))
+			out[pos+1] = floatToHalf(float32(g[gIndices[i]]))
+		}
+	})
+	return out
+}
+
 // Decode reads BC5 encoded data from a reader into a new BC5 and returns a pointer to it.
 // It expects a signature equal to "BC5 ", then two uint32 values for width and height,
-// followed by all the block data. It will return an error if the data could not be
-// decoded properly.
+// followed by all the block data. A signature of "BC52" is followed by the width and height,
+// then a uint32 length and that many bytes of metadata before the block data. It will return
+// an error if the data could not be decoded properly. Data stored in a layout other than
+// Interleaved is reassembled into whole blocks. Parity written by EncodeFEC is dropped unchecked.
+// Data too short for the width and height is an error.
 func Decode(r io.Reader) (*BC5, error) {
 
+	img, err := decodeStored(r)
+	if err != nil {
+		return nil, err
+	}
+	img.dropFEC()
+	if err := img.restoreLayout(); err != nil {
+		return nil, err
+	}
+	if err := img.checkDataSize(); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// reads a BC5 like Decode, leaving the data in its stored layout
+func decodeStored(r io.Reader) (*BC5, error) {
+
 	readBytes, err := ioutil.ReadAll(r)
 	if err != nil {
 		return nil, err
@@ -139,48 +524,113 @@ func Decode(r io.Reader) (*BC5, error) {
 	buf := bytes.NewBuffer(readBytes)
 
 	signature := binary.BigEndian.Uint32(buf.Next(4))
-	if signature != strToDword("BC5 ") {
+	if signature != strToDword("BC5 ") && signature != strToDword("BC52") {
 		return nil, errors.New("invalid file signature")
 	}
 
 	width := binary.BigEndian.Uint32(buf.Next(4))
 	height := binary.BigEndian.Uint32(buf.Next(4))
+	if width%4 != 0 || height%4 != 0 || width > MaxDimension || height > MaxDimension {
+		return nil, errors.New("invalid image size")
+	}
+
+	img := new(BC5)
+	if signature == strToDword("BC52") {
+		if buf.Len() < 4 {
+			return nil, errors.New("missing metadata length")
+		}
+		metaLen := binary.BigEndian.Uint32(buf.Next(4))
+		if uint32(buf.Len()) < metaLen {
+			return nil, errors.New("not enough data for metadata")
+		}
+		img.Metadata, err = unmarshalMetadata(buf.Next(int(metaLen)))
+		if err != nil {
+			return nil, err
+		}
+		img.restoreProfile()
+	}
 
-	if len(readBytes) < 13 {
+	if buf.Len() < 1 {
 		return nil, errors.New("no image data found")
 	}
 
-	img := new(BC5)
 	img.Rect = image.Rect(0, 0, int(width), int(height))
-	img.Data = readBytes[12:]
+	img.Data = buf.Bytes()
 	return img, nil
 }
 
+// returns an error if the interleaved data of a decoded b holds fewer blocks than its size needs
+func (b BC5) checkDataSize() error {
+
+	if len(b.Data) < b.Rect.Size().X/4*b.Rect.Size().Y/4*16 {
+		return errors.New("not enough image data")
+	}
+	return nil
+}
+
 // Encode writes the contents of img to w, along with a 12 byte header containing the
 // uint32 encoding of "BC5 ", followed by two more uint32 values for width and height,
-// followed by all the block data.
+// followed by all the block data. If img has metadata the signature is "BC52" and the
+// header is followed by the uint32 length of the metadata and the metadata itself.
 func Encode(img *BC5, w io.Writer) error {
 
+	defer traceRegion(StageSerialize.String()).End()
+	if err := writeHeader(w, img.Rect.Size(), img.Metadata); err != nil {
+		return err
+	}
+
+	data, err := img.supercompress(img.storedData())
+	if err != nil {
+		return err
+	}
+	n, err := w.Write(data)
+	if err != nil {
+		return err
+	}
+	if n != len(data) {
+		return errors.New("failed to write image data")
+	}
+	return nil
+}
+
+// writes the file header for an image of the given size, using the v2 layout if meta is not empty
+func writeHeader(w io.Writer, size image.Point, meta map[string]string) error {
+
 	headerBytes := make([]byte, 12)
 	binary.BigEndian.PutUint32(headerBytes[:4], strToDword("BC5 "))
-	binary.BigEndian.PutUint32(headerBytes[4:8], uint32(img.Rect.Size().X))
-	binary.BigEndian.PutUint32(headerBytes[8:12], uint32(img.Rect.Size().Y))
+	binary.BigEndian.PutUint32(headerBytes[4:8], uint32(size.X))
+	binary.BigEndian.PutUint32(headerBytes[8:12], uint32(size.Y))
+	if len(meta) > 0 {
+		binary.BigEndian.PutUint32(headerBytes[:4], strToDword("BC52"))
+		metaBytes := marshalMetadata(meta)
+		metaLen := make([]byte, 4)
+		binary.BigEndian.PutUint32(metaLen, uint32(len(metaBytes)))
+		headerBytes = append(append(headerBytes, metaLen...), metaBytes...)
+	}
 	n, err := w.Write(headerBytes)
 	if err != nil {
 		return err
 	}
-	if n != 12 {
+	if n != len(headerBytes) {
 		return errors.New("failed to write header")
 	}
+	return nil
+}
 
-	n, err = w.Write(img.Data)
-	if err != nil {
-		return err
+// returns the quality to compress with and the profile named by o, if any
+func (o *EncodeOptions) resolve() (Quality, *Profile, error) {
+
+	if o.Profile == "" {
+		return o.Quality, nil, nil
 	}
-	if n != len(img.Data) {
-		return errors.New("failed to write image data")
+	p, ok := Profiles[o.Profile]
+	if !ok {
+		return 0, nil, errors.New("unknown profile " + o.Profile)
 	}
-	return nil
+	if o.Quality == QualityFast {
+		return p.Quality, &p, nil
+	}
+	return o.Quality, &p, nil
 }
 
 // converts string to uint32
@@ -190,92 +640,172 @@ func strToDword(s string) uint32 {
 	return binary.BigEndian.Uint32(b)
 }
 
-// returns array of 4x4 sub-images from img
-func makeBlocks(img *image.RGBA) []*image.RGBA {
+// copies the 4x4 pixels of src with the top left corner (x,y) into block
+func loadBlock(block, src *image.RGBA, x, y int) {
 
-	blocks := make([]*image.RGBA, (img.Rect.Size().X/4)*(img.Rect.Size().Y/4))
-	currentBlockIx := 0
-	for y := 0; y < img.Rect.Size().Y/4; y++ {
-		for x := 0; x < img.Rect.Size().X/4; x++ {
-			subImg := img.SubImage(image.Rect(x*4, y*4, (x*4)+4, (y*4)+4))
-			blocks[currentBlockIx] = image.NewRGBA(image.Rect(0, 0, 4, 4))
-			draw.Draw(blocks[currentBlockIx], blocks[currentBlockIx].Bounds(), subImg, subImg.Bounds().Min, draw.Src)
-			currentBlockIx++
-		}
+	for row := 0; row < 4; row++ {
+		pos := src.PixOffset(x, y+row)
+		copy(block.Pix[row*block.Stride:(row+1)*block.Stride], src.Pix[pos:pos+16])
 	}
-
-	return blocks
 }
 
 // returns 16 byte BC5 compressed block bytes for the given 4x4 RGBA image
-func compressBlock(block *image.RGBA) []byte {
+func compressBlock(block *image.RGBA, quality Quality) []byte {
 
-	var minR, maxR, minG, maxG byte = 255, 0, 255, 0
+	var r, g [16]byte
 	for y := 0; y < 4; y++ {
 		for x := 0; x < 4; x++ {
 			c := block.RGBAAt(x, y)
+			r[y*4+x] = c.R
+			g[y*4+x] = c.G
+		}
+	}
 
-			if c.R < minR {
-				minR = c.R
-			}
-			if c.R > maxR {
-				maxR = c.R
-			}
+	blockBytes := make([]byte, 16)
+	compressChannel(r, blockBytes[:8], quality)
+	compressChannel(g, blockBytes[8:], quality)
+	return blockBytes
+}
 
-			if c.G < minG {
-				minG = c.G
-			}
-			if c.G > maxG {
-				maxG = c.G
-			}
+// writes the 8 compressed bytes for the 16 values of a single block channel into dst
+func compressChannel(values [16]byte, dst []byte, quality Quality) {
+
+	c0, c1 := searchEndpoints(values, quality, fitChannel)
+	assignIndices(values, c0, c1, dst)
+}
+
+// returns the reference values giving the lowest error, as measured by fit, for the 16 values of a
+// block channel
+func searchEndpoints(values [16]byte, quality Quality, fit channelFit) (byte, byte) {
+
+	var min, max byte = 255, 0
+	for _, v := range values {
+		if v < min {
+			min = v
+		}
+		if v > max {
+			max = v
 		}
 	}
 
-	palR := generatePalette(normalize(minR), normalize(maxR))
-	palG := generatePalette(normalize(minG), normalize(maxG))
-	nearest := func(pal [8]float64, v byte) byte {
-		ni := 0
-		for i := 0; i < 8; i++ {
-			if math.Abs(pal[i]-normalize(v)) < math.Abs(pal[ni]-normalize(v)) {
-				ni = i
+	if min == max {
+		return min, max
+	}
+
+	best0, best1 := min, max
+	_, bestErr := fit(values, min, max)
+	try := func(c0, c1 byte) {
+		if _, err := fit(values, c0, c1); err < bestErr {
+			best0, best1, bestErr = c0, c1, err
+		}
+	}
+	if quality >= QualityNormal {
+		//Eight value palette
+		try(max, min)
+	}
+	if quality >= QualityHigh {
+		inset := int(max-min) / 8
+		for lo := 0; lo <= inset; lo++ {
+			for hi := 0; hi <= inset; hi++ {
+				if int(min)+lo < int(max)-hi {
+					try(max-byte(hi), min+byte(lo))
+					try(min+byte(lo), max-byte(hi))
+				}
 			}
 		}
-		return byte(ni)
 	}
+	return best0, best1
+}
 
-	//Compare red and green values and select closest in palette
-	rIndexU, gIndexU := uint64(0), uint64(0)
-	for y := 0; y < 4; y++ {
-		for x := 0; x < 4; x++ {
-			c := block.RGBAAt(x, y)
-			rIndexU = (rIndexU << 3) | uint64(nearest(palR, c.R))
-			gIndexU = (gIndexU << 3) | uint64(nearest(palG, c.G))
+// writes the reference values c0 and c1 and the closest palette index for each of values into the 8
+// byte channel half of a block dst
+func assignIndices(values [16]byte, c0, c1 byte, dst []byte) {
+
+	dst[0], dst[1] = c0, c1
+	if c0 == c1 {
+		//Constant channel, every index refers to the first reference value
+		for i := 2; i < 8; i++ {
+			dst[i] = 0
 		}
+		return
 	}
+	indices, _ := fitChannel(values, c0, c1)
+	putIndices(indices, dst[2:8])
+}
 
-	rIxBytes, gIxBytes := make([]byte, 8), make([]byte, 8)
-	binary.BigEndian.PutUint64(rIxBytes, rIndexU)
-	binary.BigEndian.PutUint64(gIxBytes, gIndexU)
+// returns the closest palette index for each of the values given reference values c0 and c1, along
+// with the sum of the squared differences between the values and the decoded palette entries
+func fitChannel(values [16]byte, c0, c1 byte) ([16]int, float64) {
 
-	blockBytes := make([]byte, 16)
-	blockBytes[0] = denormalize(palR[0])
-	blockBytes[1] = denormalize(palR[1])
-	copy(blockBytes[2:8], rIxBytes[2:8])
+	if c0 == c1 {
+		//Constant channel, as written by assignIndices
+		sum := 0.0
+		for _, v := range values {
+			d := float64(c0) - float64(v)
+			sum += d * d
+		}
+		return [16]int{}, sum
+	}
 
-	blockBytes[8] = denormalize(palG[0])
-	blockBytes[9] = denormalize(palG[1])
-	copy(blockBytes[10:], gIxBytes[2:8])
+	pal := generatePalette(normalize(c0), normalize(c1))
+	indices := [16]int{}
+	sum := 0.0
+	for i, v := range values {
+		for j := 1; j < 8; j++ {
+			if math.Abs(pal[j]-normalize(v)) < math.Abs(pal[indices[i]]-normalize(v)) {
+				indices[i] = j
+			}
+		}
+		d := float64(denormalize(pal[indices[i]])) - float64(v)
+		sum += d * d
+	}
+	return indices, sum
+}
 
-	return blockBytes
+// reports whether every red and green value of the decompressed compressed block is within
+// tolerance of the corresponding value in block
+func blockMatches(block *image.RGBA, compressed []byte, tolerance int) bool {
+
+	dec := decompressBlock(compressed, Zero, Swizzle{})
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			c, d := block.RGBAAt(x, y), dec.RGBAAt(x, y)
+			if absDiff(c.R, d.R) > tolerance || absDiff(c.G, d.G) > tolerance {
+				return false
+			}
+		}
+	}
+	return true
 }
 
 // returns an RGBA image containing the decompressed contents of block
-func decompressBlock(block []byte, blueMode BlueMode) *image.RGBA {
+func decompressBlock(block []byte, blueMode BlueMode, swizzle Swizzle) *image.RGBA {
+
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	var px [16]color.RGBA
+	decodeBlock(block, blueMode, swizzle, &px)
+	for i, c := range px {
+		img.SetRGBA(i%4, i/4, c)
+	}
+	return img
+}
+
+// decodes the 16 pixels of block into px in rows, without allocating
+func decodeBlock(block []byte, blueMode BlueMode, swizzle Swizzle, px *[16]color.RGBA) {
 
 	if len(block) != 16 {
 		panic("invalid block size")
 	}
 
+	if isConstant(block[:8]) && isConstant(block[8:]) {
+		//Flat block, every pixel decodes to the first reference values
+		c := decodePixel(normalize(block[0]), normalize(block[8]), blueMode, swizzle)
+		for i := range px {
+			px[i] = c
+		}
+		return
+	}
+
 	//First two bytes are reference reds
 	r := generatePalette(normalize(block[0]), normalize(block[1]))
 	rIndices := getIndices(block[2:8])
@@ -283,34 +813,69 @@ func decompressBlock(block []byte, blueMode BlueMode) *image.RGBA {
 	g := generatePalette(normalize(block[8]), normalize(block[9]))
 	gIndices := getIndices(block[10:])
 
-	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
-	pxIndex := 0
-	for y := 0; y < 4; y++ {
-		for x := 0; x < 4; x++ {
+	for i := range px {
+		px[i] = decodePixel(r[rIndices[i]], g[gIndices[i]], blueMode, swizzle)
+	}
+}
 
-			pxR := denormalize(r[rIndices[pxIndex]])
-			pxG := denormalize(g[gIndices[pxIndex]])
-			var pxB byte
-			switch blueMode {
-			case ComputeNormal:
-				pxB = denormalize((math.Sqrt(1-math.Pow(2*r[rIndices[pxIndex]]-1, 2)+math.Pow(2*g[gIndices[pxIndex]]-1, 2)))/2 + 0.5)
-			case Greyscale:
-				pxB = pxR
-			case One:
-				pxB = denormalize(1)
-			default:
-				pxB = 0
-			}
-			img.SetRGBA(x, y, color.RGBA{
-				R: pxR,
-				G: pxG,
-				B: pxB,
-				A: 1.0,
-			})
-			pxIndex++
-		}
+// returns the decompressed pixel for the normalized red and green values r and g
+func decodePixel(r, g float64, blueMode BlueMode, swizzle Swizzle) color.RGBA {
+
+	px := [4]uint16{uint16(denormalize(r)), uint16(denormalize(g)), uint16(denormalize(computeBlue(r, g, blueMode))), 255}
+	swizzle.apply(&px)
+	return color.RGBA{
+		R: byte(px[0]),
+		G: byte(px[1]),
+		B: byte(px[2]),
+		A: byte(px[3]),
 	}
-	return img
+}
+
+// reports whether the 8 byte channel half of a block decodes to a single value, which is the case
+// when both reference values are equal and every index refers to the first of them
+func isConstant(half []byte) bool {
+
+	return half[0] == half[1] && half[2]|half[3]|half[4]|half[5]|half[6]|half[7] == 0
+}
+
+// returns the normalized blue component for the normalized red and green values r and g
+func computeBlue(r, g float64, blueMode BlueMode) float64 {
+
+	switch blueMode {
+	case ComputeNormal:
+		return math.Sqrt(1-(math.Pow(2*r-1, 2)+math.Pow(2*g-1, 2)))/2 + 0.5
+	case Greyscale:
+		return r
+	case One:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// moves the red and green components of px, ordered r, g, b, a, to their destinations in s
+func (s Swizzle) apply(px *[4]uint16) {
+
+	r, g := px[0], px[1]
+	if s.R.moves() {
+		px[0] = 0
+	}
+	if s.G.moves() {
+		px[1] = 0
+	}
+	if s.R.moves() {
+		px[s.R-RedChannel] = r
+	}
+	if s.G.moves() {
+		px[s.G-RedChannel] = g
+	}
+}
+
+// reports whether c is a destination a swizzle moves a channel to, rather than DefaultChannel or an
+// unknown value
+func (c Channel) moves() bool {
+
+	return c >= RedChannel && c <= AlphaChannel
 }
 
 // generates the block palette from the reference colors
@@ -337,14 +902,15 @@ func generatePalette(c0, c1 float64) [8]float64 {
 	return pal
 }
 
-// returns an array of 16 indices parsed from b, separating out the 3-bit index values
+// returns an array of 16 indices parsed from b, separating out the 3-bit index values.
+// As in the spec, b holds a little endian 48-bit value with the index of pixel 0 in the lowest bits.
 func getIndices(b []byte) [16]int {
 
 	if len(b) != 6 {
 		panic("invalid index array size")
 	}
 
-	data := binary.BigEndian.Uint64(append([]byte{0, 0}, b...))
+	data := uint64(binary.LittleEndian.Uint32(b)) | uint64(binary.LittleEndian.Uint16(b[4:]))<<32
 
 	ix := [16]int{}
 	for i := 0; i < 16; i++ {
@@ -354,6 +920,83 @@ func getIndices(b []byte) [16]int {
 	return ix
 }
 
+// returns the offset into b.Data of the block containing pixel (x,y)
+func (b BC5) blockOffset(x, y int) int {
+
+	return ((y/4)*b.blocksPerRow() + x/4) * 16
+}
+
+// returns the number of blocks in each row of b.Data
+func (b BC5) blocksPerRow() int {
+
+	if b.stride > 0 {
+		return b.stride
+	}
+	return b.Rect.Size().X / 4
+}
+
+// returns the block data of b as one contiguous run of rows, copying it if b is a view
+func (b BC5) blockData() []byte {
+
+	w, h := b.Rect.Size().X/4, b.Rect.Size().Y/4
+	if b.blocksPerRow() == w {
+		return b.Data[:w*h*16]
+	}
+
+	data := make([]byte, 0, w*h*16)
+	for y := 0; y < h; y++ {
+		pos := b.blockOffset(0, y*4)
+		data = append(data, b.Data[pos:pos+w*16]...)
+	}
+	return data
+}
+
+// calls fn with the data of each block of b in row-major order, along with the block's pixel position
+func (b BC5) eachBlock(fn func(x, y int, block []byte)) {
+
+	for y := 0; y < b.Rect.Size().Y; y += 4 {
+		for x := 0; x < b.Rect.Size().X; x += 4 {
+			pos := b.blockOffset(x, y)
+			fn(x, y, b.Data[pos:pos+16])
+		}
+	}
+}
+
+// decompresses the blocks of b overlapping r into dst, placing each pixel at its coordinates in b
+func (b BC5) decompressBlocks(dst *image.RGBA, r image.Rectangle) {
+
+	var pixels [16]color.RGBA
+	for y := r.Min.Y / 4 * 4; y < r.Max.Y; y += 4 {
+		for x := r.Min.X / 4 * 4; x < r.Max.X; x += 4 {
+			pos := b.blockOffset(x, y)
+			decodeBlock(b.Data[pos:pos+16], b.BlueMode, b.Swizzle, &pixels)
+			area := image.Rect(x, y, x+4, y+4).Intersect(r)
+			for py := area.Min.Y; py < area.Max.Y; py++ {
+				for px := area.Min.X; px < area.Max.X; px++ {
+					dst.SetRGBA(px, py, pixels[(py-y)*4+px-x])
+				}
+			}
+		}
+	}
+}
+
+// packs 16 3-bit index values into b, the inverse of getIndices
+func putIndices(ix [16]int, b []byte) {
+
+	if len(b) != 6 {
+		panic("invalid index array size")
+	}
+
+	data := uint64(0)
+	for i := 0; i < 16; i++ {
+		data |= uint64(ix[i]&7) << uint(i*3)
+	}
+
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, data)
+	copy(b, buf[:6])
+}
+
 // returns v as a float normalized between 0 and 1
 func normalize(v byte) float64 {
 
@@ -365,3 +1008,83 @@ func denormalize(v float64) byte {
 
 	return byte(v * 255)
 }
+
+// returns a 16-bit representation of the normalized float v
+func expand16(v float64) uint16 {
+
+	return uint16(v*65535 + 0.5)
+}
+
+// returns the absolute difference between a and b
+func absDiff(a, b byte) int {
+
+	if a > b {
+		return int(a - b)
+	}
+	return int(b - a)
+}
+
+// returns the IEEE 754 half precision form of f, rounding to nearest even
+func floatToHalf(f float32) uint16 {
+
+	bits := math.Float32bits(f)
+	sign := uint16(bits>>16) & 0x8000
+	exp := int(bits>>23&0xff) - 127 + 15
+	mant := bits & 0x7fffff
+
+	switch {
+	case bits&0x7fffffff > 0x7f800000:
+		//NaN
+		return sign | 0x7e00
+	case exp >= 0x1f:
+		//Overflow to infinity
+		return sign | 0x7c00
+	case exp <= 0:
+		//Subnormal or zero
+		if exp < -10 {
+			return sign
+		}
+		mant |= 0x800000
+		shift := uint(14 - exp)
+		half := uint16(mant >> shift)
+		rem := mant & (1<<shift - 1)
+		if rem > 1<<(shift-1) || (rem == 1<<(shift-1) && half&1 == 1) {
+			half++
+		}
+		return sign | half
+	}
+
+	half := sign | uint16(exp)<<10 | uint16(mant>>13)
+	rem := mant & 0x1fff
+	if rem > 0x1000 || (rem == 0x1000 && half&1 == 1) {
+		//Rounding may carry into the exponent, which is still correct
+		half++
+	}
+	return half
+}
+
+// returns the float32 value of the IEEE 754 half precision h
+func halfToFloat(h uint16) float32 {
+
+	sign := uint32(h&0x8000) << 16
+	exp := int(h>>10) & 0x1f
+	mant := uint32(h & 0x3ff)
+
+	switch {
+	case exp == 0x1f:
+		//Infinity or NaN
+		return math.Float32frombits(sign | 0x7f800000 | mant<<13)
+	case exp == 0:
+		if mant == 0 {
+			return math.Float32frombits(sign)
+		}
+		//Subnormal, normalized for float32
+		exp = 1
+		for mant&0x400 == 0 {
+			mant <<= 1
+			exp--
+		}
+		mant &= 0x3ff
+	}
+	return math.Float32frombits(sign | uint32(exp+127-15)<<23 | mant<<13)
+}
@@ -0,0 +1,650 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// returns a width by height image with red rising across it and green rising down it
+func gradient(width, height int) *image.RGBA {
+
+	rgba := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			rgba.SetRGBA(x, y, color.RGBA{R: uint8(x * 255 / width), G: uint8(y * 255 / height), A: 255})
+		}
+	}
+	return rgba
+}
+
+func TestNonSquareRoundTrip(t *testing.T) {
+
+	for _, size := range []image.Point{{32, 8}, {8, 32}} {
+		src := gradient(size.X, size.Y)
+		b, err := NewBC5FromRGBA(src)
+		if err != nil {
+			t.Fatalf("%v: %v", size, err)
+		}
+		if b.Rect.Size() != size || len(b.Data) != size.X/4*size.Y/4*16 {
+			t.Fatalf("%v: compressed to %v with %d bytes", size, b.Rect.Size(), len(b.Data))
+		}
+
+		dec := b.Decompress()
+		for y := 0; y < size.Y; y++ {
+			for x := 0; x < size.X; x++ {
+				s, d := src.RGBAAt(x, y), dec.RGBAAt(x, y)
+				if absDiff(s.R, d.R) > 8 || absDiff(s.G, d.G) > 8 {
+					t.Fatalf("%v: pixel (%d,%d) decoded as (%d,%d), source (%d,%d)", size, x, y, d.R, d.G, s.R, s.G)
+				}
+			}
+		}
+
+		var buf bytes.Buffer
+		if err := Encode(b, &buf); err != nil {
+			t.Fatalf("%v: %v", size, err)
+		}
+		decoded, err := Decode(&buf)
+		if err != nil {
+			t.Fatalf("%v: %v", size, err)
+		}
+		if decoded.Rect != b.Rect || !bytes.Equal(decoded.Data, b.Data) {
+			t.Errorf("%v: container round trip changed the image", size)
+		}
+	}
+}
+
+func TestAtMatchesDecompress(t *testing.T) {
+
+	for _, size := range []image.Point{{16, 16}, {32, 8}, {8, 32}} {
+		b, err := NewBC5FromRGBA(gradient(size.X, size.Y))
+		if err != nil {
+			t.Fatalf("%v: %v", size, err)
+		}
+		for _, mode := range []BlueMode{Zero, One, ComputeNormal, Greyscale} {
+			b.BlueMode = mode
+			dec := b.Decompress()
+			for y := 0; y < size.Y; y++ {
+				for x := 0; x < size.X; x++ {
+					if got, want := b.At(x, y), dec.At(x, y); got != want {
+						t.Fatalf("%v, blue mode %d: At(%d,%d) is %v, Decompress gives %v", size, mode, x, y, got, want)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestGoldenVectorsVerify(t *testing.T) {
+
+	if err := VerifyGoldenVectors(); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteGoldenVectors(&buf, GoldenVectors()); err != nil {
+		t.Fatal(err)
+	}
+	vectors, err := ReadGoldenVectors(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vectors) != len(GoldenVectors()) {
+		t.Fatalf("read %d vectors, wrote %d", len(vectors), len(GoldenVectors()))
+	}
+	for _, v := range vectors {
+		if err := v.Verify(); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestDecodeTruncated(t *testing.T) {
+
+	b, err := NewBC5FromRGBA(gradient(16, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, layout := range []Layout{Interleaved, Planar, Split} {
+		b.SetLayout(layout)
+		var buf bytes.Buffer
+		if err := Encode(b, &buf); err != nil {
+			t.Fatal(err)
+		}
+		file := buf.Bytes()
+		for _, cut := range []int{1, 16, len(b.Data) - 1} {
+			if _, err := Decode(bytes.NewReader(file[:len(file)-cut])); err == nil {
+				t.Errorf("%v layout: Decode accepted data %d bytes short", layout, cut)
+			}
+			if _, _, err := DecodeFEC(bytes.NewReader(file[:len(file)-cut])); err == nil {
+				t.Errorf("%v layout: DecodeFEC accepted data %d bytes short", layout, cut)
+			}
+		}
+	}
+}
+
+func TestProfileRoundTrip(t *testing.T) {
+
+	Profiles["test-srgb"] = Profile{BlueMode: One, Swizzle: Swizzle{R: GreenChannel, G: RedChannel}, ColorSpace: SRGB}
+	defer delete(Profiles, "test-srgb")
+
+	tests := []struct {
+		profile    string
+		blueMode   BlueMode
+		swizzle    Swizzle
+		colorSpace ColorSpace
+		mipFilter  string
+	}{
+		{"normal", ComputeNormal, Swizzle{}, Linear, "normal"},
+		{"height", Greyscale, Swizzle{}, Linear, "box"},
+		{"test-srgb", One, Swizzle{R: GreenChannel, G: RedChannel}, SRGB, "box"},
+	}
+	for _, test := range tests {
+		b := new(BC5)
+		if err := b.SetFromRGBAWithOptions(gradient(16, 16), &EncodeOptions{Profile: test.profile}); err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := Encode(b, &buf); err != nil {
+			t.Fatal(err)
+		}
+		got, err := Decode(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.BlueMode != test.blueMode || got.Swizzle != test.swizzle {
+			t.Errorf("%s: decoded blue mode %v and swizzle %v, want %v and %v", test.profile, got.BlueMode, got.Swizzle, test.blueMode, test.swizzle)
+		}
+		if got.ColorSpace() != test.colorSpace || got.Metadata[MetaMipFilter] != test.mipFilter {
+			t.Errorf("%s: decoded color space %v and mip filter %q", test.profile, got.ColorSpace(), got.Metadata[MetaMipFilter])
+		}
+		if !bytes.Equal(got.Decompress().Pix, b.Decompress().Pix) {
+			t.Errorf("%s: decoded image decompresses differently", test.profile)
+		}
+	}
+}
+
+func TestMipFilters(t *testing.T) {
+
+	//The left column holds a flat normal and black, the right one a normal lying along X and white
+	src := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	for y := 0; y < 2; y++ {
+		src.SetRGBA(0, y, color.RGBA{R: 128, G: 128, B: 0, A: 255})
+		src.SetRGBA(1, y, color.RGBA{R: 255, G: 128, B: 255, A: 255})
+	}
+
+	tests := []struct {
+		name   string
+		filter MipFilter
+		space  ColorSpace
+		want   color.RGBA
+	}{
+		{"box", BoxFilter, Linear, color.RGBA{R: 192, G: 128, B: 128, A: 255}},
+		{"srgb", BoxFilter, SRGB, color.RGBA{R: 205, G: 128, B: 188, A: 255}},
+		{"normal", NormalFilter, Linear, color.RGBA{R: 218, G: 128, B: 128, A: 255}},
+	}
+	for _, test := range tests {
+		got := mipDownsample(src, test.filter, test.space).RGBAAt(0, 0)
+		if absDiff(got.R, test.want.R) > 1 || absDiff(got.G, test.want.G) > 1 || absDiff(got.B, test.want.B) > 1 || got.A != test.want.A {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestFECLayouts(t *testing.T) {
+
+	//Repeated tiles give the codebook fewer entries than blocks
+	src := gradient(64, 64)
+	for y := 32; y < 64; y++ {
+		copy(src.Pix[y*src.Stride:(y+1)*src.Stride], src.Pix[(y-32)*src.Stride:])
+	}
+	opts := FECOptions{DataShards: 4, ParityShards: 2, ShardSize: 64}
+	for _, layout := range []Layout{Interleaved, Planar, Split, Codebook} {
+		b, err := NewBC5FromRGBA(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := b.Decompress().Pix
+		b.SetLayout(layout)
+		var buf bytes.Buffer
+		if err := EncodeFEC(b, &buf, opts); err != nil {
+			t.Fatal(err)
+		}
+		file := buf.Bytes()
+		dataStart := bytes.Index(file, b.storedData())
+
+		damaged := append([]byte(nil), file...)
+		for i := 0; i < 2*opts.ShardSize; i++ {
+			damaged[dataStart+i] ^= 0xff
+		}
+		for _, test := range []struct {
+			name     string
+			file     []byte
+			repaired int
+		}{
+			{"intact", file, 0},
+			{"damaged", damaged, 2},
+		} {
+			got, repaired, err := DecodeFEC(bytes.NewReader(test.file))
+			if err != nil {
+				t.Errorf("%v layout, %s: %v", layout, test.name, err)
+				continue
+			}
+			if repaired != test.repaired || !bytes.Equal(got.Decompress().Pix, want) {
+				t.Errorf("%v layout, %s: repaired %d shards, want %d, image matches %v", layout, test.name, repaired, test.repaired, bytes.Equal(got.Decompress().Pix, want))
+			}
+		}
+
+		got, err := Decode(bytes.NewReader(file))
+		if err != nil {
+			t.Errorf("%v layout: Decode: %v", layout, err)
+		} else if !bytes.Equal(got.Decompress().Pix, want) {
+			t.Errorf("%v layout: Decode changed the image", layout)
+		}
+	}
+}
+
+func TestFECMetadataWithoutLength(t *testing.T) {
+
+	b, err := NewBC5FromRGBA(gradient(32, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := EncodeFEC(b, &buf, DefaultFEC); err != nil {
+		t.Fatal(err)
+	}
+
+	//Rewrite the header as files written before the data length was recorded have it
+	fec := fmt.Sprintf("%d,%d,%d", DefaultFEC.DataShards, DefaultFEC.ParityShards, DefaultFEC.ShardSize)
+	headerLen := 16 + len(marshalMetadata(map[string]string{MetaFEC: fmt.Sprintf("%s,%d", fec, len(b.Data))}))
+	var file bytes.Buffer
+	if err := writeHeader(&file, b.Rect.Size(), map[string]string{MetaFEC: fec}); err != nil {
+		t.Fatal(err)
+	}
+	file.Write(buf.Bytes()[headerLen:])
+
+	got, _, err := DecodeFEC(bytes.NewReader(file.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Data, b.Data) {
+		t.Error("DecodeFEC changed the data")
+	}
+}
+
+func TestParseEncodeOptions(t *testing.T) {
+
+	want := &EncodeOptions{Quality: QualityHigh, Profile: "normal", Tolerance: 2, RDO: 0.5, Seed: 7, PadEdges: true}
+	tests := []struct {
+		name, options string
+	}{
+		{"json", `{"quality": "high", "profile": "normal", "tolerance": 2, "rdo": 0.5, "seed": 7, "padEdges": true}`},
+		{"yaml", "quality: high\nprofile: normal\ntolerance: 2\nrdo: 0.5\nseed: 7\npadEdges: true\n"},
+		{"yaml flow", "# encode settings\n{quality: high, profile: normal, tolerance: 2, rdo: 0.5, seed: 7, padEdges: true}"},
+	}
+	wantHash, err := want.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		got, err := ParseEncodeOptions(strings.NewReader(test.options))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if hash, _ := got.Hash(); !reflect.DeepEqual(got, want) || hash != wantHash {
+			t.Errorf("%s: got %+v with hash %s, want %+v with hash %s", test.name, got, hash, want, wantHash)
+		}
+	}
+
+	for _, options := range []string{"quality: best", "qualty: high", "quality: [high"} {
+		if _, err := ParseEncodeOptions(strings.NewReader(options)); err == nil {
+			t.Errorf("%q: no error", options)
+		}
+	}
+}
+
+func TestHashIgnoresUnserializedFields(t *testing.T) {
+
+	opts := &EncodeOptions{Quality: QualityNormal, Seed: 1}
+	hash, _ := opts.Hash()
+	ignored := *opts
+	ignored.MemoryBudget, ignored.Workers, ignored.Record = 1<<20, 3, true
+	ignored.OnStage = func(Stage, time.Duration) {}
+	if got, _ := ignored.Hash(); got != hash {
+		t.Error("hash depends on fields that are not serialized")
+	}
+	changed := *opts
+	changed.Seed = 2
+	if got, _ := changed.Hash(); got == hash {
+		t.Error("hash does not depend on the seed")
+	}
+}
+
+func TestDecompressGray16Offset(t *testing.T) {
+
+	b, err := NewBC5FromRGBA(gradient(16, 8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := b.DecompressGray16(GreenChannel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Rect = b.Rect.Add(image.Pt(32, 8))
+	got, err := b.DecompressGray16(GreenChannel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Rect != b.Rect {
+		t.Fatalf("bounds %v, want %v", got.Rect, b.Rect)
+	}
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 16; x++ {
+			if g, w := got.Gray16At(32+x, 8+y), want.Gray16At(x, y); g != w {
+				t.Fatalf("(%d,%d): got %v, want %v", x, y, g, w)
+			}
+		}
+	}
+}
+
+func TestPaddedContentEdge(t *testing.T) {
+
+	b := new(BC5)
+	if err := b.SetFromRGBAWithOptions(gradient(10, 6), &EncodeOptions{PadEdges: true}); err != nil {
+		t.Fatal(err)
+	}
+	content := image.Pt(10, 6)
+
+	for _, test := range []struct {
+		mode         AddressMode
+		x, y         int
+		wantX, wantY int
+	}{
+		{Clamp, 10, 5, 9, 5},
+		{Clamp, 11, 7, 9, 5},
+		{Wrap, 10, 6, 0, 0},
+		{Mirror, 10, 2, 9, 2},
+	} {
+		b.AddressMode = test.mode
+		if got, want := b.RGBAAt(test.x, test.y), b.RGBAAt(test.wantX, test.wantY); got != want {
+			t.Errorf("mode %d: RGBAAt(%d,%d) = %v, want %v", test.mode, test.x, test.y, got, want)
+		}
+		if got, want := b.At16(test.x, test.y), b.At16(test.wantX, test.wantY); got != want {
+			t.Errorf("mode %d: At16(%d,%d) = %v, want %v", test.mode, test.x, test.y, got, want)
+		}
+	}
+
+	b.AddressMode = Border
+	if c := b.RGBAAt(10, 0); c != (color.RGBA{}) {
+		t.Errorf("RGBAAt in the padding = %v, want the zero color", c)
+	}
+	if _, ok := b.AtOK(10, 0); ok {
+		t.Error("AtOK reported the padding as in bounds")
+	}
+	if half := b.DecompressHalf(); len(half) != content.X*content.Y*2 {
+		t.Errorf("DecompressHalf returned %d values, want %d", len(half), content.X*content.Y*2)
+	}
+	if gray, err := b.DecompressGray16(RedChannel); err != nil || gray.Rect.Size() != content {
+		t.Errorf("DecompressGray16 bounds %v, %v", gray.Rect, err)
+	}
+	if img := NewUncompressed(b).Image(); img.Rect.Size() != content {
+		t.Errorf("Uncompressed image bounds %v", img.Rect)
+	}
+	if d, _ := NewDescriptor([]*BC5{b}); d.Width != 12 || d.ContentWidth != content.X || d.ContentHeight != content.Y {
+		t.Errorf("descriptor %+v", d)
+	}
+
+	//Sampling at the right edge with Clamp blends only the last column of the content
+	r, _ := Sampler{AddressMode: Clamp}.Sample(b, 1, 2.5/6)
+	if want := normalize(b.RGBAAt(9, 2).R); math.Abs(r-want) > 1e-9 {
+		t.Errorf("Sample at the content edge = %v, want %v", r, want)
+	}
+}
+
+// returns a copy of the blocks of b with their indices stored in order
+func withIndexOrder(b *BC5, order IndexOrder) *BC5 {
+
+	out := *b
+	out.Data = append([]byte(nil), b.Data...)
+	out.eachBlock(func(x, y int, block []byte) {
+		for _, half := range [][]byte{block[2:8], block[10:16]} {
+			ix := getIndices(half)
+			var v uint64
+			for i, index := range ix {
+				if order == V1Order {
+					v |= uint64(index) << uint(3*(15-i))
+				} else {
+					v |= uint64(index) << uint(3*i)
+				}
+			}
+			for i := range half {
+				half[i] = byte(v >> uint(8*(5-i)))
+			}
+		}
+	})
+	return &out
+}
+
+func TestIndexOrders(t *testing.T) {
+
+	b, err := NewBC5FromRGBA(gradient(32, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		order     IndexOrder
+		container string
+		warnings  []WarningKind
+	}{
+		{SpecOrder, "BC5 ", []WarningKind{WarnOldContainer}},
+		{SpecOrder, "BC52", nil},
+		{V1Order, "BC5 ", []WarningKind{WarnOldContainer, WarnV1Indices}},
+		{BigEndianOrder, "BC5 ", []WarningKind{WarnOldContainer, WarnBigEndianIndices}},
+		{BigEndianOrder, "BC52", []WarningKind{WarnBigEndianIndices}},
+	}
+	for _, test := range tests {
+		stored := withIndexOrder(b, test.order)
+		if test.order == SpecOrder {
+			stored = b
+		}
+		report := stored.DetectV1()
+		if report.Order != test.order || report.V1 != (test.order == V1Order) {
+			t.Errorf("order %d: detected %+v", test.order, report)
+		}
+		migrated := *stored
+		migrated.Data = append([]byte(nil), stored.Data...)
+		migrated.MigrateIndices(report.Order)
+		if !bytes.Equal(migrated.Data, b.Data) {
+			t.Errorf("order %d: MigrateIndices did not restore the blocks", test.order)
+		}
+
+		file := *stored
+		if test.container == "BC52" {
+			file.Metadata = map[string]string{MetaProfile: "mask"}
+		}
+		var buf bytes.Buffer
+		if err := Encode(&file, &buf); err != nil {
+			t.Fatal(err)
+		}
+		var warnings []DecodeWarning
+		got, err := DecodeWithWarnings(&buf, CollectWarnings(&warnings))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var kinds []WarningKind
+		for _, w := range warnings {
+			kinds = append(kinds, w.Kind)
+		}
+		if !reflect.DeepEqual(kinds, test.warnings) || !bytes.Equal(got.Data, b.Data) {
+			t.Errorf("order %d in %q: warnings %v, want %v, blocks repaired %v", test.order, test.container, kinds, test.warnings, bytes.Equal(got.Data, b.Data))
+		}
+	}
+}
+
+func TestLayoutRoundTrip(t *testing.T) {
+
+	//Repeated rows give the codebook and zlib something to remove
+	src := gradient(32, 32)
+	for y := 16; y < 32; y++ {
+		copy(src.Pix[y*src.Stride:(y+1)*src.Stride], src.Pix[(y-16)*src.Stride:])
+	}
+	b, err := NewBC5FromRGBA(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, layout := range []Layout{Interleaved, Planar, Split, Codebook} {
+		var sizes []int
+		for _, s := range []KTX2Supercompression{KTX2None, KTX2Zlib} {
+			img := *b
+			img.Metadata = nil
+			img.SetLayout(layout)
+			if err := img.SetSupercompression(s); err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			if err := Encode(&img, &buf); err != nil {
+				t.Fatal(err)
+			}
+			sizes = append(sizes, buf.Len())
+			got, err := Decode(&buf)
+			if err != nil {
+				t.Errorf("%v layout, supercompression %d: %v", layout, s, err)
+				continue
+			}
+			if got.Layout() != layout || got.Supercompression() != s || !bytes.Equal(got.Data, b.Data) {
+				t.Errorf("%v layout, supercompression %d: decoded as %v, %d, data matches %v", layout, s, got.Layout(), got.Supercompression(), bytes.Equal(got.Data, b.Data))
+			}
+		}
+		if sizes[1] >= sizes[0] {
+			t.Errorf("%v layout: zlib file is %d bytes, uncompressed %d", layout, sizes[1], sizes[0])
+		}
+	}
+}
+
+func TestDecodeTolerant(t *testing.T) {
+
+	b, err := NewBC5FromRGBA(gradient(32, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		layout  Layout
+		hashes  bool
+		sum     bool
+		cut     int //Bytes removed from the end of the file.
+		flip    int //Offset into the block data of a byte to damage, -1 for none.
+		damaged []image.Rectangle
+	}{
+		{"intact", Interleaved, true, false, 0, -1, nil},
+		{"truncated block", Interleaved, false, false, 16, -1, []image.Rectangle{image.Rect(28, 28, 32, 32)}},
+		{"truncated blocks", Interleaved, false, false, 20, -1, []image.Rectangle{image.Rect(24, 28, 32, 32)}},
+		{"truncated plane", Planar, false, false, 1, -1, []image.Rectangle{image.Rect(0, 0, 32, 32)}},
+		{"hash mismatch", Interleaved, true, false, 0, 2*16 + 3, []image.Rectangle{image.Rect(8, 0, 16, 8)}},
+		{"checksum mismatch", Interleaved, false, true, 0, 2*16 + 3, []image.Rectangle{image.Rect(0, 0, 32, 32)}},
+	}
+	for _, test := range tests {
+		img := *b
+		img.Metadata = nil
+		if test.hashes {
+			if err := img.SetHashTable(8); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if test.sum {
+			img.SetChecksum()
+		}
+		img.SetLayout(test.layout)
+		var buf bytes.Buffer
+		if err := Encode(&img, &buf); err != nil {
+			t.Fatal(err)
+		}
+		file := buf.Bytes()
+		if test.flip >= 0 {
+			file[len(file)-len(b.Data)+test.flip] ^= 0xff
+		}
+
+		got, damaged, err := DecodeTolerant(bytes.NewReader(file[:len(file)-test.cut]))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(damaged, test.damaged) {
+			t.Errorf("%s: damaged %v, want %v", test.name, damaged, test.damaged)
+		}
+		if test.sum {
+			continue
+		}
+		originDamaged := false
+		for _, r := range test.damaged {
+			if c := got.RGBAAt(r.Min.X, r.Min.Y); c.R != NeutralValue || c.G != NeutralValue {
+				t.Errorf("%s: damaged pixel %v is %v", test.name, r.Min, c)
+			}
+			originDamaged = originDamaged || r.Min == image.Point{}
+		}
+		if c, want := got.RGBAAt(0, 0), b.RGBAAt(0, 0); !originDamaged && c != want {
+			t.Errorf("%s: intact pixel is %v, want %v", test.name, c, want)
+		}
+	}
+}
+
+func TestFECRecovery(t *testing.T) {
+
+	b, err := NewBC5FromRGBA(gradient(64, 64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := FECOptions{DataShards: 4, ParityShards: 2, ShardSize: 256}
+	var buf bytes.Buffer
+	if err := EncodeFEC(b, &buf, opts); err != nil {
+		t.Fatal(err)
+	}
+	file := buf.Bytes()
+	data := bytes.Index(file, b.Data)
+	parity := data + len(b.Data)
+
+	tests := []struct {
+		name     string
+		damage   []int //Offsets into the file of bytes to damage.
+		repaired int
+		fails    bool
+	}{
+		{"intact", nil, 0, false},
+		{"one data shard", []int{data + 10}, 1, false},
+		{"one parity shard", []int{parity + 300}, 1, false},
+		{"two shards in each group", []int{data, data + 256, data + 1024, data + 1536, data + 2048, parity + 1024}, 6, false},
+		{"too many in a group", []int{data, data + 256, data + 512}, 0, true},
+		{"checksum", []int{len(file) - 1}, 1, false},
+	}
+	for _, test := range tests {
+		damaged := append([]byte(nil), file...)
+		for _, pos := range test.damage {
+			damaged[pos] ^= 0x5a
+		}
+		got, repaired, err := DecodeFEC(bytes.NewReader(damaged))
+		if test.fails {
+			if err == nil {
+				t.Errorf("%s: no error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if repaired != test.repaired || !bytes.Equal(got.Data, b.Data) {
+			t.Errorf("%s: repaired %d shards, want %d, data matches %v", test.name, repaired, test.repaired, bytes.Equal(got.Data, b.Data))
+		}
+	}
+}
@@ -0,0 +1,276 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+// Package bc5test provides random inputs and ready-made property checks for testing code built on
+// package bc5, such as alternative decoders, compression backends, blue modes and swizzles. The checks
+// report failures through a testing.TB, so they can be called straight from a test or fuzz target:
+//
+//	func TestMyDecoder(t *testing.T) {
+//		bc5test.CheckDecoder(t, myDecodeBlock, 10000, 1, 1)
+//	}
+package bc5test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	bc5 "github.com/leylandski/go-bc5"
+)
+
+// Decoder decodes a single 16 byte block to the red and green values of its texels in rows from the
+// top left, as bc5.ReferenceDecodeBlock does.
+type Decoder func(block []byte) (red, green [16]uint8)
+
+// Encoder compresses an image, such as by calling (*bc5.BC5).SetFromRGBAWithOptions with fixed options.
+type Encoder func(rgba *image.RGBA) (*bc5.BC5, error)
+
+// Block returns a random block. Each half is equally likely to use the eight value palette, the six
+// value palette, or to be constant, so every decoding path is exercised.
+func Block(rng *rand.Rand) []byte {
+
+	block := make([]byte, 16)
+	rng.Read(block)
+	for _, half := range [][]byte{block[:8], block[8:]} {
+		switch rng.Intn(3) {
+		case 0:
+			if half[0] <= half[1] {
+				half[0], half[1] = half[1]+1, half[0]
+				if half[0] == 0 {
+					half[0], half[1] = 255, 0
+				}
+			}
+		case 1:
+			if half[0] > half[1] {
+				half[0], half[1] = half[1], half[0]
+			}
+		default:
+			half[1] = half[0]
+			for i := 2; i < 8; i++ {
+				half[i] = 0
+			}
+		}
+	}
+	return block
+}
+
+// Image returns a random size by size image, which must be a multiple of 4, of one of several kinds:
+// a flat color, a smooth gradient, noise, hard edges, or a normal map. Blue is 0 and alpha 255.
+func Image(rng *rand.Rand, size int) *image.RGBA {
+
+	return imageOf(rng, rng.Intn(5), size)
+}
+
+// CheckDecoder decodes n random blocks from seed with decode, and reports through t any red or green
+// value that differs from bc5.ReferenceDecodeBlock by more than tolerance. A tolerance of 1 allows for
+// rounding interpolated palette entries either way, as the specification does.
+func CheckDecoder(t testing.TB, decode Decoder, n int, seed int64, tolerance int) {
+
+	t.Helper()
+	rng := rand.New(rand.NewSource(seed))
+	for i := 0; i < n; i++ {
+		block := Block(rng)
+		wantR, wantG, err := bc5.ReferenceDecodeBlock(block)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gotR, gotG := decode(append([]byte(nil), block...))
+		for p := 0; p < 16; p++ {
+			if diff(gotR[p], wantR[p]) > tolerance || diff(gotG[p], wantG[p]) > tolerance {
+				t.Errorf("block % x texel %d: got (%d,%d), reference (%d,%d)", block, p, gotR[p], gotG[p], wantR[p], wantG[p])
+				return
+			}
+		}
+	}
+}
+
+// CheckEncoder compresses n random images from seed with encode and checks that:
+//   - the result has the size of the source;
+//   - compressing the same source again gives the same blocks;
+//   - flat images are reproduced exactly;
+//   - smooth gradients decode within maxError of the source;
+//   - Decompress agrees with bc5.ReferenceDecode to within 1;
+//   - the result survives bc5.Encode and bc5.Decode unchanged.
+func CheckEncoder(t testing.TB, encode Encoder, n int, seed int64, maxError int) {
+
+	t.Helper()
+	rng := rand.New(rand.NewSource(seed))
+	for i := 0; i < n; i++ {
+		kind := rng.Intn(5)
+		src := imageOf(rng, kind, 4*(1+rng.Intn(8)))
+		b, err := encode(src)
+		if err != nil {
+			t.Fatalf("image %d: %v", i, err)
+		}
+		if b.Rect.Size() != src.Rect.Size() {
+			t.Fatalf("image %d: compressed size %v, source %v", i, b.Rect.Size(), src.Rect.Size())
+		}
+		again, err := encode(src)
+		if err != nil {
+			t.Fatalf("image %d: %v", i, err)
+		}
+		if !bytes.Equal(again.BlockData(), b.BlockData()) {
+			t.Errorf("image %d: compressing twice gave different blocks", i)
+		}
+		switch kind {
+		case kindFlat:
+			AssertErrorBound(t, src, b, 0)
+		case kindGradient:
+			AssertErrorBound(t, src, b, maxError)
+		}
+		CheckView(t, b)
+
+		var buf bytes.Buffer
+		if err := bc5.Encode(b, &buf); err != nil {
+			t.Fatalf("image %d: %v", i, err)
+		}
+		decoded, err := bc5.Decode(&buf)
+		if err != nil {
+			t.Fatalf("image %d: %v", i, err)
+		}
+		if !bytes.Equal(decoded.BlockData(), b.BlockData()) || len(b.Metadata) > 0 && !reflect.DeepEqual(decoded.Metadata, b.Metadata) {
+			t.Errorf("image %d: container round trip changed the image", i)
+		}
+	}
+}
+
+// AssertErrorBound reports through t the first pixel whose red or green value decoded from b differs
+// from src by more than maxError. The swizzle of b is ignored.
+func AssertErrorBound(t testing.TB, src *image.RGBA, b *bc5.BC5, maxError int) {
+
+	t.Helper()
+	plain := *b
+	plain.Swizzle, plain.Origin = bc5.Swizzle{}, bc5.ZeroOrigin
+	size := src.Rect.Size()
+	dec := plain.DecompressRect(image.Rect(0, 0, size.X, size.Y))
+	for y := 0; y < size.Y; y++ {
+		for x := 0; x < size.X; x++ {
+			s, d := src.RGBAAt(src.Rect.Min.X+x, src.Rect.Min.Y+y), dec.RGBAAt(x, y)
+			if diff(s.R, d.R) > maxError || diff(s.G, d.G) > maxError {
+				t.Errorf("pixel (%d,%d): decoded (%d,%d), source (%d,%d), bound %d", x, y, d.R, d.G, s.R, s.G, maxError)
+				return
+			}
+		}
+	}
+}
+
+// CheckView checks that every way of reading pixels from b agrees, with whatever blue mode and swizzle
+// b has: At and At16 match Decompress, and the red and green values land in the channels the swizzle
+// names, within 1 of bc5.ReferenceDecode.
+func CheckView(t testing.TB, b *bc5.BC5) {
+
+	t.Helper()
+	size := b.Rect.Size()
+	img := b.Decompress()
+	ref, err := bc5.ReferenceDecode(b.BlockData(), size.X, size.Y)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for y := 0; y < size.Y; y++ {
+		for x := 0; x < size.X; x++ {
+			c := img.RGBAAt(img.Rect.Min.X+x, img.Rect.Min.Y+y)
+			if at := b.RGBAAt(x, y); at != c {
+				t.Errorf("pixel (%d,%d): At gives %v, Decompress %v", x, y, at, c)
+				return
+			}
+			c16 := b.At16(x, y)
+			if got := (color.RGBA{uint8(c16.R >> 8), uint8(c16.G >> 8), uint8(c16.B >> 8), uint8(c16.A >> 8)}); diffRGBA(got, c) > 1 {
+				t.Errorf("pixel (%d,%d): At16 gives %v, Decompress %v", x, y, c16, c)
+				return
+			}
+			r := ref.RGBAAt(x, y)
+			px := [4]uint8{c.R, c.G, c.B, c.A}
+			if got := px[destination(b.Swizzle.R, 0)]; diff(got, r.R) > 1 {
+				t.Errorf("pixel (%d,%d): red decodes as %d, reference %d", x, y, got, r.R)
+				return
+			}
+			if got := px[destination(b.Swizzle.G, 1)]; diff(got, r.G) > 1 {
+				t.Errorf("pixel (%d,%d): green decodes as %d, reference %d", x, y, got, r.G)
+				return
+			}
+		}
+	}
+}
+
+// kinds of image generated by imageOf
+const (
+	kindFlat = iota
+	kindGradient
+	kindNoise
+	kindEdges
+	kindNormal
+)
+
+// returns a random size by size image of the given kind
+func imageOf(rng *rand.Rand, kind, size int) *image.RGBA {
+
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	r0, g0 := rng.Float64()*255, rng.Float64()*255
+	dr, dg := (rng.Float64()*2-1)*8, (rng.Float64()*2-1)*8
+	fx, fy := rng.Float64()*0.5, rng.Float64()*0.5
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			var r, g float64
+			switch kind {
+			case kindFlat:
+				r, g = r0, g0
+			case kindGradient:
+				r, g = r0+dr*float64(x), g0+dg*float64(y)
+			case kindNoise:
+				r, g = rng.Float64()*255, rng.Float64()*255
+			case kindEdges:
+				r, g = r0, g0
+				if float64(x)*fx+float64(y)*fy > float64(size)/4 {
+					r, g = 255-r0, 255-g0
+				}
+			default:
+				//Normal map of a sum of waves, in the 0 to 255 encoding
+				nx, ny := 0.5*math.Sin(float64(x)*fx)*fy, 0.5*math.Cos(float64(y)*fy)*fx
+				l := math.Sqrt(nx*nx + ny*ny + 1)
+				r, g = (nx/l+1)*127.5, (ny/l+1)*127.5
+			}
+			img.SetRGBA(x, y, color.RGBA{R: clamp(r), G: clamp(g), A: 255})
+		}
+	}
+	return img
+}
+
+// returns the index of the destination of a channel in an RGBA pixel, def for DefaultChannel
+func destination(c bc5.Channel, def int) int {
+
+	if c == bc5.DefaultChannel {
+		return def
+	}
+	return int(c - bc5.RedChannel)
+}
+
+// returns v rounded and clamped to a byte
+func clamp(v float64) uint8 {
+
+	return uint8(math.Max(0, math.Min(255, math.Floor(v+0.5))))
+}
+
+// returns the absolute difference of a and b
+func diff(a, b uint8) int {
+
+	if a > b {
+		return int(a - b)
+	}
+	return int(b - a)
+}
+
+// returns the largest difference between the components of a and b
+func diffRGBA(a, b color.RGBA) int {
+
+	d := diff(a.R, b.R)
+	for _, v := range []int{diff(a.G, b.G), diff(a.B, b.B), diff(a.A, b.A)} {
+		if v > d {
+			d = v
+		}
+	}
+	return d
+}
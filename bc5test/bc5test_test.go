@@ -0,0 +1,54 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5test_test
+
+import (
+	"image"
+	"math/rand"
+	"testing"
+
+	bc5 "github.com/leylandski/go-bc5"
+	"github.com/leylandski/go-bc5/bc5test"
+)
+
+// decodes a single block with Decompress, for checking against the reference decoder
+func decodeBlock(block []byte) (red, green [16]uint8) {
+
+	img := (&bc5.BC5{Rect: image.Rect(0, 0, 4, 4), Data: block}).Decompress()
+	for i := 0; i < 16; i++ {
+		c := img.RGBAAt(i%4, i/4)
+		red[i], green[i] = c.R, c.G
+	}
+	return red, green
+}
+
+func TestDecoderProperties(t *testing.T) {
+
+	bc5test.CheckDecoder(t, decodeBlock, 5000, 1, 1)
+}
+
+func TestEncoderProperties(t *testing.T) {
+
+	for _, q := range []bc5.Quality{bc5.QualityFast, bc5.QualityNormal, bc5.QualityHigh} {
+		bc5test.CheckEncoder(t, func(rgba *image.RGBA) (*bc5.BC5, error) {
+			b := new(bc5.BC5)
+			return b, b.SetFromRGBAWithOptions(rgba, &bc5.EncodeOptions{Quality: q})
+		}, 40, 2, 20)
+	}
+}
+
+func TestViewProperties(t *testing.T) {
+
+	rng := rand.New(rand.NewSource(3))
+	for _, s := range []bc5.Swizzle{{}, {R: bc5.AlphaChannel, G: bc5.RedChannel}, {R: bc5.BlueChannel}} {
+		for _, mode := range []bc5.BlueMode{bc5.Zero, bc5.One, bc5.ComputeNormal, bc5.Greyscale} {
+			b, err := bc5.NewBC5FromRGBA(bc5test.Image(rng, 16))
+			if err != nil {
+				t.Fatal(err)
+			}
+			b.Swizzle, b.BlueMode = s, mode
+			bc5test.CheckView(t, b)
+		}
+	}
+}
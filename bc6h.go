@@ -0,0 +1,613 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"math"
+	"strings"
+)
+
+// Vulkan formats of BC6H data.
+const (
+	VulkanFormatBC6HUnsigned = 143 //VK_FORMAT_BC6H_UFLOAT_BLOCK
+	VulkanFormatBC6HSigned   = 144 //VK_FORMAT_BC6H_SFLOAT_BLOCK
+)
+
+// FloatImage is an image of floating point red, green and blue values, such as the high dynamic range
+// pixels of an environment map.
+type FloatImage struct {
+	Pix    []float32 //Red, green and blue of each pixel, left to right and top to bottom.
+	Stride int       //Number of values between vertically adjacent pixels.
+	Rect   image.Rectangle
+}
+
+// NewFloatImage returns a FloatImage with the given bounds, with every value zero.
+func NewFloatImage(r image.Rectangle) *FloatImage {
+
+	return &FloatImage{Pix: make([]float32, r.Dx()*r.Dy()*3), Stride: r.Dx() * 3, Rect: r}
+}
+
+// PixOffset returns the index of the red value of the pixel at (x,y) in f.Pix.
+func (f *FloatImage) PixOffset(x, y int) int {
+
+	return (y-f.Rect.Min.Y)*f.Stride + (x-f.Rect.Min.X)*3
+}
+
+// FloatAt returns the red, green and blue values at (x,y). Coordinates outside f return zero.
+func (f *FloatImage) FloatAt(x, y int) [3]float32 {
+
+	if !image.Pt(x, y).In(f.Rect) {
+		return [3]float32{}
+	}
+	i := f.PixOffset(x, y)
+	return [3]float32{f.Pix[i], f.Pix[i+1], f.Pix[i+2]}
+}
+
+// SetFloat sets the red, green and blue values at (x,y). Coordinates outside f are ignored.
+func (f *FloatImage) SetFloat(x, y int, c [3]float32) {
+
+	if !image.Pt(x, y).In(f.Rect) {
+		return
+	}
+	i := f.PixOffset(x, y)
+	copy(f.Pix[i:i+3], c[:])
+}
+
+// BC6H holds high dynamic range color compressed in the BC6H format, as half precision floats. Each
+// 4x4 block takes 16 bytes, in one of 14 modes trading the precision of its reference colors against
+// splitting the block into two regions with colors of their own.
+type BC6H struct {
+	Rect   image.Rectangle
+	Data   []byte
+	Signed bool //Whether the blocks hold signed values, BC6H_SF, rather than unsigned ones, BC6H_UF.
+}
+
+// NewBC6HFromFloat returns a BC6H containing the compressed form of img, with signed values if signed
+// is set.
+func NewBC6HFromFloat(img *FloatImage, signed bool) (*BC6H, error) {
+
+	b := &BC6H{Signed: signed}
+	if err := b.SetFromFloatWithOptions(img, nil); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// SetFromFloat encodes img into this BC6H image using the default options.
+func (b *BC6H) SetFromFloat(img *FloatImage) error {
+
+	return b.SetFromFloatWithOptions(img, nil)
+}
+
+// SetFromFloatWithOptions encodes img into this BC6H image using the settings in opts, which may be nil
+// to use the defaults, in the signed or unsigned format as b.Signed gives. The width and height of img
+// must be multiples of 4. Values beyond the range of half precision are clamped to it, as are negative
+// ones in the unsigned format. Every block is encoded in the single region mode with 10 bit reference
+// colors, which decoders of both formats accept. Quality, Profile and OnStage are used as they are for
+// BC5, the other options have no effect.
+func (b *BC6H) SetFromFloatWithOptions(img *FloatImage, opts *EncodeOptions) error {
+
+	if opts == nil {
+		opts = &EncodeOptions{}
+	}
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	size := img.Rect.Size()
+	if size.X%4 != 0 || size.Y%4 != 0 {
+		return errors.New("width and height must be multiples of 4")
+	}
+	quality, _, _ := opts.resolve()
+
+	timer := newStageTimer(opts.OnStage)
+	blocksPerRow := size.X / 4
+	data := make([]byte, blocksPerRow*(size.Y/4)*16)
+	for i := 0; i < len(data)/16; i++ {
+		var px [16][3]float64
+		x, y := img.Rect.Min.X+i%blocksPerRow*4, img.Rect.Min.Y+i/blocksPerRow*4
+		for p := range px {
+			c := img.FloatAt(x+p%4, y+p/4)
+			for k := 0; k < 3; k++ {
+				px[p][k] = float64(halfOrder(floatToHalf(c[k]), b.Signed))
+			}
+		}
+		timer.mark(StageSplit)
+		compressBC6HBlock(px, b.Signed, data[i*16:i*16+16], quality, timer)
+	}
+	timer.report()
+
+	b.Rect = img.Rect
+	b.Data = data
+	return nil
+}
+
+// Decompress returns a FloatImage containing the decompressed contents of b. Its bounds are b.Rect.
+func (b BC6H) Decompress() *FloatImage {
+
+	img := NewFloatImage(b.Rect)
+	blocksPerRow := b.Rect.Size().X / 4
+	for i := 0; i < len(b.Data)/16; i++ {
+		pixels := decodeBC6HBlock(b.Data[i*16:i*16+16], b.Signed)
+		x, y := b.Rect.Min.X+i%blocksPerRow*4, b.Rect.Min.Y+i/blocksPerRow*4
+		for p, h := range pixels {
+			img.SetFloat(x+p%4, y+p/4, [3]float32{halfToFloat(h[0]), halfToFloat(h[1]), halfToFloat(h[2])})
+		}
+	}
+	return img
+}
+
+// FloatAt returns the decompressed red, green and blue values at (x,y), relative to the top left of
+// b.Rect, decoding only the block holding it. Coordinates outside b return zero.
+func (b BC6H) FloatAt(x, y int) [3]float32 {
+
+	if !image.Pt(x, y).In(image.Rect(0, 0, b.Rect.Size().X, b.Rect.Size().Y)) {
+		return [3]float32{}
+	}
+	pos := ((y/4)*(b.Rect.Size().X/4) + x/4) * 16
+	h := decodeBC6HBlock(b.Data[pos:pos+16], b.Signed)[(y%4)*4+x%4]
+	return [3]float32{halfToFloat(h[0]), halfToFloat(h[1]), halfToFloat(h[2])}
+}
+
+// EncodeBC6H writes the contents of img to w, along with a 12 byte header containing the uint32
+// encoding of "BC6U", or "BC6S" for the signed format, followed by two more uint32 values for width and
+// height.
+func EncodeBC6H(img *BC6H, w io.Writer) error {
+
+	sig := "BC6U"
+	if img.Signed {
+		sig = "BC6S"
+	}
+	header := make([]byte, 12)
+	binary.BigEndian.PutUint32(header[:4], strToDword(sig))
+	binary.BigEndian.PutUint32(header[4:8], uint32(img.Rect.Size().X))
+	binary.BigEndian.PutUint32(header[8:12], uint32(img.Rect.Size().Y))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(img.Data)
+	return err
+}
+
+// DecodeBC6H reads data written by EncodeBC6H from r into a new BC6H. An error is returned if the
+// header is invalid or the block data does not match the size it gives.
+func DecodeBC6H(r io.Reader) (*BC6H, error) {
+
+	readBytes, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(readBytes) < 12 {
+		return nil, errors.New("not enough data for BC6H")
+	}
+
+	buf := bytes.NewBuffer(readBytes)
+	img := new(BC6H)
+	switch binary.BigEndian.Uint32(buf.Next(4)) {
+	case strToDword("BC6U"):
+	case strToDword("BC6S"):
+		img.Signed = true
+	default:
+		return nil, errors.New("invalid file signature")
+	}
+	width := int(binary.BigEndian.Uint32(buf.Next(4)))
+	height := int(binary.BigEndian.Uint32(buf.Next(4)))
+	if width%4 != 0 || height%4 != 0 || width > MaxDimension || height > MaxDimension {
+		return nil, errors.New("invalid image size")
+	}
+	if buf.Len() != width/4*height/4*16 {
+		return nil, errors.New("block data does not match image size")
+	}
+	img.Rect = image.Rect(0, 0, width, height)
+	img.Data = buf.Bytes()
+	return img, nil
+}
+
+// a BC6H block mode
+type bc6hMode struct {
+	value       uint32    //Mode bits, from the lowest bit of the block.
+	bits        int       //Number of mode bits, 2 or 5.
+	regions     int       //Number of regions, each with two reference colors.
+	transformed bool      //Whether the reference colors after the first are stored as differences from it.
+	epb         int       //Bits of precision of the reference colors.
+	delta       [3]int    //Bits stored of each channel of the reference colors after the first.
+	layout      []bc6hBit //Bits of the reference colors in the order they follow the mode bits.
+}
+
+// a bit of a BC6H reference color
+type bc6hBit struct {
+	endpoint, channel, bit int
+}
+
+// the modes of BC6H blocks, in the order of the format specification. The layouts are written as
+// there, with the reference colors of the first region numbered 0 and 1 and of the second 2 and 3.
+var bc6hModes = [...]bc6hMode{
+	{0, 2, 2, true, 10, [3]int{5, 5, 5}, bc6hLayout("g2[4] b2[4] b3[4] r0[9:0] g0[9:0] b0[9:0] r1[4:0] g3[4] g2[3:0] g1[4:0] b3[0] g3[3:0] b1[4:0] b3[1] b2[3:0] r2[4:0] b3[2] r3[4:0] b3[3]")},
+	{1, 2, 2, true, 7, [3]int{6, 6, 6}, bc6hLayout("g2[5] g3[4] g3[5] r0[6:0] b3[0] b3[1] b2[4] g0[6:0] b2[5] b3[2] g2[4] b0[6:0] b3[3] b3[5] b3[4] r1[5:0] g2[3:0] g1[5:0] g3[3:0] b1[5:0] b2[3:0] r2[5:0] r3[5:0]")},
+	{2, 5, 2, true, 11, [3]int{5, 4, 4}, bc6hLayout("r0[9:0] g0[9:0] b0[9:0] r1[4:0] r0[10] g2[3:0] g1[3:0] g0[10] b3[0] g3[3:0] b1[3:0] b0[10] b3[1] b2[3:0] r2[4:0] b3[2] r3[4:0] b3[3]")},
+	{6, 5, 2, true, 11, [3]int{4, 5, 4}, bc6hLayout("r0[9:0] g0[9:0] b0[9:0] r1[3:0] r0[10] g3[4] g2[3:0] g1[4:0] g0[10] g3[3:0] b1[3:0] b0[10] b3[1] b2[3:0] r2[3:0] b3[0] b3[2] r3[3:0] g2[4] b3[3]")},
+	{10, 5, 2, true, 11, [3]int{4, 4, 5}, bc6hLayout("r0[9:0] g0[9:0] b0[9:0] r1[3:0] r0[10] b2[4] g2[3:0] g1[3:0] g0[10] b3[0] g3[3:0] b1[4:0] b0[10] b2[3:0] r2[3:0] b3[1] b3[2] r3[3:0] b3[4] b3[3]")},
+	{14, 5, 2, true, 9, [3]int{5, 5, 5}, bc6hLayout("r0[8:0] b2[4] g0[8:0] g2[4] b0[8:0] b3[4] r1[4:0] g3[4] g2[3:0] g1[4:0] b3[0] g3[3:0] b1[4:0] b3[1] b2[3:0] r2[4:0] b3[2] r3[4:0] b3[3]")},
+	{18, 5, 2, true, 8, [3]int{6, 5, 5}, bc6hLayout("r0[7:0] g3[4] b2[4] g0[7:0] b3[2] g2[4] b0[7:0] b3[3] b3[4] r1[5:0] g2[3:0] g1[4:0] b3[0] g3[3:0] b1[4:0] b3[1] b2[3:0] r2[5:0] r3[5:0]")},
+	{22, 5, 2, true, 8, [3]int{5, 6, 5}, bc6hLayout("r0[7:0] b3[0] b2[4] g0[7:0] g2[5] g2[4] b0[7:0] g3[5] b3[4] r1[4:0] g3[4] g2[3:0] g1[5:0] g3[3:0] b1[4:0] b3[1] b2[3:0] r2[4:0] b3[2] r3[4:0] b3[3]")},
+	{26, 5, 2, true, 8, [3]int{5, 5, 6}, bc6hLayout("r0[7:0] b3[1] b2[4] g0[7:0] b2[5] g2[4] b0[7:0] b3[5] b3[4] r1[4:0] g3[4] g2[3:0] g1[4:0] b3[0] g3[3:0] b1[5:0] b2[3:0] r2[4:0] b3[2] r3[4:0] b3[3]")},
+	{30, 5, 2, false, 6, [3]int{6, 6, 6}, bc6hLayout("r0[5:0] g3[4] b3[0] b3[1] b2[4] g0[5:0] g2[5] b2[5] b3[2] g2[4] b0[5:0] g3[5] b3[3] b3[5] b3[4] r1[5:0] g2[3:0] g1[5:0] g3[3:0] b1[5:0] b2[3:0] r2[5:0] r3[5:0]")},
+	{3, 5, 1, false, 10, [3]int{10, 10, 10}, bc6hLayout("r0[9:0] g0[9:0] b0[9:0] r1[9:0] g1[9:0] b1[9:0]")},
+	{7, 5, 1, true, 11, [3]int{9, 9, 9}, bc6hLayout("r0[9:0] g0[9:0] b0[9:0] r1[8:0] r0[10] g1[8:0] g0[10] b1[8:0] b0[10]")},
+	{11, 5, 1, true, 12, [3]int{8, 8, 8}, bc6hLayout("r0[9:0] g0[9:0] b0[9:0] r1[7:0] r0[10:11] g1[7:0] g0[10:11] b1[7:0] b0[10:11]")},
+	{15, 5, 1, true, 16, [3]int{4, 4, 4}, bc6hLayout("r0[9:0] g0[9:0] b0[9:0] r1[3:0] r0[10:15] g1[3:0] g0[10:15] b1[3:0] b0[10:15]")},
+}
+
+// index of the mode the encoder uses, with a single region and 10 bit reference colors
+const bc6hEncodeMode = 10
+
+// the subsets of the partitions of two region blocks, with bit i set if pixel i is in the second
+var bc6hPartitions = [32]uint16{
+	0xcccc, 0x8888, 0xeeee, 0xecc8, 0xc880, 0xfeec, 0xfec8, 0xec80,
+	0xc800, 0xffec, 0xfe80, 0xe800, 0xffe8, 0xff00, 0xfff0, 0xf000,
+	0xf710, 0x008e, 0x7100, 0x08ce, 0x008c, 0x7310, 0x3100, 0x8cce,
+	0x088c, 0x3110, 0x6666, 0x366c, 0x17e8, 0x0ff0, 0x718e, 0x399c,
+}
+
+// the pixel of the second region of each partition whose index has its top bit left out
+var bc6hAnchors = [32]int{
+	15, 15, 15, 15, 15, 15, 15, 15,
+	15, 15, 15, 15, 15, 15, 15, 15,
+	15, 2, 8, 2, 2, 8, 8, 15,
+	2, 8, 2, 2, 8, 8, 2, 2,
+}
+
+// interpolation weights out of 64 for 3 and 4 bit indices
+var (
+	bc6hWeights3 = [8]int{0, 9, 18, 27, 37, 46, 55, 64}
+	bc6hWeights4 = [16]int{0, 4, 9, 13, 17, 21, 26, 30, 34, 38, 43, 47, 51, 55, 60, 64}
+)
+
+// returns the bits described by layout, a list of fields such as "r0[9:0]" or "g2[4]" as written in
+// the format specification. Each field is a channel, a reference color and a range of bits, the first
+// of them stored last.
+func bc6hLayout(layout string) []bc6hBit {
+
+	var bits []bc6hBit
+	for _, field := range strings.Fields(layout) {
+		var channel byte
+		var endpoint, first, last int
+		if n, _ := fmt.Sscanf(field, "%c%d[%d:%d]", &channel, &endpoint, &first, &last); n < 3 {
+			panic("invalid BC6H layout field " + field)
+		} else if n == 3 {
+			last = first
+		}
+		c := strings.IndexByte("rgb", channel)
+		step := 1
+		if last > first {
+			step = -1
+		}
+		for bit := last; ; bit += step {
+			bits = append(bits, bc6hBit{endpoint, c, bit})
+			if bit == first {
+				break
+			}
+		}
+	}
+	return bits
+}
+
+// reads bits of a 128 bit block from the lowest up
+type bc6hBits struct {
+	lo, hi uint64
+	pos    uint
+}
+
+// returns the next n bits as a number, the first of them lowest
+func (r *bc6hBits) read(n int) int {
+
+	v := 0
+	for i := 0; i < n; i++ {
+		word := r.lo
+		if r.pos >= 64 {
+			word = r.hi >> (r.pos - 64)
+		} else {
+			word >>= r.pos
+		}
+		v |= int(word&1) << uint(i)
+		r.pos++
+	}
+	return v
+}
+
+// writes the low n bits of v as the next bits, the lowest first
+func (r *bc6hBits) write(v, n int) {
+
+	for i := 0; i < n; i++ {
+		bit := uint64(v>>uint(i)) & 1
+		if r.pos >= 64 {
+			r.hi |= bit << (r.pos - 64)
+		} else {
+			r.lo |= bit << r.pos
+		}
+		r.pos++
+	}
+}
+
+// returns the half precision red, green and blue of the 16 pixels of a BC6H block. Blocks with a
+// reserved mode decode as zero.
+func decodeBC6HBlock(block []byte, signed bool) [16][3]uint16 {
+
+	var pixels [16][3]uint16
+	r := &bc6hBits{lo: binary.LittleEndian.Uint64(block), hi: binary.LittleEndian.Uint64(block[8:])}
+	value := uint32(r.read(2))
+	if value >= 2 {
+		value |= uint32(r.read(3)) << 2
+	}
+	var mode *bc6hMode
+	for i := range bc6hModes {
+		if bc6hModes[i].value == value {
+			mode = &bc6hModes[i]
+		}
+	}
+	if mode == nil {
+		return pixels
+	}
+
+	var ep [4][3]int
+	for _, b := range mode.layout {
+		ep[b.endpoint][b.channel] |= r.read(1) << uint(b.bit)
+	}
+	for c := 0; c < 3; c++ {
+		if signed {
+			ep[0][c] = signExtend(ep[0][c], mode.epb)
+		}
+		for e := 1; e < mode.regions*2; e++ {
+			if mode.transformed {
+				ep[e][c] = (ep[0][c] + signExtend(ep[e][c], mode.delta[c])) & (1<<uint(mode.epb) - 1)
+			}
+			if signed {
+				ep[e][c] = signExtend(ep[e][c], mode.epb)
+			}
+		}
+		for e := 0; e < mode.regions*2; e++ {
+			ep[e][c] = bc6hUnquantize(ep[e][c], mode.epb, signed)
+		}
+	}
+
+	partition, indexBits, weights := 0, 4, bc6hWeights4[:]
+	if mode.regions == 2 {
+		partition, indexBits, weights = r.read(5), 3, bc6hWeights3[:]
+	}
+	for p := range pixels {
+		n := indexBits
+		if p == 0 || (mode.regions == 2 && p == bc6hAnchors[partition]) {
+			n--
+		}
+		w := weights[r.read(n)]
+		region := int(bc6hPartitions[partition]>>uint(p)) & (mode.regions - 1)
+		a, b := ep[region*2], ep[region*2+1]
+		for c := 0; c < 3; c++ {
+			pixels[p][c] = bc6hFinish((a[c]*(64-w)+b[c]*w+32)>>6, signed)
+		}
+	}
+	return pixels
+}
+
+// returns the low bits of v, a two's complement number of that many bits, as an int
+func signExtend(v, bits int) int {
+
+	v &= 1<<uint(bits) - 1
+	if v&(1<<uint(bits-1)) != 0 {
+		return v - 1<<uint(bits)
+	}
+	return v
+}
+
+// returns a reference color component of epb bits widened to 16 bits, signed or unsigned, for
+// interpolation
+func bc6hUnquantize(v, epb int, signed bool) int {
+
+	if !signed {
+		switch {
+		case epb >= 15:
+			return v
+		case v == 0:
+			return 0
+		case v == 1<<uint(epb)-1:
+			return 0xffff
+		}
+		return (v<<16 + 0x8000) >> uint(epb)
+	}
+
+	if epb >= 16 {
+		return v
+	}
+	neg := v < 0
+	if neg {
+		v = -v
+	}
+	switch {
+	case v == 0:
+	case v >= 1<<uint(epb-1)-1:
+		v = 0x7fff
+	default:
+		v = (v<<15 + 0x4000) >> uint(epb-1)
+	}
+	if neg {
+		return -v
+	}
+	return v
+}
+
+// returns the half precision bits of an interpolated component
+func bc6hFinish(v int, signed bool) uint16 {
+
+	if !signed {
+		return uint16(v * 31 >> 6)
+	}
+	if v < 0 {
+		return 0x8000 | uint16(-v*31>>5)
+	}
+	return uint16(v * 31 >> 5)
+}
+
+// returns the half precision h as an integer ordered as the values are, negative for negative values
+// if signed and zero for them otherwise, limited to the finite values
+func halfOrder(h uint16, signed bool) int {
+
+	mag := int(h & 0x7fff)
+	if mag > 0x7bff {
+		if mag > 0x7c00 {
+			//NaN
+			return 0
+		}
+		mag = 0x7bff
+	}
+	if h&0x8000 != 0 {
+		if !signed {
+			return 0
+		}
+		return -mag
+	}
+	return mag
+}
+
+// writes the 16 compressed bytes of a block of pixels, given as ordered half precision values, into
+// dst. The reference colors are the extremes of the pixels along their principal axis, inset at
+// QualityNormal and above, and refined by least squares at QualityHigh and above. Time spent is added
+// to timer.
+func compressBC6HBlock(px [16][3]float64, signed bool, dst []byte, quality Quality, timer *stageTimer) {
+
+	var all [16]bool
+	for i := range all {
+		all[i] = true
+	}
+	min := 0.0
+	if signed {
+		min = -0x7bff
+	}
+	lo, hi := colorExtremes(px, all, min, 0x7bff)
+	candidates := [][2][3]float64{{lo, hi}}
+	if quality >= QualityNormal {
+		var inLo, inHi [3]float64
+		for k := 0; k < 3; k++ {
+			inset := (hi[k] - lo[k]) / 16
+			inLo[k], inHi[k] = lo[k]+inset, hi[k]-inset
+		}
+		candidates = append(candidates, [2][3]float64{inLo, inHi})
+	}
+	timer.mark(StageEndpoints)
+
+	best, bestErr := make([]byte, 16), math.Inf(1)
+	var bestIndices [16]int
+	try := func(a, b [3]float64) {
+		var e0, e1 [3]int
+		for k := 0; k < 3; k++ {
+			e0[k], e1[k] = bc6hQuantize(a[k], signed), bc6hQuantize(b[k], signed)
+		}
+		cand := make([]byte, 16)
+		e, indices := fitBC6H(px, e0, e1, signed, cand)
+		if e < bestErr {
+			best, bestErr, bestIndices = cand, e, indices
+		}
+	}
+	for _, c := range candidates {
+		try(c[0], c[1])
+	}
+	if quality >= QualityHigh {
+		//Refine the reference colors by least squares against the indices chosen so far
+		for iter := 0; iter < 2; iter++ {
+			var weights [16]float64
+			for i, ix := range bestIndices {
+				weights[i] = 1 - float64(bc6hWeights4[ix])/64
+			}
+			a, b, ok := solveEndpoints(px, weights)
+			if !ok {
+				break
+			}
+			try(a, b)
+		}
+	}
+	timer.mark(StageIndices)
+	copy(dst, best)
+}
+
+// returns the 10 bit reference color component whose decoded value is closest to the ordered half
+// precision value v
+func bc6hQuantize(v float64, signed bool) int {
+
+	if !signed {
+		return clampInt(int(math.Floor((v-15)/31+0.5)), 0, 1023)
+	}
+	q := clampInt(int(math.Floor((math.Abs(v)-31)/62+0.5)), 0, 511)
+	if v < 0 {
+		return -q
+	}
+	return q
+}
+
+// writes the block encoding px with 10 bit reference colors e0 and e1 in the single region mode into
+// dst, choosing the closest palette entry for each pixel, and returns its squared error and indices.
+// The reference colors are swapped if needed so that the first pixel's index fits its 3 bits.
+func fitBC6H(px [16][3]float64, e0, e1 [3]int, signed bool, dst []byte) (float64, [16]int) {
+
+	var pal [16][3]float64
+	for i, w := range bc6hWeights4 {
+		for c := 0; c < 3; c++ {
+			a, b := bc6hUnquantize(e0[c], 10, signed), bc6hUnquantize(e1[c], 10, signed)
+			pal[i][c] = float64(halfOrder(bc6hFinish((a*(64-w)+b*w+32)>>6, signed), signed))
+		}
+	}
+
+	var indices [16]int
+	sum := 0.0
+	for i, p := range px {
+		bestErr := math.Inf(1)
+		for j, q := range pal {
+			dr, dg, db := p[0]-q[0], p[1]-q[1], p[2]-q[2]
+			if e := dr*dr + dg*dg + db*db; e < bestErr {
+				indices[i], bestErr = j, e
+			}
+		}
+		sum += bestErr
+	}
+	if indices[0] >= 8 {
+		//The weights are symmetric, so swapping the colors and reversing the indices decodes the same
+		e0, e1 = e1, e0
+		for i := range indices {
+			indices[i] = 15 - indices[i]
+		}
+	}
+
+	packBC6HBlock(bc6hEncodeMode, [4][3]int{e0, e1}, 0, indices, dst)
+	return sum, indices
+}
+
+// writes a block of mode m with the stored reference colors ep, partition and indices into dst. The
+// reference colors are as stored, so differences from the first for transformed modes.
+func packBC6HBlock(m int, ep [4][3]int, partition int, indices [16]int, dst []byte) {
+
+	mode := &bc6hModes[m]
+	w := &bc6hBits{}
+	w.write(int(mode.value), mode.bits)
+	for _, b := range mode.layout {
+		w.write(ep[b.endpoint][b.channel]>>uint(b.bit), 1)
+	}
+	indexBits := 4
+	if mode.regions == 2 {
+		w.write(partition, 5)
+		indexBits = 3
+	}
+	for p, ix := range indices {
+		n := indexBits
+		if p == 0 || (mode.regions == 2 && p == bc6hAnchors[partition]) {
+			n--
+		}
+		w.write(ix, n)
+	}
+	binary.LittleEndian.PutUint64(dst, w.lo)
+	binary.LittleEndian.PutUint64(dst[8:], w.hi)
+}
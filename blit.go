@@ -0,0 +1,49 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"image"
+	"image/draw"
+)
+
+// Blit composites src onto dst with its top left pixel at the point at. Blocks of dst entirely covered
+// by an aligned block of src are copied without recompression, so aligning at to the 4x4 block grid
+// avoids any quality loss. Blocks only partly covered, or covered by unaligned source pixels, are
+// decompressed, composited and recompressed. Parts of src falling outside dst are ignored.
+func Blit(dst, src *BC5, at image.Point) {
+
+	dstBounds := image.Rect(0, 0, dst.Rect.Size().X, dst.Rect.Size().Y)
+	area := image.Rectangle{Min: at, Max: at.Add(src.Rect.Size())}.Intersect(dstBounds)
+	if area.Empty() {
+		return
+	}
+	aligned := at.X%4 == 0 && at.Y%4 == 0
+
+	//Decoded source pixels for blocks that need recompression, in dst coordinates
+	var srcPixels *image.RGBA
+
+	for y := area.Min.Y / 4 * 4; y < area.Max.Y; y += 4 {
+		for x := area.Min.X / 4 * 4; x < area.Max.X; x += 4 {
+			blockRect := image.Rect(x, y, x+4, y+4)
+			dstPos := dst.blockOffset(x, y)
+			if aligned && blockRect.In(area) {
+				srcPos := src.blockOffset(x-at.X, y-at.Y)
+				copy(dst.Data[dstPos:dstPos+16], src.Data[srcPos:srcPos+16])
+				continue
+			}
+
+			if srcPixels == nil {
+				plain := *src
+				plain.Swizzle = Swizzle{}
+				decoded := plain.decompressRect(area.Sub(at))
+				srcPixels = &image.RGBA{Pix: decoded.Pix, Stride: decoded.Stride, Rect: decoded.Rect.Add(at)}
+			}
+			block := decompressBlock(dst.Data[dstPos:dstPos+16], Zero, Swizzle{})
+			overlap := blockRect.Intersect(area)
+			draw.Draw(block, overlap.Sub(blockRect.Min), srcPixels, overlap.Min, draw.Src)
+			copy(dst.Data[dstPos:dstPos+16], compressBlock(block, QualityFast))
+		}
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import "errors"
+
+// NumBlocks returns the number of 4x4 blocks in b.
+func (b BC5) NumBlocks() int {
+
+	return (b.Rect.Size().X / 4) * (b.Rect.Size().Y / 4)
+}
+
+// BlockData returns the blocks of b as one contiguous run of rows from the top left, as GPU upload
+// functions expect. The data is shared with b unless b is a view into a larger image, such as from
+// SubImage, in which case it is copied.
+func (b BC5) BlockData() []byte {
+
+	return b.blockData()
+}
+
+// BlockEndpoints returns the red and green reference values of block i, counting blocks in rows from
+// the top left.
+func (b BC5) BlockEndpoints(i int) (r0, r1, g0, g1 byte, err error) {
+
+	block, err := b.block(i)
+	if err != nil {
+		return 0, 0, 0, 0, err
+	}
+	return block[0], block[1], block[8], block[9], nil
+}
+
+// SetBlockEndpoints replaces the red and green reference values of block i, leaving its indices
+// untouched. Note that the order of each pair selects the palette mode, so swapping a pair changes
+// how the indices are interpreted.
+func (b *BC5) SetBlockEndpoints(i int, r0, r1, g0, g1 byte) error {
+
+	block, err := b.block(i)
+	if err != nil {
+		return err
+	}
+	block[0], block[1], block[8], block[9] = r0, r1, g0, g1
+	return nil
+}
+
+// BlockIndices returns the red and green palette indices of block i, one per pixel in rows from the
+// top left of the block.
+func (b BC5) BlockIndices(i int) (r, g [16]byte, err error) {
+
+	block, err := b.block(i)
+	if err != nil {
+		return r, g, err
+	}
+	rIndices, gIndices := getIndices(block[2:8]), getIndices(block[10:])
+	for j := 0; j < 16; j++ {
+		r[j], g[j] = byte(rIndices[j]), byte(gIndices[j])
+	}
+	return r, g, nil
+}
+
+// SetBlockIndices replaces the red and green palette indices of block i, leaving its reference values
+// untouched. Every index must be less than 8.
+func (b *BC5) SetBlockIndices(i int, r, g [16]byte) error {
+
+	block, err := b.block(i)
+	if err != nil {
+		return err
+	}
+
+	var rIndices, gIndices [16]int
+	for j := 0; j < 16; j++ {
+		if r[j] > 7 || g[j] > 7 {
+			return errors.New("index out of range")
+		}
+		rIndices[j], gIndices[j] = int(r[j]), int(g[j])
+	}
+	putIndices(rIndices, block[2:8])
+	putIndices(gIndices, block[10:])
+	return nil
+}
+
+// returns the 16 bytes of block i
+func (b BC5) block(i int) ([]byte, error) {
+
+	if i < 0 || i >= b.NumBlocks() {
+		return nil, errors.New("block out of range")
+	}
+	w := b.Rect.Size().X / 4
+	pos := b.blockOffset((i%w)*4, (i/w)*4)
+	if pos+16 > len(b.Data) {
+		return nil, errors.New("block data missing")
+	}
+	return b.Data[pos : pos+16], nil
+}
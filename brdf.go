@@ -0,0 +1,92 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math"
+	"math/bits"
+)
+
+// BRDFOptions holds settings for NewBRDFLUT.
+type BRDFOptions struct {
+	Size    int            //Width and height of the table, defaults to 128.
+	Samples int            //Importance samples integrated per texel, defaults to 1024.
+	Encode  *EncodeOptions //Options to compress with, defaults to the "lut" profile.
+}
+
+// NewBRDFLUT generates the split-sum BRDF integration table for image based lighting with a GGX
+// distribution and Smith geometry term, as described by Karis in "Real Shading in Unreal Engine 4",
+// and compresses it. NdotV increases from left to right and roughness from top to bottom, both
+// sampled at texel centers, so the table is looked up at (NdotV, roughness) with the first row at
+// zero. Red holds the scale and green the bias applied to F0.
+func NewBRDFLUT(opts *BRDFOptions) (*BC5, error) {
+
+	if opts == nil {
+		opts = &BRDFOptions{}
+	}
+	size, samples := opts.Size, opts.Samples
+	if size == 0 {
+		size = 128
+	}
+	if samples == 0 {
+		samples = 1024
+	}
+	if size < 0 || samples < 0 {
+		return nil, errors.New("size and samples must not be negative")
+	}
+	encode := opts.Encode
+	if encode == nil {
+		encode = &EncodeOptions{Profile: "lut"}
+	}
+
+	toByte := func(v float64) uint8 {
+		return uint8(clampInt(int(math.Floor(v*255+0.5)), 0, 255))
+	}
+	rgba := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			scale, bias := integrateBRDF((float64(x)+0.5)/float64(size), (float64(y)+0.5)/float64(size), samples)
+			rgba.SetRGBA(x, y, color.RGBA{R: toByte(scale), G: toByte(bias), A: 255})
+		}
+	}
+
+	b := new(BC5)
+	if err := b.SetFromRGBAWithOptions(rgba, encode); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// returns the split-sum scale and bias for a view at nDotV to a surface of the given roughness,
+// integrated over n importance samples of the GGX distribution
+func integrateBRDF(nDotV, roughness float64, n int) (float64, float64) {
+
+	v := [3]float64{math.Sqrt(1 - nDotV*nDotV), 0, nDotV}
+	a := roughness * roughness
+	k := a / 2
+	var scale, bias float64
+	for i := 0; i < n; i++ {
+		//Hammersley point mapped to a half vector around the normal (0,0,1).
+		u1, u2 := float64(i)/float64(n), float64(bits.Reverse32(uint32(i)))/(1<<32)
+		phi := 2 * math.Pi * u1
+		cosTheta := math.Sqrt((1 - u2) / (1 + (a*a-1)*u2))
+		sinTheta := math.Sqrt(1 - cosTheta*cosTheta)
+		h := [3]float64{sinTheta * math.Cos(phi), sinTheta * math.Sin(phi), cosTheta}
+
+		vDotH := v[0]*h[0] + v[1]*h[1] + v[2]*h[2]
+		nDotL := 2*vDotH*h[2] - v[2]
+		if nDotL <= 0 {
+			continue
+		}
+		g := nDotL / (nDotL*(1-k) + k) * nDotV / (nDotV*(1-k) + k)
+		gVis := g * vDotH / (h[2] * nDotV)
+		fc := math.Pow(1-vDotH, 5)
+		scale += (1 - fc) * gVis
+		bias += fc * gVis
+	}
+	return scale / float64(n), bias / float64(n)
+}
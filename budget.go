@@ -0,0 +1,12 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import "errors"
+
+// ErrBudgetExceeded is returned by operations that cannot complete within their memory budget.
+var ErrBudgetExceeded = errors.New("memory budget exceeded")
+
+// approximate memory used by each 4x4 block image held while compressing
+const blockWorkingSize = 128
@@ -0,0 +1,76 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"runtime"
+	"runtime/debug"
+	"sort"
+)
+
+// modulePath is the import path of this package, used to find its version in the build information.
+const modulePath = "github.com/leylandski/go-bc5"
+
+// CapabilityReport describes what this build of the package can do, for display by tools and for
+// pipelines to check at startup.
+type CapabilityReport struct {
+	Version      string   `json:"version"`      //Module version, "devel" if built from a source tree.
+	GoVersion    string   `json:"goVersion"`    //Version of Go the program was built with.
+	Containers   []string `json:"containers"`   //File formats that can be read or written.
+	Layouts      []string `json:"layouts"`      //Block data layouts, see Layout.
+	GPUFormats   []string `json:"gpuFormats"`   //Formats the data can be transcoded to for upload.
+	Qualities    []string `json:"qualities"`    //Compression qualities, see Quality.
+	Profiles     []string `json:"profiles"`     //Names of the registered encode profiles.
+	Acceleration []string `json:"acceleration"` //Hardware acceleration in use, empty as the package is pure Go.
+	Workers      int      `json:"workers"`      //Number of CPUs available to parallel operations.
+}
+
+// Capabilities returns the version and capabilities of this build of the package.
+func Capabilities() CapabilityReport {
+
+	report := CapabilityReport{
+		Version:      "devel",
+		GoVersion:    runtime.Version(),
+		Containers:   []string{"bc1", "bc3", "bc4", "bc5", "bc52", "bc5q", "bc5s", "bc6s", "bc6u", "dds", "fec", "godot-ctex", "ktx2"},
+		GPUFormats:   []string{"bc5", "eac-rg11", "rg8", "rgba8", WebGPUFormat},
+		Acceleration: []string{},
+		Workers:      runtime.GOMAXPROCS(0),
+	}
+	for l := Interleaved; l <= Codebook; l++ {
+		report.Layouts = append(report.Layouts, l.String())
+	}
+	for q := QualityFast; q <= QualityAnneal; q++ {
+		report.Qualities = append(report.Qualities, q.String())
+	}
+	for name := range Profiles {
+		report.Profiles = append(report.Profiles, name)
+	}
+	sort.Strings(report.Profiles)
+
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if info.Main.Path == modulePath && info.Main.Version != "" && info.Main.Version != "(devel)" {
+			report.Version = info.Main.Version
+		}
+		for _, dep := range info.Deps {
+			if dep.Path == modulePath {
+				report.Version = dep.Version
+			}
+		}
+	}
+	return report
+}
+
+// Supports reports whether name is listed among the containers, layouts, GPU formats, qualities,
+// profiles or acceleration of r.
+func (r CapabilityReport) Supports(name string) bool {
+
+	for _, list := range [][]string{r.Containers, r.Layouts, r.GPUFormats, r.Qualities, r.Profiles, r.Acceleration} {
+		for _, v := range list {
+			if v == name {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+)
+
+// MetaChannelStats is the metadata key holding the statistics of the red and green values before they
+// were normalized, as the minimum, maximum and mean of red followed by those of green.
+const MetaChannelStats = "channelstats"
+
+// ChannelStats describes the values of a channel before they were normalized to fill 0 to 255, such as
+// the heights in meters of a heightmap. Min and Max are the values that 0 and 255 now stand for.
+type ChannelStats struct {
+	Min, Max, Mean float64
+}
+
+// Denormalize returns the value that v, a decoded value of the channel, stood for before normalizing.
+func (s ChannelStats) Denormalize(v byte) float64 {
+
+	return s.Min + float64(v)/255*(s.Max-s.Min)
+}
+
+// ChannelStats returns the statistics of channel recorded in the metadata of b, and false if there
+// are none. channel must be RedChannel or GreenChannel. Statistics recorded by the ChannelStats encode
+// option are in units of the source values, from 0 to 1.
+func (b BC5) ChannelStats(channel Channel) (ChannelStats, bool) {
+
+	var s [2]ChannelStats
+	v, ok := b.Metadata[MetaChannelStats]
+	if !ok || (channel != RedChannel && channel != GreenChannel) {
+		return ChannelStats{}, false
+	}
+	if _, err := fmt.Sscanf(v, "%g,%g,%g,%g,%g,%g", &s[0].Min, &s[0].Max, &s[0].Mean, &s[1].Min, &s[1].Max, &s[1].Mean); err != nil {
+		return ChannelStats{}, false
+	}
+	return s[channel-RedChannel], true
+}
+
+// SetChannelStats records the statistics of the red and green values in the metadata of b, for
+// sources normalized before compression, so decoders can recover the original units. An error is
+// returned if any statistic is not finite.
+func (b *BC5) SetChannelStats(red, green ChannelStats) error {
+
+	for _, v := range []float64{red.Min, red.Max, red.Mean, green.Min, green.Max, green.Mean} {
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return errors.New("channel statistics must be finite")
+		}
+	}
+	b.setMeta(MetaChannelStats, fmt.Sprintf("%g,%g,%g,%g,%g,%g", red.Min, red.Max, red.Mean, green.Min, green.Max, green.Mean))
+	return nil
+}
+
+// returns a copy of rgba with its red and green values each stretched to fill 0 to 255, along with
+// their statistics beforehand as fractions of 255. Channels holding a single value are left as they are.
+func stretchChannels(rgba *image.RGBA) (*image.RGBA, [2]ChannelStats) {
+
+	size := rgba.Rect.Size()
+	var lo, hi [2]int
+	var sum [2]float64
+	lo[0], lo[1] = 255, 255
+	for y := 0; y < size.Y; y++ {
+		row := rgba.PixOffset(rgba.Rect.Min.X, rgba.Rect.Min.Y+y)
+		for x := 0; x < size.X; x++ {
+			for ch := 0; ch < 2; ch++ {
+				v := int(rgba.Pix[row+x*4+ch])
+				if v < lo[ch] {
+					lo[ch] = v
+				}
+				if v > hi[ch] {
+					hi[ch] = v
+				}
+				sum[ch] += float64(v)
+			}
+		}
+	}
+
+	var stats [2]ChannelStats
+	out := image.NewRGBA(rgba.Rect)
+	for y := 0; y < size.Y; y++ {
+		src := rgba.PixOffset(rgba.Rect.Min.X, rgba.Rect.Min.Y+y)
+		dst := out.PixOffset(out.Rect.Min.X, out.Rect.Min.Y+y)
+		copy(out.Pix[dst:dst+size.X*4], rgba.Pix[src:src+size.X*4])
+	}
+	for ch := 0; ch < 2; ch++ {
+		stats[ch] = ChannelStats{Min: float64(lo[ch]) / 255, Max: float64(hi[ch]) / 255}
+		if n := size.X * size.Y; n > 0 {
+			stats[ch].Mean = sum[ch] / float64(n) / 255
+		}
+		if hi[ch] <= lo[ch] {
+			//With Min and Max equal, every value denormalizes to the one held
+			continue
+		}
+		span := hi[ch] - lo[ch]
+		for i := ch; i < len(out.Pix); i += 4 {
+			out.Pix[i] = uint8(((int(out.Pix[i])-lo[ch])*255 + span/2) / span)
+		}
+	}
+	return out, stats
+}
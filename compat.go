@@ -0,0 +1,158 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"math"
+)
+
+// The first release of this package packed the indices of each block as a big endian 48-bit value with
+// the first pixel in the highest bits, but decoded them with the first pixel in the lowest bits, so
+// its own output came back rotated by 180 degrees within each block. It also decoded with an alpha of
+// 1 rather than 255 and with a sign error in ComputeNormal. The releases that followed packed the
+// indices in the order they were decoded, but still as a big endian value, until they were switched to
+// the little endian order of the spec. The functions here detect, reproduce and repair data affected
+// by this.
+
+// Alias for the orders in which releases of this package have stored the indices of a block channel.
+type IndexOrder int
+
+const (
+	SpecOrder      IndexOrder = iota //A little endian 48-bit value with the first pixel in the lowest bits, as the spec defines.
+	V1Order                          //A big endian value with the first pixel in the highest bits, as the first release wrote.
+	BigEndianOrder                   //A big endian value with the first pixel in the lowest bits, as the releases after it wrote until the switch to SpecOrder.
+)
+
+// V1Report holds the result of DetectV1.
+type V1Report struct {
+	Error          float64    //Mean difference per pixel between neighbouring red and green values as stored.
+	V1Error        float64    //The same with the indices read in V1Order.
+	BigEndianError float64    //The same with the indices read in BigEndianOrder.
+	Order          IndexOrder //The order the blocks appear to have been written in.
+	V1             bool       //Whether the blocks appear to have been written by the first release, with Order being V1Order.
+}
+
+// DetectV1 reports whether the blocks of b appear to have been compressed by an earlier release of
+// this package in an order other than SpecOrder, by checking whether the image is smoother with its
+// indices read in V1Order or BigEndianOrder. Flat images cannot be told apart and are reported as in
+// SpecOrder.
+func (b BC5) DetectV1() V1Report {
+
+	report := V1Report{Error: roughness(b.decompressRect(image.Rect(0, 0, b.Rect.Dx(), b.Rect.Dy())))}
+	best := report.Error * 0.8
+	for _, order := range []IndexOrder{V1Order, BigEndianOrder} {
+		migrated := b
+		migrated.Data = append([]byte(nil), b.blockData()...)
+		migrated.stride = 0
+		migrated.MigrateIndices(order)
+
+		e := roughness(migrated.decompressRect(image.Rect(0, 0, b.Rect.Dx(), b.Rect.Dy())))
+		if order == V1Order {
+			report.V1Error = e
+		} else {
+			report.BigEndianError = e
+		}
+		if e < best {
+			report.Order, best = order, e
+		}
+	}
+	report.V1 = report.Order == V1Order
+	return report
+}
+
+// MigrateV1 rewrites the indices of every block of b from the order the first release wrote them in to
+// the standard order, in place. The endpoints are unchanged, so the result decodes to exactly the
+// values the first release chose for each pixel, and no information is lost. It should only be used
+// on data known or detected with DetectV1 to come from that release.
+func (b *BC5) MigrateV1() {
+
+	b.MigrateIndices(V1Order)
+}
+
+// MigrateIndices rewrites the indices of every block of b from the order from to SpecOrder, in place,
+// as MigrateV1 does for V1Order. It should only be used on data known or detected with DetectV1 to be
+// stored in that order.
+func (b *BC5) MigrateIndices(from IndexOrder) {
+
+	if from != V1Order && from != BigEndianOrder {
+		return
+	}
+	b.eachBlock(func(x, y int, block []byte) {
+		for _, half := range [][]byte{block[2:8], block[10:16]} {
+			v := v1IndexBits(half)
+			var ix [16]int
+			for i := range ix {
+				if from == V1Order {
+					ix[i] = int(v>>uint(3*(15-i))) & 7
+				} else {
+					ix[i] = int(v>>uint(3*i)) & 7
+				}
+			}
+			putIndices(ix, half)
+		}
+	})
+}
+
+// DecompressV1 decompresses b exactly as the first release of this package did, for comparing against
+// archives consumed by old binaries. Indices are read in that release's order, alpha is 1, values are
+// truncated rather than rounded, and ComputeNormal uses its formula, sign error included. Swizzle and
+// the metadata are ignored. The bounds are b.Rect.
+func (b BC5) DecompressV1() *image.RGBA {
+
+	rgba := image.NewRGBA(b.Rect)
+	b.eachBlock(func(x, y int, block []byte) {
+		r, g := generatePalette(normalize(block[0]), normalize(block[1])), generatePalette(normalize(block[8]), normalize(block[9]))
+		rBits, gBits := v1IndexBits(block[2:8]), v1IndexBits(block[10:16])
+		for i := 0; i < 16; i++ {
+			pr, pg := r[(rBits>>uint(3*i))&7], g[(gBits>>uint(3*i))&7]
+			c := color.RGBA{R: denormalize(pr), G: denormalize(pg), A: 1}
+			switch b.BlueMode {
+			case ComputeNormal:
+				//Out of range results wrapped around, as the conversion did on the platforms of the time
+				c.B = byte(int((math.Sqrt(1-math.Pow(2*pr-1, 2)+math.Pow(2*pg-1, 2))/2 + 0.5) * 255))
+			case Greyscale:
+				c.B = c.R
+			case One:
+				c.B = 255
+			}
+			rgba.SetRGBA(b.Rect.Min.X+x+i%4, b.Rect.Min.Y+y+i/4, c)
+		}
+	})
+	return rgba
+}
+
+// returns the 6 index bytes of a block channel as the big endian value the releases before SpecOrder
+// used
+func v1IndexBits(b []byte) uint64 {
+
+	return binary.BigEndian.Uint64(append([]byte{0, 0}, b...))
+}
+
+// returns the mean absolute difference between the red and green values of horizontally and
+// vertically neighbouring pixels of img
+func roughness(img *image.RGBA) float64 {
+
+	var sum, n float64
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		for x := img.Rect.Min.X; x < img.Rect.Max.X; x++ {
+			c := img.RGBAAt(x, y)
+			for _, d := range []image.Point{{1, 0}, {0, 1}} {
+				p := image.Pt(x, y).Add(d)
+				if !p.In(img.Rect) {
+					continue
+				}
+				o := img.RGBAAt(p.X, p.Y)
+				sum += math.Abs(float64(c.R)-float64(o.R)) + math.Abs(float64(c.G)-float64(o.G))
+				n++
+			}
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return sum / n
+}
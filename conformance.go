@@ -0,0 +1,52 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"errors"
+	"image"
+	"io"
+	"io/ioutil"
+)
+
+// Mismatch describes a pixel where the decoded red and green values of a BC5 differ from a reference.
+type Mismatch struct {
+	X, Y       int  //Pixel position.
+	R, G       byte //Values decoded by this package.
+	RefR, RefG byte //Values in the reference.
+}
+
+// CompareRG8 reads a raw RG8 dump from r, such as a capture of a GPU sampling the same blocks as b,
+// and returns every pixel where the red or green value decoded by b differs from the dump by more than
+// tolerance. The dump must hold two bytes per pixel, red then green, in rows from the top left with no
+// padding. GPUs usually round interpolated palette values where this package truncates them, so a
+// tolerance of 1 is typical.
+func CompareRG8(b *BC5, r io.Reader, tolerance int) ([]Mismatch, error) {
+
+	ref, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	w, h := b.Rect.Size().X, b.Rect.Size().Y
+	if len(ref) != w*h*2 {
+		return nil, errors.New("reference size does not match image")
+	}
+
+	plain := *b
+	plain.Swizzle = Swizzle{}
+	img := plain.decompressRect(image.Rect(0, 0, w, h))
+
+	var mismatches []Mismatch
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := img.RGBAAt(x, y)
+			refR, refG := ref[(y*w+x)*2], ref[(y*w+x)*2+1]
+			if absDiff(c.R, refR) > tolerance || absDiff(c.G, refG) > tolerance {
+				mismatches = append(mismatches, Mismatch{X: x, Y: y, R: c.R, G: c.G, RefR: refR, RefG: refG})
+			}
+		}
+	}
+	return mismatches, nil
+}
@@ -0,0 +1,137 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+	"io/ioutil"
+)
+
+// Constants from the DirectDraw Surface header and its DX10 extension.
+const (
+	ddsHeaderSize         = 124
+	ddsPixelFormatSize    = 32
+	ddsFlags              = 0x1 | 0x2 | 0x4 | 0x1000 | 0x80000 //DDSD_CAPS | DDSD_HEIGHT | DDSD_WIDTH | DDSD_PIXELFORMAT | DDSD_LINEARSIZE
+	ddsFourCC             = 0x4                                //DDPF_FOURCC
+	ddsCapsTexture        = 0x1000                             //DDSCAPS_TEXTURE
+	dxgiFormatBC5Typeless = 82                                 //DXGI_FORMAT_BC5_TYPELESS
+	dxgiFormatBC5UNorm    = 83                                 //DXGI_FORMAT_BC5_UNORM
+	ddsDimensionTexture2  = 3                                  //D3D10_RESOURCE_DIMENSION_TEXTURE2D
+)
+
+// EncodeDDS writes b to w as a DirectDraw Surface with a DX10 header declaring DXGI_FORMAT_BC5_UNORM,
+// which Direct3D, Vulkan loaders and most texture tools accept. Metadata is not stored.
+func EncodeDDS(b *BC5, w io.Writer) error {
+
+	width, height := b.Rect.Size().X, b.Rect.Size().Y
+	data := b.blockData()
+
+	header := []interface{}{
+		[4]byte{'D', 'D', 'S', ' '},
+		uint32(ddsHeaderSize),
+		uint32(ddsFlags),
+		uint32(height),
+		uint32(width),
+		uint32(len(data)), //Linear size of the top level
+		uint32(0),         //Depth
+		uint32(0),         //Mipmap count, 0 for a single level
+		[11]uint32{},      //Reserved
+		uint32(ddsPixelFormatSize),
+		uint32(ddsFourCC),
+		[4]byte{'D', 'X', '1', '0'},
+		[5]uint32{}, //RGB bit count and masks, unused with a FourCC
+		uint32(ddsCapsTexture),
+		[3]uint32{}, //Caps2 to Caps4
+		uint32(0),   //Reserved
+		uint32(dxgiFormatBC5UNorm),
+		uint32(ddsDimensionTexture2),
+		uint32(0), //Misc flags
+		uint32(1), //Array size
+		uint32(0), //Alpha mode, unknown
+	}
+	for _, v := range header {
+		if err := binary.Write(w, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+
+	n, err := w.Write(data)
+	if err != nil {
+		return err
+	}
+	if n != len(data) {
+		return errors.New("failed to write image data")
+	}
+	return nil
+}
+
+// Alias for the channel orders DecodeDDS can assume for ATI2 data.
+type ATI2Order int
+
+const (
+	ATI2Auto     ATI2Order = iota //Detect swapped data from its content, which suits normal maps.
+	ATI2Standard                  //Red block first, as in BC5.
+	ATI2Swapped                   //Green block first, as written by some early 3Dc tools.
+)
+
+// DecodeDDS reads the top level of a DirectDraw Surface holding BC5 data, declared either with a DX10
+// header as DXGI_FORMAT_BC5_UNORM or DXGI_FORMAT_BC5_TYPELESS, or with the legacy "ATI2" or "BC5U"
+// FourCC. Signed BC5 is not supported.
+//
+// Some tools from the 3Dc era wrote "ATI2" files with the green block of each pair first. order
+// selects how such files are read, and is ignored for the other declarations, which are unambiguous.
+// With ATI2Auto the channels are swapped if the data reads as a more consistent normal map that way,
+// which suits normal maps, the usual content of 3Dc files, but should not be relied on for anything
+// else.
+func DecodeDDS(r io.Reader, order ATI2Order) (*BC5, error) {
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 4+ddsHeaderSize || string(data[:4]) != "DDS " {
+		return nil, errors.New("invalid DDS signature")
+	}
+	header := data[4 : 4+ddsHeaderSize]
+	data = data[4+ddsHeaderSize:]
+
+	height, width := binary.LittleEndian.Uint32(header[8:]), binary.LittleEndian.Uint32(header[12:])
+	flags, fourCC := binary.LittleEndian.Uint32(header[76:]), string(header[80:84])
+	if flags&ddsFourCC == 0 {
+		return nil, errors.New("DDS pixel format is not BC5")
+	}
+	ati2 := false
+	switch fourCC {
+	case "DX10":
+		if len(data) < 20 {
+			return nil, errors.New("missing DX10 header")
+		}
+		if format := binary.LittleEndian.Uint32(data); format != dxgiFormatBC5UNorm && format != dxgiFormatBC5Typeless {
+			return nil, errors.New("DDS pixel format is not BC5")
+		}
+		data = data[20:]
+	case "ATI2":
+		ati2 = true
+	case "BC5U":
+	default:
+		return nil, errors.New("DDS pixel format is not BC5")
+	}
+
+	if width%4 != 0 || height%4 != 0 {
+		return nil, errors.New("size must be a multiple of 4")
+	}
+	size := int(width/4) * int(height/4) * 16
+	if len(data) < size {
+		return nil, errors.New("not enough data for image")
+	}
+
+	b := &BC5{Rect: image.Rect(0, 0, int(width), int(height)), Data: data[:size:size]}
+	if ati2 && (order == ATI2Swapped || order == ATI2Auto && b.DetectSwappedChannels().Swapped) {
+		swapBlockHalves(b.Data)
+	}
+	return b, nil
+}
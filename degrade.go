@@ -0,0 +1,119 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+	"io/ioutil"
+)
+
+// NeutralValue is the red and green value written over damaged blocks by DecodeTolerant, which decodes
+// as a flat +Z normal.
+const NeutralValue = 128
+
+// DecodeTolerant reads a BC5 like Decode, but replaces damaged parts of the image with NeutralValue
+// instead of failing, returning the rectangles that were replaced. Blocks missing from truncated data
+// are always replaced. If the metadata holds tile hashes, each tile whose data does not match its hash
+// is replaced too. Unreadable metadata is dropped. A mismatched whole image checksum cannot be traced
+// to any blocks, so the data is kept and the whole image is reported instead. Truncated data stored
+// in a layout other than Interleaved, or a damaged codebook, leaves no block intact, so the whole
+// image is replaced. An error is only returned if the header cannot be read.
+func DecodeTolerant(r io.Reader) (*BC5, []image.Rectangle, error) {
+
+	readBytes, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(readBytes) < 12 {
+		return nil, nil, errors.New("not enough data for BC5")
+	}
+
+	buf := bytes.NewBuffer(readBytes)
+	signature := binary.BigEndian.Uint32(buf.Next(4))
+	if signature != strToDword("BC5 ") && signature != strToDword("BC52") {
+		return nil, nil, errors.New("invalid file signature")
+	}
+	width := int(binary.BigEndian.Uint32(buf.Next(4)))
+	height := int(binary.BigEndian.Uint32(buf.Next(4)))
+	if width%4 != 0 || height%4 != 0 || width > MaxDimension || height > MaxDimension {
+		return nil, nil, errors.New("invalid image size")
+	}
+
+	img := &BC5{Rect: image.Rect(0, 0, width, height)}
+	if signature == strToDword("BC52") && buf.Len() >= 4 {
+		metaLen := int(binary.BigEndian.Uint32(buf.Next(4)))
+		if metaLen > buf.Len() {
+			metaLen = buf.Len()
+		}
+		img.Metadata, _ = unmarshalMetadata(buf.Next(metaLen))
+		delete(img.Metadata, MetaFEC)
+		img.restoreProfile()
+	}
+
+	var damaged []image.Rectangle
+	data := make([]byte, width/4*height/4*16)
+	if img.Layout() == Codebook || img.Supercompression() != KTX2None {
+		//Blocks are only found through the codebook or the inflated stream, so they are either all
+		//intact or none are
+		img.Data = buf.Bytes()
+		if err := img.restoreLayout(); err != nil {
+			damaged = append(damaged, img.Rect)
+			img.Data = data
+		}
+	} else {
+		n := copy(data, buf.Bytes())
+		img.Data = data
+		if n < len(data) && img.Layout() != Interleaved {
+			//Every block has a part in the last plane, so none of them are intact
+			damaged = append(damaged, img.Rect)
+			n = len(data)
+		}
+		for pos := n / 16 * 16; pos < len(data); pos += 16 {
+			x, y := (pos/16)%(width/4)*4, (pos/16)/(width/4)*4
+			damaged = append(damaged, image.Rect(x, y, x+4, y+4))
+		}
+		if err := img.restoreLayout(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	checksumFailed := false
+	if table, err := img.StoredHashTable(); err == nil && table != nil {
+		actual, _ := img.HashTable(table.TileSize)
+		for _, i := range table.Changed(actual) {
+			damaged = append(damaged, table.TileRect(i))
+		}
+	} else if ok, present := img.VerifyChecksum(); present && !ok {
+		checksumFailed = true
+	}
+
+	for _, rect := range damaged {
+		img.ClearRect(rect, NeutralValue)
+	}
+	if checksumFailed {
+		return img, []image.Rectangle{img.Rect}, nil
+	}
+	return img, mergeRects(damaged), nil
+}
+
+// merges each run of horizontally adjacent rectangles of the same height into one
+func mergeRects(rects []image.Rectangle) []image.Rectangle {
+
+	var out []image.Rectangle
+	for _, r := range rects {
+		if len(out) > 0 {
+			last := &out[len(out)-1]
+			if last.Max.X == r.Min.X && last.Min.Y == r.Min.Y && last.Max.Y == r.Max.Y {
+				last.Max.X = r.Max.X
+				continue
+			}
+		}
+		out = append(out, r)
+	}
+	return out
+}
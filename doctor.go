@@ -0,0 +1,111 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MaxDimension is the largest width or height Doctor considers sane.
+const MaxDimension = 65536
+
+// DoctorReport holds the results of scanning a directory of BC5 files with Doctor.
+type DoctorReport struct {
+	Files    []FileReport `json:"files"`
+	Problems int          `json:"problems"` //Number of files with at least one problem.
+}
+
+// FileReport holds the results of checking a single BC5 file.
+type FileReport struct {
+	Path      string   `json:"path"`
+	Width     int      `json:"width"`
+	Height    int      `json:"height"`
+	NaNBlocks int      `json:"nanBlocks"` //Blocks producing NaN blue values under ComputeNormal.
+	Problems  []string `json:"problems,omitempty"`
+}
+
+// Doctor walks dir and checks every file with a ".bc5" extension, reporting header and data size
+// inconsistencies, insane dimensions, checksum mismatches and blocks that produce NaN blue values
+// under ComputeNormal. The report is suitable for encoding as JSON. An error is only returned if
+// the directory could not be walked; problems with individual files are recorded in the report.
+func Doctor(dir string) (*DoctorReport, error) {
+
+	report := &DoctorReport{Files: []FileReport{}}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.EqualFold(filepath.Ext(path), ".bc5") {
+			return nil
+		}
+
+		file := CheckFile(path)
+		if len(file.Problems) > 0 {
+			report.Problems++
+		}
+		report.Files = append(report.Files, file)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// CheckFile performs the checks of Doctor on a single file.
+func CheckFile(path string) FileReport {
+
+	report := FileReport{Path: path}
+	img, err := NewBC5FromFile(path)
+	if err != nil {
+		report.Problems = append(report.Problems, err.Error())
+		return report
+	}
+
+	report.Width, report.Height = img.Rect.Size().X, img.Rect.Size().Y
+	if report.Width <= 0 || report.Height <= 0 || report.Width > MaxDimension || report.Height > MaxDimension {
+		report.Problems = append(report.Problems, fmt.Sprintf("insane dimensions %dx%d", report.Width, report.Height))
+		return report
+	}
+	if report.Width%4 != 0 || report.Height%4 != 0 {
+		report.Problems = append(report.Problems, fmt.Sprintf("dimensions %dx%d are not multiples of 4", report.Width, report.Height))
+		return report
+	}
+	if expected := report.Width * report.Height; len(img.Data) != expected {
+		report.Problems = append(report.Problems, fmt.Sprintf("header expects %d bytes of block data, found %d", expected, len(img.Data)))
+		return report
+	}
+	if ok, present := img.VerifyChecksum(); present && !ok {
+		report.Problems = append(report.Problems, "checksum mismatch")
+	}
+
+	report.NaNBlocks = img.nanBlocks()
+	if report.NaNBlocks > 0 {
+		report.Problems = append(report.Problems, fmt.Sprintf("%d blocks produce NaN under ComputeNormal", report.NaNBlocks))
+	}
+	return report
+}
+
+// returns the number of blocks containing a pixel whose red and green values describe a vector longer
+// than 1, leaving no real blue component for ComputeNormal
+func (b BC5) nanBlocks() int {
+
+	count := 0
+	b.eachBlock(func(x, y int, block []byte) {
+		r := generatePalette(normalize(block[0]), normalize(block[1]))
+		g := generatePalette(normalize(block[8]), normalize(block[9]))
+		rIndices, gIndices := getIndices(block[2:8]), getIndices(block[10:])
+		for i := 0; i < 16; i++ {
+			if math.IsNaN(computeBlue(r[rIndices[i]], g[gIndices[i]], ComputeNormal)) {
+				count++
+				return
+			}
+		}
+	})
+	return count
+}
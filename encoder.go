@@ -0,0 +1,159 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io"
+)
+
+// Encoder compresses an image one row of blocks at a time and writes it to an io.Writer as it goes, in
+// the same format as Encode, so images too large to hold in memory can be compressed from a source
+// read in strips. Only a row of blocks, and the preceding RDOWindow blocks when RDO is set, are held
+// at once. The output is identical to compressing the whole image with SetFromRGBAWithOptions and
+// writing it with Encode.
+type Encoder struct {
+	w       io.Writer
+	size    image.Point //Size of the source, before any padding.
+	opts    EncodeOptions
+	quality Quality
+	row     int    //Next block row to be written.
+	history []byte //The preceding blocks searched by RDO.
+	block   *image.RGBA
+	timer   *stageTimer
+}
+
+// NewEncoder writes the header for a width by height image compressed with opts, which may be nil, to
+// w and returns an Encoder for its rows of blocks. The options are checked as ValidateFor does.
+// FixTiling and ChannelStats are not supported, as they need the whole image, and blocks are
+// compressed on the calling goroutine whatever the Workers option.
+func NewEncoder(w io.Writer, width, height int, opts *EncodeOptions) (*Encoder, error) {
+
+	if opts == nil {
+		opts = &EncodeOptions{}
+	}
+	if width <= 0 || height <= 0 {
+		return nil, errors.New("invalid image size")
+	}
+	if opts.FixTiling {
+		return nil, errors.New("FixTiling is not supported when encoding rows")
+	}
+	if opts.ChannelStats {
+		return nil, errors.New("ChannelStats is not supported when encoding rows")
+	}
+	if err := opts.ValidateFor(&image.RGBA{Rect: image.Rect(0, 0, width, height)}); err != nil {
+		return nil, err
+	}
+	quality, profile, _ := opts.resolve()
+
+	header := &BC5{}
+	padded := image.Pt(alignUp(width, 4), alignUp(height, 4))
+	header.recordPadding(image.Pt(width, height), padded)
+	if profile != nil {
+		header.recordProfile(opts.Profile, profile)
+	}
+	if opts.Record {
+		if err := header.recordOptions(opts); err != nil {
+			return nil, err
+		}
+	}
+	if err := writeHeader(w, padded, header.Metadata); err != nil {
+		return nil, err
+	}
+
+	return &Encoder{
+		w:       w,
+		size:    image.Pt(width, height),
+		opts:    *opts,
+		quality: quality,
+		block:   image.NewRGBA(image.Rect(0, 0, 4, 4)),
+		timer:   newStageTimer(opts.OnStage),
+	}, nil
+}
+
+// Rows returns the number of rows of blocks in the image, each 4 pixels high.
+func (e *Encoder) Rows() int {
+
+	return alignUp(e.size.Y, 4) / 4
+}
+
+// WriteBlockRow compresses rgba as the next row of blocks and writes it. Its width must be the width
+// of the image and its height 4, or whatever remains of the image for the last row when PadEdges is
+// set. Its bounds may be positioned anywhere.
+func (e *Encoder) WriteBlockRow(rgba *image.RGBA) error {
+
+	if e.row >= e.Rows() {
+		return errors.New("all block rows have been written")
+	}
+	if want := image.Pt(e.size.X, clampInt(e.size.Y-e.row*4, 0, 4)); rgba.Rect.Size() != want {
+		return fmt.Errorf("block row %d must be %dx%d, not %dx%d", e.row, want.X, want.Y, rgba.Rect.Dx(), rgba.Rect.Dy())
+	}
+	if rgba.Rect.Dx()%4 != 0 || rgba.Rect.Dy() != 4 {
+		rgba = padEdges(rgba)
+	}
+	e.timer.mark(StageSplit)
+
+	region := traceRegion("compress")
+	blocksPerRow := rgba.Rect.Dx() / 4
+	data := make([]byte, len(e.history)+blocksPerRow*16)
+	copy(data, e.history)
+	row := data[len(e.history):]
+	reused := make([]bool, blocksPerRow)
+	for bx := 0; bx < blocksPerRow; bx++ {
+		pos := bx * 16
+		loadBlock(e.block, rgba, rgba.Rect.Min.X+bx*4, rgba.Rect.Min.Y)
+		e.timer.mark(StageSplit)
+		if e.opts.Previous != nil {
+			prevIx := e.opts.Previous.blockOffset(bx*4, e.row*4)
+			prev := e.opts.Previous.Data[prevIx : prevIx+16]
+			matched := blockMatches(e.block, prev, e.opts.Tolerance)
+			e.timer.mark(StageEndpoints)
+			if matched {
+				copy(row[pos:pos+16], prev)
+				reused[bx] = true
+				continue
+			}
+		}
+		copy(row[pos:pos+16], encodeBlock(e.block, e.row*blocksPerRow+bx, e.quality, &e.opts, e.timer))
+	}
+	if e.opts.RDO > 0 {
+		for bx := 0; bx < blocksPerRow; bx++ {
+			if reused[bx] {
+				continue
+			}
+			loadBlock(e.block, rgba, rgba.Rect.Min.X+bx*4, rgba.Rect.Min.Y)
+			e.timer.mark(StageSplit)
+			rdoBlock(data, len(e.history)+bx*16, e.block, e.opts.RDO, e.opts.rdoWindow())
+			e.timer.mark(StageIndices)
+		}
+		//Keep only the blocks the next row can reach
+		keep := clampInt(e.opts.rdoWindow()*16, 0, len(data))
+		e.history = append(e.history[:0], data[len(data)-keep:]...)
+	}
+	region.End()
+
+	region = traceRegion(StageSerialize.String())
+	_, err := e.w.Write(row)
+	region.End()
+	if err != nil {
+		return err
+	}
+	e.timer.mark(StageSerialize)
+	e.row++
+	return nil
+}
+
+// Close reports the time spent in each stage to the OnStage option, if set. An error is returned if
+// any rows of blocks have not been written, leaving the output incomplete. The underlying writer is
+// not closed.
+func (e *Encoder) Close() error {
+
+	e.timer.report()
+	if e.row < e.Rows() {
+		return fmt.Errorf("%d of %d block rows not written", e.Rows()-e.row, e.Rows())
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+// EndpointMultiples returns an endpoint set for EncodeOptions.Endpoints holding every multiple of n
+// from 0 to 255, such as multiples of 8 to help palettize the output further down the line.
+func EndpointMultiples(n int) []byte {
+
+	var set []byte
+	for v := 0; n > 0 && v < 256; v += n {
+		set = append(set, byte(v))
+	}
+	return set
+}
+
+// EndpointsExcept returns an endpoint set for EncodeOptions.Endpoints holding every value except
+// those given, such as 0 and 255 for decoders that mishandle the extremes.
+func EndpointsExcept(values ...byte) []byte {
+
+	var excluded [256]bool
+	for _, v := range values {
+		excluded[v] = true
+	}
+	var set []byte
+	for v := 0; v < 256; v++ {
+		if !excluded[v] {
+			set = append(set, byte(v))
+		}
+	}
+	return set
+}
+
+// endpointSet holds the reference values the encoder may choose from, in ascending order
+type endpointSet []byte
+
+// returns the sorted distinct values of set, or nil if any value is allowed
+func newEndpointSet(set []byte) endpointSet {
+
+	if set == nil {
+		return nil
+	}
+	var allowed [256]bool
+	for _, v := range set {
+		allowed[v] = true
+	}
+	s := make(endpointSet, 0, len(set))
+	for v := range allowed {
+		if allowed[v] {
+			s = append(s, byte(v))
+		}
+	}
+	return s
+}
+
+// returns the position in s of the largest value not above v, or of the smallest value if all are
+func (s endpointSet) floor(v byte) int {
+
+	i := 0
+	for i+1 < len(s) && s[i+1] <= v {
+		i++
+	}
+	return i
+}
+
+// returns the value of s closest to v
+func (s endpointSet) nearest(v int) byte {
+
+	b := byte(clampInt(v, 0, 255))
+	i := s.floor(b)
+	if i+1 < len(s) && absDiff(s[i+1], b) < absDiff(s[i], b) {
+		return s[i+1]
+	}
+	return s[i]
+}
+
+// returns the reference values from s giving the lowest error, as measured by fit, for the 16 values of
+// a block channel. Pairs around the lowest and highest values are tried, widening with quality, along
+// with the single value closest to their midpoint as a constant channel.
+func (s endpointSet) search(values [16]byte, quality Quality, fit channelFit) (byte, byte) {
+
+	var lo, hi byte = 255, 0
+	for _, v := range values {
+		if v < lo {
+			lo = v
+		}
+		if v > hi {
+			hi = v
+		}
+	}
+
+	c := s.nearest((int(lo) + int(hi) + 1) / 2)
+	best0, best1 := c, c
+	_, bestErr := fit(values, c, c)
+
+	reach := 1
+	if quality >= QualityHigh {
+		reach = 3
+	}
+	candidates := func(v byte) []byte {
+		i := s.floor(v)
+		return s[clampInt(i-reach+1, 0, len(s)):clampInt(i+reach+1, 0, len(s))]
+	}
+	try := func(c0, c1 byte) {
+		if c0 == c1 {
+			return
+		}
+		if _, err := fit(values, c0, c1); err < bestErr {
+			best0, best1, bestErr = c0, c1, err
+		}
+	}
+	for _, a := range candidates(lo) {
+		for _, b := range candidates(hi) {
+			try(a, b)
+			if quality >= QualityNormal {
+				try(b, a)
+			}
+		}
+	}
+	return best0, best1
+}
@@ -0,0 +1,77 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import "math"
+
+// ErrorModel returns the cost of a candidate encoding of one channel of a block, lower being better.
+// Texels holds the red and green source values of the 16 pixels of the block, from the top left in
+// rows, and ch is RedChannel or GreenChannel. Pixel i decodes to palette[indices[i]]. The endpoint
+// searches of every quality keep the candidate with the lowest cost, so metrics suited to the data,
+// such as AngularError for normal maps, can replace the default squared error. It must be safe to
+// call from several goroutines at once.
+type ErrorModel func(texels [2][16]byte, ch Channel, palette [8]byte, indices [16]int) float64
+
+// AngularError is an ErrorModel for tangent space normal maps. It sums the squared angle in degrees
+// between the normal of each source pixel and the normal decoded from the candidate, with the other
+// channel taken from the source, as each channel is encoded independently.
+func AngularError(texels [2][16]byte, ch Channel, palette [8]byte, indices [16]int) float64 {
+
+	sum := 0.0
+	for i := 0; i < 16; i++ {
+		r, g := texels[0][i], texels[1][i]
+		dr, dg := r, g
+		if ch == RedChannel {
+			dr = palette[indices[i]]
+		} else {
+			dg = palette[indices[i]]
+		}
+		a := normalAngle(unpackNormal(r, g), unpackNormal(dr, dg))
+		sum += a * a
+	}
+	return sum
+}
+
+// returns the unit normal stored as red and green values r and g, with z reconstructed as ComputeNormal
+// does
+func unpackNormal(r, g byte) [3]float64 {
+
+	x, y := 2*normalize(r)-1, 2*normalize(g)-1
+	z := math.Sqrt(math.Max(0, 1-x*x-y*y))
+	l := math.Sqrt(x*x + y*y + z*z)
+	return [3]float64{x / l, y / l, z / l}
+}
+
+// returns the angle between unit vectors a and b in degrees
+func normalAngle(a, b [3]float64) float64 {
+
+	dot := a[0]*b[0] + a[1]*b[1] + a[2]*b[2]
+	return math.Acos(math.Max(-1, math.Min(1, dot))) * 180 / math.Pi
+}
+
+// returns the palette indices closest to the values of a block channel for reference values c0 and c1,
+// along with their cost, as fitChannel does
+type channelFit func(values [16]byte, c0, c1 byte) ([16]int, float64)
+
+// returns a channelFit scoring candidates with model for channel ch of a block with the given texels,
+// or fitChannel if model is nil
+func modelFit(model ErrorModel, texels [2][16]byte, ch Channel) channelFit {
+
+	if model == nil {
+		return fitChannel
+	}
+	return func(values [16]byte, c0, c1 byte) ([16]int, float64) {
+
+		indices, _ := fitChannel(values, c0, c1)
+		var palette [8]byte
+		pal := generatePalette(normalize(c0), normalize(c1))
+		for i := range palette {
+			palette[i] = c0
+			if c0 != c1 {
+				palette[i] = denormalize(pal[i])
+			}
+		}
+		return indices, model(texels, ch, palette, indices)
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"fmt"
+	"image/png"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// ExportOptions holds settings for ExportPNG.
+type ExportOptions struct {
+	Recursive bool      //Descend into subdirectories of the source directory.
+	Workers   int       //Number of files converted at once, defaults to GOMAXPROCS.
+	BlueMode  BlueMode  //Blue mode used when decompressing each file.
+	ATI2Order ATI2Order //Channel order assumed for ATI2 data in ".dds" files, see DecodeDDS.
+}
+
+// ExportPNG decompresses every ".bc5" file, and every ".dds" file holding BC5 data, in srcDir to a PNG
+// with the same relative path and base name in dstDir, creating directories as needed. Files are converted in parallel. If any files
+// fail to convert, the remaining files are still exported and an error listing the failures is returned.
+func ExportPNG(srcDir, dstDir string, opts *ExportOptions) error {
+
+	if opts == nil {
+		opts = &ExportOptions{}
+	}
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	var files []string
+	err := filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != srcDir && !opts.Recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ext := filepath.Ext(path); strings.EqualFold(ext, ".bc5") || strings.EqualFold(ext, ".dds") {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	paths := make(chan string)
+	var mu sync.Mutex
+	var failed []string
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range paths {
+				if err := exportFile(srcDir, dstDir, path, opts); err != nil {
+					mu.Lock()
+					failed = append(failed, fmt.Sprintf("%s: %v", path, err))
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, path := range files {
+		paths <- path
+	}
+	close(paths)
+	wg.Wait()
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to export %d files:\n%s", len(failed), strings.Join(failed, "\n"))
+	}
+	return nil
+}
+
+// decompresses the BC5 or DDS file at path into a PNG at the same position relative to dstDir
+func exportFile(srcDir, dstDir, path string, opts *ExportOptions) error {
+
+	rel, err := filepath.Rel(srcDir, path)
+	if err != nil {
+		return err
+	}
+	out := filepath.Join(dstDir, strings.TrimSuffix(rel, filepath.Ext(rel))+".png")
+	if err := os.MkdirAll(filepath.Dir(out), 0755); err != nil {
+		return err
+	}
+
+	img, err := readExportFile(path, opts.ATI2Order)
+	if err != nil {
+		return err
+	}
+	img.BlueMode = opts.BlueMode
+
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img.Decompress()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// reads the file at path with DecodeDDS if it has a ".dds" extension, or as a BC5 file otherwise
+func readExportFile(path string, order ATI2Order) (*BC5, error) {
+
+	if !strings.EqualFold(filepath.Ext(path), ".dds") {
+		return NewBC5FromFile(path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return DecodeDDS(f, order)
+}
@@ -0,0 +1,384 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// MetaFEC is the metadata key marking a file written by EncodeFEC, holding the data shard count,
+// parity shard count, shard size and length of the stored block data separated by commas. Files
+// written before the length was recorded hold only the first three, their data always being
+// interleaved.
+const MetaFEC = "fec"
+
+// FECOptions configures the Reed-Solomon parity written by EncodeFEC. The block data is split into
+// shards of ShardSize bytes, and every group of DataShards shards gets ParityShards parity shards,
+// any ParityShards of which can be rebuilt if lost. The size overhead is ParityShards/DataShards.
+type FECOptions struct {
+	DataShards   int
+	ParityShards int
+	ShardSize    int
+}
+
+// DefaultFEC can repair two damaged kilobytes in every sixteen, for an overhead of 12.5%.
+var DefaultFEC = FECOptions{DataShards: 16, ParityShards: 2, ShardSize: 1024}
+
+// EncodeFEC writes img to w like Encode, followed by Reed-Solomon parity shards and a CRC-32 of every
+// shard, so that DecodeFEC can repair damage to the block data. Decode and DecodeTolerant read the file
+// without repairing it, dropping the parity and the MetaFEC key as if it had been written by Encode.
+func EncodeFEC(img *BC5, w io.Writer, opts FECOptions) error {
+
+	if err := opts.validate(); err != nil {
+		return err
+	}
+	if img.Supercompression() != KTX2None {
+		return errors.New("supercompressed data cannot be protected by FEC")
+	}
+
+	meta := make(map[string]string, len(img.Metadata)+1)
+	for k, v := range img.Metadata {
+		meta[k] = v
+	}
+	data := img.storedData()
+	meta[MetaFEC] = fmt.Sprintf("%d,%d,%d,%d", opts.DataShards, opts.ParityShards, opts.ShardSize, len(data))
+	if err := writeHeader(w, img.Rect.Size(), meta); err != nil {
+		return err
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+
+	shards := opts.shards(data)
+	matrix := cauchyMatrix(opts.DataShards, opts.ParityShards)
+	var sums []byte
+	for g := 0; g < len(shards); g += opts.DataShards {
+		group := shards[g : g+opts.DataShards]
+		for _, shard := range group {
+			sums = appendCRC(sums, shard)
+		}
+		for _, row := range matrix {
+			parity := make([]byte, opts.ShardSize)
+			for j, shard := range group {
+				gfMulAdd(parity, shard, row[j])
+			}
+			if _, err := w.Write(parity); err != nil {
+				return err
+			}
+			sums = appendCRC(sums, parity)
+		}
+	}
+	_, err := w.Write(sums)
+	return err
+}
+
+// removes the parity and checksums EncodeFEC appends from the data of a decoded b, along with the
+// metadata describing them
+func (b *BC5) dropFEC() {
+
+	v, ok := b.Metadata[MetaFEC]
+	if !ok {
+		return
+	}
+	delete(b.Metadata, MetaFEC)
+	if _, n, err := b.fecParams(v); err == nil && len(b.Data) > n {
+		b.Data = b.Data[:n:n]
+	}
+}
+
+// returns the options and stored data length recorded in the MetaFEC value v of b
+func (b BC5) fecParams(v string) (FECOptions, int, error) {
+
+	fields := strings.Split(v, ",")
+	if len(fields) != 3 && len(fields) != 4 {
+		return FECOptions{}, 0, errors.New("malformed fec metadata")
+	}
+	values := make([]int, len(fields))
+	for i, f := range fields {
+		n, err := strconv.Atoi(f)
+		if err != nil {
+			return FECOptions{}, 0, errors.New("malformed fec metadata")
+		}
+		values[i] = n
+	}
+	opts := FECOptions{DataShards: values[0], ParityShards: values[1], ShardSize: values[2]}
+	if err := opts.validate(); err != nil {
+		return FECOptions{}, 0, err
+	}
+	dataLen := b.Rect.Size().X / 4 * b.Rect.Size().Y / 4 * 16
+	if len(values) == 4 {
+		dataLen = values[3]
+	}
+	if dataLen < 0 {
+		return FECOptions{}, 0, errors.New("malformed fec metadata")
+	}
+	return opts, dataLen, nil
+}
+
+// DecodeFEC reads a BC5 from r like Decode. If the file was written by EncodeFEC, damaged shards of the
+// block data are found by their checksums and rebuilt from the parity, returning the number of shards
+// repaired. An error is returned if a group has more damaged shards than it has parity.
+func DecodeFEC(r io.Reader) (*BC5, int, error) {
+
+	img, err := decodeStored(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	v, ok := img.Metadata[MetaFEC]
+	if !ok {
+		if err := img.restoreLayout(); err != nil {
+			return nil, 0, err
+		}
+		if err := img.checkDataSize(); err != nil {
+			return nil, 0, err
+		}
+		return img, 0, nil
+	}
+	delete(img.Metadata, MetaFEC)
+
+	opts, dataLen, err := img.fecParams(v)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(img.Data) < dataLen {
+		return nil, 0, errors.New("not enough image data")
+	}
+	data, trailer := img.Data[:dataLen:dataLen], img.Data[dataLen:]
+	img.Data = data
+
+	shards := opts.shards(data)
+	groups := len(shards) / opts.DataShards
+	perGroup := opts.DataShards + opts.ParityShards
+	parityLen := groups * opts.ParityShards * opts.ShardSize
+	if len(trailer) < parityLen+groups*perGroup*4 {
+		return nil, 0, errors.New("fec parity is truncated")
+	}
+	sums := trailer[parityLen:]
+
+	matrix := cauchyMatrix(opts.DataShards, opts.ParityShards)
+	repaired := 0
+	for g := 0; g < groups; g++ {
+		all := make([][]byte, perGroup)
+		copy(all, shards[g*opts.DataShards:(g+1)*opts.DataShards])
+		for i := 0; i < opts.ParityShards; i++ {
+			pos := (g*opts.ParityShards + i) * opts.ShardSize
+			all[opts.DataShards+i] = trailer[pos : pos+opts.ShardSize]
+		}
+
+		var lost []int
+		for i, shard := range all {
+			if crc32.ChecksumIEEE(shard) != binary.BigEndian.Uint32(sums[(g*perGroup+i)*4:]) {
+				lost = append(lost, i)
+			}
+		}
+		if len(lost) == 0 {
+			continue
+		}
+		if len(lost) > opts.ParityShards {
+			return nil, repaired, fmt.Errorf("fec group %d has %d damaged shards, at most %d can be repaired", g, len(lost), opts.ParityShards)
+		}
+		if err := recoverShards(all, lost, matrix, opts.DataShards); err != nil {
+			return nil, repaired, err
+		}
+		repaired += len(lost)
+	}
+
+	//Shards at the end of the data were padded copies, so write them back
+	for i := 0; i*opts.ShardSize < dataLen; i++ {
+		copy(data[i*opts.ShardSize:], shards[i])
+	}
+	if err := img.restoreLayout(); err != nil {
+		return nil, repaired, err
+	}
+	if err := img.checkDataSize(); err != nil {
+		return nil, repaired, err
+	}
+	return img, repaired, nil
+}
+
+// checks that o describes a usable code
+func (o FECOptions) validate() error {
+
+	if o.DataShards <= 0 || o.ParityShards <= 0 || o.ShardSize <= 0 {
+		return errors.New("fec shard counts and size must be positive")
+	}
+	if o.DataShards+o.ParityShards > 256 {
+		return errors.New("fec groups are limited to 256 shards")
+	}
+	return nil
+}
+
+// splits data into shards, padding the last with zeros and adding zero shards to fill the last group
+func (o FECOptions) shards(data []byte) [][]byte {
+
+	count := (len(data) + o.ShardSize - 1) / o.ShardSize
+	count = (count + o.DataShards - 1) / o.DataShards * o.DataShards
+	shards := make([][]byte, count)
+	for i := range shards {
+		start, end := i*o.ShardSize, (i+1)*o.ShardSize
+		if end <= len(data) {
+			shards[i] = data[start:end]
+			continue
+		}
+		shards[i] = make([]byte, o.ShardSize)
+		if start < len(data) {
+			copy(shards[i], data[start:])
+		}
+	}
+	return shards
+}
+
+// rebuilds the lost shards of a group in place. all holds the data shards followed by the parity shards.
+func recoverShards(all [][]byte, lost []int, matrix [][]byte, k int) error {
+
+	isLost := make(map[int]bool, len(lost))
+	for _, i := range lost {
+		isLost[i] = true
+	}
+
+	//Any k intact shards determine the data, each being a known combination of the data shards
+	rows := make([][]byte, 0, k)
+	inputs := make([][]byte, 0, k)
+	for i := 0; i < len(all) && len(rows) < k; i++ {
+		if isLost[i] {
+			continue
+		}
+		row := make([]byte, k)
+		if i < k {
+			row[i] = 1
+		} else {
+			copy(row, matrix[i-k])
+		}
+		rows = append(rows, row)
+		inputs = append(inputs, all[i])
+	}
+	inverse, err := gfInvert(rows)
+	if err != nil {
+		return err
+	}
+
+	for _, i := range lost {
+		if i >= k {
+			continue
+		}
+		out := make([]byte, len(all[i]))
+		for j, input := range inputs {
+			gfMulAdd(out, input, inverse[i][j])
+		}
+		copy(all[i], out)
+	}
+	return nil
+}
+
+// appends the big endian CRC-32 of b to sums
+func appendCRC(sums, b []byte) []byte {
+
+	sum := make([]byte, 4)
+	binary.BigEndian.PutUint32(sum, crc32.ChecksumIEEE(b))
+	return append(sums, sum...)
+}
+
+// GF(256) tables for the polynomial x^8+x^4+x^3+x^2+1
+var gfExp, gfLog = func() ([512]byte, [256]byte) {
+
+	var exp [512]byte
+	var log [256]byte
+	x := 1
+	for i := 0; i < 255; i++ {
+		exp[i], exp[i+255] = byte(x), byte(x)
+		log[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	return exp, log
+}()
+
+// returns a*b in GF(256)
+func gfMul(a, b byte) byte {
+
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+// returns 1/a in GF(256), a must not be zero
+func gfInv(a byte) byte {
+
+	return gfExp[255-int(gfLog[a])]
+}
+
+// adds c*src to dst in GF(256)
+func gfMulAdd(dst, src []byte, c byte) {
+
+	if c == 0 {
+		return
+	}
+	for i, v := range src {
+		dst[i] ^= gfMul(v, c)
+	}
+}
+
+// returns the m by k Cauchy matrix generating the parity shards. Every square submatrix of a Cauchy
+// matrix is invertible, so any k shards of a group are enough to rebuild the rest.
+func cauchyMatrix(k, m int) [][]byte {
+
+	matrix := make([][]byte, m)
+	for i := range matrix {
+		matrix[i] = make([]byte, k)
+		for j := range matrix[i] {
+			matrix[i][j] = gfInv(byte(k+i) ^ byte(j))
+		}
+	}
+	return matrix
+}
+
+// returns the inverse of the square matrix m by Gauss-Jordan elimination, leaving m unchanged
+func gfInvert(m [][]byte) ([][]byte, error) {
+
+	n := len(m)
+	a := make([][]byte, n)
+	inv := make([][]byte, n)
+	for i := range m {
+		a[i] = append([]byte(nil), m[i]...)
+		inv[i] = make([]byte, n)
+		inv[i][i] = 1
+	}
+
+	for col := 0; col < n; col++ {
+		pivot := col
+		for pivot < n && a[pivot][col] == 0 {
+			pivot++
+		}
+		if pivot == n {
+			return nil, errors.New("fec matrix is singular")
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		inv[col], inv[pivot] = inv[pivot], inv[col]
+
+		scale := gfInv(a[col][col])
+		for j := 0; j < n; j++ {
+			a[col][j] = gfMul(a[col][j], scale)
+			inv[col][j] = gfMul(inv[col][j], scale)
+		}
+		for row := 0; row < n; row++ {
+			if row == col || a[row][col] == 0 {
+				continue
+			}
+			f := a[row][col]
+			gfMulAdd(a[row], a[col], f)
+			gfMulAdd(inv[row], inv[col], f)
+		}
+	}
+	return inv, nil
+}
@@ -0,0 +1,110 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"container/list"
+	"errors"
+	"image"
+	"sync"
+)
+
+// TileFetcher decodes square tiles of a mip chain to plain RG8 bytes, keeping recently used tiles in a
+// least recently used cache. It suits software rasterizers and occlusion systems which sweep through
+// texture space coherently and revisit the same tiles many times. It is safe for concurrent use.
+type TileFetcher struct {
+	Levels     []*BC5 //Mip chain ordered from the base level down, such as from NewMipChain.
+	TileSize   int    //Width and height of a tile in pixels, a multiple of 4. Defaults to 64.
+	CacheTiles int    //Maximum number of decoded tiles kept, 64 if zero.
+
+	mu    sync.Mutex
+	tiles map[tileKey]*list.Element
+	lru   *list.List
+}
+
+// identifies a tile of a TileFetcher
+type tileKey struct {
+	level, tx, ty int
+}
+
+// a cached tile
+type fetchedTile struct {
+	key  tileKey
+	data []byte
+}
+
+// NewTileFetcher returns a TileFetcher for levels with the default tile and cache sizes.
+func NewTileFetcher(levels ...*BC5) *TileFetcher {
+
+	return &TileFetcher{Levels: levels}
+}
+
+// FetchTile decodes tile (tx,ty) of the given level into dst as red and green byte pairs, TileSize
+// pairs to a row, and returns the pixels of the level it covers. Tiles at the right and bottom edges
+// of a level, or of a level smaller than a tile, cover less than a whole tile and the rest of dst is
+// zeroed. dst must hold at least TileSize*TileSize*2 bytes.
+func (f *TileFetcher) FetchTile(level, tx, ty int, dst []byte) (image.Rectangle, error) {
+
+	size := f.TileSize
+	if size == 0 {
+		size = 64
+	}
+	if size < 0 || size%4 != 0 {
+		return image.Rectangle{}, errors.New("tile size must be a positive multiple of 4")
+	}
+	if len(dst) < size*size*2 {
+		return image.Rectangle{}, errors.New("destination too small for a tile")
+	}
+	if level < 0 || level >= len(f.Levels) {
+		return image.Rectangle{}, errors.New("level out of range")
+	}
+	b := f.Levels[level]
+	levelSize := b.Rect.Size()
+	r := image.Rect(tx*size, ty*size, (tx+1)*size, (ty+1)*size).Intersect(image.Rect(0, 0, levelSize.X, levelSize.Y))
+	if tx < 0 || ty < 0 || r.Empty() {
+		return image.Rectangle{}, errors.New("tile out of range")
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.tiles == nil {
+		f.tiles = make(map[tileKey]*list.Element)
+		f.lru = list.New()
+	}
+
+	key := tileKey{level, tx, ty}
+	if e, ok := f.tiles[key]; ok {
+		f.lru.MoveToFront(e)
+		copy(dst, e.Value.(*fetchedTile).data)
+		return r, nil
+	}
+
+	data := make([]byte, size*size*2)
+	for by := r.Min.Y; by < r.Max.Y; by += 4 {
+		for bx := r.Min.X; bx < r.Max.X; bx += 4 {
+			pos := b.blockOffset(bx, by)
+			block := decompressBlock(b.Data[pos:pos+16], Zero, Swizzle{})
+			for y := 0; y < 4; y++ {
+				row := ((by-r.Min.Y+y)*size + bx - r.Min.X) * 2
+				for x := 0; x < 4; x++ {
+					c := block.RGBAAt(x, y)
+					data[row+x*2], data[row+x*2+1] = c.R, c.G
+				}
+			}
+		}
+	}
+	copy(dst, data)
+
+	f.tiles[key] = f.lru.PushFront(&fetchedTile{key: key, data: data})
+	limit := f.CacheTiles
+	if limit <= 0 {
+		limit = 64
+	}
+	for f.lru.Len() > limit {
+		oldest := f.lru.Back()
+		f.lru.Remove(oldest)
+		delete(f.tiles, oldest.Value.(*fetchedTile).key)
+	}
+	return r, nil
+}
@@ -0,0 +1,97 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+)
+
+// MetaFlowMax is the metadata key holding the largest speed along each axis of a flow map, which
+// red and green values of 0 and 255 map to the negative and positive of.
+const MetaFlowMax = "flowmax"
+
+// NewBC5FromFlow compresses a flow or velocity field of width by height vectors, given as X and Y
+// pairs in row order, and records max in the metadata so the velocities can be recovered by FlowAt.
+// Components are mapped from -max to max onto 0 to 255, clamping any beyond. If max is zero, the
+// largest component of the field is used. opts defaults to the "flow" profile if nil.
+func NewBC5FromFlow(width, height int, field []float32, max float64, opts *EncodeOptions) (*BC5, error) {
+
+	if width < 0 || height < 0 || len(field) != width*height*2 {
+		return nil, errors.New("field length does not match size")
+	}
+	if max == 0 {
+		for _, v := range field {
+			max = math.Max(max, math.Abs(float64(v)))
+		}
+	}
+	if max <= 0 || math.IsInf(max, 0) || math.IsNaN(max) {
+		return nil, errors.New("flow range must be positive and finite")
+	}
+	if opts == nil {
+		opts = &EncodeOptions{Profile: "flow"}
+	}
+
+	encode := func(v float32) uint8 {
+		return uint8(clampInt(int(math.Floor((float64(v)/max+1)/2*255+0.5)), 0, 255))
+	}
+	rgba := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := 0; i < width*height; i++ {
+		rgba.SetRGBA(i%width, i/width, color.RGBA{R: encode(field[i*2]), G: encode(field[i*2+1]), A: 255})
+	}
+
+	b := new(BC5)
+	if err := b.SetFromRGBAWithOptions(rgba, opts); err != nil {
+		return nil, err
+	}
+	b.setMeta(MetaFlowMax, strconv.FormatFloat(max, 'g', -1, 64))
+	return b, nil
+}
+
+// FlowMax returns the flow range recorded in the metadata of b, and false if there is none.
+func (b BC5) FlowMax() (float64, bool) {
+
+	max, err := strconv.ParseFloat(b.Metadata[MetaFlowMax], 64)
+	if err != nil || max <= 0 {
+		return 0, false
+	}
+	return max, true
+}
+
+// FlowAt returns the velocity at (x,y) of a flow map. Without a recorded flow range the components are
+// returned from -1 to 1. As 8 bit values have no exact middle, a still pixel decodes to within
+// max/255 of zero.
+func (b BC5) FlowAt(x, y int) (float64, float64) {
+
+	c := b.RGBAAt(x, y)
+	return flowComponent(c.R, b), flowComponent(c.G, b)
+}
+
+// Flow decodes every velocity of a flow map, as X and Y pairs in row order.
+func (b BC5) Flow() []float32 {
+
+	size := b.ContentSize()
+	img := b.decompressRect(image.Rectangle{Max: size})
+	field := make([]float32, 0, size.X*size.Y*2)
+	for y := 0; y < size.Y; y++ {
+		for x := 0; x < size.X; x++ {
+			c := img.RGBAAt(img.Rect.Min.X+x, img.Rect.Min.Y+y)
+			field = append(field, float32(flowComponent(c.R, b)), float32(flowComponent(c.G, b)))
+		}
+	}
+	return field
+}
+
+// maps a stored flow component back to a velocity
+func flowComponent(v uint8, b BC5) float64 {
+
+	max, ok := b.FlowMax()
+	if !ok {
+		max = 1
+	}
+	return (float64(v)/255*2 - 1) * max
+}
@@ -0,0 +1,186 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math"
+)
+
+// standard deviation in pixels of the Gaussian used by the void and cluster method
+const blueNoiseSigma = 1.5
+
+// GeneratorOptions holds settings for the utility texture generators.
+type GeneratorOptions struct {
+	Size   int            //Width and height of the texture, defaults to 64.
+	Seed   int64          //Seed of the random pattern, or the first frame of interleaved gradient noise.
+	Encode *EncodeOptions //Options to compress with, defaults to the "lut" profile.
+}
+
+// returns the size and encode options of o, applying the defaults
+func (o *GeneratorOptions) resolve() (int, *EncodeOptions, error) {
+
+	if o == nil {
+		o = &GeneratorOptions{}
+	}
+	size, encode := o.Size, o.Encode
+	if size == 0 {
+		size = 64
+	}
+	if size < 0 {
+		return 0, nil, errors.New("size must not be negative")
+	}
+	if encode == nil {
+		encode = &EncodeOptions{Profile: "lut"}
+	}
+	return size, encode, nil
+}
+
+// NewBlueNoise generates a tileable blue noise texture by Ulichney's void and cluster method, with
+// independent patterns in red and green from the seeds opts.Seed and opts.Seed+1. Every value from 0
+// to 255 occurs equally often in each channel before compression. Generation takes time proportional
+// to the square of the pixel count, so is intended for small textures.
+func NewBlueNoise(opts *GeneratorOptions) (*BC5, error) {
+
+	size, encode, err := opts.resolve()
+	if err != nil {
+		return nil, err
+	}
+	var seed int64
+	if opts != nil {
+		seed = opts.Seed
+	}
+
+	rgba := image.NewRGBA(image.Rect(0, 0, size, size))
+	n := size * size
+	for c := 0; c < 2; c++ {
+		rank := voidAndCluster(size, seed+int64(c))
+		for i, r := range rank {
+			rgba.Pix[i*4+c] = uint8(r * 256 / n)
+		}
+	}
+	for i := 3; i < len(rgba.Pix); i += 4 {
+		rgba.Pix[i] = 255
+	}
+
+	b := new(BC5)
+	if err := b.SetFromRGBAWithOptions(rgba, encode); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// NewInterleavedGradientNoise generates Jimenez's interleaved gradient noise, as used for temporal
+// dithering, with frame opts.Seed in red and the following frame in green. Each frame is offset by
+// 5.588238 pixels in both directions.
+func NewInterleavedGradientNoise(opts *GeneratorOptions) (*BC5, error) {
+
+	size, encode, err := opts.resolve()
+	if err != nil {
+		return nil, err
+	}
+	var frame float64
+	if opts != nil {
+		frame = float64(opts.Seed)
+	}
+
+	ign := func(x, y, frame float64) uint8 {
+		x, y = x+5.588238*frame, y+5.588238*frame
+		_, f := math.Modf(0.06711056*x + 0.00583715*y)
+		_, v := math.Modf(52.9829189 * math.Abs(f))
+		return uint8(clampInt(int(v*256), 0, 255))
+	}
+	rgba := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			rgba.SetRGBA(x, y, color.RGBA{R: ign(float64(x), float64(y), frame), G: ign(float64(x), float64(y), frame+1), A: 255})
+		}
+	}
+
+	b := new(BC5)
+	if err := b.SetFromRGBAWithOptions(rgba, encode); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// returns the rank of each pixel of a size by size blue noise pattern in row order, from 0 to size*size-1
+func voidAndCluster(size int, seed int64) []int {
+
+	n := size * size
+	kernel := make([]float64, n)
+	for dy := 0; dy < size; dy++ {
+		for dx := 0; dx < size; dx++ {
+			x, y := math.Min(float64(dx), float64(size-dx)), math.Min(float64(dy), float64(size-dy))
+			kernel[dy*size+dx] = math.Exp(-(x*x + y*y) / (2 * blueNoiseSigma * blueNoiseSigma))
+		}
+	}
+
+	//energy holds the sum of the kernel centered on every set pixel, wrapping at the edges
+	energy := make([]float64, n)
+	set := make([]bool, n)
+	toggle := func(p int, on bool) {
+		set[p] = on
+		sign := 1.0
+		if !on {
+			sign = -1
+		}
+		px, py := p%size, p/size
+		for i := range energy {
+			dx, dy := wrapCoord(i%size-px, size), wrapCoord(i/size-py, size)
+			energy[i] += sign * kernel[dy*size+dx]
+		}
+	}
+	//returns the set pixel with the highest energy if on, or the unset pixel with the lowest
+	extreme := func(on bool) int {
+		best := -1
+		for i, e := range energy {
+			if set[i] == on && (best < 0 || on && e > energy[best] || !on && e < energy[best]) {
+				best = i
+			}
+		}
+		return best
+	}
+
+	//initial pattern of a tenth of the pixels, relaxed until the tightest cluster is the largest void
+	rng := newSplitMix(seed, 0)
+	ones := n / 10
+	if ones == 0 {
+		ones = 1
+	}
+	for placed := 0; placed < ones; {
+		if p := rng.intn(n); !set[p] {
+			toggle(p, true)
+			placed++
+		}
+	}
+	for {
+		cluster := extreme(true)
+		toggle(cluster, false)
+		void := extreme(false)
+		toggle(void, true)
+		if void == cluster {
+			break
+		}
+	}
+	initial := append([]bool(nil), set...)
+	initialEnergy := append([]float64(nil), energy...)
+
+	rank := make([]int, n)
+	for r := ones - 1; r >= 0; r-- {
+		p := extreme(true)
+		toggle(p, false)
+		rank[p] = r
+	}
+	copy(set, initial)
+	copy(energy, initialEnergy)
+	for r := ones; r < n; r++ {
+		p := extreme(false)
+		toggle(p, true)
+		rank[p] = r
+	}
+	return rank
+}
@@ -0,0 +1,111 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+//go:build gl
+// +build gl
+
+// Package gl uploads BC5 images and mip chains to OpenGL textures through go-gl. It is only built with
+// the gl build tag, so the main package does not depend on cgo or go-gl:
+//
+//	go build -tags gl
+//
+// The OpenGL 4.1 core bindings are used so that it works on macOS, and gl.Init must have been called
+// on the current context. Immutable storage through glTexStorage2D is not core until 4.2, so textures
+// are specified level by level with glCompressedTexImage2D, and the level range is set so that a chain
+// ending at 4x4 is still complete.
+package gl
+
+import (
+	"errors"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	bc5 "github.com/leylandski/go-bc5"
+)
+
+// InternalFormat is the OpenGL internal format of BC5 data, known there as RGTC2.
+const InternalFormat = gl.COMPRESSED_RG_RGTC2
+
+// Upload creates a 2D texture holding levels, ordered from the base level down as bc5.NewMipChain
+// returns them, and returns its name. The texture is left bound to GL_TEXTURE_2D on the active
+// texture unit.
+func Upload(levels ...*bc5.BC5) (uint32, error) {
+
+	var tex uint32
+	gl.GenTextures(1, &tex)
+	gl.BindTexture(gl.TEXTURE_2D, tex)
+	if err := UploadTo(gl.TEXTURE_2D, levels...); err != nil {
+		gl.DeleteTextures(1, &tex)
+		return 0, err
+	}
+	return tex, nil
+}
+
+// UploadTo specifies levels as the image of the texture bound to target, which may also be a face of
+// a cube map such as GL_TEXTURE_CUBE_MAP_POSITIVE_X. Each level must be half the size of the last,
+// rounded down, as OpenGL requires. Any buffer bound to GL_PIXEL_UNPACK_BUFFER and any unpack row
+// length or skips are cleared for the upload and restored afterwards, as they would otherwise
+// silently change where the data is read from.
+func UploadTo(target uint32, levels ...*bc5.BC5) error {
+
+	if len(levels) == 0 {
+		return errors.New("no levels given")
+	}
+	width, height := levels[0].Rect.Dx(), levels[0].Rect.Dy()
+	for i, l := range levels {
+		if w, h := maxInt(width>>uint(i), 1), maxInt(height>>uint(i), 1); l.Rect.Dx() != w || l.Rect.Dy() != h {
+			return errors.New("each level must be half the size of the last")
+		}
+		if len(l.BlockData()) == 0 {
+			return errors.New("level has no data")
+		}
+	}
+
+	var unpackBuffer int32
+	gl.GetIntegerv(gl.PIXEL_UNPACK_BUFFER_BINDING, &unpackBuffer)
+	gl.BindBuffer(gl.PIXEL_UNPACK_BUFFER, 0)
+	params := []uint32{gl.UNPACK_ROW_LENGTH, gl.UNPACK_SKIP_ROWS, gl.UNPACK_SKIP_PIXELS}
+	saved := make([]int32, len(params))
+	for i, p := range params {
+		gl.GetIntegerv(p, &saved[i])
+		gl.PixelStorei(p, 0)
+	}
+	defer func() {
+		for i, p := range params {
+			gl.PixelStorei(p, saved[i])
+		}
+		gl.BindBuffer(gl.PIXEL_UNPACK_BUFFER, uint32(unpackBuffer))
+	}()
+
+	for i, l := range levels {
+		data := l.BlockData()
+		gl.CompressedTexImage2D(target, int32(i), InternalFormat, int32(l.Rect.Dx()), int32(l.Rect.Dy()), 0, int32(len(data)), gl.Ptr(data))
+	}
+
+	//Cube map faces share the parameters of the cube map itself
+	param := target
+	if target >= gl.TEXTURE_CUBE_MAP_POSITIVE_X && target <= gl.TEXTURE_CUBE_MAP_NEGATIVE_Z {
+		param = gl.TEXTURE_CUBE_MAP
+	}
+	gl.TexParameteri(param, gl.TEXTURE_BASE_LEVEL, 0)
+	gl.TexParameteri(param, gl.TEXTURE_MAX_LEVEL, int32(len(levels)-1))
+	if len(levels) == 1 {
+		gl.TexParameteri(param, gl.TEXTURE_MIN_FILTER, gl.LINEAR)
+	} else {
+		gl.TexParameteri(param, gl.TEXTURE_MIN_FILTER, gl.LINEAR_MIPMAP_LINEAR)
+	}
+	gl.TexParameteri(param, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
+
+	if e := gl.GetError(); e != gl.NO_ERROR {
+		return errors.New("OpenGL error during upload")
+	}
+	return nil
+}
+
+// returns the larger of a and b
+func maxInt(a, b int) int {
+
+	if a > b {
+		return a
+	}
+	return b
+}
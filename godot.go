@@ -0,0 +1,64 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Constants from Godot 4's CompressedTexture2D and Image classes.
+const (
+	godotFormatVersion   = 1
+	godotDataFormatImage = 0
+	godotImageRGTCRG     = 21      //Image::FORMAT_RGTC_RG
+	godotDetectNormal    = 1 << 26 //FORMAT_BIT_DETECT_NORMAL
+)
+
+// EncodeGodot writes b to w as a Godot 4 compressed texture (.ctex) holding the block data in the
+// RGTC_RG image format, so it can be loaded by Godot without importing through the editor. Images
+// encoded with the normal profile are flagged as normal maps. Godot 3's .stex format is not supported.
+func EncodeGodot(b *BC5, w io.Writer) error {
+
+	width, height := b.Rect.Size().X, b.Rect.Size().Y
+	if width > 0xffff || height > 0xffff {
+		return errors.New("image too large for a Godot texture")
+	}
+
+	var flags uint32
+	if b.Metadata[MetaProfile] == "normal" {
+		flags |= godotDetectNormal
+	}
+
+	header := []interface{}{
+		[4]byte{'G', 'S', 'T', '2'},
+		uint32(godotFormatVersion),
+		uint32(width),
+		uint32(height),
+		flags,
+		int32(0),    //Mipmap limit
+		[3]uint32{}, //Reserved
+		uint32(godotDataFormatImage),
+		uint16(width),
+		uint16(height),
+		uint32(0), //Mipmap count, excluding the base level
+		uint32(godotImageRGTCRG),
+	}
+	for _, v := range header {
+		if err := binary.Write(w, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+
+	data := b.blockData()
+	n, err := w.Write(data)
+	if err != nil {
+		return err
+	}
+	if n != len(data) {
+		return errors.New("failed to write image data")
+	}
+	return nil
+}
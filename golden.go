@@ -0,0 +1,164 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"strings"
+)
+
+// GoldenVector is a canonical test case for a single block. An encode vector gives source texels, a
+// quality and the block this package compresses them to. A decode vector gives a block and the values
+// it decodes to under the specification. Values are hex encoded so vectors can be shared as JSON with
+// implementations in other languages.
+type GoldenVector struct {
+	Name    string `json:"name"`
+	Texels  string `json:"texels,omitempty"`  //Source red and green pairs of an encode vector, in rows from the top left.
+	Quality string `json:"quality,omitempty"` //Quality an encode vector is compressed at.
+	Block   string `json:"block"`             //Compressed block, the output of an encode vector or the input of a decode vector.
+	Decoded string `json:"decoded,omitempty"` //Red and green pairs a decode vector decodes to, as ReferenceDecodeBlock gives them.
+}
+
+// GoldenVectors returns the canonical vectors shipped with this package. Encode vectors pin the output
+// of the encoder, so any change to them must be deliberate. Decode vectors hold for every conforming
+// decoder, allowing for the rounding of interpolated palette entries.
+func GoldenVectors() []GoldenVector {
+
+	return append([]GoldenVector(nil), goldenVectors...)
+}
+
+// ReadGoldenVectors reads vectors written by WriteGoldenVectors.
+func ReadGoldenVectors(r io.Reader) ([]GoldenVector, error) {
+
+	var vectors []GoldenVector
+	if err := json.NewDecoder(r).Decode(&vectors); err != nil {
+		return nil, err
+	}
+	return vectors, nil
+}
+
+// WriteGoldenVectors writes vectors to w as an indented JSON array.
+func WriteGoldenVectors(w io.Writer, vectors []GoldenVector) error {
+
+	b, err := json.MarshalIndent(vectors, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// Verify checks v against this package. An encode vector must compress to exactly its block, and a
+// decode vector must decode exactly with ReferenceDecodeBlock and to within 1 with Decompress.
+func (v GoldenVector) Verify() error {
+
+	block, err := hex.DecodeString(v.Block)
+	if err != nil || len(block) != 16 {
+		return fmt.Errorf("%s: block must be 16 hex encoded bytes", v.Name)
+	}
+
+	if v.Texels != "" {
+		texels, err := hex.DecodeString(v.Texels)
+		if err != nil || len(texels) != 32 {
+			return fmt.Errorf("%s: texels must be 32 hex encoded bytes", v.Name)
+		}
+		var quality Quality
+		if err := quality.UnmarshalText([]byte(v.Quality)); err != nil {
+			return fmt.Errorf("%s: %v", v.Name, err)
+		}
+		rgba := image.NewRGBA(image.Rect(0, 0, 4, 4))
+		for i := 0; i < 16; i++ {
+			rgba.Pix[i*4], rgba.Pix[i*4+1], rgba.Pix[i*4+3] = texels[i*2], texels[i*2+1], 255
+		}
+		b := new(BC5)
+		if err := b.SetFromRGBAWithOptions(rgba, &EncodeOptions{Quality: quality}); err != nil {
+			return fmt.Errorf("%s: %v", v.Name, err)
+		}
+		if !bytes.Equal(b.Data, block) {
+			return fmt.Errorf("%s: compressed to %x, want %s", v.Name, b.Data, v.Block)
+		}
+		return nil
+	}
+
+	decoded, err := hex.DecodeString(v.Decoded)
+	if err != nil || len(decoded) != 32 {
+		return fmt.Errorf("%s: decoded values must be 32 hex encoded bytes", v.Name)
+	}
+	red, green, _ := ReferenceDecodeBlock(block)
+	img := (&BC5{Rect: image.Rect(0, 0, 4, 4), Data: block}).Decompress()
+	for i := 0; i < 16; i++ {
+		if red[i] != decoded[i*2] || green[i] != decoded[i*2+1] {
+			return fmt.Errorf("%s: reference decode of texel %d is (%d,%d), want (%d,%d)", v.Name, i, red[i], green[i], decoded[i*2], decoded[i*2+1])
+		}
+		if c := img.RGBAAt(i%4, i/4); absDiff(c.R, decoded[i*2]) > 1 || absDiff(c.G, decoded[i*2+1]) > 1 {
+			return fmt.Errorf("%s: texel %d decodes as (%d,%d), want (%d,%d)", v.Name, i, c.R, c.G, decoded[i*2], decoded[i*2+1])
+		}
+	}
+	return nil
+}
+
+// VerifyGoldenVectors verifies every vector shipped with this package, returning an error listing
+// those that fail.
+func VerifyGoldenVectors() error {
+
+	var failed []string
+	for _, v := range goldenVectors {
+		if err := v.Verify(); err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d golden vectors failed:\n%s", len(failed), strings.Join(failed, "\n"))
+	}
+	return nil
+}
+
+// The vectors returned by GoldenVectors.
+var goldenVectors = []GoldenVector{
+	{Name: "encode/flat/fast", Texels: "64c864c864c864c864c864c864c864c864c864c864c864c864c864c864c864c8", Quality: "fast", Block: "6464000000000000c8c8000000000000"},
+	{Name: "encode/flat/normal", Texels: "64c864c864c864c864c864c864c864c864c864c864c864c864c864c864c864c8", Quality: "normal", Block: "6464000000000000c8c8000000000000"},
+	{Name: "encode/flat/high", Texels: "64c864c864c864c864c864c864c864c864c864c864c864c864c864c864c864c8", Quality: "high", Block: "6464000000000000c8c8000000000000"},
+	{Name: "encode/flat/anneal", Texels: "64c864c864c864c864c864c864c864c864c864c864c864c864c864c864c864c8", Quality: "anneal", Block: "6464000000000000c8c8000000000000"},
+	{Name: "encode/black/fast", Texels: "0000000000000000000000000000000000000000000000000000000000000000", Quality: "fast", Block: "00000000000000000000000000000000"},
+	{Name: "encode/black/normal", Texels: "0000000000000000000000000000000000000000000000000000000000000000", Quality: "normal", Block: "00000000000000000000000000000000"},
+	{Name: "encode/black/high", Texels: "0000000000000000000000000000000000000000000000000000000000000000", Quality: "high", Block: "00000000000000000000000000000000"},
+	{Name: "encode/black/anneal", Texels: "0000000000000000000000000000000000000000000000000000000000000000", Quality: "anneal", Block: "00000000000000000000000000000000"},
+	{Name: "encode/extremes/fast", Texels: "00ffff0000ffff0000ffff0000ffff0000ffff0000ffff0000ffff0000ffff00", Quality: "fast", Block: "00ff08822008822000ff411004411004"},
+	{Name: "encode/extremes/normal", Texels: "00ffff0000ffff0000ffff0000ffff0000ffff0000ffff0000ffff0000ffff00", Quality: "normal", Block: "00ff08822008822000ff411004411004"},
+	{Name: "encode/extremes/high", Texels: "00ffff0000ffff0000ffff0000ffff0000ffff0000ffff0000ffff0000ffff00", Quality: "high", Block: "00ff08822008822000ff411004411004"},
+	{Name: "encode/extremes/anneal", Texels: "00ffff0000ffff0000ffff0000ffff0000ffff0000ffff0000ffff0000ffff00", Quality: "anneal", Block: "00ff08822008822000ff411004411004"},
+	{Name: "encode/ramp/fast", Texels: "00ff11ee22dd33cc44bb55aa6699778888779966aa55bb44cc33dd22ee11ff00", Quality: "fast", Block: "00ff80a46d24db2600ff495b92db2401"},
+	{Name: "encode/ramp/normal", Texels: "00ff11ee22dd33cc44bb55aa6699778888779966aa55bb44cc33dd22ee11ff00", Quality: "normal", Block: "ff00c96fb7e42601ff0080b491adfd27"},
+	{Name: "encode/ramp/high", Texels: "00ff11ee22dd33cc44bb55aa6699778888779966aa55bb44cc33dd22ee11ff00", Quality: "high", Block: "f708c96fb7e42601f70880b491adfd27"},
+	{Name: "encode/ramp/anneal", Texels: "00ff11ee22dd33cc44bb55aa6699778888779966aa55bb44cc33dd22ee11ff00", Quality: "anneal", Block: "f708c96fb7e42601f70880b491adfd27"},
+	{Name: "encode/edge/fast", Texels: "1edc1edcc828c8281edc1edcc828c8281edc1edcc828c8281edc1edcc828c828", Quality: "fast", Block: "1ec840022440022428dc099000099000"},
+	{Name: "encode/edge/normal", Texels: "1edc1edcc828c8281edc1edcc828c8281edc1edcc828c8281edc1edcc828c828", Quality: "normal", Block: "1ec840022440022428dc099000099000"},
+	{Name: "encode/edge/high", Texels: "1edc1edcc828c8281edc1edcc828c8281edc1edcc828c8281edc1edcc828c828", Quality: "high", Block: "1ec840022440022428dc099000099000"},
+	{Name: "encode/edge/anneal", Texels: "1edc1edcc828c8281edc1edcc828c8281edc1edcc828c8281edc1edcc828c828", Quality: "anneal", Block: "1ec840022440022428dc099000099000"},
+	{Name: "encode/narrow/fast", Texels: "788279827a82788279837a83788379837a84788479847a84788579857a857885", Quality: "fast", Block: "787a58b060c18205828500b06d249924"},
+	{Name: "encode/narrow/normal", Texels: "788279827a82788279837a83788379837a84788479847a84788579857a857885", Quality: "normal", Block: "7a78214284081122828500b06d249924"},
+	{Name: "encode/narrow/high", Texels: "788279827a82788279837a83788379837a84788479847a84788579857a857885", Quality: "high", Block: "7a78214284081122828500b06d249924"},
+	{Name: "encode/narrow/anneal", Texels: "788279827a82788279837a83788379837a84788479847a84788579857a857885", Quality: "anneal", Block: "7a782142840811228085db46926d9b24"},
+	{Name: "encode/normal/fast", Texels: "80bcb2bcb6bc88bc80a0b2a0b6a088a08067b267b66788678044b244b6448844", Quality: "fast", Block: "80b648844448844444bc49d2b6920400"},
+	{Name: "encode/normal/normal", Texels: "80bcb2bcb6bc88bc80a0b2a0b6a088a08067b267b66788678044b244b6448844", Quality: "normal", Block: "b680111ee1111ee1bc4400b06db69d24"},
+	{Name: "encode/normal/high", Texels: "80bcb2bcb6bc88bc80a0b2a0b6a088a08067b267b66788678044b244b6448844", Quality: "high", Block: "b480011ee0011ee0bc4500b06db69d24"},
+	{Name: "encode/normal/anneal", Texels: "80bcb2bcb6bc88bc80a0b2a0b6a088a08067b267b66788678044b244b6448844", Quality: "anneal", Block: "b880111ee1111ee1bf4500b06db69d24"},
+	{Name: "encode/mixed/fast", Texels: "0000ffff070cfa03402103fa80b4ffff01015affb48000f02140f0000c07ff5a", Quality: "fast", Block: "00ff08223058a12000ff08a034082360"},
+	{Name: "encode/mixed/normal", Texels: "0000ffff070cfa03402103fa80b4ffff01015affb48000f02140f0000c07ff5a", Quality: "normal", Block: "ff0041e010f17204ff0041720c01e1c4"},
+	{Name: "encode/mixed/high", Texels: "0000ffff070cfa03402103fa80b4ffff01015affb48000f02140f0000c07ff5a", Quality: "high", Block: "05e43e2ef05eade005e43ea0f73e2363"},
+	{Name: "encode/mixed/anneal", Texels: "0000ffff070cfa03402103fa80b4ffff01015affb48000f02140f0000c07ff5a", Quality: "anneal", Block: "23b6be2ff35e8cfb23b6be8de73e2f7b"},
+	{Name: "decode/constant", Block: "80800000000000004040000000000000", Decoded: "8040804080408040804080408040804080408040804080408040804080408040"},
+	{Name: "decode/eight/first", Block: "ff00000000000000c832000000000000", Decoded: "ffc8ffc8ffc8ffc8ffc8ffc8ffc8ffc8ffc8ffc8ffc8ffc8ffc8ffc8ffc8ffc8"},
+	{Name: "decode/eight/all-indices", Block: "f00f88c6fa88c6faf00fd1580bd1580b", Decoded: "f00f0fd0d0b0b090906f6f4f4fd02ff0f00f0fd0d0b0b090906f6f4f4fd02ff0"},
+	{Name: "decode/six/all-indices", Block: "0ff088c6fa88c6fa0ff0d1580bd1580b", Decoded: "0ff0f03c3c69699696c3c300003cff0f0ff0f03c3c69699696c3c300003cff0f"},
+	{Name: "decode/six/extremes", Block: "4040ffffffffffff1010b66ddbb66ddb", Decoded: "ff00ff00ff00ff00ff00ff00ff00ff00ff00ff00ff00ff00ff00ff00ff00ff00"},
+	{Name: "decode/equal/non-zero-indices", Block: "7777fffffffffffff0f0111111111111", Decoded: "fff0fff0fff0fff0fff0fff0fff0fff0fff0fff0fff0fff0fff0fff0fff0fff0"},
+	{Name: "decode/eight/adjacent", Block: "0100ab896745230180ff12345678abcd", Decoded: "019900990080019901b300cc00e60199008001ff00e600e6019901b301b30100"},
+	{Name: "decode/six/full-range", Block: "00ffd1580bd1580bff00c6fa88c6fa88", Decoded: "ff4933ff66b6996dcc24000033db0092ff4933ff66b6996dcc24000033db0092"},
+}
@@ -0,0 +1,113 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"image"
+	"strconv"
+	"strings"
+)
+
+// MetaTileHashes is the metadata key holding a HashTable, stored as the tile size followed by a colon
+// and the comma separated tile hashes.
+const MetaTileHashes = "tilehashes"
+
+// HashTable holds content hashes of the compressed data of an image split into square tiles, so that
+// sync tools can transfer only the tiles that changed between two versions of a file.
+type HashTable struct {
+	TileSize int         //Width and height of each tile in pixels, tiles on the right and bottom edges may be smaller.
+	Size     image.Point //Size of the image in pixels.
+	Hashes   []string    //Hex encoded hash of each tile's block data, ordered by row from the top left.
+}
+
+// HashTable computes the hashes of the tiles of b. tileSize must be a positive multiple of 4. Each hash
+// is the first 16 bytes of the SHA-256 of the tile's blocks in row order, so it depends only on the
+// compressed data and not on metadata or the layout of b in memory.
+func (b BC5) HashTable(tileSize int) (*HashTable, error) {
+
+	if tileSize <= 0 || tileSize%4 != 0 {
+		return nil, errors.New("tile size must be a positive multiple of 4")
+	}
+
+	t := &HashTable{TileSize: tileSize, Size: b.Rect.Size()}
+	for i := 0; i < t.Tiles(); i++ {
+		view, err := b.SubImage(t.TileRect(i))
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(view.blockData())
+		t.Hashes = append(t.Hashes, hex.EncodeToString(sum[:16]))
+	}
+	return t, nil
+}
+
+// SetHashTable computes the tile hashes of b and records them in its metadata.
+func (b *BC5) SetHashTable(tileSize int) error {
+
+	t, err := b.HashTable(tileSize)
+	if err != nil {
+		return err
+	}
+	b.setMeta(MetaTileHashes, strconv.Itoa(t.TileSize)+":"+strings.Join(t.Hashes, ","))
+	return nil
+}
+
+// StoredHashTable returns the tile hashes recorded in the metadata of b. It returns nil if b has none.
+func (b BC5) StoredHashTable() (*HashTable, error) {
+
+	v, ok := b.Metadata[MetaTileHashes]
+	if !ok {
+		return nil, nil
+	}
+	parts := strings.SplitN(v, ":", 2)
+	if len(parts) != 2 {
+		return nil, errors.New("malformed tile hashes")
+	}
+	tileSize, err := strconv.Atoi(parts[0])
+	if err != nil || tileSize <= 0 || tileSize%4 != 0 {
+		return nil, errors.New("malformed tile hash size")
+	}
+
+	t := &HashTable{TileSize: tileSize, Size: b.Rect.Size()}
+	if parts[1] != "" {
+		t.Hashes = strings.Split(parts[1], ",")
+	}
+	if len(t.Hashes) != t.Tiles() {
+		return nil, errors.New("tile hash count does not match image size")
+	}
+	return t, nil
+}
+
+// Tiles returns the number of tiles covered by t.
+func (t HashTable) Tiles() int {
+
+	across := (t.Size.X + t.TileSize - 1) / t.TileSize
+	down := (t.Size.Y + t.TileSize - 1) / t.TileSize
+	return across * down
+}
+
+// TileRect returns the pixel rectangle of tile i.
+func (t HashTable) TileRect(i int) image.Rectangle {
+
+	across := (t.Size.X + t.TileSize - 1) / t.TileSize
+	origin := image.Pt((i%across)*t.TileSize, (i/across)*t.TileSize)
+	return image.Rectangle{Min: origin, Max: origin.Add(image.Pt(t.TileSize, t.TileSize))}.Intersect(image.Rectangle{Max: t.Size})
+}
+
+// Changed returns the indices of the tiles whose hashes differ between t and other. Every tile is
+// reported if the tables do not share a tile size and image size.
+func (t HashTable) Changed(other *HashTable) []int {
+
+	var changed []int
+	same := other != nil && other.TileSize == t.TileSize && other.Size == t.Size && len(other.Hashes) == len(t.Hashes)
+	for i := range t.Hashes {
+		if !same || t.Hashes[i] != other.Hashes[i] {
+			changed = append(changed, i)
+		}
+	}
+	return changed
+}
@@ -0,0 +1,77 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"image"
+	"time"
+)
+
+// blocks decoded between checks of the clock in Step
+const incrementalCheckEvery = 32
+
+// IncrementalDecode decompresses a BC5 a few blocks at a time, so a large texture can be decoded across
+// several frames without stalling any of them. Blocks are decoded in rows from the top left.
+type IncrementalDecode struct {
+	b     BC5
+	dst   *image.RGBA //Bounds start at the origin, offset when returned from Image.
+	next  int         //Index of the next block to decode.
+	total int
+}
+
+// DecompressIncremental returns an IncrementalDecode for b. Nothing is decoded until Step is called.
+func (b BC5) DecompressIncremental() *IncrementalDecode {
+
+	size := b.Rect.Size()
+	return &IncrementalDecode{
+		b:     b,
+		dst:   image.NewRGBA(image.Rect(0, 0, size.X, size.Y)),
+		total: ((size.X + 3) / 4) * ((size.Y + 3) / 4),
+	}
+}
+
+// Step decodes blocks until budget has elapsed or the image is complete, and returns the fraction of
+// blocks decoded so far and whether the image is complete. At least one block is decoded per call, so
+// repeated calls always make progress. The clock is checked every few blocks, so a call may overrun
+// budget by the time taken to decode them.
+func (d *IncrementalDecode) Step(budget time.Duration) (float64, bool) {
+
+	deadline := time.Now().Add(budget)
+	blocksPerRow := (d.dst.Rect.Dx() + 3) / 4
+	for n := 0; d.next < d.total; n++ {
+		if n > 0 && n%incrementalCheckEvery == 0 && !time.Now().Before(deadline) {
+			break
+		}
+		x, y := d.next%blocksPerRow*4, d.next/blocksPerRow*4
+		d.b.decompressBlocks(d.dst, image.Rect(x, y, x+4, y+4).Intersect(d.dst.Rect))
+		d.next++
+	}
+	return d.Progress(), d.Done()
+}
+
+// Progress returns the fraction of blocks decoded so far, from 0 to 1.
+func (d *IncrementalDecode) Progress() float64 {
+
+	if d.total == 0 {
+		return 1
+	}
+	return float64(d.next) / float64(d.total)
+}
+
+// Done reports whether every block has been decoded.
+func (d *IncrementalDecode) Done() bool {
+
+	return d.next >= d.total
+}
+
+// Image returns the image being decoded into, with bounds as from Decompress. Blocks not yet decoded
+// are transparent black. The image is shared with d and filled in by later calls to Step.
+func (d *IncrementalDecode) Image() *image.RGBA {
+
+	img := *d.dst.SubImage(image.Rectangle{Max: d.b.ContentSize()}).(*image.RGBA)
+	if d.b.Origin == ImageOrigin {
+		img.Rect = img.Rect.Add(d.b.Rect.Min)
+	}
+	return &img
+}
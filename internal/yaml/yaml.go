@@ -0,0 +1,436 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+// Package yaml converts the YAML used by configuration files to JSON, so that code decoding JSON with
+// encoding/json can read YAML too without a dependency outside the standard library.
+//
+// Block mappings and sequences, single line flow mappings and sequences, comments, plain and quoted
+// scalars are supported. Anchors, aliases, tags, multi-line and block scalars, and documents after the
+// first are reported as errors rather than misread. Plain scalars that are null, true, false or a
+// number become the JSON values of the same kind, and all others become strings.
+package yaml
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// a line of the document with its comment and trailing space removed
+type line struct {
+	num    int
+	indent int
+	text   string
+}
+
+// ToJSON returns the JSON form of the YAML document data. Data whose first character other than space
+// is '{' or '[' is already JSON and is returned as it is.
+func ToJSON(data []byte) ([]byte, error) {
+
+	if trimmed := strings.TrimSpace(string(data)); strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+		return data, nil
+	}
+
+	lines, err := split(string(data))
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	if len(lines) > 0 {
+		var next int
+		v, next, err = parseBlock(lines, 0, lines[0].indent)
+		if err != nil {
+			return nil, err
+		}
+		if next < len(lines) {
+			return nil, lineError(lines[next], "unexpected indentation")
+		}
+	}
+	return json.Marshal(v)
+}
+
+// splits s into lines, dropping blank lines, comments and the markers around a single document
+func split(s string) ([]line, error) {
+
+	var lines []line
+	for i, text := range strings.Split(strings.Replace(s, "\r\n", "\n", -1), "\n") {
+		text = strings.TrimRight(stripComment(text), " \t")
+		content := strings.TrimLeft(text, " ")
+		if content == "" {
+			continue
+		}
+		l := line{num: i + 1, indent: len(text) - len(content), text: content}
+		if strings.HasPrefix(content, "\t") {
+			return nil, lineError(l, "tabs cannot be used for indentation")
+		}
+		if content == "---" || content == "..." || strings.HasPrefix(content, "%") {
+			if len(lines) > 0 && content != "..." {
+				return nil, lineError(l, "only a single document is supported")
+			}
+			continue
+		}
+		lines = append(lines, l)
+	}
+	return lines, nil
+}
+
+// returns s without any comment, which starts with a '#' outside quotes at the start of s or after space
+func stripComment(s string) string {
+
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && quote == '"' {
+				i++
+			}
+		case c == '"' || c == '\'':
+			if i == 0 || strings.IndexByte(" \t[{,:-", s[i-1]) >= 0 {
+				quote = c
+			}
+		case c == '#' && (i == 0 || s[i-1] == ' ' || s[i-1] == '\t'):
+			return s[:i]
+		}
+	}
+	return s
+}
+
+// parses the mapping, sequence or scalar starting at lines[i], whose lines are indented by indent, and
+// returns it with the index of the line after it
+func parseBlock(lines []line, i, indent int) (interface{}, int, error) {
+
+	if isItem(lines[i].text) {
+		return parseSequence(lines, i, indent)
+	}
+	if _, _, ok := splitKey(lines[i].text); ok {
+		return parseMapping(lines, i, indent)
+	}
+	v, err := parseInline(lines[i])
+	if err != nil {
+		return nil, 0, err
+	}
+	if i+1 < len(lines) && lines[i+1].indent > indent {
+		return nil, 0, lineError(lines[i+1], "multi-line scalars are not supported")
+	}
+	return v, i + 1, nil
+}
+
+// parses the block sequence starting at lines[i]
+func parseSequence(lines []line, i, indent int) (interface{}, int, error) {
+
+	seq := []interface{}{}
+	for i < len(lines) && lines[i].indent == indent && isItem(lines[i].text) {
+		rest := strings.TrimLeft(lines[i].text[1:], " ")
+		if rest == "" {
+			if i+1 < len(lines) && lines[i+1].indent > indent {
+				v, next, err := parseBlock(lines, i+1, lines[i+1].indent)
+				if err != nil {
+					return nil, 0, err
+				}
+				seq, i = append(seq, v), next
+			} else {
+				seq, i = append(seq, nil), i+1
+			}
+			continue
+		}
+
+		//The item's content is a block of its own, indented to where it starts, so any mapping keys on
+		//the lines after it line up with the first
+		item := lines[i]
+		item.indent += len(item.text) - len(rest)
+		item.text = rest
+		lines[i] = item
+		v, next, err := parseBlock(lines, i, item.indent)
+		if err != nil {
+			return nil, 0, err
+		}
+		seq, i = append(seq, v), next
+	}
+	if i < len(lines) && lines[i].indent > indent {
+		return nil, 0, lineError(lines[i], "unexpected indentation")
+	}
+	return seq, i, nil
+}
+
+// parses the block mapping starting at lines[i]
+func parseMapping(lines []line, i, indent int) (interface{}, int, error) {
+
+	m := map[string]interface{}{}
+	for i < len(lines) && lines[i].indent == indent {
+		l := lines[i]
+		if isItem(l.text) {
+			return nil, 0, lineError(l, "sequence item in a mapping")
+		}
+		key, rest, ok := splitKey(l.text)
+		if !ok {
+			return nil, 0, lineError(l, "expected a key followed by a colon")
+		}
+		if _, dup := m[key]; dup {
+			return nil, 0, lineError(l, "duplicate key "+strconv.Quote(key))
+		}
+
+		i++
+		switch {
+		case rest != "":
+			v, err := parseInline(line{num: l.num, indent: l.indent, text: rest})
+			if err != nil {
+				return nil, 0, err
+			}
+			m[key] = v
+		case i < len(lines) && (lines[i].indent > indent || lines[i].indent == indent && isItem(lines[i].text)):
+			v, next, err := parseBlock(lines, i, lines[i].indent)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[key], i = v, next
+		default:
+			m[key] = nil
+		}
+	}
+	if i < len(lines) && lines[i].indent > indent {
+		return nil, 0, lineError(lines[i], "unexpected indentation")
+	}
+	return m, i, nil
+}
+
+// reports whether text is an item of a block sequence
+func isItem(text string) bool {
+
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// splits text into a mapping key and the value after its colon, reporting whether it holds a key
+func splitKey(text string) (string, string, bool) {
+
+	if text == "" || strings.IndexByte("[{", text[0]) >= 0 {
+		return "", "", false
+	}
+	if text[0] == '"' || text[0] == '\'' {
+		key, n, err := parseQuoted(text)
+		if err != nil {
+			return "", "", false
+		}
+		rest := strings.TrimLeft(text[n:], " ")
+		if !strings.HasPrefix(rest, ":") || len(rest) > 1 && rest[1] != ' ' {
+			return "", "", false
+		}
+		return key, strings.TrimSpace(rest[1:]), true
+	}
+	for i := 0; i < len(text); i++ {
+		if text[i] == ':' && (i+1 == len(text) || text[i+1] == ' ') {
+			return strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+1:]), true
+		}
+	}
+	return "", "", false
+}
+
+// parses the scalar or flow collection making up the whole of l.text
+func parseInline(l line) (interface{}, error) {
+
+	text := l.text
+	switch text[0] {
+	case '[', '{', '"', '\'':
+		p := &flowParser{text: text}
+		v, err := p.value()
+		if err == nil && p.skipSpace() < len(text) {
+			err = errors.New("unexpected " + strconv.Quote(text[p.pos:]))
+		}
+		if err != nil {
+			return nil, lineError(l, err.Error())
+		}
+		return v, nil
+	case '&', '*', '!', '|', '>', '@', '`':
+		return nil, lineError(l, "unsupported syntax "+strconv.Quote(text[:1]))
+	}
+	return plainValue(text), nil
+}
+
+// reads flow collections and quoted scalars from text
+type flowParser struct {
+	text string
+	pos  int
+}
+
+// skips spaces and returns the new position
+func (p *flowParser) skipSpace() int {
+
+	for p.pos < len(p.text) && p.text[p.pos] == ' ' {
+		p.pos++
+	}
+	return p.pos
+}
+
+// parses the value at the current position
+func (p *flowParser) value() (interface{}, error) {
+
+	if p.skipSpace() == len(p.text) {
+		return nil, errors.New("missing value")
+	}
+	switch p.text[p.pos] {
+	case '[':
+		return p.sequence()
+	case '{':
+		return p.mapping()
+	case '"', '\'':
+		s, n, err := parseQuoted(p.text[p.pos:])
+		p.pos += n
+		return s, err
+	}
+	start := p.pos
+	for p.pos < len(p.text) && strings.IndexByte(",[]{}", p.text[p.pos]) < 0 && !p.atColon() {
+		p.pos++
+	}
+	return plainValue(strings.TrimSpace(p.text[start:p.pos])), nil
+}
+
+// reports whether the current position holds a colon ending a key
+func (p *flowParser) atColon() bool {
+
+	return p.text[p.pos] == ':' && (p.pos+1 == len(p.text) || strings.IndexByte(" ,]}", p.text[p.pos+1]) >= 0)
+}
+
+// parses the flow sequence at the current position
+func (p *flowParser) sequence() (interface{}, error) {
+
+	seq := []interface{}{}
+	p.pos++
+	if p.skipSpace() < len(p.text) && p.text[p.pos] == ']' {
+		p.pos++
+		return seq, nil
+	}
+	for {
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		seq = append(seq, v)
+		if err := p.separator(']'); err != nil {
+			return nil, err
+		}
+		if p.text[p.pos-1] == ']' {
+			return seq, nil
+		}
+	}
+}
+
+// parses the flow mapping at the current position
+func (p *flowParser) mapping() (interface{}, error) {
+
+	m := map[string]interface{}{}
+	p.pos++
+	if p.skipSpace() < len(p.text) && p.text[p.pos] == '}' {
+		p.pos++
+		return m, nil
+	}
+	for {
+		k, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			key = fmt.Sprint(k)
+		}
+		if _, dup := m[key]; dup {
+			return nil, errors.New("duplicate key " + strconv.Quote(key))
+		}
+		if p.skipSpace() == len(p.text) || p.text[p.pos] != ':' {
+			return nil, errors.New("expected a colon after key " + strconv.Quote(key))
+		}
+		p.pos++
+		if m[key], err = p.value(); err != nil {
+			return nil, err
+		}
+		if err := p.separator('}'); err != nil {
+			return nil, err
+		}
+		if p.text[p.pos-1] == '}' {
+			return m, nil
+		}
+	}
+}
+
+// consumes the comma between flow entries or the closing bracket end
+func (p *flowParser) separator(end byte) error {
+
+	if p.skipSpace() == len(p.text) {
+		return errors.New("unterminated flow collection")
+	}
+	if c := p.text[p.pos]; c != ',' && c != end {
+		return errors.New("expected a comma or " + string(end))
+	}
+	p.pos++
+	return nil
+}
+
+// parses the quoted scalar at the start of s, returning it and the number of bytes it takes up
+func parseQuoted(s string) (string, int, error) {
+
+	if s[0] == '\'' {
+		var b strings.Builder
+		for i := 1; i < len(s); i++ {
+			if s[i] != '\'' {
+				b.WriteByte(s[i])
+				continue
+			}
+			if i+1 < len(s) && s[i+1] == '\'' {
+				b.WriteByte('\'')
+				i++
+				continue
+			}
+			return b.String(), i + 1, nil
+		}
+		return "", 0, errors.New("unterminated quoted scalar")
+	}
+
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			v, err := strconv.Unquote(s[:i+1])
+			if err != nil {
+				return "", 0, errors.New("invalid escape in " + s[:i+1])
+			}
+			return v, i + 1, nil
+		}
+	}
+	return "", 0, errors.New("unterminated quoted scalar")
+}
+
+// numbers as written in YAML's core schema
+var number = regexp.MustCompile(`^[-+]?(\.[0-9]+|[0-9]+(\.[0-9]*)?)([eE][-+]?[0-9]+)?$`)
+
+// returns the value of a plain scalar
+func plainValue(s string) interface{} {
+
+	switch s {
+	case "", "~", "null", "Null", "NULL":
+		return nil
+	case "true", "True", "TRUE":
+		return true
+	case "false", "False", "FALSE":
+		return false
+	}
+	if number.MatchString(s) {
+		//Integers are kept exact so they still decode into integer fields
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return json.Number(strconv.FormatInt(n, 10))
+		}
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return json.Number(strconv.FormatFloat(f, 'g', -1, 64))
+		}
+	}
+	return s
+}
+
+// returns an error for l
+func lineError(l line, msg string) error {
+
+	return fmt.Errorf("yaml: line %d: %s", l.num, msg)
+}
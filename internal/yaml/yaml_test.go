@@ -0,0 +1,77 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package yaml
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestToJSON(t *testing.T) {
+
+	tests := []struct {
+		name, yaml, json string
+	}{
+		{"empty", "", `null`},
+		{"scalars", "a: text\nb: 12\nc: -1.5\nd: true\ne: ~\nf:\ng: '007'\nh: \"tab\\there\"", `{"a":"text","b":12,"c":-1.5,"d":true,"e":null,"f":null,"g":"007","h":"tab\there"}`},
+		{"comments", "# header\na: 1 # trailing\nb: 'not # a comment'\nc: x#y", `{"a":1,"b":"not # a comment","c":"x#y"}`},
+		{"nested", "a:\n  b:\n    c: 1\n  d: 2", `{"a":{"b":{"c":1},"d":2}}`},
+		{"sequence", "- 1\n- two\n-\n- - 3\n  - 4", `[1,"two",null,[3,4]]`},
+		{"mapping items", "entries:\n  - source: a.png\n    output: a.bc5\n  - source: b.png\n    output: b.bc5", `{"entries":[{"output":"a.bc5","source":"a.png"},{"output":"b.bc5","source":"b.png"}]}`},
+		{"unindented items", "entries:\n- x: 1\n- x: 2\nstate: s", `{"entries":[{"x":1},{"x":2}],"state":"s"}`},
+		{"flow", "a: {profile: normal, mips: true, list: [1, 'b', \"c\"]}\nb: []\nc: {}", `{"a":{"list":[1,"b","c"],"mips":true,"profile":"normal"},"b":[],"c":{}}`},
+		{"colons", "url: http://example.com/a\n\"quoted key\": v", `{"quoted key":"v","url":"http://example.com/a"}`},
+		{"document markers", "---\na: 1\n...", `{"a":1}`},
+		{"json", ` {"a": [1, 2]}`, ` {"a": [1, 2]}`},
+	}
+	for _, test := range tests {
+		got, err := ToJSON([]byte(test.yaml))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if test.name == "json" {
+			if string(got) != test.json {
+				t.Errorf("%s: JSON changed to %s", test.name, got)
+			}
+			continue
+		}
+		var gotV, wantV interface{}
+		if err := json.Unmarshal(got, &gotV); err != nil {
+			t.Fatalf("%s: invalid JSON %s: %v", test.name, got, err)
+		}
+		if err := json.Unmarshal([]byte(test.json), &wantV); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(gotV, wantV) {
+			t.Errorf("%s: got %s, want %s", test.name, got, test.json)
+		}
+	}
+}
+
+func TestToJSONErrors(t *testing.T) {
+
+	tests := []struct {
+		name, yaml, err string
+	}{
+		{"tab indent", "a:\n\tb: 1", "line 2: tabs"},
+		{"bad indent", "a: 1\n  b: 2", "line 2: unexpected indentation"},
+		{"duplicate", "a: 1\na: 2", "line 2: duplicate key"},
+		{"anchor", "a: &x 1", "line 1: unsupported syntax"},
+		{"block scalar", "a: |\n  text", "line 1: unsupported syntax"},
+		{"second document", "a: 1\n---\nb: 2", "line 2: only a single document"},
+		{"unterminated flow", "a: [1, 2", "line 1: unterminated flow collection"},
+		{"unterminated quote", "a: 'text", "line 1: unterminated quoted scalar"},
+		{"item in mapping", "a: 1\n- 2", "line 2: sequence item in a mapping"},
+		{"not a key", "a: 1\nplain", "line 2: expected a key"},
+	}
+	for _, test := range tests {
+		_, err := ToJSON([]byte(test.yaml))
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: got error %v, want one containing %q", test.name, err, test.err)
+		}
+	}
+}
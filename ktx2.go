@@ -0,0 +1,303 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Alias for KTX2 supercompression scheme constants.
+type KTX2Supercompression int
+
+const (
+	KTX2None KTX2Supercompression = 0 //Level data is stored as is.
+	KTX2Zlib KTX2Supercompression = 3 //Each level is deflated with zlib.
+)
+
+// Values from the KTX2 specification and the Khronos Data Format specification.
+const (
+	ktx2HeaderSize      = 80 //Identifier, header and index, up to the level index.
+	ktx2LevelIndexSize  = 24
+	ktx2ModelBC5        = 132 //KHR_DF_MODEL_BC5
+	ktx2PrimariesBT709  = 1   //KHR_DF_PRIMARIES_BT709
+	ktx2TransferLinear  = 1   //KHR_DF_TRANSFER_LINEAR
+	ktx2ChannelBC5Red   = 0   //KHR_DF_CHANNEL_BC5_RED
+	ktx2ChannelBC5Green = 1   //KHR_DF_CHANNEL_BC5_GREEN
+	ktx2MetadataPrefix  = "bc5:"
+)
+
+var ktx2Identifier = [12]byte{0xAB, 'K', 'T', 'X', ' ', '2', '0', 0xBB, '\r', '\n', 0x1A, '\n'}
+
+// KTX2Options describes the shape of the texture a KTX2Builder assembles.
+type KTX2Options struct {
+	Levels           int                  //Number of mip levels, 1 if zero. Every level must be a multiple of 4 in each dimension.
+	Layers           int                  //Number of array layers, or zero for a texture that is not an array.
+	Cubemap          bool                 //Each layer has 6 faces, in the order +X, -X, +Y, -Y, +Z, -Z.
+	Supercompression KTX2Supercompression //Scheme applied to each level.
+}
+
+// KTX2Builder assembles a KTX2 file from BC5 images one at a time, so that mips, array layers and cube
+// faces can be added as they are compressed. Any combination of the three is supported, along with
+// supercompression and key/value metadata. Levels are laid out as the specification requires, which
+// is also the layout BasisLZ uses, though BasisLZ itself is not supported as it needs its own encoder.
+type KTX2Builder struct {
+	width, height int
+	opts          KTX2Options
+	images        []*BC5 //Indexed by level, then layer, then face.
+	metadata      map[string]string
+}
+
+// NewKTX2Builder returns a KTX2Builder for a texture with a base level of width by height texels.
+func NewKTX2Builder(width, height int, opts KTX2Options) (*KTX2Builder, error) {
+
+	if opts.Levels == 0 {
+		opts.Levels = 1
+	}
+	if width <= 0 || height <= 0 || opts.Levels < 0 || opts.Layers < 0 {
+		return nil, errors.New("invalid KTX2 texture shape")
+	}
+	if opts.Supercompression != KTX2None && opts.Supercompression != KTX2Zlib {
+		return nil, errors.New("unsupported supercompression scheme")
+	}
+	if opts.Cubemap && width != height {
+		return nil, errors.New("cubemap faces must be square")
+	}
+	for level := 0; level < opts.Levels; level++ {
+		w, h := width>>uint(level), height>>uint(level)
+		if w == 0 || h == 0 || w%4 != 0 || h%4 != 0 {
+			return nil, fmt.Errorf("level %d would be %dx%d, which is not a multiple of 4", level, w, h)
+		}
+	}
+
+	k := &KTX2Builder{width: width, height: height, opts: opts, metadata: make(map[string]string)}
+	k.images = make([]*BC5, opts.Levels*k.layers()*k.faces())
+	return k, nil
+}
+
+// Set adds the image for a level, array layer and cube face, replacing any set before. Layer and face
+// must be zero if the texture is not an array or not a cubemap respectively.
+func (k *KTX2Builder) Set(level, layer, face int, img *BC5) error {
+
+	if level < 0 || level >= k.opts.Levels || layer < 0 || layer >= k.layers() || face < 0 || face >= k.faces() {
+		return fmt.Errorf("level %d, layer %d, face %d is out of range", level, layer, face)
+	}
+	w, h := k.width>>uint(level), k.height>>uint(level)
+	if img.Rect.Dx() != w || img.Rect.Dy() != h {
+		return fmt.Errorf("level %d must be %dx%d, not %dx%d", level, w, h, img.Rect.Dx(), img.Rect.Dy())
+	}
+	k.images[k.index(level, layer, face)] = img
+	return nil
+}
+
+// SetMipChain sets every level of a layer and face from levels, largest first, as from NewMipChain.
+func (k *KTX2Builder) SetMipChain(layer, face int, levels []*BC5) error {
+
+	if len(levels) != k.opts.Levels {
+		return fmt.Errorf("expected %d levels, got %d", k.opts.Levels, len(levels))
+	}
+	for i, img := range levels {
+		if err := k.Set(i, layer, face, img); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetMetadata adds a key/value pair to the file. Keys starting with "KTX" or "ktx" are reserved by
+// the specification and only the ones it defines should be set. The metadata of the first image is
+// stored too, with each key prefixed by "bc5:", unless a key of that name is set here.
+func (k *KTX2Builder) SetMetadata(key, value string) error {
+
+	if key == "" || strings.IndexByte(key, 0) >= 0 {
+		return errors.New("invalid metadata key")
+	}
+	k.metadata[key] = value
+	return nil
+}
+
+// Write writes the KTX2 file to w. An error listing them is returned if any images have not been set.
+func (k *KTX2Builder) Write(w io.Writer) error {
+
+	var missing []string
+	for level := 0; level < k.opts.Levels; level++ {
+		for layer := 0; layer < k.layers(); layer++ {
+			for face := 0; face < k.faces(); face++ {
+				if k.images[k.index(level, layer, face)] == nil {
+					missing = append(missing, fmt.Sprintf("level %d, layer %d, face %d", level, layer, face))
+				}
+			}
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%d images not set:\n%s", len(missing), strings.Join(missing, "\n"))
+	}
+
+	//Gather and supercompress each level up front, as the index holds their sizes
+	raw := make([][]byte, k.opts.Levels)
+	stored := make([][]byte, k.opts.Levels)
+	for level := range raw {
+		var buf bytes.Buffer
+		for layer := 0; layer < k.layers(); layer++ {
+			for face := 0; face < k.faces(); face++ {
+				buf.Write(k.images[k.index(level, layer, face)].blockData())
+			}
+		}
+		raw[level], stored[level] = buf.Bytes(), buf.Bytes()
+		if k.opts.Supercompression == KTX2Zlib {
+			var z bytes.Buffer
+			zw := zlib.NewWriter(&z)
+			if _, err := zw.Write(raw[level]); err != nil {
+				return err
+			}
+			if err := zw.Close(); err != nil {
+				return err
+			}
+			stored[level] = z.Bytes()
+		}
+	}
+
+	dfd := k.dfd()
+	kvd := k.kvd()
+	dfdOffset := ktx2HeaderSize + ktx2LevelIndexSize*k.opts.Levels
+	kvdOffset := dfdOffset + len(dfd)
+	end := kvdOffset + len(kvd)
+
+	//Levels are stored smallest first, each aligned to a block unless supercompressed
+	align := 16
+	if k.opts.Supercompression != KTX2None {
+		align = 1
+	}
+	offsets := make([]int, k.opts.Levels)
+	for level := k.opts.Levels - 1; level >= 0; level-- {
+		end = alignUp(end, align)
+		offsets[level] = end
+		end += len(stored[level])
+	}
+
+	header := []interface{}{
+		ktx2Identifier,
+		uint32(VulkanFormat),
+		uint32(1), //Type size, 1 for block compressed formats
+		uint32(k.width),
+		uint32(k.height),
+		uint32(0),             //Depth
+		uint32(k.opts.Layers), //Zero if not an array
+		uint32(k.faces()),
+		uint32(k.opts.Levels),
+		uint32(k.opts.Supercompression),
+		uint32(dfdOffset),
+		uint32(len(dfd)),
+		uint32(kvdOffset),
+		uint32(len(kvd)),
+		uint64(0), //Supercompression global data offset
+		uint64(0), //Supercompression global data length
+	}
+	for level := range stored {
+		header = append(header, uint64(offsets[level]), uint64(len(stored[level])), uint64(len(raw[level])))
+	}
+
+	var out bytes.Buffer
+	for _, v := range header {
+		if err := binary.Write(&out, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+	out.Write(dfd)
+	out.Write(kvd)
+	for level := k.opts.Levels - 1; level >= 0; level-- {
+		out.Write(make([]byte, offsets[level]-out.Len()))
+		out.Write(stored[level])
+	}
+
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// returns the number of layers in each level, at least 1
+func (k *KTX2Builder) layers() int {
+
+	if k.opts.Layers > 0 {
+		return k.opts.Layers
+	}
+	return 1
+}
+
+// returns the number of faces in each layer
+func (k *KTX2Builder) faces() int {
+
+	if k.opts.Cubemap {
+		return 6
+	}
+	return 1
+}
+
+// returns the position of an image in k.images
+func (k *KTX2Builder) index(level, layer, face int) int {
+
+	return (level*k.layers()+layer)*k.faces() + face
+}
+
+// returns the data format descriptor, a basic descriptor block with a 64-bit sample for each channel
+func (k *KTX2Builder) dfd() []byte {
+
+	const blockSize = 24 + 16*2
+	bytesPlane0 := uint8(16)
+	if k.opts.Supercompression != KTX2None {
+		bytesPlane0 = 0 //Unsized, as required when supercompressed
+	}
+
+	d := make([]byte, 4+blockSize)
+	binary.LittleEndian.PutUint32(d, uint32(len(d)))
+	binary.LittleEndian.PutUint32(d[4:], 0) //Khronos vendor, basic descriptor type
+	binary.LittleEndian.PutUint16(d[8:], 2) //Version
+	binary.LittleEndian.PutUint16(d[10:], blockSize)
+	copy(d[12:], []byte{ktx2ModelBC5, ktx2PrimariesBT709, ktx2TransferLinear, 0})
+	copy(d[16:], []byte{3, 3, 0, 0}) //Block dimensions minus one
+	d[20] = bytesPlane0
+	for i, channel := range []uint8{ktx2ChannelBC5Red, ktx2ChannelBC5Green} {
+		s := d[28+16*i:]
+		binary.LittleEndian.PutUint16(s, uint16(64*i)) //Bit offset
+		s[2], s[3] = 63, channel                       //Bit length minus one, channel type
+		binary.LittleEndian.PutUint32(s[8:], 0)
+		binary.LittleEndian.PutUint32(s[12:], 0xFFFFFFFF)
+	}
+	return d
+}
+
+// returns the key/value data, sorted by key as the specification requires, with each entry padded to
+// 4 bytes
+func (k *KTX2Builder) kvd() []byte {
+
+	pairs := map[string]string{"KTXwriter": "go-bc5"}
+	if first := k.images[0]; first != nil {
+		for key, value := range first.Metadata {
+			pairs[ktx2MetadataPrefix+key] = value
+		}
+	}
+	for key, value := range k.metadata {
+		pairs[key] = value
+	}
+	keys := make([]string, 0, len(pairs))
+	for key := range pairs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	for _, key := range keys {
+		//Values are NUL terminated too, as the specification recommends for text
+		entry := append(append([]byte(key), 0), append([]byte(pairs[key]), 0)...)
+		binary.Write(&buf, binary.LittleEndian, uint32(len(entry)))
+		buf.Write(entry)
+		buf.Write(make([]byte, alignUp(len(entry), 4)-len(entry)))
+	}
+	return buf.Bytes()
+}
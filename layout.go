@@ -0,0 +1,205 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// MetaLayout is the metadata key recording the order Encode writes the block data in.
+const MetaLayout = "layout"
+
+// Alias for block data layout constants.
+type Layout int
+
+const (
+	Interleaved Layout = iota //Each block is stored whole, in the order it is uploaded to the GPU.
+	Planar                    //The red halves of every block are stored first, then the green halves.
+	Split                     //Like Planar, but each channel's reference values are stored apart from its indices.
+	Codebook                  //Each distinct block is stored once, followed by the index of every block's entry.
+)
+
+// byte ranges of a block making up each plane of a layout, in stored order
+var layoutPlanes = map[Layout][][2]int{
+	Planar: {{0, 8}, {8, 16}},
+	Split:  {{0, 2}, {2, 8}, {8, 10}, {10, 16}},
+}
+
+// String returns the metadata name of l.
+func (l Layout) String() string {
+
+	switch l {
+	case Planar:
+		return "planar"
+	case Split:
+		return "split"
+	case Codebook:
+		return "codebook"
+	default:
+		return "interleaved"
+	}
+}
+
+// Layout returns the layout recorded in the metadata of b.
+func (b BC5) Layout() Layout {
+
+	switch b.Metadata[MetaLayout] {
+	case Planar.String():
+		return Planar
+	case Split.String():
+		return Split
+	case Codebook.String():
+		return Codebook
+	default:
+		return Interleaved
+	}
+}
+
+// SetLayout sets the layout Encode writes the block data of b in. Grouping similar bytes together
+// makes no difference to the size of the file itself, but lets general purpose compressors such as
+// zstd or deflate find far more redundancy in it. Codebook shrinks the file itself when blocks repeat,
+// which Quantize arranges. Data in memory is always interleaved, Decode reassembles it transparently.
+func (b *BC5) SetLayout(l Layout) {
+
+	if l == Interleaved {
+		delete(b.Metadata, MetaLayout)
+		return
+	}
+	b.setMeta(MetaLayout, l.String())
+}
+
+// returns the block data of b in its stored layout
+func (b BC5) storedData() []byte {
+
+	data := b.blockData()
+	if b.Layout() == Codebook {
+		return codebookData(data)
+	}
+	planes, ok := layoutPlanes[b.Layout()]
+	if !ok {
+		return data
+	}
+
+	out := make([]byte, 0, len(data))
+	for _, plane := range planes {
+		for pos := 0; pos < len(data); pos += 16 {
+			out = append(out, data[pos+plane[0]:pos+plane[1]]...)
+		}
+	}
+	return out
+}
+
+// converts the data of a decoded b from its stored layout back to interleaved blocks, inflating it
+// first if it is supercompressed
+func (b *BC5) restoreLayout() error {
+
+	if err := b.inflate(); err != nil {
+		return err
+	}
+	name, ok := b.Metadata[MetaLayout]
+	if !ok {
+		return nil
+	}
+	if b.Layout() == Codebook {
+		return b.restoreCodebook()
+	}
+	planes, ok := layoutPlanes[b.Layout()]
+	if !ok {
+		if name == Interleaved.String() {
+			return nil
+		}
+		return errors.New("unknown layout " + name)
+	}
+
+	n := b.Rect.Size().X / 4 * b.Rect.Size().Y / 4
+	if len(b.Data) < n*16 {
+		return errors.New("not enough image data for layout")
+	}
+	data := make([]byte, n*16)
+	src := b.Data
+	for _, plane := range planes {
+		size := plane[1] - plane[0]
+		for i := 0; i < n; i++ {
+			copy(data[i*16+plane[0]:i*16+plane[1]], src[i*size:(i+1)*size])
+		}
+		src = src[n*size:]
+	}
+	b.Data = data
+	return nil
+}
+
+// returns data as a uint32 entry count, the distinct blocks in order of first use and the index of
+// each block's entry, as uint16 values if there are at most 65536 entries and uint32 otherwise
+func codebookData(data []byte) []byte {
+
+	entries := make(map[[16]byte]uint32)
+	var book []byte
+	ix := make([]uint32, len(data)/16)
+	for i := range ix {
+		var block [16]byte
+		copy(block[:], data[i*16:])
+		e, ok := entries[block]
+		if !ok {
+			e = uint32(len(entries))
+			entries[block] = e
+			book = append(book, block[:]...)
+		}
+		ix[i] = e
+	}
+
+	width := indexWidth(len(entries))
+	out := make([]byte, 4, 4+len(book)+len(ix)*width)
+	binary.BigEndian.PutUint32(out, uint32(len(entries)))
+	out = append(out, book...)
+	for _, e := range ix {
+		if width == 2 {
+			out = append(out, byte(e>>8), byte(e))
+		} else {
+			out = append(out, byte(e>>24), byte(e>>16), byte(e>>8), byte(e))
+		}
+	}
+	return out
+}
+
+// expands data written by codebookData
+func (b *BC5) restoreCodebook() error {
+
+	n := b.Rect.Size().X / 4 * b.Rect.Size().Y / 4
+	if len(b.Data) < 4 {
+		return errors.New("missing codebook size")
+	}
+	entries := int(binary.BigEndian.Uint32(b.Data))
+	width := indexWidth(entries)
+	if entries > n || len(b.Data) < 4+entries*16+n*width {
+		return errors.New("not enough image data for codebook")
+	}
+
+	book := b.Data[4 : 4+entries*16]
+	ix := b.Data[4+entries*16:]
+	data := make([]byte, n*16)
+	for i := 0; i < n; i++ {
+		var e int
+		if width == 2 {
+			e = int(binary.BigEndian.Uint16(ix[i*2:]))
+		} else {
+			e = int(binary.BigEndian.Uint32(ix[i*4:]))
+		}
+		if e >= entries {
+			return errors.New("codebook index out of range")
+		}
+		copy(data[i*16:i*16+16], book[e*16:])
+	}
+	b.Data = data
+	return nil
+}
+
+// returns the size in bytes of a codebook index
+func indexWidth(entries int) int {
+
+	if entries <= 1<<16 {
+		return 2
+	}
+	return 4
+}
@@ -0,0 +1,262 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+	"sync"
+)
+
+// MaxLazyMetadata is the largest metadata in bytes NewLazyBC5 will read. As the length of the file
+// behind an io.ReaderAt is unknown, the length in the header cannot otherwise be checked before the
+// metadata is allocated.
+const MaxLazyMetadata = 16 << 20
+
+// LazyBC5 is a BC5 file whose blocks are read from an io.ReaderAt only when needed, so that parts of
+// large textures can be decompressed without reading the whole file.
+type LazyBC5 struct {
+	Rect     image.Rectangle
+	BlueMode BlueMode
+	Swizzle  Swizzle
+	Metadata map[string]string
+
+	//MemoryBudget limits the memory in bytes a single read may use, zero for no limit. Reads needing
+	//more return ErrBudgetExceeded.
+	MemoryBudget int64
+
+	r      io.ReaderAt
+	offset int64 //Position of the first block in r.
+
+	warmup  sync.Once
+	mu      sync.Mutex
+	hints   []image.Rectangle //Rectangles waiting to be prefetched, most urgent first.
+	wake    chan struct{}
+	done    chan struct{}
+	closing sync.Once
+}
+
+// NewLazyBC5 reads the header of the BC5 file in r and returns a LazyBC5 for it.
+func NewLazyBC5(r io.ReaderAt) (*LazyBC5, error) {
+
+	header := make([]byte, 16)
+	n, err := r.ReadAt(header, 0)
+	if n < 12 {
+		if err == nil || err == io.EOF {
+			err = errors.New("not enough data for BC5")
+		}
+		return nil, err
+	}
+
+	signature := binary.BigEndian.Uint32(header[:4])
+	if signature != strToDword("BC5 ") && signature != strToDword("BC52") {
+		return nil, errors.New("invalid file signature")
+	}
+	width, height := binary.BigEndian.Uint32(header[4:8]), binary.BigEndian.Uint32(header[8:12])
+
+	l := &LazyBC5{Rect: image.Rect(0, 0, int(width), int(height)), r: r, offset: 12}
+	if signature == strToDword("BC52") {
+		if n < 16 {
+			return nil, errors.New("missing metadata length")
+		}
+		metaLen := binary.BigEndian.Uint32(header[12:16])
+		if metaLen > MaxLazyMetadata {
+			return nil, errors.New("metadata too large")
+		}
+		meta := make([]byte, metaLen)
+		if _, err := r.ReadAt(meta, 16); err != nil {
+			return nil, errors.New("not enough data for metadata")
+		}
+		if l.Metadata, err = unmarshalMetadata(meta); err != nil {
+			return nil, err
+		}
+		profile := &BC5{Metadata: l.Metadata}
+		profile.restoreProfile()
+		l.BlueMode, l.Swizzle = profile.BlueMode, profile.Swizzle
+		l.offset = 16 + int64(len(meta))
+	}
+	if (&BC5{Metadata: l.Metadata}).Layout() != Interleaved {
+		return nil, errors.New("blocks cannot be read individually from a file that is not interleaved")
+	}
+	if (&BC5{Metadata: l.Metadata}).Supercompression() != KTX2None {
+		return nil, errors.New("blocks cannot be read individually from a supercompressed file")
+	}
+	return l, nil
+}
+
+// ContentSize returns the size of the image l was compressed from, before any padding, as
+// BC5.ContentSize does.
+func (l *LazyBC5) ContentSize() image.Point {
+
+	return BC5{Rect: l.Rect, Metadata: l.Metadata}.ContentSize()
+}
+
+// Region reads the blocks covering r, clipped to the ContentSize of l, and returns them as a BC5 along
+// with the rectangle of the image they cover, which is r expanded to 4x4 block boundaries. Each row of
+// blocks is fetched with a single read.
+func (l *LazyBC5) Region(r image.Rectangle) (*BC5, image.Rectangle, error) {
+
+	r = r.Intersect(image.Rectangle{Max: l.ContentSize()})
+	if r.Empty() {
+		return nil, r, errors.New("rectangle does not overlap the image")
+	}
+	area := image.Rect(r.Min.X/4*4, r.Min.Y/4*4, (r.Max.X+3)/4*4, (r.Max.Y+3)/4*4)
+	b, err := l.readBlocks(area)
+	return b, area, err
+}
+
+// reads the blocks of area, which must be aligned to 4x4 blocks and lie within l.Rect, and returns
+// them as a BC5
+func (l *LazyBC5) readBlocks(area image.Rectangle) (*BC5, error) {
+
+	if l.MemoryBudget > 0 && int64(area.Dx()*area.Dy()) > l.MemoryBudget {
+		return nil, ErrBudgetExceeded
+	}
+
+	rowBytes := area.Dx() / 4 * 16
+	data := make([]byte, rowBytes*area.Dy()/4)
+	for y := area.Min.Y; y < area.Max.Y; y += 4 {
+		pos := l.offset + int64(((y/4)*(l.Rect.Size().X/4)+area.Min.X/4)*16)
+		dst := data[(y-area.Min.Y)/4*rowBytes:][:rowBytes]
+		if n, err := l.r.ReadAt(dst, pos); n < len(dst) {
+			if err == nil || err == io.EOF {
+				err = errors.New("not enough image data")
+			}
+			return nil, err
+		}
+	}
+	return &BC5{Data: data, Rect: image.Rect(0, 0, area.Dx(), area.Dy()), BlueMode: l.BlueMode, Swizzle: l.Swizzle}, nil
+}
+
+// DecompressRect reads and decompresses the pixels of l within r, returning an image with bounds r
+// clipped to the ContentSize of l, as BC5.DecompressRect does, so padding is never returned.
+func (l *LazyBC5) DecompressRect(r image.Rectangle) (*image.RGBA, error) {
+
+	b, area, err := l.Region(r)
+	if err != nil {
+		return nil, err
+	}
+	r = r.Intersect(area).Intersect(image.Rectangle{Max: l.ContentSize()})
+	if l.MemoryBudget > 0 && int64(area.Dx()*area.Dy()+r.Dx()*r.Dy()*4) > l.MemoryBudget {
+		return nil, ErrBudgetExceeded
+	}
+	rgba := b.decompressRect(r.Sub(area.Min))
+	rgba.Rect = r
+	return rgba, nil
+}
+
+// DecompressTiles decompresses the pixels of l within r in square tiles, passing each to fn in rows
+// from the top left. The tiles are as large as MemoryBudget allows, or a single tile covering r if
+// there is no budget, so large areas can be processed or written out to disk without holding them in
+// memory at once. ErrBudgetExceeded is returned if the budget cannot hold a single 4x4 block.
+func (l *LazyBC5) DecompressTiles(r image.Rectangle, fn func(tile *image.RGBA) error) error {
+
+	r = r.Intersect(image.Rectangle{Max: l.ContentSize()})
+	tileSize := r.Dx()
+	if r.Dy() > tileSize {
+		tileSize = r.Dy()
+	}
+	if l.MemoryBudget > 0 {
+		//A tile needs its pixels and the blocks under them, which may overhang by up to a block each side
+		limit := tileSize
+		tileSize = 0
+		for next := 4; next <= limit && int64((next+8)*(next+8)*5) <= l.MemoryBudget; next += 4 {
+			tileSize = next
+		}
+		if tileSize == 0 {
+			return ErrBudgetExceeded
+		}
+	}
+
+	for y := r.Min.Y; y < r.Max.Y; y += tileSize {
+		for x := r.Min.X; x < r.Max.X; x += tileSize {
+			tile, err := l.DecompressRect(image.Rect(x, y, x+tileSize, y+tileSize).Intersect(r))
+			if err != nil {
+				return err
+			}
+			if err := fn(tile); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Load reads every block of l and returns the complete image.
+func (l *LazyBC5) Load() (*BC5, error) {
+
+	b, err := l.readBlocks(image.Rect(0, 0, l.Rect.Size().X, l.Rect.Size().Y))
+	if err != nil {
+		return nil, err
+	}
+	b.Rect, b.Metadata = l.Rect, l.Metadata
+	return b, nil
+}
+
+// Prefetch hints that the pixels within rects will be needed soon, most urgent first. The blocks
+// covering them are read in the background by a single goroutine, which warms any cache behind the
+// io.ReaderAt, such as a RangeReaderAt, so that later reads of them return quickly. Each call replaces
+// the hints that have not been started yet, so a streaming renderer can pass the areas around the
+// camera every frame without falling behind. Read errors are ignored, they are returned again by
+// the read that needs the data.
+func (l *LazyBC5) Prefetch(rects ...image.Rectangle) {
+
+	l.warmup.Do(func() {
+		l.wake = make(chan struct{}, 1)
+		l.done = make(chan struct{})
+		go l.prefetchLoop()
+	})
+
+	l.mu.Lock()
+	l.hints = append(l.hints[:0], rects...)
+	l.mu.Unlock()
+	select {
+	case l.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Close stops the background goroutine started by Prefetch. It does not close the io.ReaderAt.
+func (l *LazyBC5) Close() error {
+
+	l.warmup.Do(func() {})
+	l.closing.Do(func() {
+		if l.done != nil {
+			close(l.done)
+		}
+	})
+	return nil
+}
+
+// reads the hinted rectangles until l is closed
+func (l *LazyBC5) prefetchLoop() {
+
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-l.wake:
+		}
+
+		for {
+			l.mu.Lock()
+			if len(l.hints) == 0 {
+				l.mu.Unlock()
+				break
+			}
+			r := l.hints[0]
+			l.hints = l.hints[1:]
+			l.mu.Unlock()
+
+			select {
+			case <-l.done:
+				return
+			default:
+			}
+			l.Region(r)
+		}
+	}
+}
@@ -0,0 +1,338 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"container/list"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrTextureNotFound is returned by TextureLibrary.Get for names its source does not hold.
+var ErrTextureNotFound = errors.New("texture not found")
+
+// TextureSource provides the files of a TextureLibrary by logical name.
+type TextureSource interface {
+	Names() ([]string, error)                //Returns the name of every texture held.
+	Open(name string) (io.ReadCloser, error) //Opens the file of a texture, in the format Decode reads.
+}
+
+// DirSource is a TextureSource holding the ".bc5" files in a directory and its subdirectories. Each is
+// named by its path relative to the directory, with forward slashes and without the extension, such
+// as "ui/icons/close".
+type DirSource string
+
+// Names returns the name of every ".bc5" file in d.
+func (d DirSource) Names() ([]string, error) {
+
+	var names []string
+	err := filepath.Walk(string(d), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.EqualFold(filepath.Ext(path), ".bc5") {
+			return nil
+		}
+		rel, err := filepath.Rel(string(d), path)
+		if err != nil {
+			return err
+		}
+		names = append(names, filepath.ToSlash(strings.TrimSuffix(rel, filepath.Ext(rel))))
+		return nil
+	})
+	return names, err
+}
+
+// Open opens the file of the texture called name.
+func (d DirSource) Open(name string) (io.ReadCloser, error) {
+
+	return os.Open(filepath.Join(string(d), filepath.FromSlash(name)+".bc5"))
+}
+
+// ErrMemoryLimit is returned by TextureLibrary.Acquire when pinning a texture would take the textures
+// pinned over the library's MemoryLimit.
+var ErrMemoryLimit = errors.New("texture library memory limit reached")
+
+// TextureLibrary is an in-memory cache of the textures of a TextureSource, keyed by name. Each texture
+// is decoded the first time it is asked for, and the most recently used are kept, up to CacheTextures
+// and MemoryLimit. Textures in use can be pinned with Acquire, so they are never evicted until each
+// Acquire has been matched by a Release; the rest are evicted least recently used first. It is safe for
+// concurrent use.
+type TextureLibrary struct {
+	Source        TextureSource
+	CacheTextures int                         //Maximum number of decoded textures kept, 32 if zero. Pinned textures count towards it but are kept regardless.
+	MemoryLimit   int64                       //Maximum bytes of block data kept, including pinned textures, or no limit if zero.
+	OnEvict       func(name string, tex *BC5) //Called, if set, for each texture dropped from the cache, once the library is unlocked.
+
+	mu       sync.Mutex
+	names    map[string]bool
+	textures map[string]*list.Element
+	lru      *list.List
+	bytes    int64                   //Block data of every cached texture.
+	pinned   int64                   //Block data of the pinned textures.
+	loading  map[string]*libraryLoad //Textures being decoded, for callers to wait on.
+}
+
+// a texture being decoded by a TextureLibrary
+type libraryLoad struct {
+	done chan struct{} //Closed once tex and err are set.
+	tex  *BC5
+	err  error
+}
+
+// a cached texture
+type libraryTexture struct {
+	name  string
+	tex   *BC5
+	size  int64
+	refs  int  //Number of Acquire calls not yet released.
+	stale bool //Whether Refresh was called while pinned, so it is dropped once released.
+}
+
+// NewTextureLibrary returns a TextureLibrary for src, reading the names it holds.
+func NewTextureLibrary(src TextureSource) (*TextureLibrary, error) {
+
+	l := &TextureLibrary{Source: src}
+	if err := l.Refresh(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Refresh reads the names held by the source again, for when textures have been added or removed, and
+// drops every decoded texture so that changed files are decoded afresh. Pinned textures are kept, and
+// still returned for their names, until they are released.
+func (l *TextureLibrary) Refresh() error {
+
+	names, err := l.Source.Names()
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	l.names = make(map[string]bool, len(names))
+	for _, name := range names {
+		l.names[name] = true
+	}
+	if l.lru == nil {
+		l.textures = make(map[string]*list.Element)
+		l.lru = list.New()
+		l.loading = make(map[string]*libraryLoad)
+	}
+	var evicted []*libraryTexture
+	for e := l.lru.Front(); e != nil; {
+		next := e.Next()
+		if t := e.Value.(*libraryTexture); t.refs > 0 {
+			t.stale = true
+		} else {
+			evicted = append(evicted, l.remove(e))
+		}
+		e = next
+	}
+	l.mu.Unlock()
+	l.notify(evicted)
+	return nil
+}
+
+// List returns the name of every texture in the library, sorted.
+func (l *TextureLibrary) List() []string {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	names := make([]string, 0, len(l.names))
+	for name := range l.names {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Exists reports whether the library holds a texture called name.
+func (l *TextureLibrary) Exists(name string) bool {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.names[name]
+}
+
+// Get returns the texture called name, decoding it if it is not cached. Concurrent calls for a texture
+// being decoded wait for that decode rather than repeating it. ErrTextureNotFound is returned if there
+// is none. The texture is shared with other callers and must not be modified. It may be
+// evicted at any time, straight away if it does not fit under MemoryLimit; use Acquire to keep it.
+func (l *TextureLibrary) Get(name string) (*BC5, error) {
+
+	return l.get(name, false)
+}
+
+// Acquire returns the texture called name as Get does, and pins it in the cache until Release is
+// called for it as many times as Acquire was. ErrMemoryLimit is returned, and nothing pinned, if it
+// would take the pinned textures over MemoryLimit.
+func (l *TextureLibrary) Acquire(name string) (*BC5, error) {
+
+	return l.get(name, true)
+}
+
+// Release unpins a texture returned by Acquire. Once every Acquire has been released, the texture may
+// be evicted like any other. An error is returned if the texture called name is not pinned.
+func (l *TextureLibrary) Release(name string) error {
+
+	l.mu.Lock()
+	e, ok := l.textures[name]
+	if !ok || e.Value.(*libraryTexture).refs == 0 {
+		l.mu.Unlock()
+		return errors.New("texture " + name + " is not acquired")
+	}
+	var evicted []*libraryTexture
+	t := e.Value.(*libraryTexture)
+	t.refs--
+	if t.refs == 0 {
+		l.pinned -= t.size
+		if t.stale {
+			evicted = append(evicted, l.remove(e))
+		} else {
+			evicted = l.trim()
+		}
+	}
+	l.mu.Unlock()
+	l.notify(evicted)
+	return nil
+}
+
+// returns the texture called name, pinning it if pin is set, and calls OnEvict for anything evicted
+// to make room
+func (l *TextureLibrary) get(name string, pin bool) (*BC5, error) {
+
+	l.mu.Lock()
+	tex, evicted, err := l.load(name, pin)
+	l.mu.Unlock()
+	l.notify(evicted)
+	return tex, err
+}
+
+// returns the texture called name, decoding and caching it if needed, along with the textures evicted
+// to make room. l.mu must be held, and is released while decoding, so callers asking for a texture
+// being decoded wait for it rather than decoding it again.
+func (l *TextureLibrary) load(name string, pin bool) (*BC5, []*libraryTexture, error) {
+
+	if !l.names[name] {
+		return nil, nil, ErrTextureNotFound
+	}
+	if e, ok := l.textures[name]; ok {
+		return l.use(e, pin)
+	}
+
+	var tex *BC5
+	if ld, ok := l.loading[name]; ok {
+		l.mu.Unlock()
+		<-ld.done
+		l.mu.Lock()
+		if ld.err != nil {
+			return nil, nil, ld.err
+		}
+		if e, ok := l.textures[name]; ok {
+			return l.use(e, pin)
+		}
+		tex = ld.tex
+	} else {
+		ld := &libraryLoad{done: make(chan struct{})}
+		l.loading[name] = ld
+		l.mu.Unlock()
+		ld.tex, ld.err = l.decode(name)
+		l.mu.Lock()
+		delete(l.loading, name)
+		close(ld.done)
+		if ld.err != nil {
+			return nil, nil, ld.err
+		}
+		tex = ld.tex
+	}
+
+	t := &libraryTexture{name: name, tex: tex, size: int64(len(tex.Data))}
+	if pin {
+		if l.MemoryLimit > 0 && l.pinned+t.size > l.MemoryLimit {
+			return nil, nil, ErrMemoryLimit
+		}
+		t.refs = 1
+		l.pinned += t.size
+	}
+	l.textures[name] = l.lru.PushFront(t)
+	l.bytes += t.size
+	return tex, l.trim(), nil
+}
+
+// returns the cached texture e, pinning it if pin is set. l.mu must be held.
+func (l *TextureLibrary) use(e *list.Element, pin bool) (*BC5, []*libraryTexture, error) {
+
+	t := e.Value.(*libraryTexture)
+	if pin {
+		if t.refs == 0 {
+			if l.MemoryLimit > 0 && l.pinned+t.size > l.MemoryLimit {
+				return nil, nil, ErrMemoryLimit
+			}
+			l.pinned += t.size
+		}
+		t.refs++
+	}
+	l.lru.MoveToFront(e)
+	return t.tex, nil, nil
+}
+
+// opens and decodes the texture called name
+func (l *TextureLibrary) decode(name string) (*BC5, error) {
+
+	f, err := l.Source.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Decode(f)
+}
+
+// evicts unpinned textures, least recently used first, until the cache is within its limits or only
+// pinned textures remain, and returns them. l.mu must be held.
+func (l *TextureLibrary) trim() []*libraryTexture {
+
+	limit := l.CacheTextures
+	if limit <= 0 {
+		limit = 32
+	}
+	var evicted []*libraryTexture
+	for e := l.lru.Back(); e != nil; {
+		if l.lru.Len() <= limit && (l.MemoryLimit <= 0 || l.bytes <= l.MemoryLimit) {
+			break
+		}
+		prev := e.Prev()
+		if e.Value.(*libraryTexture).refs == 0 {
+			evicted = append(evicted, l.remove(e))
+		}
+		e = prev
+	}
+	return evicted
+}
+
+// removes a cached texture and returns it. l.mu must be held.
+func (l *TextureLibrary) remove(e *list.Element) *libraryTexture {
+
+	t := e.Value.(*libraryTexture)
+	l.lru.Remove(e)
+	delete(l.textures, t.name)
+	l.bytes -= t.size
+	return t
+}
+
+// calls OnEvict for each texture evicted, in the order they were. l.mu must not be held, so OnEvict
+// may use the library.
+func (l *TextureLibrary) notify(evicted []*libraryTexture) {
+
+	if l.OnEvict == nil {
+		return
+	}
+	for _, t := range evicted {
+		l.OnEvict(t.name, t.tex)
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"image"
+	"math"
+)
+
+// LODOptions describes a tiled mip chain laid out in world space for SelectLOD. Each level has half the
+// resolution of the last with tiles of the same pixel size, as with NewMipChain and TileFetcher, so a
+// tile of level n covers 2^n times the world units of a tile of the base level.
+type LODOptions struct {
+	TileWorldSize int         //World units covered by a tile of the base level.
+	TilePixels    int         //Width and height of a tile in pixels, as TileFetcher.TileSize. Defaults to 64.
+	Levels        int         //Number of levels in the chain. Defaults to 1.
+	Size          image.Point //Size of the base level in pixels, limiting the tiles selected. Unlimited if zero.
+	Bias          float64     //Added to the chosen level before rounding down, positive to favour coarser levels.
+}
+
+// LODSelection is the result of SelectLOD.
+type LODSelection struct {
+	Level         int           //Level to fetch tiles from.
+	TileWorldSize int           //World units covered by a tile of Level.
+	Tiles         []image.Point //Tiles of Level overlapping the viewport, in rows from the top left.
+}
+
+// SelectLOD chooses the coarsest level whose pixels are no larger on screen than a screen pixel when the
+// world space viewport is drawn at screen pixels, and returns the tiles of it that cover the viewport.
+// The tile IDs index TileFetcher.FetchTile, and TileRect gives where to place each in an Assembler.
+func SelectLOD(viewport image.Rectangle, screen image.Point, opts LODOptions) LODSelection {
+
+	tilePixels, levels := opts.TilePixels, opts.Levels
+	if tilePixels <= 0 {
+		tilePixels = 64
+	}
+	if levels <= 0 {
+		levels = 1
+	}
+	if opts.TileWorldSize <= 0 || viewport.Empty() {
+		return LODSelection{}
+	}
+
+	//Base level pixels per screen pixel along each axis, the finer of which decides the level
+	pixelsPerUnit := float64(tilePixels) / float64(opts.TileWorldSize)
+	ratio := math.Inf(1)
+	if screen.X > 0 {
+		ratio = math.Min(ratio, pixelsPerUnit*float64(viewport.Dx())/float64(screen.X))
+	}
+	if screen.Y > 0 {
+		ratio = math.Min(ratio, pixelsPerUnit*float64(viewport.Dy())/float64(screen.Y))
+	}
+	level := 0
+	if !math.IsInf(ratio, 1) && ratio > 1 {
+		level = int(math.Floor(math.Log2(ratio) + opts.Bias))
+	} else if opts.Bias >= 1 {
+		level = int(opts.Bias)
+	}
+	level = clampInt(level, 0, levels-1)
+
+	sel := LODSelection{Level: level, TileWorldSize: opts.TileWorldSize << uint(level)}
+	tiles := image.Rect(
+		floorDiv(viewport.Min.X, sel.TileWorldSize),
+		floorDiv(viewport.Min.Y, sel.TileWorldSize),
+		floorDiv(viewport.Max.X-1, sel.TileWorldSize)+1,
+		floorDiv(viewport.Max.Y-1, sel.TileWorldSize)+1,
+	)
+	if opts.Size != (image.Point{}) {
+		size := image.Pt(clampInt(opts.Size.X>>uint(level), 1, opts.Size.X), clampInt(opts.Size.Y>>uint(level), 1, opts.Size.Y))
+		tiles = tiles.Intersect(image.Rect(0, 0, (size.X+tilePixels-1)/tilePixels, (size.Y+tilePixels-1)/tilePixels))
+	}
+	for y := tiles.Min.Y; y < tiles.Max.Y; y++ {
+		for x := tiles.Min.X; x < tiles.Max.X; x++ {
+			sel.Tiles = append(sel.Tiles, image.Pt(x, y))
+		}
+	}
+	return sel
+}
+
+// TileRect returns the world space rectangle covered by tile of the selected level.
+func (s LODSelection) TileRect(tile image.Point) image.Rectangle {
+
+	origin := tile.Mul(s.TileWorldSize)
+	return image.Rectangle{origin, origin.Add(image.Pt(s.TileWorldSize, s.TileWorldSize))}
+}
+
+// returns a/b rounded towards negative infinity, for positive b
+func floorDiv(a, b int) int {
+
+	if a < 0 {
+		return -((-a + b - 1) / b)
+	}
+	return a / b
+}
@@ -0,0 +1,129 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"sort"
+)
+
+// Metadata keys used by this package.
+const (
+	MetaConvention  = "convention"  //Green channel convention, "opengl" or "directx".
+	MetaChecksum    = "crc32"       //CRC-32 (IEEE) of the block data as 8 hex digits.
+	MetaProfile     = "profile"     //Name of the encode profile used.
+	MetaSize        = "size"        //Size of the source before padding to whole blocks, as "WIDTHxHEIGHT".
+	MetaUVTransform = "uvtransform" //Scale and offset mapping texture coordinates of the source onto the stored texture, as "SU SV OU OV".
+)
+
+// String returns the metadata name of c.
+func (c GreenConvention) String() string {
+
+	switch c {
+	case OpenGL:
+		return "opengl"
+	case DirectX:
+		return "directx"
+	default:
+		return "unknown"
+	}
+}
+
+// Convention returns the green channel convention recorded in the metadata of b.
+func (b BC5) Convention() GreenConvention {
+
+	switch b.Metadata[MetaConvention] {
+	case OpenGL.String():
+		return OpenGL
+	case DirectX.String():
+		return DirectX
+	default:
+		return UnknownConvention
+	}
+}
+
+// SetConvention records the green channel convention of b in its metadata.
+func (b *BC5) SetConvention(c GreenConvention) {
+
+	if c == UnknownConvention {
+		delete(b.Metadata, MetaConvention)
+		return
+	}
+	b.setMeta(MetaConvention, c.String())
+}
+
+// SetChecksum records a checksum of the block data of b in its metadata.
+func (b *BC5) SetChecksum() {
+
+	b.setMeta(MetaChecksum, fmt.Sprintf("%08x", crc32.ChecksumIEEE(b.blockData())))
+}
+
+// VerifyChecksum compares the block data of b against the checksum in its metadata. It returns false
+// for present if b has no checksum.
+func (b BC5) VerifyChecksum() (ok, present bool) {
+
+	sum, present := b.Metadata[MetaChecksum]
+	if !present {
+		return false, false
+	}
+	return sum == fmt.Sprintf("%08x", crc32.ChecksumIEEE(b.blockData())), true
+}
+
+// sets a metadata value, creating the map if needed
+func (b *BC5) setMeta(key, value string) {
+
+	if b.Metadata == nil {
+		b.Metadata = make(map[string]string)
+	}
+	b.Metadata[key] = value
+}
+
+// returns the binary form of meta, each entry being a uint16 key length, the key, a uint32 value
+// length and the value, ordered by key
+func marshalMetadata(meta map[string]string) []byte {
+
+	keys := make([]string, 0, len(meta))
+	for k := range meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var out []byte
+	for _, k := range keys {
+		entry := make([]byte, 6+len(k)+len(meta[k]))
+		binary.BigEndian.PutUint16(entry[:2], uint16(len(k)))
+		copy(entry[2:], k)
+		binary.BigEndian.PutUint32(entry[2+len(k):], uint32(len(meta[k])))
+		copy(entry[6+len(k):], meta[k])
+		out = append(out, entry...)
+	}
+	return out
+}
+
+// parses metadata written by marshalMetadata
+func unmarshalMetadata(b []byte) (map[string]string, error) {
+
+	meta := make(map[string]string)
+	for len(b) > 0 {
+		if len(b) < 2 {
+			return nil, errors.New("truncated metadata key")
+		}
+		keyLen := int(binary.BigEndian.Uint16(b))
+		if len(b) < 6+keyLen {
+			return nil, errors.New("truncated metadata key")
+		}
+		key := string(b[2 : 2+keyLen])
+		valueLen := int(binary.BigEndian.Uint32(b[2+keyLen:]))
+		b = b[6+keyLen:]
+		if len(b) < valueLen {
+			return nil, errors.New("truncated metadata value")
+		}
+		meta[key] = string(b[:valueLen])
+		b = b[valueLen:]
+	}
+	return meta, nil
+}
@@ -0,0 +1,186 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MigrateOptions holds settings for MigrateFiles.
+type MigrateOptions struct {
+	Recursive bool              //Descend into subdirectories of the source directory.
+	DstDir    string            //Directory to write the migrated files to, mirroring the source tree. Files are rewritten in place if empty.
+	Metadata  map[string]string //Entries added to the metadata of every migrated file.
+	Layout    Layout            //Layout to store the block data in, such as Split to help zstd or deflate compress the files.
+	RepairV1  bool              //Repair files detected with DetectV1 as written by the first release of this package or in BigEndianOrder, including those already using "BC52".
+	DryRun    bool              //Report what would be done without writing anything.
+
+	//Supercompression compresses the stored block data of every migrated file, such as with KTX2Zlib.
+	//See BC5.SetSupercompression.
+	Supercompression KTX2Supercompression
+}
+
+// MigrateReport lists the files handled by MigrateFiles.
+type MigrateReport struct {
+	Files []MigratedFile
+}
+
+// MigratedFile describes what MigrateFiles did, or in a dry run would do, with one file.
+type MigratedFile struct {
+	Path       string //The source file.
+	Output     string //Where the migrated file is written, empty if it was skipped.
+	Skipped    bool   //The file already uses the metadata container and needs no repair.
+	RepairedV1 bool   //The indices were repaired from V1Order.
+	Repaired   bool   //The indices were repaired from V1Order or BigEndianOrder.
+	Err        error
+}
+
+// MigrateFiles rewrites every ".bc5" file in srcDir that uses the original "BC5 " container into the
+// "BC52" container, recording a checksum of the block data along with opts.Metadata and storing the
+// blocks in opts.Layout with opts.Supercompression. Files already using "BC52" are skipped unless
+// opts.RepairV1 is set and their indices are detected in BigEndianOrder. Files are rewritten in place
+// through a temporary file, so an interrupted migration never leaves one half written, unless
+// opts.DstDir is set. If any files fail to migrate, the rest are still migrated and an error listing the failures is
+// returned along with the report.
+func MigrateFiles(srcDir string, opts *MigrateOptions) (*MigrateReport, error) {
+
+	if opts == nil {
+		opts = &MigrateOptions{}
+	}
+
+	report := &MigrateReport{}
+	err := filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != srcDir && !opts.Recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.EqualFold(filepath.Ext(path), ".bc5") {
+			report.Files = append(report.Files, migrateFile(srcDir, path, opts))
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	var failed []string
+	for _, f := range report.Files {
+		if f.Err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", f.Path, f.Err))
+		}
+	}
+	if len(failed) > 0 {
+		return report, fmt.Errorf("failed to migrate %d files:\n%s", len(failed), strings.Join(failed, "\n"))
+	}
+	return report, nil
+}
+
+// WriteText writes a line for each file in r to w.
+func (r *MigrateReport) WriteText(w io.Writer) error {
+
+	for _, f := range r.Files {
+		var line string
+		switch {
+		case f.Err != nil:
+			line = fmt.Sprintf("FAIL %s: %v", f.Path, f.Err)
+		case f.Skipped:
+			line = fmt.Sprintf("skip %s: already migrated", f.Path)
+		case f.RepairedV1:
+			line = fmt.Sprintf("ok   %s -> %s (repaired first release indices)", f.Path, f.Output)
+		default:
+			line = fmt.Sprintf("ok   %s -> %s", f.Path, f.Output)
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrates the file at path, writing nothing if opts.DryRun is set
+func migrateFile(srcDir, path string, opts *MigrateOptions) MigratedFile {
+
+	result := MigratedFile{Path: path}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	current := len(data) >= 4 && string(data[:4]) == "BC52"
+	if current && !opts.RepairV1 {
+		result.Skipped = true
+		return result
+	}
+	img, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	order := SpecOrder
+	if opts.RepairV1 {
+		order = img.DetectV1().Order
+	}
+	if current && order != BigEndianOrder {
+		//Only the "BC5 " container could hold data from the first release
+		result.Skipped = true
+		return result
+	}
+
+	result.Output = path
+	if opts.DstDir != "" {
+		rel, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			result.Err = err
+			return result
+		}
+		result.Output = filepath.Join(opts.DstDir, rel)
+	}
+	if order != SpecOrder {
+		img.MigrateIndices(order)
+		result.Repaired, result.RepairedV1 = true, order == V1Order
+	}
+	for k, v := range opts.Metadata {
+		img.setMeta(k, v)
+	}
+	img.SetLayout(opts.Layout)
+	if err := img.SetSupercompression(opts.Supercompression); err != nil {
+		result.Err = err
+		return result
+	}
+	img.SetChecksum()
+	if opts.DryRun {
+		return result
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(img, &buf); err != nil {
+		result.Err = err
+		return result
+	}
+	if err := os.MkdirAll(filepath.Dir(result.Output), 0755); err != nil {
+		result.Err = err
+		return result
+	}
+	tmp := result.Output + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		result.Err = err
+		return result
+	}
+	if err := os.Rename(tmp, result.Output); err != nil {
+		os.Remove(tmp)
+		result.Err = err
+	}
+	return result
+}
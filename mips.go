@@ -0,0 +1,227 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"image"
+	"math"
+)
+
+// NewMipChain compresses rgba and each successive half size level of it, ordered from the base level
+// down as NewWebGPUUpload expects. Levels are box filtered unless opts.Profile names a profile with
+// another MipFilter or ColorSpace. The chain ends at the first level whose half would
+// not be a multiple of 4 in both width and height, which for a square image is 4 by 4 at the latest.
+// opts is used for every level, except that opts.Previous only applies to the base level. See
+// EncodeOptions.ShareMipSearch for reusing the search of each level in the next.
+func NewMipChain(rgba *image.RGBA, opts *EncodeOptions) ([]*BC5, error) {
+
+	if opts == nil {
+		opts = &EncodeOptions{}
+	}
+
+	_, profile, err := opts.resolve()
+	if err != nil {
+		return nil, err
+	}
+	if profile == nil {
+		profile = &Profile{}
+	}
+
+	sources := []*image.RGBA{rgba}
+	for rgba.Rect.Dx()%8 == 0 && rgba.Rect.Dy()%8 == 0 {
+		rgba = mipDownsample(rgba, profile.MipFilter, profile.ColorSpace)
+		sources = append(sources, rgba)
+	}
+
+	levels := make([]*BC5, len(sources))
+	levelOpts := *opts
+	compress := func(level int) error {
+		levelOpts.Previous = nil
+		if level == 0 {
+			levelOpts.Previous = opts.Previous
+		}
+		b := new(BC5)
+		if err := b.SetFromRGBAWithOptions(sources[level], &levelOpts); err != nil {
+			return err
+		}
+		levels[level] = b
+		return nil
+	}
+
+	if !opts.ShareMipSearch {
+		for level := range sources {
+			if err := compress(level); err != nil {
+				return nil, err
+			}
+		}
+		return levels, nil
+	}
+	for level := len(sources) - 1; level >= 0; level-- {
+		if err := compress(level); err != nil {
+			return nil, err
+		}
+		levelOpts.mipParent = levels[level]
+	}
+	return levels, nil
+}
+
+// returns the next mip level of src, half its size, produced with filter from values in the color
+// space space
+func mipDownsample(src *image.RGBA, filter MipFilter, space ColorSpace) *image.RGBA {
+
+	if filter == BoxFilter && space == Linear {
+		return downsample(src, src.Rect, 2)
+	}
+
+	out := image.NewRGBA(image.Rect(0, 0, src.Rect.Dx()/2, src.Rect.Dy()/2))
+	for y := 0; y < out.Rect.Dy(); y++ {
+		for x := 0; x < out.Rect.Dx(); x++ {
+			var sum [4]float64
+			var normal [3]float64
+			for sy := 0; sy < 2; sy++ {
+				for sx := 0; sx < 2; sx++ {
+					c := src.RGBAAt(src.Rect.Min.X+x*2+sx, src.Rect.Min.Y+y*2+sy)
+					for i, v := range [4]uint8{c.R, c.G, c.B, c.A} {
+						f := normalize(v)
+						if space == SRGB && i < 3 {
+							f = srgbToLinear(f)
+						}
+						sum[i] += f / 4
+					}
+					nx, ny := normalize(c.R)*2-1, normalize(c.G)*2-1
+					normal[0], normal[1] = normal[0]+nx, normal[1]+ny
+					normal[2] += math.Sqrt(math.Max(0, 1-nx*nx-ny*ny))
+				}
+			}
+
+			var px [4]uint8
+			for i, f := range sum {
+				switch {
+				case filter == NormalFilter && i < 2:
+					//Red and green hold a vector, which is never sRGB encoded
+					f = 0.5
+					if l := math.Sqrt(normal[0]*normal[0] + normal[1]*normal[1] + normal[2]*normal[2]); l > 0 {
+						f = (normal[i]/l + 1) / 2
+					}
+				case space == SRGB && i < 3:
+					f = linearToSRGB(f)
+				}
+				px[i] = uint8(f*255 + 0.5)
+			}
+			pos := out.PixOffset(x, y)
+			copy(out.Pix[pos:pos+4], px[:])
+		}
+	}
+	return out
+}
+
+// returns the linear value of the sRGB encoded v
+func srgbToLinear(v float64) float64 {
+
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// returns the sRGB encoding of the linear value v
+func linearToSRGB(v float64) float64 {
+
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+// returns the reference values of channel ch chosen for the block covering block i in the next smaller
+// mip level, or nil if there is none
+func (o *EncodeOptions) mipHint(i, ch int) []byte {
+
+	if !o.ShareMipSearch || o.mipParent == nil {
+		return nil
+	}
+	parentPerRow := o.mipParent.blocksPerRow()
+	x, y := i%(parentPerRow*2)/2, i/(parentPerRow*2)/2
+	pos := o.mipParent.blockOffset(x*4, y*4) + ch*8
+	return o.mipParent.Data[pos : pos+2]
+}
+
+// returns the reference values giving the lowest error, as measured by fit, for the 16 values of a
+// block channel, searching the same pairs as searchEndpoints does at QualityHigh. Instead of trying them
+// all, it starts each palette from the better of the lowest and highest values and the hint h0 and h1
+// moved into range, and moves either or both values by steps that shrink to one while that lowers the
+// error.
+func seededSearch(values [16]byte, fit channelFit, h0, h1 byte) (byte, byte) {
+
+	var lo, hi byte = 255, 0
+	for _, v := range values {
+		if v < lo {
+			lo = v
+		}
+		if v > hi {
+			hi = v
+		}
+	}
+	if lo == hi {
+		return lo, hi
+	}
+
+	//Pairs are held as the low and high reference values, and whether the high one comes first to
+	//select the eight value palette
+	inset := int(hi-lo) / 8
+	valid := func(l, h int) bool {
+		return l >= int(lo) && l <= int(lo)+inset && h <= int(hi) && h >= int(hi)-inset && l < h
+	}
+	cost := func(l, h int, eight bool) float64 {
+		if eight {
+			_, e := fit(values, byte(h), byte(l))
+			return e
+		}
+		_, e := fit(values, byte(l), byte(h))
+		return e
+	}
+
+	hl, hh := int(h0), int(h1)
+	if hl > hh {
+		hl, hh = hh, hl
+	}
+	hl, hh = clampInt(hl, int(lo), int(lo)+inset), clampInt(hh, int(hi)-inset, int(hi))
+
+	//Each palette is refined separately from its best start, as they favour different pairs
+	bestL, bestH, bestEight := int(lo), int(hi), false
+	bestErr := cost(bestL, bestH, false)
+	for _, eight := range []bool{false, true} {
+		l, h := int(lo), int(hi)
+		e := cost(l, h, eight)
+		if valid(hl, hh) {
+			if he := cost(hl, hh, eight); he < e {
+				l, h, e = hl, hh, he
+			}
+		}
+
+		//Steps start coarse and halve, trying moves of either value and of both together
+		for size := inset/4 + 1; size >= 1; size /= 2 {
+			for improved := true; improved && e > 0; {
+				improved = false
+				for _, step := range [][2]int{{-1, 0}, {1, 0}, {0, -1}, {0, 1}, {-1, -1}, {1, 1}, {-1, 1}, {1, -1}} {
+					nl, nh := l+step[0]*size, h+step[1]*size
+					if !valid(nl, nh) {
+						continue
+					}
+					if ne := cost(nl, nh, eight); ne < e {
+						l, h, e, improved = nl, nh, ne, true
+					}
+				}
+			}
+		}
+		if e < bestErr {
+			bestL, bestH, bestEight, bestErr = l, h, eight, e
+		}
+	}
+
+	if bestEight {
+		return byte(bestH), byte(bestL)
+	}
+	return byte(bestL), byte(bestH)
+}
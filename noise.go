@@ -0,0 +1,140 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"errors"
+	"image"
+	"math"
+)
+
+// Alias for procedural noise constants.
+type NoiseType int
+
+const (
+	Perlin NoiseType = iota //Gradient noise, centered on 128.
+	Worley                  //Distance to the nearest of one random feature point per cell, 0 at the points.
+)
+
+// NoiseChannel describes the noise generated in one channel.
+type NoiseChannel struct {
+	Type    NoiseType
+	Period  int   //Cells across the texture in the first octave, after which it repeats. Defaults to 4.
+	Octaves int   //Number of octaves summed, each with twice the frequency and half the amplitude of the last. Defaults to 1.
+	Seed    int64 //Seed of the random gradients or feature points.
+}
+
+// NoiseOptions holds settings for NewNoise.
+type NoiseOptions struct {
+	Size   int            //Width and height of the base level, defaults to 256.
+	Red    NoiseChannel   //Noise generated in red.
+	Green  NoiseChannel   //Noise generated in green, from Seed+1 so that it differs from red with the same settings.
+	Encode *EncodeOptions //Options to compress each level with, defaults to the "mask" profile.
+}
+
+// NewNoise generates tileable noise in red and green and compresses it into a mip chain with
+// NewMipChain. As every octave repeats a whole number of times across the texture, it tiles seamlessly
+// in both directions.
+func NewNoise(opts *NoiseOptions) ([]*BC5, error) {
+
+	if opts == nil {
+		opts = &NoiseOptions{}
+	}
+	size, encode := opts.Size, opts.Encode
+	if size == 0 {
+		size = 256
+	}
+	if size < 0 {
+		return nil, errors.New("size must not be negative")
+	}
+	if encode == nil {
+		encode = &EncodeOptions{Profile: "mask"}
+	}
+
+	rgba := image.NewRGBA(image.Rect(0, 0, size, size))
+	for c, ch := range []NoiseChannel{opts.Red, opts.Green} {
+		if ch.Period == 0 {
+			ch.Period = 4
+		}
+		if ch.Octaves == 0 {
+			ch.Octaves = 1
+		}
+		if ch.Period < 0 || ch.Octaves < 0 {
+			return nil, errors.New("period and octaves must not be negative")
+		}
+		if ch.Type != Perlin && ch.Type != Worley {
+			return nil, errors.New("unknown noise type")
+		}
+		ch.Seed += int64(c)
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				v := ch.at((float64(x)+0.5)/float64(size), (float64(y)+0.5)/float64(size))
+				rgba.Pix[rgba.PixOffset(x, y)+c] = uint8(clampInt(int(math.Floor(v*255+0.5)), 0, 255))
+			}
+		}
+	}
+	for i := 3; i < len(rgba.Pix); i += 4 {
+		rgba.Pix[i] = 255
+	}
+	return NewMipChain(rgba, encode)
+}
+
+// returns the noise of c from 0 to 1 at (u,v), where the texture spans 0 to 1
+func (c NoiseChannel) at(u, v float64) float64 {
+
+	var sum, total float64
+	amplitude := 1.0
+	for o := 0; o < c.Octaves; o++ {
+		period := c.Period << uint(o)
+		x, y := u*float64(period), v*float64(period)
+		if c.Type == Worley {
+			sum += amplitude * math.Min(worley(x, y, period, c.Seed, o), 1)
+		} else {
+			sum += amplitude * (perlin(x, y, period, c.Seed, o)*math.Sqrt2*0.5 + 0.5)
+		}
+		total += amplitude
+		amplitude /= 2
+	}
+	return sum / total
+}
+
+// returns the random generator for lattice point (x,y) of octave o, wrapping at period
+func latticeRand(x, y, period int, seed int64, o int) *splitMix {
+
+	x, y = wrapCoord(x, period), wrapCoord(y, period)
+	return newSplitMix(seed, (o*period+y)*period+x)
+}
+
+// returns Perlin gradient noise from about -1/sqrt(2) to 1/sqrt(2) at (x,y) in cells
+func perlin(x, y float64, period int, seed int64, o int) float64 {
+
+	x0, y0 := math.Floor(x), math.Floor(y)
+	fx, fy := x-x0, y-y0
+	dot := func(cx, cy int, dx, dy float64) float64 {
+		a := latticeRand(int(x0)+cx, int(y0)+cy, period, seed, o).float() * 2 * math.Pi
+		return math.Cos(a)*dx + math.Sin(a)*dy
+	}
+	fade := func(t float64) float64 {
+		return t * t * t * (t*(t*6-15) + 10)
+	}
+	u, v := fade(fx), fade(fy)
+	top := dot(0, 0, fx, fy)*(1-u) + dot(1, 0, fx-1, fy)*u
+	bottom := dot(0, 1, fx, fy-1)*(1-u) + dot(1, 1, fx-1, fy-1)*u
+	return top*(1-v) + bottom*v
+}
+
+// returns the distance in cells from (x,y) to the nearest feature point
+func worley(x, y float64, period int, seed int64, o int) float64 {
+
+	cx, cy := int(math.Floor(x)), int(math.Floor(y))
+	nearest := math.Inf(1)
+	for dy := -1; dy <= 1; dy++ {
+		for dx := -1; dx <= 1; dx++ {
+			rng := latticeRand(cx+dx, cy+dy, period, seed, o)
+			px, py := float64(cx+dx)+rng.float(), float64(cy+dy)+rng.float()
+			nearest = math.Min(nearest, math.Hypot(px-x, py-y))
+		}
+	}
+	return nearest
+}
@@ -0,0 +1,132 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"image"
+	"math"
+)
+
+// Alias for normal map green channel convention constants.
+type GreenConvention int
+
+const (
+	UnknownConvention GreenConvention = iota //The convention could not be determined.
+	OpenGL                                   //Green is Y+, pointing up the texture.
+	DirectX                                  //Green is Y-, pointing down the texture.
+)
+
+// NormalMapReport holds the results of checking whether an image looks like a tangent-space normal map.
+type NormalMapReport struct {
+	Samples         int             //Number of pixels examined.
+	MeanLength      float64         //Mean length of the decoded vectors.
+	UnitFraction    float64         //Fraction of vectors within UnitTolerance of unit length.
+	Convention      GreenConvention //Most likely green channel convention.
+	ConventionScore float64         //Correlation between the cross derivatives, negative for OpenGL and positive for DirectX.
+	SRGBSuspected   bool            //The channel averages suggest the data went through an sRGB conversion.
+	MeanRed         float64         //Mean red value, 0 to 255.
+	MeanGreen       float64         //Mean green value, 0 to 255.
+}
+
+// UnitTolerance is the largest difference from 1 a vector length can have while being counted as unit length.
+const UnitTolerance = 0.1
+
+// maximum number of pixels examined along each axis
+const maxNormalSamples = 256
+
+// IsNormalMap reports whether the results are consistent with a linear tangent-space normal map.
+func (r NormalMapReport) IsNormalMap() bool {
+
+	return r.Samples > 0 && r.UnitFraction >= 0.9 && !r.SRGBSuspected
+}
+
+// CheckNormalMap samples img and reports whether its pixels decode to unit length vectors, which green
+// channel convention it most likely uses and whether it appears to have been converted to or from sRGB.
+// Images larger than 256 pixels in either dimension are sampled on an even grid.
+func CheckNormalMap(img image.Image) NormalMapReport {
+
+	return checkNormals(img.Bounds(), func(x, y int) (float64, float64, float64) {
+		r, g, b, _ := img.At(x, y).RGBA()
+		return float64(r) / 0xffff, float64(g) / 0xffff, float64(b) / 0xffff
+	}, false)
+}
+
+// CheckNormalMap samples b and reports whether it is suitable for storing a normal map. As BC5 only stores
+// red and green, a vector counts as unit length when a blue component can be reconstructed for it.
+func (b BC5) CheckNormalMap() NormalMapReport {
+
+	img := b.decompressRect(image.Rectangle{Max: b.ContentSize()})
+	return checkNormals(img.Rect, func(x, y int) (float64, float64, float64) {
+		c := img.RGBAAt(x, y)
+		return normalize(c.R), normalize(c.G), 0
+	}, true)
+}
+
+// gathers a NormalMapReport from the normalized components returned by at for pixels in rect
+func checkNormals(rect image.Rectangle, at func(x, y int) (float64, float64, float64), reconstructZ bool) NormalMapReport {
+
+	report := NormalMapReport{}
+	if rect.Dx() < 2 || rect.Dy() < 2 {
+		return report
+	}
+
+	stepX, stepY := 1+rect.Dx()/maxNormalSamples, 1+rect.Dy()/maxNormalSamples
+	vec := func(x, y int) (float64, float64, float64) {
+		r, g, b := at(x, y)
+		return 2*r - 1, 2*g - 1, 2*b - 1
+	}
+
+	var sumLen, sumR, sumG float64
+	var unit int
+	var sxy, sxx, syy float64
+	for y := rect.Min.Y; y < rect.Max.Y-1; y += stepY {
+		for x := rect.Min.X; x < rect.Max.X-1; x += stepX {
+
+			nx, ny, nz := vec(x, y)
+			lenSq := nx*nx + ny*ny
+			if !reconstructZ {
+				lenSq += nz * nz
+			} else if lenSq <= 1 {
+				lenSq = 1
+			}
+			length := math.Sqrt(lenSq)
+			if math.Abs(length-1) <= UnitTolerance {
+				unit++
+			}
+			sumLen += length
+			sumR += (nx + 1) / 2 * 255
+			sumG += (ny + 1) / 2 * 255
+
+			//Cross derivatives of a height derived normal map agree in magnitude, their sign gives the convention
+			nxDown, _, _ := vec(x, y+1)
+			_, nyRight, _ := vec(x+1, y)
+			dxdy, dydx := nxDown-nx, nyRight-ny
+			sxy += dxdy * dydx
+			sxx += dxdy * dxdy
+			syy += dydx * dydx
+			report.Samples++
+		}
+	}
+
+	n := float64(report.Samples)
+	report.MeanLength = sumLen / n
+	report.UnitFraction = float64(unit) / n
+	report.MeanRed, report.MeanGreen = sumR/n, sumG/n
+	if sxx > 0 && syy > 0 {
+		report.ConventionScore = sxy / math.Sqrt(sxx*syy)
+	}
+	switch {
+	case report.ConventionScore <= -0.2:
+		report.Convention = OpenGL
+	case report.ConventionScore >= 0.2:
+		report.Convention = DirectX
+	}
+
+	//A flat linear normal sits at 128, an sRGB encode of it at 188 and an sRGB decode at 55
+	nearSRGB := func(v float64) bool {
+		return math.Abs(v-188) < math.Abs(v-128) || math.Abs(v-55) < math.Abs(v-128)
+	}
+	report.SRGBSuspected = nearSRGB(report.MeanRed) && nearSRGB(report.MeanGreen)
+	return report
+}
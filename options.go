@@ -0,0 +1,193 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"math"
+	"strings"
+
+	"github.com/leylandski/go-bc5/internal/yaml"
+)
+
+// Metadata keys written when EncodeOptions.Record is set.
+const (
+	MetaOptions     = "options"     //The encode options as JSON.
+	MetaOptionsHash = "optionshash" //Hash of the encode options, as returned by EncodeOptions.Hash.
+)
+
+// MarshalText returns the name of q, so that it is stored by name in JSON.
+func (q Quality) MarshalText() ([]byte, error) {
+
+	if q.String() == "unknown" {
+		return nil, errors.New("unknown quality")
+	}
+	return []byte(q.String()), nil
+}
+
+// UnmarshalText sets q from its name.
+func (q *Quality) UnmarshalText(text []byte) error {
+
+	for v := QualityFast; v <= QualityAnneal; v++ {
+		if v.String() == string(text) {
+			*q = v
+			return nil
+		}
+	}
+	return errors.New("unknown quality " + string(text))
+}
+
+// ParseEncodeOptions reads encode options from JSON such as {"quality": "high", "profile": "normal"},
+// or from YAML with the same keys, such as "quality: high" and "profile: normal" on separate lines.
+// Input starting with '{' is read as JSON and any other as YAML, of which block and single line flow
+// mappings and sequences, comments and quoted strings are supported. The keys are the same as those
+// written by json.Marshal. Unknown keys are an error, so misspelt settings are not silently ignored.
+// Previous, MemoryBudget, OnStage, ErrorModel, Workers and Record are not serialized.
+func ParseEncodeOptions(r io.Reader) (*EncodeOptions, error) {
+
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if b, err = yaml.ToJSON(b); err != nil {
+		return nil, err
+	}
+
+	opts := new(EncodeOptions)
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(opts); err != nil {
+		return nil, err
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
+// Validate checks o for settings that are out of range or conflict with each other, so that mistakes
+// are reported before any work starts rather than part way through a long job. Every problem found is
+// listed in the error, not just the first.
+func (o *EncodeOptions) Validate() error {
+
+	return validationError(o.problems())
+}
+
+// ValidateFor checks o as Validate does, and also against the source image rgba, reporting anything
+// that would stop SetFromRGBAWithOptions compressing it with o.
+func (o *EncodeOptions) ValidateFor(rgba *image.RGBA) error {
+
+	problems := o.problems()
+	size := rgba.Rect.Size()
+	if (size.X%4 != 0 || size.Y%4 != 0) && !o.PadEdges {
+		problems = append(problems, "width and height must be multiples of 4 unless PadEdges is set")
+	}
+	if o.Previous != nil && o.Previous.Rect.Size() != image.Pt(alignUp(size.X, 4), alignUp(size.Y, 4)) {
+		problems = append(problems, "previous image size does not match")
+	}
+	return validationError(problems)
+}
+
+// returns a description of each problem with o that does not depend on the source image
+func (o *EncodeOptions) problems() []string {
+
+	var problems []string
+	if o.Quality.String() == "unknown" {
+		problems = append(problems, fmt.Sprintf("unknown quality %d", o.Quality))
+	}
+	if _, _, err := o.resolve(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if o.Tolerance < 0 || o.Tolerance > 255 {
+		problems = append(problems, "tolerance must be from 0 to 255")
+	}
+	if o.MemoryBudget < 0 {
+		problems = append(problems, "memory budget must not be negative")
+	}
+	if o.RDO < 0 || math.IsNaN(o.RDO) {
+		problems = append(problems, "RDO must not be negative")
+	}
+	if o.RDOWindow < 0 {
+		problems = append(problems, "RDO window must not be negative")
+	}
+	if o.RDOWindow > 0 && o.RDO <= 0 {
+		problems = append(problems, "RDO window has no effect unless RDO is greater than zero")
+	}
+	if o.Endpoints != nil && len(o.Endpoints) == 0 {
+		problems = append(problems, "endpoint set must hold at least one value")
+	}
+	if o.FixTiling && o.PadEdges {
+		problems = append(problems, "FixTiling cannot be combined with PadEdges, as the padding would be tiled")
+	}
+	return problems
+}
+
+// returns nil if there are no problems, the problem itself if there is one, or an error listing them all
+func validationError(problems []string) error {
+
+	switch len(problems) {
+	case 0:
+		return nil
+	case 1:
+		return errors.New(problems[0])
+	default:
+		return fmt.Errorf("%d problems with encode options:\n%s", len(problems), strings.Join(problems, "\n"))
+	}
+}
+
+// Hash returns a hex encoded SHA-256 of the JSON form of o, as json.Marshal writes it. It covers
+// Quality, Profile, Tolerance, RDO, RDOWindow, Seed, Endpoints, ShareMipSearch, FixTiling, PadEdges and
+// ChannelStats. Quality is always written, by name, and the rest are left out while they are zero, so
+// adding a new setting does not change the hash of existing options. The fields tagged json:"-" are not
+// covered: Previous, MemoryBudget, OnStage, ErrorModel, Workers and Record, nor are the unexported
+// context and mip parent. Of those, Previous and ErrorModel change the output, so options with the same
+// hash only give the same output if they match too.
+func (o *EncodeOptions) Hash() (string, error) {
+
+	b, err := json.Marshal(o)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// RecordedOptions returns the encode options stored in the metadata of b by EncodeOptions.Record, or
+// nil if there are none. An error is returned if they do not match their recorded hash.
+func (b BC5) RecordedOptions() (*EncodeOptions, error) {
+
+	v, ok := b.Metadata[MetaOptions]
+	if !ok {
+		return nil, nil
+	}
+	opts, err := ParseEncodeOptions(bytes.NewReader([]byte(v)))
+	if err != nil {
+		return nil, err
+	}
+	if hash, _ := opts.Hash(); hash != b.Metadata[MetaOptionsHash] {
+		return nil, errors.New("recorded options do not match their hash")
+	}
+	return opts, nil
+}
+
+// stores opts and their hash in the metadata of b
+func (b *BC5) recordOptions(opts *EncodeOptions) error {
+
+	v, err := json.Marshal(opts)
+	if err != nil {
+		return err
+	}
+	hash, _ := opts.Hash()
+	b.setMeta(MetaOptions, string(v))
+	b.setMeta(MetaOptionsHash, hash)
+	return nil
+}
@@ -0,0 +1,187 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"sort"
+)
+
+// page size used by a Packer if PackOptions.PageSize is zero
+const defaultPageSize = 512
+
+// PackOptions holds settings for a Packer.
+type PackOptions struct {
+	PageSize int            //Width and height of each page in pixels, a multiple of 4. 512 if zero.
+	Gutter   int            //Pixels of each texture's edge repeated around it, so filtering does not bleed in from its neighbours.
+	Encode   *EncodeOptions //Options used to compress each page, which may be nil.
+}
+
+// Packer groups many small textures, such as icons or lookup table strips, into shared pages, so they
+// are stored and bound as a few textures rather than one each. Each texture is placed on a whole
+// number of blocks, so no block holds more than one texture and none loses accuracy to another.
+type Packer struct {
+	opts     PackOptions
+	textures map[string]*image.RGBA
+}
+
+// PackIndex records where a Packer placed each texture. It is suitable for encoding as JSON to ship
+// alongside the pages.
+type PackIndex struct {
+	PageSize int                      `json:"pageSize"`
+	Pages    int                      `json:"pages"`
+	Textures map[string]PackedTexture `json:"textures"`
+}
+
+// PackedTexture is the placement of a single texture in a page.
+type PackedTexture struct {
+	Page int             `json:"page"` //Index of the page holding the texture.
+	Rect image.Rectangle `json:"rect"` //Pixels of the texture in the page, not including the gutter.
+	UV   [4]float64      `json:"uv"`   //Rect as texture coordinates of the page, in the order minimum u, minimum v, maximum u, maximum v.
+}
+
+// NewPacker returns an empty Packer using opts, which may be nil for the defaults.
+func NewPacker(opts *PackOptions) (*Packer, error) {
+
+	p := &Packer{textures: make(map[string]*image.RGBA)}
+	if opts != nil {
+		p.opts = *opts
+	}
+	if p.opts.PageSize == 0 {
+		p.opts.PageSize = defaultPageSize
+	}
+	if p.opts.PageSize < 0 || p.opts.PageSize%4 != 0 {
+		return nil, errors.New("page size must be a positive multiple of 4")
+	}
+	if p.opts.Gutter < 0 {
+		return nil, errors.New("gutter must not be negative")
+	}
+	return p, nil
+}
+
+// Add adds a texture to be packed under name. An error is returned if the name is already taken or
+// the texture and its gutter do not fit on a page.
+func (p *Packer) Add(name string, rgba *image.RGBA) error {
+
+	if _, ok := p.textures[name]; ok {
+		return errors.New("texture " + name + " already added")
+	}
+	if rgba.Rect.Empty() {
+		return errors.New("texture " + name + " is empty")
+	}
+	if size := p.footprint(rgba); size.X > p.opts.PageSize || size.Y > p.opts.PageSize {
+		return fmt.Errorf("texture %s needs %dx%d pixels, more than a %d pixel page", name, size.X, size.Y, p.opts.PageSize)
+	}
+	p.textures[name] = rgba
+	return nil
+}
+
+// Pack places every texture added, draws the pages and compresses each of them once. Textures are
+// placed on shelves, tallest first, and the result depends only on the textures added, not the order
+// they were added in.
+func (p *Packer) Pack() ([]*BC5, *PackIndex, error) {
+
+	names := make([]string, 0, len(p.textures))
+	for name := range p.textures {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := p.footprint(p.textures[names[i]]), p.footprint(p.textures[names[j]])
+		if a.Y != b.Y {
+			return a.Y > b.Y
+		}
+		if a.X != b.X {
+			return a.X > b.X
+		}
+		return names[i] < names[j]
+	})
+
+	index := &PackIndex{PageSize: p.opts.PageSize, Textures: make(map[string]PackedTexture, len(names))}
+	var sources []*image.RGBA
+	var x, y, shelf int
+	for _, name := range names {
+		rgba := p.textures[name]
+		size := p.footprint(rgba)
+		if x+size.X > p.opts.PageSize {
+			x, y, shelf = 0, y+shelf, 0
+		}
+		if sources == nil || y+size.Y > p.opts.PageSize {
+			sources = append(sources, image.NewRGBA(image.Rect(0, 0, p.opts.PageSize, p.opts.PageSize)))
+			x, y, shelf = 0, 0, 0
+		}
+		page := sources[len(sources)-1]
+
+		//The gutter repeats the edge, and any space left to the next block repeats it further
+		area := image.Rectangle{Min: image.Pt(x, y), Max: image.Pt(x, y).Add(size)}
+		rect := image.Rectangle{Min: area.Min.Add(image.Pt(p.opts.Gutter, p.opts.Gutter)), Max: area.Min.Add(image.Pt(p.opts.Gutter, p.opts.Gutter)).Add(rgba.Rect.Size())}
+		for py := area.Min.Y; py < area.Max.Y; py++ {
+			for px := area.Min.X; px < area.Max.X; px++ {
+				sx := rgba.Rect.Min.X + clampCoord(px-rect.Min.X, rgba.Rect.Dx())
+				sy := rgba.Rect.Min.Y + clampCoord(py-rect.Min.Y, rgba.Rect.Dy())
+				page.SetRGBA(px, py, rgba.RGBAAt(sx, sy))
+			}
+		}
+
+		s := float64(p.opts.PageSize)
+		index.Textures[name] = PackedTexture{
+			Page: len(sources) - 1,
+			Rect: rect,
+			UV:   [4]float64{float64(rect.Min.X) / s, float64(rect.Min.Y) / s, float64(rect.Max.X) / s, float64(rect.Max.Y) / s},
+		}
+		x += size.X
+		if size.Y > shelf {
+			shelf = size.Y
+		}
+	}
+
+	pages := make([]*BC5, len(sources))
+	for i, src := range sources {
+		pages[i] = new(BC5)
+		if err := pages[i].SetFromRGBAWithOptions(src, p.opts.Encode); err != nil {
+			return nil, nil, err
+		}
+	}
+	index.Pages = len(pages)
+	return pages, index, nil
+}
+
+// returns the size of the area rgba takes up on a page, its gutter included, rounded up to whole
+// blocks
+func (p *Packer) footprint(rgba *image.RGBA) image.Point {
+
+	size := rgba.Rect.Size().Add(image.Pt(2*p.opts.Gutter, 2*p.opts.Gutter))
+	return image.Pt(alignUp(size.X, 4), alignUp(size.Y, 4))
+}
+
+// Lookup returns the placement of the texture added under name, and whether there is one.
+func (ix *PackIndex) Lookup(name string) (PackedTexture, bool) {
+
+	t, ok := ix.Textures[name]
+	return t, ok
+}
+
+// WriteJSON writes ix to w as indented JSON.
+func (ix *PackIndex) WriteJSON(w io.Writer) error {
+
+	out, err := json.MarshalIndent(ix, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+// ReadPackIndex reads a PackIndex written by WriteJSON.
+func ReadPackIndex(r io.Reader) (*PackIndex, error) {
+
+	ix := new(PackIndex)
+	if err := json.NewDecoder(r).Decode(ix); err != nil {
+		return nil, err
+	}
+	return ix, nil
+}
@@ -0,0 +1,87 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"fmt"
+	"image"
+)
+
+// ContentSize returns the size of the image b was compressed from. This is the size of b.Rect unless
+// the source was padded to whole blocks with EncodeOptions.PadEdges, in which case it is the size
+// recorded in the metadata. The padding is still present in the block data, but is never decoded:
+// At and the other samplers treat the content edge as the edge of the image.
+func (b BC5) ContentSize() image.Point {
+
+	size := b.Rect.Size()
+	if v, ok := b.Metadata[MetaSize]; ok {
+		var w, h int
+		if _, err := fmt.Sscanf(v, "%dx%d", &w, &h); err == nil && w > 0 && h > 0 && w <= size.X && h <= size.Y {
+			return image.Pt(w, h)
+		}
+	}
+	return size
+}
+
+// UVTransform maps texture coordinates of a source image onto the texture it was stored in, for
+// runtimes to correct coordinates authored against the source. Coordinates are mapped by multiplying
+// by the scale and adding the offset.
+type UVTransform struct {
+	ScaleU, ScaleV   float64
+	OffsetU, OffsetV float64
+}
+
+// Apply returns the texture coordinates (u,v) of the source mapped onto the stored texture.
+func (t UVTransform) Apply(u, v float64) (float64, float64) {
+
+	return u*t.ScaleU + t.OffsetU, v*t.ScaleV + t.OffsetV
+}
+
+// UVTransform returns the mapping of texture coordinates of the image b was compressed from onto b
+// itself. Padding with EncodeOptions.PadEdges shrinks the source to the top left of b, so its
+// coordinates must be scaled to sample only the content. The transform is read from the metadata,
+// or worked out from ContentSize for images encoded before it was recorded, and is the identity
+// for images that were not padded.
+func (b BC5) UVTransform() UVTransform {
+
+	var t UVTransform
+	if _, err := fmt.Sscanf(b.Metadata[MetaUVTransform], "%g %g %g %g", &t.ScaleU, &t.ScaleV, &t.OffsetU, &t.OffsetV); err == nil {
+		return t
+	}
+	content, size := b.ContentSize(), b.Rect.Size()
+	if size.X == 0 || size.Y == 0 {
+		return UVTransform{ScaleU: 1, ScaleV: 1}
+	}
+	return UVTransform{ScaleU: float64(content.X) / float64(size.X), ScaleV: float64(content.Y) / float64(size.Y)}
+}
+
+// records in the metadata of b the size of a source padded to padded, and the UV transform it needs,
+// or removes them if it was not padded
+func (b *BC5) recordPadding(size, padded image.Point) {
+
+	if size == padded {
+		delete(b.Metadata, MetaSize)
+		delete(b.Metadata, MetaUVTransform)
+		return
+	}
+	b.setMeta(MetaSize, fmt.Sprintf("%dx%d", size.X, size.Y))
+	b.setMeta(MetaUVTransform, fmt.Sprintf("%g %g %g %g", float64(size.X)/float64(padded.X), float64(size.Y)/float64(padded.Y), 0.0, 0.0))
+}
+
+// returns a copy of rgba extended to whole 4x4 blocks by repeating its last column and row
+func padEdges(rgba *image.RGBA) *image.RGBA {
+
+	size := rgba.Rect.Size()
+	padded := image.NewRGBA(image.Rectangle{Min: rgba.Rect.Min, Max: rgba.Rect.Min.Add(image.Pt(alignUp(size.X, 4), alignUp(size.Y, 4)))})
+	for y := 0; y < padded.Rect.Dy(); y++ {
+		row := rgba.PixOffset(rgba.Rect.Min.X, rgba.Rect.Min.Y+clampCoord(y, size.Y))
+		dst := padded.PixOffset(padded.Rect.Min.X, padded.Rect.Min.Y+y)
+		copy(padded.Pix[dst:dst+size.X*4], rgba.Pix[row:row+size.X*4])
+		last := rgba.Pix[row+(size.X-1)*4 : row+size.X*4]
+		for x := size.X; x < padded.Rect.Dx(); x++ {
+			copy(padded.Pix[dst+x*4:], last)
+		}
+	}
+	return padded
+}
@@ -0,0 +1,90 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"image"
+	"image/color"
+)
+
+// Set changes the color at (x,y) so that b can be used as a draw.Image, as SetRGBA does.
+func (b *BC5) Set(x, y int, c color.Color) {
+
+	b.SetRGBA(x, y, color.RGBAModel.Convert(c).(color.RGBA))
+}
+
+// SetRGBA changes the red and green values at (x,y), in the coordinates At uses, by decompressing the
+// 4x4 block holding it, replacing the pixel and compressing the block again at QualityHigh, leaving
+// the rest of b untouched. The values are read from the components of c that b.Swizzle moves the
+// channels to, so a pixel set this way is returned by At as closely as the block allows. Other
+// pixels in the block may shift slightly as it is recompressed. Coordinates outside b are ignored,
+// and any checksum in the metadata is not updated.
+func (b *BC5) SetRGBA(x, y int, c color.RGBA) {
+
+	if !image.Pt(x, y).In(image.Rect(0, 0, b.Rect.Size().X, b.Rect.Size().Y)) {
+		return
+	}
+
+	pos := b.blockOffset(x, y)
+	block := decompressBlock(b.Data[pos:pos+16], Zero, Swizzle{})
+	px := [4]uint8{c.R, c.G, c.B, c.A}
+	r, g := c.R, c.G
+	if b.Swizzle.R.moves() {
+		r = px[b.Swizzle.R-RedChannel]
+	}
+	if b.Swizzle.G.moves() {
+		g = px[b.Swizzle.G-RedChannel]
+	}
+	block.SetRGBA(x%4, y%4, color.RGBA{R: r, G: g, A: 255})
+
+	i := (y/4)*(b.Rect.Size().X/4) + x/4
+	copy(b.Data[pos:pos+16], encodeBlock(block, i, QualityHigh, &EncodeOptions{}, nil))
+}
+
+// UpdateRegion replaces the pixels of b from the point at, in the coordinates At uses, with those of
+// rgba, as UpdateRegionWithOptions does with the default options.
+func (b *BC5) UpdateRegion(rgba *image.RGBA, at image.Point) error {
+
+	return b.UpdateRegionWithOptions(rgba, at, nil)
+}
+
+// UpdateRegionWithOptions replaces the pixels of b from the point at, in the coordinates At uses, with
+// those of rgba, compressing only the blocks the region touches with opts and patching b.Data in
+// place, for streaming in or editing part of a texture. The red and green values of rgba are read as
+// SetFromRGBA reads them. Blocks only partly covered are decompressed first, so the pixels outside the
+// region are kept, though they may shift slightly as the block is recompressed. Parts of rgba falling
+// outside b are ignored, Previous and RDO have no effect, and any checksum in the metadata is not
+// updated.
+func (b *BC5) UpdateRegionWithOptions(rgba *image.RGBA, at image.Point, opts *EncodeOptions) error {
+
+	if opts == nil {
+		opts = &EncodeOptions{}
+	}
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	quality, _, _ := opts.resolve()
+
+	area := image.Rectangle{Min: at, Max: at.Add(rgba.Rect.Size())}.Intersect(image.Rect(0, 0, b.Rect.Size().X, b.Rect.Size().Y))
+	block := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for y := area.Min.Y / 4 * 4; y < area.Max.Y; y += 4 {
+		for x := area.Min.X / 4 * 4; x < area.Max.X; x += 4 {
+			blockRect := image.Rect(x, y, x+4, y+4)
+			pos := b.blockOffset(x, y)
+			if !blockRect.In(area) {
+				copy(block.Pix, decompressBlock(b.Data[pos:pos+16], Zero, Swizzle{}).Pix)
+			}
+			overlap := blockRect.Intersect(area)
+			for py := overlap.Min.Y; py < overlap.Max.Y; py++ {
+				for px := overlap.Min.X; px < overlap.Max.X; px++ {
+					block.SetRGBA(px-x, py-y, rgba.RGBAAt(rgba.Rect.Min.X+px-at.X, rgba.Rect.Min.Y+py-at.Y))
+				}
+			}
+
+			i := (y/4)*(b.Rect.Size().X/4) + x/4
+			copy(b.Data[pos:pos+16], encodeBlock(block, i, quality, opts, nil))
+		}
+	}
+	return nil
+}
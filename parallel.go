@@ -0,0 +1,75 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"image"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// compresses every block of rgba into data with opts.Workers goroutines, each taking the next row of
+// blocks in turn and copying the blocks out of the source as it goes. As every block is written to its
+// own position the output does not depend on the number of workers or the order of the rows. Returns
+// whether each block was copied from opts.Previous, or the error of opts.ctx if it is done first.
+func compressBlocks(data []byte, rgba *image.RGBA, quality Quality, opts *EncodeOptions, timer *stageTimer) ([]bool, error) {
+
+	blocksPerRow := rgba.Rect.Size().X / 4
+	rows := rgba.Rect.Size().Y / 4
+	reused := make([]bool, len(data)/16)
+
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > rows {
+		workers = rows
+	}
+
+	var next int64
+	timers := make([]*stageTimer, workers)
+	var wg sync.WaitGroup
+	for w := range timers {
+		timers[w] = newStageTimer(timer.callback())
+		wg.Add(1)
+		go func(timer *stageTimer) {
+			defer wg.Done()
+			block := image.NewRGBA(image.Rect(0, 0, 4, 4))
+			for {
+				by := int(atomic.AddInt64(&next, 1) - 1)
+				if by >= rows || opts.ctx != nil && opts.ctx.Err() != nil {
+					return
+				}
+				for bx := 0; bx < blocksPerRow; bx++ {
+					i := by*blocksPerRow + bx
+					pos := i * 16
+					loadBlock(block, rgba, rgba.Rect.Min.X+bx*4, rgba.Rect.Min.Y+by*4)
+					timer.mark(StageSplit)
+					if opts.Previous != nil {
+						prevIx := opts.Previous.blockOffset(bx*4, by*4)
+						prev := opts.Previous.Data[prevIx : prevIx+16]
+						matched := blockMatches(block, prev, opts.Tolerance)
+						timer.mark(StageEndpoints)
+						if matched {
+							copy(data[pos:pos+16], prev)
+							reused[i] = true
+							continue
+						}
+					}
+					copy(data[pos:pos+16], encodeBlock(block, i, quality, opts, timer))
+				}
+			}
+		}(timers[w])
+	}
+	wg.Wait()
+
+	if opts.ctx != nil && opts.ctx.Err() != nil {
+		return nil, opts.ctx.Err()
+	}
+	for _, t := range timers {
+		timer.merge(t)
+	}
+	return reused, nil
+}
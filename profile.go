@@ -0,0 +1,146 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import "strings"
+
+// Metadata keys recording the profile settings of an image.
+const (
+	MetaBlueMode   = "bluemode"   //Blue mode, as returned by BlueMode.String.
+	MetaSwizzle    = "swizzle"    //Swizzle as the destinations of the red and green channels, such as "green red".
+	MetaColorSpace = "colorspace" //Color space of the values, "linear" or "srgb".
+	MetaMipFilter  = "mipfilter"  //Filter used to produce mip levels, "box" or "normal".
+)
+
+// Alias for color space constants.
+type ColorSpace int
+
+const (
+	Linear ColorSpace = iota //Values are stored as they are, as for normals, masks and other data.
+	SRGB                     //Values are sRGB encoded, so mip levels are averaged in linear light.
+)
+
+// Alias for mip filter constants.
+type MipFilter int
+
+const (
+	BoxFilter    MipFilter = iota //Average each 2x2 square of pixels.
+	NormalFilter                  //Average the unit vectors red and green encode as ComputeNormal reconstructs them and renormalize the result.
+)
+
+// Profile bundles the settings suited to a kind of texture, so they can be selected by name
+// through EncodeOptions.Profile. Every setting is recorded in the metadata of images compressed with
+// it, and Decode restores BlueMode and Swizzle from there.
+type Profile struct {
+	Quality    Quality         //Compression quality.
+	BlueMode   BlueMode        //Blue mode set on the compressed image.
+	Swizzle    Swizzle         //Swizzle set on the compressed image.
+	Convention GreenConvention //Green channel convention recorded in the metadata, if known.
+	ColorSpace ColorSpace      //Color space of the source values.
+	MipFilter  MipFilter       //Filter NewMipChain uses to produce each level from the one above it.
+}
+
+// Profiles holds the named encode profiles. Callers may add their own.
+var Profiles = map[string]Profile{
+	"normal": {Quality: QualityHigh, BlueMode: ComputeNormal, MipFilter: NormalFilter}, //Tangent-space normal maps.
+	"mask":   {Quality: QualityNormal, BlueMode: Zero},                                 //Two independent masks, such as roughness and metalness.
+	"height": {Quality: QualityHigh, BlueMode: Greyscale},                              //Height in red, with green free for a second field.
+	"flow":   {Quality: QualityHigh, BlueMode: Zero},                                   //Flow or velocity fields with X in red and Y in green.
+	"sdf":    {Quality: QualityHigh, BlueMode: Zero},                                   //Signed distance fields in red, with an optional second in green.
+	"lut":    {Quality: QualityHigh, BlueMode: Zero},                                   //Lookup tables of two values, such as the split-sum BRDF scale and bias.
+}
+
+var blueModeNames = [...]string{Zero: "zero", One: "one", ComputeNormal: "computenormal", Greyscale: "greyscale"}
+
+// String returns the metadata name of m.
+func (m BlueMode) String() string {
+
+	if m < 0 || int(m) >= len(blueModeNames) {
+		return "unknown"
+	}
+	return blueModeNames[m]
+}
+
+var channelNames = [...]string{DefaultChannel: "default", RedChannel: "red", GreenChannel: "green", BlueChannel: "blue", AlphaChannel: "alpha"}
+
+// String returns the metadata name of c.
+func (c Channel) String() string {
+
+	if c < 0 || int(c) >= len(channelNames) {
+		return "unknown"
+	}
+	return channelNames[c]
+}
+
+// String returns the metadata form of s, the names of the destinations of red and green.
+func (s Swizzle) String() string {
+
+	return s.R.String() + " " + s.G.String()
+}
+
+// String returns the metadata name of c.
+func (c ColorSpace) String() string {
+
+	if c == SRGB {
+		return "srgb"
+	}
+	return "linear"
+}
+
+// String returns the metadata name of f.
+func (f MipFilter) String() string {
+
+	if f == NormalFilter {
+		return "normal"
+	}
+	return "box"
+}
+
+// ColorSpace returns the color space recorded in the metadata of b, which is Linear if none is.
+func (b BC5) ColorSpace() ColorSpace {
+
+	if b.Metadata[MetaColorSpace] == SRGB.String() {
+		return SRGB
+	}
+	return Linear
+}
+
+// records the settings of the profile p, named name, in the metadata of b
+func (b *BC5) recordProfile(name string, p *Profile) {
+
+	b.SetConvention(p.Convention)
+	b.setMeta(MetaProfile, name)
+	b.setMeta(MetaBlueMode, p.BlueMode.String())
+	b.setMeta(MetaSwizzle, p.Swizzle.String())
+	b.setMeta(MetaColorSpace, p.ColorSpace.String())
+	b.setMeta(MetaMipFilter, p.MipFilter.String())
+}
+
+// sets the blue mode and swizzle of b from its metadata, leaving any not recorded or not understood
+// unchanged
+func (b *BC5) restoreProfile() {
+
+	for m, n := range blueModeNames {
+		if b.Metadata[MetaBlueMode] == n {
+			b.BlueMode = BlueMode(m)
+		}
+	}
+	names := strings.Fields(b.Metadata[MetaSwizzle])
+	if len(names) != 2 {
+		return
+	}
+	var s [2]Channel
+	for i, name := range names {
+		s[i] = -1
+		for c, n := range channelNames {
+			if n == name {
+				s[i] = Channel(c)
+			}
+		}
+		if s[i] < 0 {
+			return
+		}
+	}
+	b.Swizzle = Swizzle{R: s[0], G: s[1]}
+}
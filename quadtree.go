@@ -0,0 +1,278 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+)
+
+// Quadtree is an experimental variable resolution container. It stores an image as a tree of equally
+// sized BC5 tiles, where each node covers a square area at the tile's resolution and is only split
+// into four children where the area holds more detail than its tile can show. Mostly flat images
+// therefore need far fewer tiles than storing them at full resolution.
+type Quadtree struct {
+	Size     int       //Width and height of the source image in pixels.
+	TileSize int       //Width and height of every tile in pixels.
+	Root     *QuadNode //Node covering the whole image.
+}
+
+// QuadNode is a node of a Quadtree.
+type QuadNode struct {
+	Rect     image.Rectangle //Area of the source image covered, in source pixels.
+	Tile     *BC5            //Compressed tile holding Rect downsampled to the tile size.
+	Children [4]*QuadNode    //Top left, top right, bottom left and bottom right quarters, nil for a leaf.
+}
+
+// NewQuadtree builds a quadtree from a square RGBA image whose size is tileSize multiplied by a power of
+// two. tileSize must be a multiple of 4. A node is split when any red or green value of its area differs
+// from the upsampled tile by more than threshold.
+func NewQuadtree(rgba *image.RGBA, tileSize, threshold int) (*Quadtree, error) {
+
+	size := rgba.Rect.Size()
+	if size.X != size.Y {
+		return nil, errors.New("image must be square")
+	}
+	if tileSize <= 0 || tileSize%4 != 0 {
+		return nil, errors.New("tile size must be a positive multiple of 4")
+	}
+	if size.X < tileSize || size.X%tileSize != 0 || !isPowerOfTwo(size.X/tileSize) {
+		return nil, errors.New("image size must be the tile size multiplied by a power of two")
+	}
+
+	origin := image.Rect(0, 0, size.X, size.Y)
+	src := &image.RGBA{Pix: rgba.Pix[rgba.PixOffset(rgba.Rect.Min.X, rgba.Rect.Min.Y):], Stride: rgba.Stride, Rect: origin}
+	q := &Quadtree{Size: size.X, TileSize: tileSize}
+	root, err := q.build(src, origin, threshold)
+	if err != nil {
+		return nil, err
+	}
+	q.Root = root
+	return q, nil
+}
+
+// Tiles returns the number of tiles stored in q.
+func (q *Quadtree) Tiles() int {
+
+	var count func(n *QuadNode) int
+	count = func(n *QuadNode) int {
+		c := 1
+		for _, child := range n.Children {
+			if child != nil {
+				c += count(child)
+			}
+		}
+		return c
+	}
+	return count(q.Root)
+}
+
+// Render reconstructs the pixels of window, given in source pixels, at the requested level of detail,
+// where level 0 is full resolution and each further level halves it. The returned image has bounds
+// window scaled down by the level. Each pixel is taken from the most detailed node covering it that
+// does not exceed the requested resolution.
+func (q *Quadtree) Render(window image.Rectangle, level int) *image.RGBA {
+
+	scale := 1 << uint(level)
+	out := image.NewRGBA(image.Rect(window.Min.X/scale, window.Min.Y/scale, window.Max.X/scale, window.Max.Y/scale))
+	for y := out.Rect.Min.Y; y < out.Rect.Max.Y; y++ {
+		for x := out.Rect.Min.X; x < out.Rect.Max.X; x++ {
+			out.SetRGBA(x, y, q.sample(x*scale+scale/2, y*scale+scale/2, scale))
+		}
+	}
+	return out
+}
+
+// Encode writes q to w. The data begins with the signature "BC5Q", then uint32 values for the image
+// size and tile size, followed by the nodes in depth first order. Each node is a byte which is 1 if
+// the node has children, followed by the tile's block data.
+func (q *Quadtree) Encode(w io.Writer) error {
+
+	bw := bufio.NewWriter(w)
+	header := make([]byte, 12)
+	binary.BigEndian.PutUint32(header[:4], strToDword("BC5Q"))
+	binary.BigEndian.PutUint32(header[4:8], uint32(q.Size))
+	binary.BigEndian.PutUint32(header[8:12], uint32(q.TileSize))
+	bw.Write(header)
+
+	var write func(n *QuadNode)
+	write = func(n *QuadNode) {
+		if n.Children[0] != nil {
+			bw.WriteByte(1)
+		} else {
+			bw.WriteByte(0)
+		}
+		bw.Write(n.Tile.blockData())
+		if n.Children[0] != nil {
+			for _, child := range n.Children {
+				write(child)
+			}
+		}
+	}
+	write(q.Root)
+	return bw.Flush()
+}
+
+// DecodeQuadtree reads a quadtree written by Quadtree.Encode from r.
+func DecodeQuadtree(r io.Reader) (*Quadtree, error) {
+
+	br := bufio.NewReader(r)
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, err
+	}
+	if binary.BigEndian.Uint32(header[:4]) != strToDword("BC5Q") {
+		return nil, errors.New("invalid file signature")
+	}
+
+	q := &Quadtree{Size: int(binary.BigEndian.Uint32(header[4:8])), TileSize: int(binary.BigEndian.Uint32(header[8:12]))}
+	if q.TileSize <= 0 || q.TileSize%4 != 0 || q.Size > MaxDimension || q.Size < q.TileSize || q.Size%q.TileSize != 0 || !isPowerOfTwo(q.Size/q.TileSize) {
+		return nil, errors.New("invalid quadtree dimensions")
+	}
+
+	tileBytes := q.TileSize * q.TileSize
+	var read func(rect image.Rectangle) (*QuadNode, error)
+	read = func(rect image.Rectangle) (*QuadNode, error) {
+		flag, err := br.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		//Read through a limit rather than allocating the tile up front, so a corrupt header cannot
+		//claim more memory than the data present.
+		data, err := ioutil.ReadAll(io.LimitReader(br, int64(tileBytes)))
+		if err != nil {
+			return nil, err
+		}
+		if len(data) != tileBytes {
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		n := &QuadNode{Rect: rect, Tile: &BC5{Data: data, Rect: image.Rect(0, 0, q.TileSize, q.TileSize)}}
+		if flag == 1 {
+			if rect.Dx() <= q.TileSize {
+				return nil, errors.New("leaf node has children")
+			}
+			for i, quarter := range quarters(rect) {
+				if n.Children[i], err = read(quarter); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return n, nil
+	}
+
+	root, err := read(image.Rect(0, 0, q.Size, q.Size))
+	if err != nil {
+		return nil, err
+	}
+	q.Root = root
+	return q, nil
+}
+
+// builds the node covering rect of src
+func (q *Quadtree) build(src *image.RGBA, rect image.Rectangle, threshold int) (*QuadNode, error) {
+
+	factor := rect.Dx() / q.TileSize
+	tile := downsample(src, rect, factor)
+	compressed, err := NewBC5FromRGBA(tile)
+	if err != nil {
+		return nil, err
+	}
+
+	n := &QuadNode{Rect: rect, Tile: compressed}
+	if factor == 1 || !needsSplit(src, rect, compressed, factor, threshold) {
+		return n, nil
+	}
+	for i, quarter := range quarters(rect) {
+		if n.Children[i], err = q.build(src, quarter, threshold); err != nil {
+			return nil, err
+		}
+	}
+	return n, nil
+}
+
+// returns the color of the source pixel (x,y) from the deepest node whose pixels cover at most scale
+// source pixels
+func (q *Quadtree) sample(x, y, scale int) color.RGBA {
+
+	n := q.Root
+	pt := image.Pt(x, y)
+	if !pt.In(n.Rect) {
+		return color.RGBA{}
+	}
+	for n.Rect.Dx()/q.TileSize > scale && n.Children[0] != nil {
+		for _, child := range n.Children {
+			if pt.In(child.Rect) {
+				n = child
+				break
+			}
+		}
+	}
+	factor := n.Rect.Dx() / q.TileSize
+	return n.Tile.RGBAAt((x-n.Rect.Min.X)/factor, (y-n.Rect.Min.Y)/factor)
+}
+
+// reports whether upsampling the compressed tile of rect misses detail in src by more than threshold
+func needsSplit(src *image.RGBA, rect image.Rectangle, tile *BC5, factor, threshold int) bool {
+
+	decoded := tile.decompressRect(image.Rect(0, 0, tile.Rect.Dx(), tile.Rect.Dy()))
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			s := src.RGBAAt(x, y)
+			d := decoded.RGBAAt((x-rect.Min.X)/factor, (y-rect.Min.Y)/factor)
+			if absDiff(s.R, d.R) > threshold || absDiff(s.G, d.G) > threshold {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// returns rect of src reduced by factor in each dimension using a box filter
+func downsample(src *image.RGBA, rect image.Rectangle, factor int) *image.RGBA {
+
+	out := image.NewRGBA(image.Rect(0, 0, rect.Dx()/factor, rect.Dy()/factor))
+	area := factor * factor
+	for y := 0; y < out.Rect.Dy(); y++ {
+		for x := 0; x < out.Rect.Dx(); x++ {
+			var sum [4]int
+			for sy := 0; sy < factor; sy++ {
+				for sx := 0; sx < factor; sx++ {
+					c := src.RGBAAt(rect.Min.X+x*factor+sx, rect.Min.Y+y*factor+sy)
+					sum[0], sum[1], sum[2], sum[3] = sum[0]+int(c.R), sum[1]+int(c.G), sum[2]+int(c.B), sum[3]+int(c.A)
+				}
+			}
+			out.SetRGBA(x, y, color.RGBA{
+				R: uint8((sum[0] + area/2) / area),
+				G: uint8((sum[1] + area/2) / area),
+				B: uint8((sum[2] + area/2) / area),
+				A: uint8((sum[3] + area/2) / area),
+			})
+		}
+	}
+	return out
+}
+
+// returns the four quarters of rect
+func quarters(rect image.Rectangle) [4]image.Rectangle {
+
+	mid := rect.Min.Add(rect.Size().Div(2))
+	return [4]image.Rectangle{
+		image.Rect(rect.Min.X, rect.Min.Y, mid.X, mid.Y),
+		image.Rect(mid.X, rect.Min.Y, rect.Max.X, mid.Y),
+		image.Rect(rect.Min.X, mid.Y, mid.X, rect.Max.Y),
+		image.Rect(mid.X, mid.Y, rect.Max.X, rect.Max.Y),
+	}
+}
+
+// reports whether v is a power of two
+func isPowerOfTwo(v int) bool {
+
+	return v > 0 && v&(v-1) == 0
+}
@@ -0,0 +1,258 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// RangeReaderAt is an io.ReaderAt over a file served by HTTP range requests, such as an object in S3
+// or another object store, for use with NewLazyBC5. Data is fetched in pages which are kept in a least
+// recently used cache. Runs of adjacent missing pages are fetched with a single request and extended
+// by Readahead pages, so the latency of a request is shared by neighbouring blocks. Requests are made
+// without holding the cache, so concurrent reads only wait for pages another read is already fetching.
+type RangeReaderAt struct {
+	URL        string
+	Client     *http.Client //Client used for requests, http.DefaultClient if nil.
+	Header     http.Header  //Extra headers sent with every request, such as authorization.
+	PageSize   int          //Size of a cached page in bytes, 64KiB if zero.
+	CachePages int          //Maximum number of pages kept, 256 if zero.
+	Readahead  int          //Number of pages fetched beyond each run of missing pages.
+
+	mu      sync.Mutex
+	size    int64
+	pages   map[int64]*list.Element
+	lru     *list.List
+	pending map[int64]chan struct{} //Pages being fetched, each closed when its request finishes.
+}
+
+// a cached page
+type rangePage struct {
+	index int64
+	data  []byte
+}
+
+// NewRangeReaderAt returns a RangeReaderAt for url with the default page and cache sizes.
+func NewRangeReaderAt(url string) *RangeReaderAt {
+
+	return &RangeReaderAt{URL: url, size: -1}
+}
+
+// Size returns the size of the remote file, making a HEAD request the first time it is needed.
+func (r *RangeReaderAt) Size() (int64, error) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.fileSize()
+}
+
+// ReadAt reads len(p) bytes from the remote file starting at off.
+func (r *RangeReaderAt) ReadAt(p []byte, off int64) (int, error) {
+
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	size, err := r.fileSize()
+	if err != nil {
+		return 0, err
+	}
+	if off >= size {
+		return 0, io.EOF
+	}
+	end := off + int64(len(p))
+	if end > size {
+		end = size
+	}
+
+	pageSize := int64(r.pageSize())
+	n := 0
+	for pos := off; pos < end; {
+		index := pos / pageSize
+		page, ok := r.pages[index]
+		if !ok {
+			if wait, ok := r.pending[index]; ok {
+				//Another read is fetching the page, look again once it is done
+				r.mu.Unlock()
+				<-wait
+				r.mu.Lock()
+				continue
+			}
+			if err := r.fetch(index, (end-1)/pageSize); err != nil {
+				return n, err
+			}
+			continue
+		}
+		r.lru.MoveToFront(page)
+		data := page.Value.(*rangePage).data
+		c := copy(p[n:end-off], data[pos%pageSize:])
+		n += c
+		pos += int64(c)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// fetches the run of missing pages starting at first, up to last and extended by the readahead. The
+// caller must hold mu, which is released during the request while the run is marked as pending.
+func (r *RangeReaderAt) fetch(first, last int64) error {
+
+	last += int64(r.Readahead)
+	if lastPage := (r.size - 1) / int64(r.pageSize()); last > lastPage {
+		last = lastPage
+	}
+	runEnd := first
+	for runEnd < last {
+		_, cached := r.pages[runEnd+1]
+		_, fetching := r.pending[runEnd+1]
+		if cached || fetching {
+			break
+		}
+		runEnd++
+	}
+
+	done := make(chan struct{})
+	for i := first; i <= runEnd; i++ {
+		r.pending[i] = done
+	}
+	r.mu.Unlock()
+	data, err := r.fetchRun(first, runEnd)
+	r.mu.Lock()
+	for i := first; i <= runEnd; i++ {
+		delete(r.pending, i)
+	}
+	close(done)
+	if err != nil {
+		return err
+	}
+	r.store(first, runEnd, data)
+	return nil
+}
+
+// requests pages first to last in a single range request and returns their data
+func (r *RangeReaderAt) fetchRun(first, last int64) ([]byte, error) {
+
+	pageSize := int64(r.pageSize())
+	start, end := first*pageSize, (last+1)*pageSize
+	if end > r.size {
+		end = r.size
+	}
+
+	resp, err := r.do("GET", fmt.Sprintf("bytes=%d-%d", start, end-1))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("range request failed: %s", resp.Status)
+	}
+	data := make([]byte, end-start)
+	if _, err := io.ReadFull(resp.Body, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// adds the data of pages first to last to the cache, evicting the least recently used pages beyond
+// its capacity. The caller must hold mu.
+func (r *RangeReaderAt) store(first, last int64, data []byte) {
+
+	pageSize := int64(r.pageSize())
+	for i := first; i <= last; i++ {
+		if _, ok := r.pages[i]; ok {
+			continue
+		}
+		pageEnd := (i - first + 1) * pageSize
+		if pageEnd > int64(len(data)) {
+			pageEnd = int64(len(data))
+		}
+		r.pages[i] = r.lru.PushFront(&rangePage{index: i, data: data[(i-first)*pageSize : pageEnd]})
+	}
+	for r.lru.Len() > r.cachePages() && r.lru.Len() > int(last-first+1) {
+		oldest := r.lru.Back()
+		r.lru.Remove(oldest)
+		delete(r.pages, oldest.Value.(*rangePage).index)
+	}
+}
+
+// returns the size of the remote file, requesting it if not yet known. The caller must hold mu.
+func (r *RangeReaderAt) fileSize() (int64, error) {
+
+	if r.pages == nil {
+		r.pages = make(map[int64]*list.Element)
+		r.pending = make(map[int64]chan struct{})
+		r.lru = list.New()
+		r.size = -1
+	}
+	if r.size >= 0 {
+		return r.size, nil
+	}
+
+	resp, err := r.do("HEAD", "")
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("size request failed: %s", resp.Status)
+	}
+	if !strings.Contains(resp.Header.Get("Accept-Ranges"), "bytes") && resp.Header.Get("Accept-Ranges") != "" {
+		return 0, errors.New("server does not support range requests")
+	}
+	size, err := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return 0, errors.New("server did not report the file size")
+	}
+	r.size = size
+	return size, nil
+}
+
+// sends a request for the file, with a Range header if rng is not empty
+func (r *RangeReaderAt) do(method, rng string) (*http.Response, error) {
+
+	req, err := http.NewRequest(method, r.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range r.Header {
+		req.Header[k] = v
+	}
+	if rng != "" {
+		req.Header.Set("Range", rng)
+	}
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(req)
+}
+
+// returns the page size in use
+func (r *RangeReaderAt) pageSize() int {
+
+	if r.PageSize <= 0 {
+		return 64 << 10
+	}
+	return r.PageSize
+}
+
+// returns the maximum number of cached pages
+func (r *RangeReaderAt) cachePages() int {
+
+	if r.CachePages <= 0 {
+		return 256
+	}
+	return r.CachePages
+}
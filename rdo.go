@@ -0,0 +1,81 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import "image"
+
+// number of preceding blocks searched for reusable bytes if RDOWindow is zero
+const defaultRDOWindow = 64
+
+// returns the window of preceding blocks searched by rate-distortion optimization
+func (o *EncodeOptions) rdoWindow() int {
+
+	if o.RDOWindow <= 0 {
+		return defaultRDOWindow
+	}
+	return o.RDOWindow
+}
+
+// rewrites each channel half of the compressed block at data[pos:pos+16] to repeat bytes found in the
+// preceding blocks, so that LZ based compressors can replace them with short back references. A
+// candidate is only taken if its squared error over the block exceeds that of the current encoding by
+// no more than tolerance per pixel. Copying a whole half is preferred to copying just its indices,
+// as the longer match compresses better.
+func rdoBlock(data []byte, pos int, block *image.RGBA, tolerance float64, window int) {
+
+	var values [2][16]byte
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			c := block.RGBAAt(x, y)
+			values[0][y*4+x], values[1][y*4+x] = c.R, c.G
+		}
+	}
+
+	start := pos - window*16
+	if start < 0 {
+		start = 0
+	}
+	for ch := 0; ch < 2; ch++ {
+		half := data[pos+ch*8 : pos+ch*8+8]
+		limit := channelError(values[ch], half) + tolerance*16
+
+		var whole, indices []byte
+		wholeErr, indicesErr := limit, limit
+		candidate := make([]byte, 8)
+		for prev := pos - 8; prev >= start; prev -= 8 {
+			src := data[prev : prev+8]
+			if e := channelError(values[ch], src); e <= wholeErr {
+				whole, wholeErr = src, e
+			}
+			if whole != nil {
+				continue
+			}
+			copy(candidate, half[:2])
+			copy(candidate[2:], src[2:])
+			if e := channelError(values[ch], candidate); e <= indicesErr {
+				indices, indicesErr = src[2:], e
+			}
+		}
+
+		switch {
+		case whole != nil:
+			copy(half, whole)
+		case indices != nil:
+			copy(half[2:], indices)
+		}
+	}
+}
+
+// returns the squared error of the 8 byte channel half of a block against the values it encodes
+func channelError(values [16]byte, half []byte) float64 {
+
+	pal := generatePalette(normalize(half[0]), normalize(half[1]))
+	ix := getIndices(half[2:8])
+	sum := 0.0
+	for i, v := range values {
+		d := float64(denormalize(pal[ix[i]])) - float64(v)
+		sum += d * d
+	}
+	return sum
+}
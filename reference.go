@@ -0,0 +1,89 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"errors"
+	"image"
+)
+
+// ReferenceDecodeBlock decodes a single 16 byte BC5 block and returns the red and green values of its
+// texels in rows from the top left. It follows the Direct3D specification step by step, with no
+// shortcuts, so that optimized decoders can be tested against it. Interpolated palette entries are
+// computed exactly and rounded to the nearest value, as the specification's float to UNORM
+// conversion does. Decoders, including Decompress, may differ from it by 1 in those entries, which
+// the specification allows.
+func ReferenceDecodeBlock(block []byte) (red, green [16]uint8, err error) {
+
+	if len(block) != 16 {
+		return red, green, errors.New("block must be 16 bytes")
+	}
+	return referenceChannel(block[0:8]), referenceChannel(block[8:16]), nil
+}
+
+// ReferenceDecode decodes width by height pixels of BC5 data, stored as blocks in rows from the top
+// left, with ReferenceDecodeBlock. Blue is 0 and alpha 255 in the result.
+func ReferenceDecode(data []byte, width, height int) (*image.RGBA, error) {
+
+	if width < 0 || height < 0 || width%4 != 0 || height%4 != 0 {
+		return nil, errors.New("size must be a multiple of 4")
+	}
+	if len(data) < width/4*height/4*16 {
+		return nil, errors.New("not enough data for image")
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for by := 0; by < height/4; by++ {
+		for bx := 0; bx < width/4; bx++ {
+			pos := (by*(width/4) + bx) * 16
+			red, green, err := ReferenceDecodeBlock(data[pos : pos+16])
+			if err != nil {
+				return nil, err
+			}
+			for i := 0; i < 16; i++ {
+				off := img.PixOffset(bx*4+i%4, by*4+i/4)
+				img.Pix[off], img.Pix[off+1], img.Pix[off+2], img.Pix[off+3] = red[i], green[i], 0, 255
+			}
+		}
+	}
+	return img, nil
+}
+
+// decodes the 8 byte half of a block holding one channel
+func referenceChannel(half []byte) [16]uint8 {
+
+	//The two reference values are followed by sixteen 3 bit indices, the first in the lowest bits
+	c0, c1 := int(half[0]), int(half[1])
+
+	//With c0 > c1 there are six interpolated values, otherwise four plus the extremes 0 and 255
+	var palette [8]uint8
+	palette[0], palette[1] = uint8(c0), uint8(c1)
+	if c0 > c1 {
+		for i := 1; i <= 6; i++ {
+			palette[i+1] = roundDiv((7-i)*c0+i*c1, 7)
+		}
+	} else {
+		for i := 1; i <= 4; i++ {
+			palette[i+1] = roundDiv((5-i)*c0+i*c1, 5)
+		}
+		palette[6], palette[7] = 0, 255
+	}
+
+	var values [16]uint8
+	for i := 0; i < 16; i++ {
+		index := 0
+		for bit := 0; bit < 3; bit++ {
+			n := i*3 + bit
+			index |= int(half[2+n/8]>>uint(n%8)&1) << uint(bit)
+		}
+		values[i] = palette[index]
+	}
+	return values
+}
+
+// returns n/d rounded to the nearest integer, for non-negative n and positive d
+func roundDiv(n, d int) uint8 {
+
+	return uint8((2*n + d) / (2 * d))
+}
@@ -0,0 +1,216 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+)
+
+// largest width or height of the thumbnails in an EncodeReport
+const thumbnailSize = 256
+
+// EncodeReport describes the result of compressing a single texture, for reviewing quality trade-offs
+// without loading it into an engine. It can be written as JSON or as a self-contained HTML page.
+type EncodeReport struct {
+	Name            string         `json:"name"`
+	Category        string         `json:"category,omitempty"` //Group the texture counts towards in a BatchReport, such as "characters".
+	Width           int            `json:"width"`
+	Height          int            `json:"height"`
+	Settings        ReportSettings `json:"settings"`
+	CompressedBytes int            `json:"compressedBytes"`
+	RMSE            [2]float64     `json:"rmse"` //Root mean squared error of red and green, 0 to 255.
+	PSNR            float64        `json:"psnr"` //Peak signal to noise ratio of red and green together in dB, zero if lossless.
+	MaxError        int            `json:"maxError"`
+	Source          []byte         `json:"source,omitempty"`  //PNG thumbnail of the source.
+	Decoded         []byte         `json:"decoded,omitempty"` //PNG thumbnail of the decompressed result.
+	Heatmap         []byte         `json:"heatmap,omitempty"` //PNG thumbnail of the largest red or green error of each pixel.
+}
+
+// ReportSettings records the options a texture was compressed with.
+type ReportSettings struct {
+	Quality string  `json:"quality"`
+	Profile string  `json:"profile,omitempty"`
+	RDO     float64 `json:"rdo,omitempty"`
+	Seed    int64   `json:"seed,omitempty"`
+}
+
+// NewEncodeReport compares b against the source it was compressed from and returns a report on it.
+// opts should be the options used to compress b, or nil for the defaults. The thumbnails are at most
+// 256 pixels across.
+func NewEncodeReport(name string, src *image.RGBA, b *BC5, opts *EncodeOptions) (*EncodeReport, error) {
+
+	if opts == nil {
+		opts = &EncodeOptions{}
+	}
+	size := src.Rect.Size()
+	if size != b.Rect.Size() {
+		return nil, errors.New("source and compressed sizes do not match")
+	}
+	quality, _, err := opts.resolve()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &EncodeReport{
+		Name:            name,
+		Width:           size.X,
+		Height:          size.Y,
+		Settings:        ReportSettings{Quality: quality.String(), Profile: opts.Profile, RDO: opts.RDO, Seed: opts.Seed},
+		CompressedBytes: len(b.blockData()),
+	}
+
+	decoded := b.decompressRect(image.Rect(0, 0, size.X, size.Y))
+	heatmap := image.NewRGBA(decoded.Rect)
+	var sum [2]float64
+	for y := 0; y < size.Y; y++ {
+		for x := 0; x < size.X; x++ {
+			s := src.RGBAAt(src.Rect.Min.X+x, src.Rect.Min.Y+y)
+			d := decoded.RGBAAt(x, y)
+			dr, dg := absDiff(s.R, d.R), absDiff(s.G, d.G)
+			sum[0] += float64(dr * dr)
+			sum[1] += float64(dg * dg)
+			e := dr
+			if dg > e {
+				e = dg
+			}
+			if e > report.MaxError {
+				report.MaxError = e
+			}
+			heatmap.SetRGBA(x, y, heatColor(e))
+		}
+	}
+	n := float64(size.X * size.Y)
+	report.RMSE = [2]float64{math.Sqrt(sum[0] / n), math.Sqrt(sum[1] / n)}
+	if mse := (sum[0] + sum[1]) / (2 * n); mse > 0 {
+		report.PSNR = 10 * math.Log10(255*255/mse)
+	}
+
+	for _, t := range []struct {
+		img *image.RGBA
+		dst *[]byte
+	}{{src, &report.Source}, {decoded, &report.Decoded}, {heatmap, &report.Heatmap}} {
+		if *t.dst, err = thumbnail(t.img); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// WriteReportsJSON writes reports to w as an indented JSON array.
+func WriteReportsJSON(w io.Writer, reports ...*EncodeReport) error {
+
+	b, err := json.MarshalIndent(reports, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// WriteReportsHTML writes reports to w as a single HTML page with the thumbnails embedded, so the file
+// can be shared on its own.
+func WriteReportsHTML(w io.Writer, reports ...*EncodeReport) error {
+
+	return reportTemplate.Execute(w, struct {
+		Batch   *BatchReport
+		Reports []*EncodeReport
+	}{nil, reports})
+}
+
+// returns the color of an error of e in a heatmap, black through red to yellow
+func heatColor(e int) color.RGBA {
+
+	v := clampInt(e*16, 0, 511)
+	if v < 256 {
+		return color.RGBA{R: uint8(v), A: 255}
+	}
+	return color.RGBA{R: 255, G: uint8(v - 256), A: 255}
+}
+
+// returns img reduced to at most thumbnailSize across and encoded as PNG
+func thumbnail(img *image.RGBA) ([]byte, error) {
+
+	factor := 1
+	for img.Rect.Dx()/factor > thumbnailSize || img.Rect.Dy()/factor > thumbnailSize {
+		factor *= 2
+	}
+	if factor > 1 {
+		img = downsample(img, img.Rect, factor)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"png": func(b []byte) template.URL {
+		return template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(b))
+	},
+}).Parse(`{{define "texture"}}<section>
+<h2>{{.Name}}</h2>
+<table>
+{{with .Category}}<tr><th>Category</th><td>{{.}}</td></tr>{{end}}
+<tr><th>Size</th><td>{{.Width}}x{{.Height}}</td></tr>
+<tr><th>Settings</th><td>quality {{.Settings.Quality}}{{with .Settings.Profile}}, profile {{.}}{{end}}{{with .Settings.RDO}}, rdo {{.}}{{end}}{{with .Settings.Seed}}, seed {{.}}{{end}}</td></tr>
+<tr><th>Compressed</th><td>{{.CompressedBytes}} bytes</td></tr>
+<tr><th>RMSE</th><td>red {{printf "%.3f" (index .RMSE 0)}}, green {{printf "%.3f" (index .RMSE 1)}}</td></tr>
+<tr><th>PSNR</th><td>{{if .PSNR}}{{printf "%.2f" .PSNR}} dB{{else}}lossless{{end}}</td></tr>
+<tr><th>Max error</th><td>{{.MaxError}}</td></tr>
+</table>
+{{with .Source}}<figure><img src="{{png .}}" alt="source"><figcaption>Source</figcaption></figure>{{end}}
+{{with .Decoded}}<figure><img src="{{png .}}" alt="decoded"><figcaption>Decoded</figcaption></figure>{{end}}
+{{with .Heatmap}}<figure><img src="{{png .}}" alt="error heatmap"><figcaption>Error</figcaption></figure>{{end}}
+</section>
+{{end}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>BC5 encode report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+section { border-top: 1px solid #ccc; padding: 1em 0; }
+table { border-collapse: collapse; }
+td, th { padding: 0.2em 1em 0.2em 0; text-align: left; }
+img { image-rendering: pixelated; width: 256px; margin-right: 1em; border: 1px solid #ccc; }
+figure { display: inline-block; margin: 0; }
+</style>
+</head>
+<body>
+<h1>BC5 encode report</h1>
+{{with .Batch}}<section>
+<h2>Summary</h2>
+<table>
+<tr><th>Textures</th><td>{{len .Reports}}</td></tr>
+<tr><th>Compressed</th><td>{{.TotalBytes}} bytes{{with .Previous}} ({{printf "%+d" .TotalBytes}} since previous){{end}}</td></tr>
+</table>
+{{with .Categories}}<h3>Categories</h3>
+<table>
+<tr><th>Category</th><th>Textures</th><th>Bytes</th><th>Budget</th></tr>
+{{range $name, $c := .}}<tr><td>{{$name}}</td><td>{{$c.Count}}</td><td>{{$c.Bytes}}</td><td>{{if $c.Budget}}{{$c.Budget}}{{if $c.OverBudget}} <strong>over</strong>{{end}}{{end}}</td></tr>
+{{end}}</table>{{end}}
+{{with .WorstByError}}<h3>Largest error</h3>
+<ol>{{range .}}<li>{{.}}</li>{{end}}</ol>{{end}}
+{{with .WorstBySize}}<h3>Largest size</h3>
+<ol>{{range .}}<li>{{.}}</li>{{end}}</ol>{{end}}
+{{with .Previous}}{{with .Changes}}<h3>Changes since previous</h3>
+<table>
+<tr><th>Texture</th><th>Bytes</th><th>PSNR</th></tr>
+{{range .}}<tr><td>{{.Name}}</td><td>{{if .Added}}added{{else if .Removed}}removed{{else}}{{printf "%+d" .Bytes}}{{end}}</td><td>{{if not (or .Added .Removed)}}{{printf "%+.2f" .PSNR}}{{end}}</td></tr>
+{{end}}</table>{{end}}{{end}}
+</section>
+{{end}}{{range .Reports}}{{template "texture" .}}{{end}}</body>
+</html>
+`))
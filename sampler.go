@@ -0,0 +1,221 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// Alias for out of bounds addressing constants, matching the GPU texture address modes.
+type AddressMode int
+
+const (
+	Border AddressMode = iota //Return the zero color for coordinates out of bounds.
+	Clamp                     //Clamp coordinates to the nearest edge pixel.
+	Wrap                      //Wrap coordinates around so the image repeats.
+	Mirror                    //Reflect coordinates at each edge so the image repeats mirrored.
+	Panic                     //Panic when coordinates are out of bounds.
+)
+
+// default and largest number of taps taken by Sampler.SampleAniso
+const defaultMaxAnisotropy = 16
+
+// Sampler reads pixels from BC5 images using its own addressing policy rather than the image's.
+type Sampler struct {
+	AddressMode
+	MaxAnisotropy int //Most taps taken by SampleAniso, from 1 to 16. Defaults to 16, as on most GPUs.
+}
+
+// At returns the RGBA color of b at (x,y), handling coordinates outside b according to s.AddressMode.
+func (s Sampler) At(b *BC5, x, y int) color.RGBA {
+
+	img := *b
+	img.AddressMode = s.AddressMode
+	return img.RGBAAt(x, y)
+}
+
+// Sample returns the red and green values of b from 0 to 1 at the normalized texture coordinates
+// (u,v), filtered bilinearly between the four nearest pixel centers as a GPU would. The coordinates
+// span the ContentSize of b, so any padding is never sampled.
+func (s Sampler) Sample(b *BC5, u, v float64) (float64, float64) {
+
+	size := b.ContentSize()
+	return s.bilinear(b, u*float64(size.X), v*float64(size.Y))
+}
+
+// SampleAniso returns the red and green values of b from 0 to 1 at (u,v), filtered anisotropically
+// over the footprint of a screen pixel whose texture coordinates change by dx and dy to the next pixel
+// across and down, as with the derivatives dFdx and dFdy. Up to MaxAnisotropy bilinear taps are
+// spread evenly along the major axis of the footprint, the common GPU approximation of an elliptical
+// weighted average. Only the single level b is filtered, so a footprint wider than a pixel along its
+// minor axis is undersampled; pick the level from a mip chain by the minor axis to avoid that.
+func (s Sampler) SampleAniso(b *BC5, u, v float64, dx, dy [2]float64) (float64, float64) {
+
+	size := b.ContentSize()
+	ax, ay := [2]float64{dx[0] * float64(size.X), dx[1] * float64(size.Y)}, [2]float64{dy[0] * float64(size.X), dy[1] * float64(size.Y)}
+	lx, ly := math.Hypot(ax[0], ax[1]), math.Hypot(ay[0], ay[1])
+	major, minor := ax, ly
+	if ly > lx {
+		major, minor = ay, lx
+	}
+	majorLen := math.Max(lx, ly)
+
+	limit := s.MaxAnisotropy
+	if limit <= 0 || limit > defaultMaxAnisotropy {
+		limit = defaultMaxAnisotropy
+	}
+	taps := limit
+	if minor > 0 {
+		taps = clampInt(int(math.Ceil(majorLen/minor)), 1, limit)
+	}
+	if majorLen <= 1 {
+		taps = 1
+	}
+
+	x, y := u*float64(size.X), v*float64(size.Y)
+	var r, g float64
+	for i := 0; i < taps; i++ {
+		t := (float64(i)+0.5)/float64(taps) - 0.5
+		tr, tg := s.bilinear(b, x+major[0]*t, y+major[1]*t)
+		r, g = r+tr, g+tg
+	}
+	return r / float64(taps), g / float64(taps)
+}
+
+// SampleGrad returns the red and green values from 0 to 1 at (u,v) of a mip chain, ordered from the
+// base level down as NewMipChain returns it, with explicit derivatives as for SampleAniso. Like the
+// GPU instruction of the same name, the level of detail is chosen from the footprint so that the taps
+// along its major axis are about a pixel apart, and the two nearest levels are sampled with
+// SampleAniso and blended.
+func (s Sampler) SampleGrad(levels []*BC5, u, v float64, dx, dy [2]float64) (float64, float64) {
+
+	if len(levels) == 0 {
+		return 0, 0
+	}
+	size := levels[0].ContentSize()
+	lx := math.Hypot(dx[0]*float64(size.X), dx[1]*float64(size.Y))
+	ly := math.Hypot(dy[0]*float64(size.X), dy[1]*float64(size.Y))
+	major, minor := math.Max(lx, ly), math.Min(lx, ly)
+
+	limit := s.MaxAnisotropy
+	if limit <= 0 || limit > defaultMaxAnisotropy {
+		limit = defaultMaxAnisotropy
+	}
+	taps := float64(limit)
+	if minor > 0 {
+		taps = math.Min(math.Ceil(major/minor), taps)
+	}
+	lod := 0.0
+	if major > taps {
+		lod = math.Min(math.Log2(major/taps), float64(len(levels)-1))
+	}
+
+	level := int(lod)
+	r, g := s.SampleAniso(levels[level], u, v, dx, dy)
+	if f := lod - float64(level); f > 0 {
+		r1, g1 := s.SampleAniso(levels[level+1], u, v, dx, dy)
+		r, g = r*(1-f)+r1*f, g*(1-f)+g1*f
+	}
+	return r, g
+}
+
+// Gradient returns the rate of change of the bilinearly filtered red and green values of b with
+// respect to u and to v at (u,v), in values from 0 to 1 per unit of the normalized texture coordinate.
+// It takes central differences one pixel either side, so is continuous across pixel boundaries.
+func (s Sampler) Gradient(b *BC5, u, v float64) (du, dv [2]float64) {
+
+	size := b.ContentSize()
+	if size.X == 0 || size.Y == 0 {
+		return du, dv
+	}
+	hu, hv := 1/float64(size.X), 1/float64(size.Y)
+	r0, g0 := s.Sample(b, u-hu, v)
+	r1, g1 := s.Sample(b, u+hu, v)
+	du = [2]float64{(r1 - r0) / (2 * hu), (g1 - g0) / (2 * hu)}
+	r0, g0 = s.Sample(b, u, v-hv)
+	r1, g1 = s.Sample(b, u, v+hv)
+	dv = [2]float64{(r1 - r0) / (2 * hv), (g1 - g0) / (2 * hv)}
+	return du, dv
+}
+
+// returns the red and green values of b from 0 to 1 at (x,y) in pixels, with pixel centers at half
+// integers, filtered bilinearly
+func (s Sampler) bilinear(b *BC5, x, y float64) (float64, float64) {
+
+	fx, fy := x-0.5, y-0.5
+	x0, y0 := int(math.Floor(fx)), int(math.Floor(fy))
+	tx, ty := fx-float64(x0), fy-float64(y0)
+	var r, g float64
+	for _, t := range []struct {
+		x, y int
+		w    float64
+	}{{x0, y0, (1 - tx) * (1 - ty)}, {x0 + 1, y0, tx * (1 - ty)}, {x0, y0 + 1, (1 - tx) * ty}, {x0 + 1, y0 + 1, tx * ty}} {
+		if t.w == 0 {
+			continue
+		}
+		c := s.At(b, t.x, t.y)
+		r, g = r+float64(c.R)*t.w, g+float64(c.G)*t.w
+	}
+	return r / 255, g / 255
+}
+
+// maps (x,y) into an image of the given size, returning false if the pixel is outside it and the mode
+// is Border. It panics if the pixel is outside the image and the mode is Panic.
+func (m AddressMode) resolve(x, y int, size image.Point) (int, int, bool) {
+
+	if x >= 0 && x < size.X && y >= 0 && y < size.Y {
+		return x, y, true
+	}
+	if size.X <= 0 || size.Y <= 0 {
+		return 0, 0, false
+	}
+
+	switch m {
+	case Clamp:
+		return clampCoord(x, size.X), clampCoord(y, size.Y), true
+	case Wrap:
+		return wrapCoord(x, size.X), wrapCoord(y, size.Y), true
+	case Mirror:
+		return mirrorCoord(x, size.X), mirrorCoord(y, size.Y), true
+	case Panic:
+		panic(fmt.Sprintf("pixel (%d,%d) out of bounds for %dx%d image", x, y, size.X, size.Y))
+	default:
+		return 0, 0, false
+	}
+}
+
+// clamps v to [0,n)
+func clampCoord(v, n int) int {
+
+	if v < 0 {
+		return 0
+	}
+	if v >= n {
+		return n - 1
+	}
+	return v
+}
+
+// wraps v into [0,n)
+func wrapCoord(v, n int) int {
+
+	v %= n
+	if v < 0 {
+		v += n
+	}
+	return v
+}
+
+// reflects v into [0,n), repeating every 2n
+func mirrorCoord(v, n int) int {
+
+	v = wrapCoord(v, 2*n)
+	if v >= n {
+		v = 2*n - 1 - v
+	}
+	return v
+}
@@ -0,0 +1,101 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// MetaSDFRange is the metadata key holding the distance ranges of a signed distance field, as the
+// minimum and maximum distance of red followed by those of green if it holds a second field.
+const MetaSDFRange = "sdfrange"
+
+// SDFRange holds the distances in world units that values of 0 and 255 represent.
+type SDFRange struct {
+	Min, Max float64
+}
+
+// NewBC5FromSDF compresses a signed distance field of width by height distances in row order into
+// red, with an optional second field in green, using the "sdf" profile. Distances are mapped linearly
+// from their range onto 0 to 255, clamping any outside, and the ranges are recorded in the metadata.
+// green may be nil, leaving the green channel zero.
+func NewBC5FromSDF(width, height int, red []float32, redRange SDFRange, green []float32, greenRange SDFRange) (*BC5, error) {
+
+	if width < 0 || height < 0 || len(red) != width*height || green != nil && len(green) != width*height {
+		return nil, errors.New("field length does not match size")
+	}
+	if !(redRange.Max > redRange.Min) || green != nil && !(greenRange.Max > greenRange.Min) {
+		return nil, errors.New("distance range must not be empty")
+	}
+
+	encode := func(d float32, r SDFRange) uint8 {
+		return uint8(clampInt(int(math.Floor((float64(d)-r.Min)/(r.Max-r.Min)*255+0.5)), 0, 255))
+	}
+	rgba := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := range red {
+		c := color.RGBA{R: encode(red[i], redRange), A: 255}
+		if green != nil {
+			c.G = encode(green[i], greenRange)
+		}
+		rgba.SetRGBA(i%width, i/width, c)
+	}
+
+	b := new(BC5)
+	if err := b.SetFromRGBAWithOptions(rgba, &EncodeOptions{Profile: "sdf"}); err != nil {
+		return nil, err
+	}
+	ranges := fmt.Sprintf("%g,%g", redRange.Min, redRange.Max)
+	if green != nil {
+		ranges += fmt.Sprintf(",%g,%g", greenRange.Min, greenRange.Max)
+	}
+	b.setMeta(MetaSDFRange, ranges)
+	return b, nil
+}
+
+// SDFRange returns the distance range of the field in channel, which must be RedChannel or
+// GreenChannel, as recorded in the metadata of b. It returns false if there is none.
+func (b BC5) SDFRange(channel Channel) (SDFRange, bool) {
+
+	var v [4]float64
+	n, _ := fmt.Sscanf(b.Metadata[MetaSDFRange], "%g,%g,%g,%g", &v[0], &v[1], &v[2], &v[3])
+	switch {
+	case channel == RedChannel && n >= 2:
+		return SDFRange{v[0], v[1]}, true
+	case channel == GreenChannel && n == 4:
+		return SDFRange{v[2], v[3]}, true
+	default:
+		return SDFRange{}, false
+	}
+}
+
+// DistanceAt returns the signed distance in world units of the field in channel at (x,y), in pixels
+// with pixel centers at half integers. The distance is filtered bilinearly, as the GPU would, which an
+// SDF relies on for smooth edges. Coordinates outside b follow its AddressMode. An error is returned
+// if b has no range recorded for channel.
+func (b BC5) DistanceAt(channel Channel, x, y float64) (float64, error) {
+
+	r, ok := b.SDFRange(channel)
+	if !ok {
+		return 0, errors.New("no distance range recorded for channel")
+	}
+
+	value := func(px, py int) float64 {
+		c := b.RGBAAt(px, py)
+		if channel == GreenChannel {
+			return float64(c.G)
+		}
+		return float64(c.R)
+	}
+	fx, fy := x-0.5, y-0.5
+	x0, y0 := int(math.Floor(fx)), int(math.Floor(fy))
+	tx, ty := fx-float64(x0), fy-float64(y0)
+	top := value(x0, y0)*(1-tx) + value(x0+1, y0)*tx
+	bottom := value(x0, y0+1)*(1-tx) + value(x0+1, y0+1)*tx
+	v := top*(1-ty) + bottom*ty
+	return r.Min + v/255*(r.Max-r.Min), nil
+}
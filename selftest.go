@@ -0,0 +1,212 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"runtime"
+)
+
+// number of random blocks compared against the reference decoder by SelfTest
+const selfTestBlocks = 4096
+
+// SelfTestReport holds the results of SelfTest.
+type SelfTestReport struct {
+	Version string           `json:"version"` //Module version, as reported by Capabilities.
+	GOOS    string           `json:"goos"`
+	GOARCH  string           `json:"goarch"`
+	Checks  []SelfTestResult `json:"checks"`
+}
+
+// SelfTestResult holds the outcome of one check of SelfTest.
+type SelfTestResult struct {
+	Name  string `json:"name"`
+	Error string `json:"error,omitempty"` //Reason the check failed, empty if it passed.
+}
+
+// SelfTest checks this build of the package on the current machine, for trusting it on an unusual
+// platform such as ARM Windows, musl or WebAssembly before use in a pipeline. It verifies the golden
+// vectors, compares the decoder against ReferenceDecode over random blocks, and round trips an image
+// through each container that can be both written and read. It takes well under a second.
+func SelfTest() *SelfTestReport {
+
+	report := &SelfTestReport{Version: Capabilities().Version, GOOS: runtime.GOOS, GOARCH: runtime.GOARCH}
+	for _, c := range []struct {
+		name  string
+		check func() error
+	}{
+		{"golden vectors", VerifyGoldenVectors},
+		{"decoder against reference", selfTestDecoder},
+		{"container bc5", func() error { return selfTestContainer(false) }},
+		{"container bc52", func() error { return selfTestContainer(true) }},
+		{"container dds", selfTestDDS},
+		{"container fec", selfTestFEC},
+	} {
+		result := SelfTestResult{Name: c.name}
+		if err := c.check(); err != nil {
+			result.Error = err.Error()
+		}
+		report.Checks = append(report.Checks, result)
+	}
+	return report
+}
+
+// Passed reports whether every check passed.
+func (r *SelfTestReport) Passed() bool {
+
+	for _, c := range r.Checks {
+		if c.Error != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteText writes r to w as one line per check, for display by command line tools.
+func (r *SelfTestReport) WriteText(w io.Writer) error {
+
+	if _, err := fmt.Fprintf(w, "bc5 %s on %s/%s\n", r.Version, r.GOOS, r.GOARCH); err != nil {
+		return err
+	}
+	for _, c := range r.Checks {
+		status := "PASS"
+		if c.Error != "" {
+			status = "FAIL"
+		}
+		line := fmt.Sprintf("%s  %s", status, c.Name)
+		if c.Error != "" {
+			line += ": " + c.Error
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodes random blocks of both palette modes and compares them with the reference decoder
+func selfTestDecoder() error {
+
+	rng := newSplitMix(1, 0)
+	data := make([]byte, selfTestBlocks*16)
+	for i := 0; i < len(data); i += 8 {
+		v := rng.next()
+		for j := 0; j < 8; j++ {
+			data[i+j] = byte(v >> uint(j*8))
+		}
+	}
+	size := int(math.Sqrt(selfTestBlocks)) * 4
+	b := &BC5{Rect: image.Rect(0, 0, size, size), Data: data}
+	ref, err := ReferenceDecode(data, size, size)
+	if err != nil {
+		return err
+	}
+	img := b.decompressRect(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			c, r := img.RGBAAt(x, y), ref.RGBAAt(x, y)
+			if absDiff(c.R, r.R) > 1 || absDiff(c.G, r.G) > 1 {
+				return fmt.Errorf("pixel (%d,%d) decodes as (%d,%d), reference (%d,%d)", x, y, c.R, c.G, r.R, r.G)
+			}
+		}
+	}
+	return nil
+}
+
+// returns a small compressed test image
+func selfTestImage() (*BC5, error) {
+
+	rgba := image.NewRGBA(image.Rect(0, 0, 32, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			rgba.SetRGBA(x, y, color.RGBA{R: uint8(x * 8), G: uint8(255 - y*8), A: 255})
+		}
+	}
+	return NewBC5FromRGBA(rgba)
+}
+
+// returns an error if a and b differ in size, blocks or metadata
+func selfTestCompare(a, b *BC5) error {
+
+	if a.Rect.Size() != b.Rect.Size() || !bytes.Equal(a.blockData(), b.blockData()) {
+		return errors.New("image changed in round trip")
+	}
+	if len(a.Metadata) != len(b.Metadata) {
+		return errors.New("metadata changed in round trip")
+	}
+	for k, v := range a.Metadata {
+		if b.Metadata[k] != v {
+			return errors.New("metadata changed in round trip")
+		}
+	}
+	return nil
+}
+
+// round trips the test image through Encode and Decode, with metadata if meta is set
+func selfTestContainer(meta bool) error {
+
+	b, err := selfTestImage()
+	if err != nil {
+		return err
+	}
+	if meta {
+		b.SetChecksum()
+	}
+	var buf bytes.Buffer
+	if err := Encode(b, &buf); err != nil {
+		return err
+	}
+	decoded, err := Decode(&buf)
+	if err != nil {
+		return err
+	}
+	return selfTestCompare(b, decoded)
+}
+
+// round trips the test image through EncodeDDS and DecodeDDS
+func selfTestDDS() error {
+
+	b, err := selfTestImage()
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := EncodeDDS(b, &buf); err != nil {
+		return err
+	}
+	decoded, err := DecodeDDS(&buf, ATI2Standard)
+	if err != nil {
+		return err
+	}
+	return selfTestCompare(b, decoded)
+}
+
+// round trips the test image through EncodeFEC and DecodeFEC with a damaged shard
+func selfTestFEC() error {
+
+	b, err := selfTestImage()
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := EncodeFEC(b, &buf, FECOptions{DataShards: 4, ParityShards: 2, ShardSize: 64}); err != nil {
+		return err
+	}
+	data := buf.Bytes()
+	data[len(data)/2] ^= 0xff
+	decoded, repaired, err := DecodeFEC(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if repaired == 0 {
+		return errors.New("damaged shard was not detected")
+	}
+	return selfTestCompare(b, decoded)
+}
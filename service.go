@@ -0,0 +1,212 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"image"
+	"runtime"
+	"sync"
+)
+
+// ErrServiceClosed is returned for requests still queued when a DecodeService is closed, and for
+// requests submitted after.
+var ErrServiceClosed = errors.New("decode service closed")
+
+// DecodeRequest asks a DecodeService to decompress part of a texture.
+type DecodeRequest struct {
+	Texture  *BC5
+	Region   image.Rectangle //Pixels to decode, in the coordinates At uses. The whole texture if empty.
+	Priority int             //Requests with higher priority are decoded first, equal ones in order of submission.
+	Context  context.Context //Cancels the request if done before it is decoded. Optional.
+}
+
+// DecodeResult holds the outcome of a DecodeRequest.
+type DecodeResult struct {
+	Request *DecodeRequest
+	Image   *image.RGBA //Decoded pixels, as from DecompressRect. Shared by requests decoded together, so it must not be modified if others may hold it.
+	Err     error       //Error from the request's context, or ErrServiceClosed.
+}
+
+// DecodeService decodes regions of textures in the background with a fixed number of workers, taking
+// queued requests in order of priority, for engines streaming many partial decodes with varying urgency.
+// Requests for the same region of the same texture are decoded once: those submitted or taken while
+// it is being decoded, and those still queued when it finishes, all receive the same result.
+type DecodeService struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	queue    decodeQueue
+	seq      uint64
+	closed   bool
+	wg       sync.WaitGroup
+	inflight map[decodeKey][]queuedDecode //Requests waiting on each region being decoded.
+}
+
+// identifies the pixels a request decodes
+type decodeKey struct {
+	tex    *BC5
+	region image.Rectangle
+}
+
+// a queued request and where to send its result
+type queuedDecode struct {
+	req *DecodeRequest
+	out chan DecodeResult
+	seq uint64
+}
+
+// decodeQueue is a heap of queued requests, highest priority first
+type decodeQueue []queuedDecode
+
+func (q decodeQueue) Len() int { return len(q) }
+func (q decodeQueue) Less(i, j int) bool {
+	if q[i].req.Priority != q[j].req.Priority {
+		return q[i].req.Priority > q[j].req.Priority
+	}
+	return q[i].seq < q[j].seq
+}
+func (q decodeQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *decodeQueue) Push(x interface{}) { *q = append(*q, x.(queuedDecode)) }
+func (q *decodeQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	old[len(old)-1] = queuedDecode{}
+	*q = old[:len(old)-1]
+	return item
+}
+
+// NewDecodeService starts a DecodeService with the given number of workers, GOMAXPROCS if zero or less.
+func NewDecodeService(workers int) *DecodeService {
+
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	s := &DecodeService{inflight: make(map[decodeKey][]queuedDecode)}
+	s.cond = sync.NewCond(&s.mu)
+	s.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go s.work()
+	}
+	return s
+}
+
+// Submit queues req and returns a channel that receives its result once. A request whose context is
+// done by the time a worker takes it is not decoded, and receives the context's error. A request for a
+// region already being decoded is not queued, and receives the result of that decode.
+func (s *DecodeService) Submit(req *DecodeRequest) <-chan DecodeResult {
+
+	out := make(chan DecodeResult, 1)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		out <- DecodeResult{Request: req, Err: ErrServiceClosed}
+		return out
+	}
+	if waiters, ok := s.inflight[req.key()]; ok && !req.done() {
+		s.inflight[req.key()] = append(waiters, queuedDecode{req, out, 0})
+		return out
+	}
+	s.seq++
+	heap.Push(&s.queue, queuedDecode{req, out, s.seq})
+	s.cond.Signal()
+	return out
+}
+
+// Pending returns the number of requests waiting for a worker.
+func (s *DecodeService) Pending() int {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queue.Len()
+}
+
+// Close stops s once the requests being decoded finish. Requests still queued receive
+// ErrServiceClosed.
+func (s *DecodeService) Close() {
+
+	s.mu.Lock()
+	s.closed = true
+	for s.queue.Len() > 0 {
+		q := heap.Pop(&s.queue).(queuedDecode)
+		q.out <- DecodeResult{Request: q.req, Err: ErrServiceClosed}
+	}
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// takes requests from the queue and decodes them until s is closed
+func (s *DecodeService) work() {
+
+	defer s.wg.Done()
+	for {
+		s.mu.Lock()
+		for s.queue.Len() == 0 && !s.closed {
+			s.cond.Wait()
+		}
+		if s.closed {
+			s.mu.Unlock()
+			return
+		}
+		q := heap.Pop(&s.queue).(queuedDecode)
+		s.mu.Unlock()
+
+		if q.req.done() {
+			q.out <- DecodeResult{Request: q.req, Err: q.req.Context.Err()}
+			continue
+		}
+		key := q.req.key()
+		s.mu.Lock()
+		if waiters, ok := s.inflight[key]; ok {
+			s.inflight[key] = append(waiters, q)
+			s.mu.Unlock()
+			continue
+		}
+		s.inflight[key] = nil
+		s.mu.Unlock()
+
+		img := key.tex.DecompressRect(key.region)
+
+		s.mu.Lock()
+		waiters := append(s.inflight[key], q)
+		delete(s.inflight, key)
+		//Answer queued requests for the same region too, leaving any whose context is done to be taken
+		queue := s.queue[:0]
+		for _, other := range s.queue {
+			if other.req.key() == key && !other.req.done() {
+				waiters = append(waiters, other)
+			} else {
+				queue = append(queue, other)
+			}
+		}
+		for i := len(queue); i < len(s.queue); i++ {
+			s.queue[i] = queuedDecode{}
+		}
+		s.queue = queue
+		heap.Init(&s.queue)
+		s.mu.Unlock()
+
+		for _, w := range waiters {
+			w.out <- DecodeResult{Request: w.req, Image: img}
+		}
+	}
+}
+
+// returns the texture and region req decodes, an empty region being the whole texture
+func (req *DecodeRequest) key() decodeKey {
+
+	region := req.Region
+	if region.Empty() {
+		region = image.Rect(0, 0, req.Texture.Rect.Dx(), req.Texture.Rect.Dy())
+	}
+	return decodeKey{req.Texture, region}
+}
+
+// reports whether the context of req is done
+func (req *DecodeRequest) done() bool {
+
+	return req.Context != nil && req.Context.Err() != nil
+}
@@ -0,0 +1,111 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Descriptor records the import settings of a compressed texture for engine editor plugins.
+type Descriptor struct {
+	Format        string `json:"format"`                  //Always "BC5".
+	Width         int    `json:"width"`                   //Width in pixels of the stored texture.
+	Height        int    `json:"height"`                  //Height in pixels of the stored texture.
+	ContentWidth  int    `json:"contentWidth,omitempty"`  //Width of the image before padding, if it was padded with EncodeOptions.PadEdges.
+	ContentHeight int    `json:"contentHeight,omitempty"` //Height of the image before padding, if it was padded.
+	SRGB          bool   `json:"srgb"`                    //BC5 data is always linear.
+	MipLevels     int    `json:"mipLevels"`               //Number of mip levels stored.
+	NormalMap     bool   `json:"normalMap"`               //The texture was encoded with the normal profile.
+	Convention    string `json:"convention,omitempty"`    //Green channel convention, if known.
+}
+
+// NewDescriptor returns the import settings describing the mip chain levels, ordered from the base
+// level down such as from NewMipChain. A texture without mips is a chain of one level. An error is
+// returned if levels is empty.
+func NewDescriptor(levels []*BC5) (Descriptor, error) {
+
+	if len(levels) == 0 {
+		return Descriptor{}, errors.New("no mip levels given")
+	}
+	b := levels[0]
+	d := Descriptor{
+		Format:    "BC5",
+		Width:     b.Rect.Size().X,
+		Height:    b.Rect.Size().Y,
+		MipLevels: len(levels),
+		NormalMap: b.Metadata[MetaProfile] == "normal",
+	}
+	if content := b.ContentSize(); content != b.Rect.Size() {
+		d.ContentWidth, d.ContentHeight = content.X, content.Y
+	}
+	if c := b.Convention(); c != UnknownConvention {
+		d.Convention = c.String()
+	}
+	return d, nil
+}
+
+// WriteDescriptor writes the import settings describing the mip chain levels to w as JSON, for editor
+// plugins that configure imported textures from a sidecar file.
+func WriteDescriptor(w io.Writer, levels []*BC5) error {
+
+	d, err := NewDescriptor(levels)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(d)
+}
+
+// UnityGUID returns a stable 32 digit asset GUID derived from name, for use with WriteUnityMeta.
+func UnityGUID(name string) string {
+
+	sum := md5.Sum([]byte(name))
+	return hex.EncodeToString(sum[:])
+}
+
+// WriteUnityMeta writes the .meta file Unity expects next to a DDS or KTX container holding the mip
+// chain levels, so the texture imports as linear with the stored mips and no manual configuration.
+func WriteUnityMeta(w io.Writer, levels []*BC5, guid string) error {
+
+	d, err := NewDescriptor(levels)
+	if err != nil {
+		return err
+	}
+	srgb, mips := 0, 0
+	if d.SRGB {
+		srgb = 1
+	}
+	if d.MipLevels > 1 {
+		mips = 1
+	}
+
+	_, err = fmt.Fprintf(w, `fileFormatVersion: 2
+guid: %s
+IHVImageFormatImporter:
+  externalObjects: {}
+  serializedVersion: 2
+  textureSettings:
+    serializedVersion: 2
+    filterMode: -1
+    aniso: -1
+    mipBias: -100
+    wrapU: -1
+    wrapV: -1
+    wrapW: -1
+  isReadable: 0
+  sRGBTexture: %d
+  streamingMipmaps: %d
+  streamingMipmapsPriority: 0
+  userData: 
+  assetBundleName: 
+  assetBundleVariant: 
+`, guid, srgb, mips)
+	return err
+}
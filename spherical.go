@@ -0,0 +1,177 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math"
+)
+
+// MetaProjection is the metadata key holding the spherical projection of an image, as the name of a
+// Projection.
+const MetaProjection = "projection"
+
+// Alias for spherical projection constants. Directions are unit vectors with Y up and -Z forward.
+type Projection int
+
+const (
+	LatLong        Projection = iota //Longitude across the width from -Z round through +X, and latitude from +Y at the top to -Y at the bottom.
+	DualParaboloid                   //The +Z hemisphere in the top half and the -Z hemisphere in the bottom half, each squashed to half height.
+)
+
+// String returns the name of p as recorded in the metadata.
+func (p Projection) String() string {
+
+	switch p {
+	case LatLong:
+		return "latlong"
+	case DualParaboloid:
+		return "dualparaboloid"
+	default:
+		return "unknown"
+	}
+}
+
+// NewBC5FromSpherical compresses size by size texels of 2 channel spherical data, such as visibility or
+// a lookup table indexed by direction, stored with proj and recorded in the metadata. fn is called with
+// the direction through the center of each texel and returns the red and green values from 0 to 1,
+// clamping any outside. Texels outside the disks of a DualParaboloid are filled from the direction
+// beyond the rim, so filtering near it stays continuous. For DualParaboloid size must be a multiple of
+// 8 so that no block straddles both hemispheres.
+func NewBC5FromSpherical(size int, proj Projection, fn func(dir [3]float64) (float64, float64), opts *EncodeOptions) (*BC5, error) {
+
+	if proj != LatLong && proj != DualParaboloid {
+		return nil, errors.New("unknown projection")
+	}
+	if proj == DualParaboloid && size%8 != 0 {
+		return nil, errors.New("dual paraboloid size must be a multiple of 8")
+	}
+
+	encode := func(v float64) uint8 {
+		return uint8(clampInt(int(math.Floor(v*255+0.5)), 0, 255))
+	}
+	rgba := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			r, g := fn(proj.texelDir(float64(x)+0.5, float64(y)+0.5, size))
+			rgba.SetRGBA(x, y, color.RGBA{R: encode(r), G: encode(g), A: 255})
+		}
+	}
+
+	b := new(BC5)
+	if err := b.SetFromRGBAWithOptions(rgba, opts); err != nil {
+		return nil, err
+	}
+	b.setMeta(MetaProjection, proj.String())
+	return b, nil
+}
+
+// Projection returns the spherical projection recorded in the metadata of b, and false if there is none.
+func (b BC5) Projection() (Projection, bool) {
+
+	for _, p := range []Projection{LatLong, DualParaboloid} {
+		if b.Metadata[MetaProjection] == p.String() {
+			return p, true
+		}
+	}
+	return 0, false
+}
+
+// SampleDirection returns the red and green values of b from 0 to 1 in direction dir, which need not be
+// normalized, filtered bilinearly. Neighbouring texels are found across the seams of the projection
+// rather than through the AddressMode of b: a LatLong image wraps around in longitude and continues
+// over the poles, and each half of a DualParaboloid is clamped to itself. An error is returned if b has
+// no projection recorded or dir is zero.
+func (b BC5) SampleDirection(dir [3]float64) (float64, float64, error) {
+
+	proj, ok := b.Projection()
+	if !ok {
+		return 0, 0, errors.New("no projection recorded")
+	}
+	l := math.Sqrt(dir[0]*dir[0] + dir[1]*dir[1] + dir[2]*dir[2])
+	if l == 0 || math.IsNaN(l) {
+		return 0, 0, errors.New("direction must not be zero")
+	}
+	for i := range dir {
+		dir[i] /= l
+	}
+
+	size := b.Rect.Dx()
+	fx, fy, half := proj.dirToTexel(dir, size)
+	fx, fy = fx-0.5, fy-0.5
+	x0, y0 := int(math.Floor(fx)), int(math.Floor(fy))
+	tx, ty := fx-float64(x0), fy-float64(y0)
+
+	var v [2]float64
+	for _, t := range []struct {
+		x, y int
+		w    float64
+	}{{x0, y0, (1 - tx) * (1 - ty)}, {x0 + 1, y0, tx * (1 - ty)}, {x0, y0 + 1, (1 - tx) * ty}, {x0 + 1, y0 + 1, tx * ty}} {
+		x, y := proj.texelAt(t.x, t.y, half, size)
+		c, _ := b.AtOK(x, y)
+		v[0] += float64(c.R) * t.w
+		v[1] += float64(c.G) * t.w
+	}
+	return v[0] / 255, v[1] / 255, nil
+}
+
+// returns the unit direction through the point (px,py) of an image of the given size
+func (p Projection) texelDir(px, py float64, size int) [3]float64 {
+
+	if p == LatLong {
+		lon := (px/float64(size) - 0.5) * 2 * math.Pi
+		theta := py / float64(size) * math.Pi
+		return [3]float64{math.Sin(theta) * math.Sin(lon), math.Cos(theta), -math.Sin(theta) * math.Cos(lon)}
+	}
+
+	h := float64(size / 2)
+	back := py >= h
+	if back {
+		py -= h
+	}
+	s, t := px/float64(size)*2-1, 1-py/h*2
+	d := 1 + s*s + t*t
+	dir := [3]float64{2 * s / d, 2 * t / d, (1 - s*s - t*t) / d}
+	if back {
+		dir[2] = -dir[2]
+	}
+	return dir
+}
+
+// returns the point of an image of the given size that the unit vector dir maps to, and the half of a
+// DualParaboloid it is in
+func (p Projection) dirToTexel(dir [3]float64, size int) (float64, float64, int) {
+
+	if p == LatLong {
+		lon := math.Atan2(dir[0], -dir[2])
+		theta := math.Acos(math.Max(-1, math.Min(1, dir[1])))
+		return (lon/(2*math.Pi) + 0.5) * float64(size), theta / math.Pi * float64(size), 0
+	}
+
+	half, z := 0, dir[2]
+	if z < 0 {
+		half, z = 1, -z
+	}
+	s, t := dir[0]/(1+z), dir[1]/(1+z)
+	h := float64(size / 2)
+	return (s*0.5 + 0.5) * float64(size), (0.5-t*0.5)*h + float64(half)*h, half
+}
+
+// returns the texel of an image of the given size holding (x,y), continuing across the seams of p
+func (p Projection) texelAt(x, y, half, size int) (int, int) {
+
+	if p == LatLong {
+		if y < 0 {
+			x, y = x+size/2, -1-y
+		} else if y >= size {
+			x, y = x+size/2, 2*size-1-y
+		}
+		return wrapCoord(x, size), clampCoord(y, size)
+	}
+
+	h := size / 2
+	return clampCoord(x, size), half*h + clampCoord(y-half*h, h)
+}
@@ -0,0 +1,104 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"context"
+	"runtime/trace"
+	"time"
+)
+
+// Alias for encode stage constants.
+type Stage int
+
+const (
+	StageSplit     Stage = iota //Fetching source pixels and splitting them into 4x4 blocks.
+	StageEndpoints              //Searching for each block channel's reference values, including reuse of previous blocks.
+	StageIndices                //Assigning palette indices to each pixel, including rate-distortion optimization.
+	StageSerialize              //Writing the compressed data out.
+)
+
+// String returns the name of s, which is also the suffix of its runtime/trace region.
+func (s Stage) String() string {
+
+	switch s {
+	case StageSplit:
+		return "split"
+	case StageEndpoints:
+		return "endpoints"
+	case StageIndices:
+		return "indices"
+	case StageSerialize:
+		return "serialize"
+	default:
+		return "unknown"
+	}
+}
+
+// accumulates the time spent in each stage of an encode. A nil stageTimer does nothing, so timing
+// costs nothing unless a callback was given.
+type stageTimer struct {
+	fn     func(Stage, time.Duration)
+	totals [StageSerialize + 1]time.Duration
+	last   time.Time
+}
+
+// returns a timer reporting to fn, or nil if fn is nil
+func newStageTimer(fn func(Stage, time.Duration)) *stageTimer {
+
+	if fn == nil {
+		return nil
+	}
+	return &stageTimer{fn: fn, last: time.Now()}
+}
+
+// adds the time since the previous mark to stage s
+func (t *stageTimer) mark(s Stage) {
+
+	if t == nil {
+		return
+	}
+	now := time.Now()
+	t.totals[s] += now.Sub(t.last)
+	t.last = now
+}
+
+// returns the callback of t, or nil if t is nil
+func (t *stageTimer) callback() func(Stage, time.Duration) {
+
+	if t == nil {
+		return nil
+	}
+	return t.fn
+}
+
+// adds the totals of o, which timed work done in parallel, to t, and restarts the time since the
+// previous mark
+func (t *stageTimer) merge(o *stageTimer) {
+
+	if t == nil || o == nil {
+		return
+	}
+	for s, d := range o.totals {
+		t.totals[s] += d
+	}
+	t.last = time.Now()
+}
+
+// passes the total of every stage to the callback
+func (t *stageTimer) report() {
+
+	if t == nil {
+		return
+	}
+	for s, d := range t.totals {
+		t.fn(Stage(s), d)
+	}
+}
+
+// starts a runtime/trace region for a pass of an encode, which is a no-op unless tracing is enabled
+func traceRegion(name string) *trace.Region {
+
+	return trace.StartRegion(context.Background(), "bc5."+name)
+}
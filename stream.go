@@ -0,0 +1,202 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+)
+
+// kinds of frame chunk in a stream
+const (
+	keyFrame   = 0 //Payload is the block data of the whole frame.
+	deltaFrame = 1 //Payload is a bitmap of changed blocks followed by the data of those blocks.
+)
+
+// StreamOptions holds settings for a StreamWriter.
+type StreamOptions struct {
+	Encode      *EncodeOptions //Options used to compress each frame, which may be nil. Previous is set by the writer.
+	KeyInterval int            //Write a whole frame every KeyInterval frames so readers can start part way, 1 if zero.
+	Dedup       bool           //Reuse the blocks of the previous frame that are within Encode.Tolerance and only store changed blocks.
+}
+
+// StreamWriter compresses a sequence of frames, such as snapshots of a simulated heightfield, into a
+// chunked stream. The stream begins with the signature "BC5S" and uint32 values for the width and
+// height, followed by a chunk for each frame. A chunk is a uint32 payload length, a byte giving the kind
+// of frame and the payload. Key frames hold the whole block data, delta frames a bitmap with a bit set
+// for each block that changed since the previous frame, in block order from the least significant bit
+// of the first byte, followed by the data of the changed blocks.
+type StreamWriter struct {
+	w       io.Writer
+	size    image.Point
+	opts    StreamOptions
+	prev    *BC5
+	written int
+}
+
+// NewStreamWriter writes the stream header for frames of the given size to w and returns a writer for
+// the frames.
+func NewStreamWriter(w io.Writer, width, height int, opts *StreamOptions) (*StreamWriter, error) {
+
+	if opts == nil {
+		opts = &StreamOptions{}
+	}
+	header := make([]byte, 12)
+	binary.BigEndian.PutUint32(header[:4], strToDword("BC5S"))
+	binary.BigEndian.PutUint32(header[4:8], uint32(width))
+	binary.BigEndian.PutUint32(header[8:12], uint32(height))
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &StreamWriter{w: w, size: image.Pt(width, height), opts: *opts}, nil
+}
+
+// WriteFrame compresses rgba and writes it as the next frame. It must be the size given to
+// NewStreamWriter.
+func (s *StreamWriter) WriteFrame(rgba *image.RGBA) error {
+
+	if rgba.Rect.Size() != s.size {
+		return errors.New("frame size does not match stream")
+	}
+
+	opts := EncodeOptions{}
+	if s.opts.Encode != nil {
+		opts = *s.opts.Encode
+	}
+	opts.Previous = nil
+	if s.opts.Dedup && s.prev != nil {
+		opts.Previous = s.prev
+	}
+	frame := new(BC5)
+	if err := frame.SetFromRGBAWithOptions(rgba, &opts); err != nil {
+		return err
+	}
+
+	interval := s.opts.KeyInterval
+	if interval <= 0 {
+		interval = 1
+	}
+	kind, payload := byte(keyFrame), frame.Data
+	if s.prev != nil && s.written%interval != 0 {
+		kind, payload = deltaFrame, deltaPayload(s.prev.Data, frame.Data)
+	}
+
+	chunk := make([]byte, 5, 5+len(payload))
+	binary.BigEndian.PutUint32(chunk[:4], uint32(len(payload)))
+	chunk[4] = kind
+	if _, err := s.w.Write(append(chunk, payload...)); err != nil {
+		return err
+	}
+	s.prev = frame
+	s.written++
+	return nil
+}
+
+// returns the changed block bitmap and changed blocks of cur relative to prev
+func deltaPayload(prev, cur []byte) []byte {
+
+	n := len(cur) / 16
+	bitmap := make([]byte, (n+7)/8)
+	var blocks []byte
+	for i := 0; i < n; i++ {
+		if !bytes.Equal(prev[i*16:i*16+16], cur[i*16:i*16+16]) {
+			bitmap[i/8] |= 1 << uint(i%8)
+			blocks = append(blocks, cur[i*16:i*16+16]...)
+		}
+	}
+	return append(bitmap, blocks...)
+}
+
+// StreamReader replays the frames of a stream written by a StreamWriter.
+type StreamReader struct {
+	r    *bufio.Reader
+	Rect image.Rectangle //Bounds of every frame.
+	cur  []byte
+}
+
+// NewStreamReader reads the stream header from r and returns a reader for its frames.
+func NewStreamReader(r io.Reader) (*StreamReader, error) {
+
+	br := bufio.NewReader(r)
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, err
+	}
+	if binary.BigEndian.Uint32(header[:4]) != strToDword("BC5S") {
+		return nil, errors.New("invalid stream signature")
+	}
+	width, height := int(binary.BigEndian.Uint32(header[4:8])), int(binary.BigEndian.Uint32(header[8:12]))
+	if width%4 != 0 || height%4 != 0 || width > MaxDimension || height > MaxDimension {
+		return nil, errors.New("invalid frame size")
+	}
+	return &StreamReader{r: br, Rect: image.Rect(0, 0, width, height)}, nil
+}
+
+// Next returns the next frame of the stream, or io.EOF after the last. Streams that begin with a delta
+// frame, such as one joined part way through, are skipped up to the first key frame.
+func (s *StreamReader) Next() (*BC5, error) {
+
+	size := s.Rect.Size().X / 4 * s.Rect.Size().Y / 4 * 16
+	for {
+		chunk := make([]byte, 5)
+		if _, err := io.ReadFull(s.r, chunk); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return nil, errors.New("truncated frame header")
+			}
+			return nil, err
+		}
+		payload := make([]byte, binary.BigEndian.Uint32(chunk[:4]))
+		if _, err := io.ReadFull(s.r, payload); err != nil {
+			return nil, errors.New("truncated frame")
+		}
+
+		switch chunk[4] {
+		case keyFrame:
+			if len(payload) != size {
+				return nil, errors.New("key frame size does not match stream")
+			}
+			s.cur = payload
+		case deltaFrame:
+			if s.cur == nil {
+				continue
+			}
+			next, err := applyDelta(s.cur, payload)
+			if err != nil {
+				return nil, err
+			}
+			s.cur = next
+		default:
+			return nil, errors.New("unknown frame kind")
+		}
+		return &BC5{Data: s.cur, Rect: s.Rect}, nil
+	}
+}
+
+// returns a copy of prev with the changed blocks of a delta payload written over it
+func applyDelta(prev, payload []byte) ([]byte, error) {
+
+	n := len(prev) / 16
+	bitmapLen := (n + 7) / 8
+	if len(payload) < bitmapLen {
+		return nil, errors.New("truncated delta frame")
+	}
+	bitmap, blocks := payload[:bitmapLen], payload[bitmapLen:]
+
+	next := append([]byte(nil), prev...)
+	for i := 0; i < n; i++ {
+		if bitmap[i/8]&(1<<uint(i%8)) == 0 {
+			continue
+		}
+		if len(blocks) < 16 {
+			return nil, errors.New("truncated delta frame")
+		}
+		copy(next[i*16:i*16+16], blocks[:16])
+		blocks = blocks[16:]
+	}
+	return next, nil
+}
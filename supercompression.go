@@ -0,0 +1,87 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+// MetaSupercompression is the metadata key naming the scheme Encode compresses the stored block data
+// with, "zlib" if it is deflated. Data is stored as is without it.
+const MetaSupercompression = "supercompression"
+
+// SetSupercompression sets the scheme Encode compresses the block data of b with once it is arranged
+// in its layout, using the schemes of KTX2 files. KTX2Zlib deflates it with zlib and KTX2None stores
+// it as is. Decode inflates the data transparently, but blocks of a supercompressed file cannot be
+// read individually by LazyBC5 or protected by EncodeFEC, and DecodeTolerant cannot tell which of
+// its blocks are intact.
+func (b *BC5) SetSupercompression(s KTX2Supercompression) error {
+
+	switch s {
+	case KTX2None:
+		delete(b.Metadata, MetaSupercompression)
+	case KTX2Zlib:
+		b.setMeta(MetaSupercompression, "zlib")
+	default:
+		return errors.New("unsupported supercompression scheme")
+	}
+	return nil
+}
+
+// Supercompression returns the scheme recorded in the metadata of b.
+func (b BC5) Supercompression() KTX2Supercompression {
+
+	if b.Metadata[MetaSupercompression] == "zlib" {
+		return KTX2Zlib
+	}
+	return KTX2None
+}
+
+// returns data compressed with the supercompression scheme of b
+func (b BC5) supercompress(data []byte) ([]byte, error) {
+
+	if b.Supercompression() == KTX2None {
+		return data, nil
+	}
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return z.Bytes(), nil
+}
+
+// reverses the supercompression of the data of a decoded b, leaving it in its stored layout. No more
+// is inflated than the largest layout of b could take, so a corrupt stream cannot exhaust memory.
+func (b *BC5) inflate() error {
+
+	switch v, ok := b.Metadata[MetaSupercompression]; {
+	case !ok:
+		return nil
+	case v != "zlib":
+		return errors.New("unknown supercompression " + v)
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(b.Data))
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	limit := int64(b.Rect.Size().X/4*b.Rect.Size().Y/4*20 + 4) //A codebook of distinct blocks, and an index for each
+	data, err := ioutil.ReadAll(io.LimitReader(zr, limit+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) > limit {
+		return errors.New("supercompressed data is larger than the image")
+	}
+	b.Data = data
+	return nil
+}
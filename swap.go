@@ -0,0 +1,76 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"image"
+	"math"
+)
+
+// swaps the red and green halves of every block in data
+func swapBlockHalves(data []byte) {
+
+	var half [8]byte
+	for pos := 0; pos+16 <= len(data); pos += 16 {
+		copy(half[:], data[pos:pos+8])
+		copy(data[pos:pos+8], data[pos+8:pos+16])
+		copy(data[pos+8:pos+16], half[:])
+	}
+}
+
+// SwapReport holds the result of DetectSwappedChannels.
+type SwapReport struct {
+	Error        float64 //Mean mismatch per pixel of the cross derivatives of the slopes as stored.
+	SwappedError float64 //The same with red and green exchanged.
+	Swapped      bool    //Whether red and green appear to be exchanged.
+}
+
+// DetectSwappedChannels checks whether b reads as a more consistent normal map with red and green
+// exchanged, as happens with assets from tools that wrote 3Dc data green first. The slopes p and q
+// along X and Y of a normal map derived from a surface satisfy dp/dy = dq/dx, whatever the sign of
+// either, while exchanging them breaks this for any surface curving differently along each axis. The
+// mismatch is measured both ways and the swap is only reported when clearly better, so flat or
+// symmetric maps are left alone. Vector lengths cannot tell the orders apart, as they are the same
+// both ways.
+func (b BC5) DetectSwappedChannels() SwapReport {
+
+	same, swapped := integrabilityError(&b)
+	return SwapReport{Error: same, SwappedError: swapped, Swapped: swapped < same*0.8}
+}
+
+// returns the mean mismatch between the cross derivatives of the slopes of b read as a normal map,
+// with red and green as read and exchanged, taking the better of both green conventions for each
+func integrabilityError(b *BC5) (same, swapped float64) {
+
+	size := b.Rect.Size()
+	img := b.decompressRect(image.Rect(0, 0, size.X, size.Y))
+	p := make([]float64, size.X*size.Y)
+	q := make([]float64, size.X*size.Y)
+	for y := 0; y < size.Y; y++ {
+		for x := 0; x < size.X; x++ {
+			c := img.RGBAAt(x, y)
+			nx, ny := float64(c.R)/255*2-1, float64(c.G)/255*2-1
+			nz := math.Max(math.Sqrt(math.Max(1-nx*nx-ny*ny, 0)), 0.1)
+			p[y*size.X+x], q[y*size.X+x] = nx/nz, ny/nz
+		}
+	}
+
+	var e [4]float64
+	for y := 0; y+1 < size.Y; y++ {
+		for x := 0; x+1 < size.X; x++ {
+			i := y*size.X + x
+			dpdy, dpdx := p[i+size.X]-p[i], p[i+1]-p[i]
+			dqdy, dqdx := q[i+size.X]-q[i], q[i+1]-q[i]
+			e[0] += math.Abs(dpdy - dqdx)
+			e[1] += math.Abs(dpdy + dqdx)
+			e[2] += math.Abs(dqdy - dpdx)
+			e[3] += math.Abs(dqdy + dpdx)
+		}
+	}
+	n := float64((size.X - 1) * (size.Y - 1))
+	if n <= 0 {
+		return 0, 0
+	}
+	return math.Min(e[0], e[1]) / n, math.Min(e[2], e[3]) / n
+}
@@ -0,0 +1,139 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// Alias for terrain source constants.
+type TerrainSource int
+
+const (
+	HeightRed   TerrainSource = iota //Red holds height, 0 to 255 mapping to 0 to TerrainOptions.HeightScale.
+	HeightGreen                      //Green holds height.
+	NormalRG                         //Red and green hold the X and Y of a tangent-space normal.
+)
+
+// TerrainOptions holds settings for SlopeMap and CurvatureMap.
+type TerrainOptions struct {
+	Source          TerrainSource //Normals follow the convention recorded in the metadata, OpenGL if there is none.
+	HeightScale     float64       //Height of a value of 255 in pixel widths, 1 if zero. Unused for normals.
+	CurvatureScale  float64       //Curvature mapped to 0 and 255 around 128 for flat ground, 1 if zero.
+	InvertCurvature bool          //Map convex areas below 128 rather than above.
+}
+
+// SlopeMap returns the steepness of each pixel of b within r, clipped to b, from 0 for flat to 255 for
+// vertical. Only the blocks within a pixel of r are decompressed, so large terrains can be processed a
+// tile at a time.
+func (b BC5) SlopeMap(r image.Rectangle, opts *TerrainOptions) *image.Gray {
+
+	r = r.Intersect(b.Bounds())
+	return terrainMap(b.decompressRect(r.Inset(-1).Intersect(b.Bounds())), r, opts, b.Convention(), false)
+}
+
+// CurvatureMap returns the curvature of each pixel of b within r, clipped to b, as a Gray image in
+// which 128 is flat and values above it are convex. Heights use the Laplacian, normals the divergence
+// of the surface gradient. As with SlopeMap, only the blocks around r are decompressed.
+func (b BC5) CurvatureMap(r image.Rectangle, opts *TerrainOptions) *image.Gray {
+
+	r = r.Intersect(b.Bounds())
+	return terrainMap(b.decompressRect(r.Inset(-1).Intersect(b.Bounds())), r, opts, b.Convention(), true)
+}
+
+// SlopeMap returns the slope map of l within r, reading only the blocks around r.
+func (l *LazyBC5) SlopeMap(r image.Rectangle, opts *TerrainOptions) (*image.Gray, error) {
+
+	r = r.Intersect(image.Rectangle{Max: l.ContentSize()})
+	src, err := l.DecompressRect(r.Inset(-1))
+	if err != nil {
+		return nil, err
+	}
+	return terrainMap(src, r, opts, BC5{Metadata: l.Metadata}.Convention(), false), nil
+}
+
+// CurvatureMap returns the curvature map of l within r, reading only the blocks around r.
+func (l *LazyBC5) CurvatureMap(r image.Rectangle, opts *TerrainOptions) (*image.Gray, error) {
+
+	r = r.Intersect(image.Rectangle{Max: l.ContentSize()})
+	src, err := l.DecompressRect(r.Inset(-1))
+	if err != nil {
+		return nil, err
+	}
+	return terrainMap(src, r, opts, BC5{Metadata: l.Metadata}.Convention(), true), nil
+}
+
+// computes the slope or curvature map of r from src, which covers r and any pixels around it that lie
+// within the image. Pixels outside src are clamped to its edge. Normals are read as OpenGL unless
+// convention is DirectX.
+func terrainMap(src *image.RGBA, r image.Rectangle, opts *TerrainOptions, convention GreenConvention, curvature bool) *image.Gray {
+
+	if opts == nil {
+		opts = &TerrainOptions{}
+	}
+	heightScale, curvScale := opts.HeightScale, opts.CurvatureScale
+	if heightScale == 0 {
+		heightScale = 1
+	}
+	if curvScale == 0 {
+		curvScale = 1
+	}
+
+	at := func(x, y int) color.RGBA {
+		return src.RGBAAt(clampInt(x, src.Rect.Min.X, src.Rect.Max.X-1), clampInt(y, src.Rect.Min.Y, src.Rect.Max.Y-1))
+	}
+	height := func(x, y int) float64 {
+		if opts.Source == HeightGreen {
+			return float64(at(x, y).G) / 255 * heightScale
+		}
+		return float64(at(x, y).R) / 255 * heightScale
+	}
+	//Surface gradient, the change in height per pixel along each axis
+	gradient := func(x, y int) (float64, float64) {
+		if opts.Source == NormalRG {
+			c := at(x, y)
+			nx, ny := 2*normalize(c.R)-1, 2*normalize(c.G)-1
+			nz := math.Sqrt(math.Max(1-nx*nx-ny*ny, 1e-6))
+			if convention != DirectX {
+				//OpenGL green points up the texture, against the direction of increasing y
+				ny = -ny
+			}
+			return -nx / nz, -ny / nz
+		}
+		return (height(x+1, y) - height(x-1, y)) / 2, (height(x, y+1) - height(x, y-1)) / 2
+	}
+
+	out := image.NewGray(r)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			var v float64
+			switch {
+			case !curvature:
+				gx, gy := gradient(x, y)
+				v = math.Atan(math.Hypot(gx, gy)) / (math.Pi / 2) * 255
+			default:
+				var c float64
+				if opts.Source == NormalRG {
+					gx0, _ := gradient(x-1, y)
+					gx1, _ := gradient(x+1, y)
+					_, gy0 := gradient(x, y-1)
+					_, gy1 := gradient(x, y+1)
+					c = (gx1-gx0)/2 + (gy1-gy0)/2
+				} else {
+					c = height(x+1, y) + height(x-1, y) + height(x, y+1) + height(x, y-1) - 4*height(x, y)
+				}
+				//A convex peak has a negative Laplacian
+				c = -c
+				if opts.InvertCurvature {
+					c = -c
+				}
+				v = 128 + c*curvScale*127
+			}
+			out.SetGray(x, y, color.Gray{Y: uint8(clampInt(int(math.Floor(v+0.5)), 0, 255))})
+		}
+	}
+	return out
+}
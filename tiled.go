@@ -0,0 +1,120 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"errors"
+	"image"
+	"io"
+)
+
+// TileProvider returns the source pixels of rect. The returned image must have the same size as rect,
+// its bounds may be positioned anywhere.
+type TileProvider func(rect image.Rectangle) (*image.RGBA, error)
+
+// EncodeTiled compresses a width by height image supplied tile by tile from provider and writes it to w
+// in the same format as Encode, without holding the whole source or output in memory. Tiles are
+// requested in rows from the top left and each is released once compressed, so only a single source
+// tile and a single row of compressed tiles are held at once. width and height must be multiples of
+// tileSize, which must be a multiple of 4. If the MemoryBudget option cannot hold a row of compressed
+// tiles and a source tile, the tile size is halved until it can, so provider may be asked for smaller
+// tiles than tileSize. The options are checked as ValidateFor does. FixTiling and ChannelStats are not
+// supported, as they need the whole image, and neither are PadEdges and Previous.
+func EncodeTiled(w io.Writer, width, height, tileSize int, provider TileProvider, opts *EncodeOptions) error {
+
+	if opts == nil {
+		opts = &EncodeOptions{}
+	}
+	if err := opts.ValidateFor(&image.RGBA{Rect: image.Rect(0, 0, width, height)}); err != nil {
+		return err
+	}
+	if tileSize <= 0 || tileSize%4 != 0 {
+		return errors.New("tile size must be a positive multiple of 4")
+	}
+	if width <= 0 || height <= 0 || width%tileSize != 0 || height%tileSize != 0 {
+		return errors.New("size must be a multiple of the tile size")
+	}
+	if opts.Previous != nil {
+		return errors.New("previous image is not supported when encoding tiles")
+	}
+	if opts.FixTiling {
+		return errors.New("FixTiling is not supported when encoding tiles")
+	}
+	if opts.ChannelStats {
+		return errors.New("ChannelStats is not supported when encoding tiles")
+	}
+	if opts.PadEdges {
+		return errors.New("PadEdges is not supported when encoding tiles")
+	}
+
+	for opts.MemoryBudget > 0 && int64(width*tileSize+tileSize*tileSize*4+blockWorkingSize) > opts.MemoryBudget {
+		if tileSize%8 != 0 {
+			return ErrBudgetExceeded
+		}
+		tileSize /= 2
+	}
+
+	quality, profile, _ := opts.resolve()
+	header := &BC5{}
+	if profile != nil {
+		header.recordProfile(opts.Profile, profile)
+	}
+	if opts.Record {
+		if err := header.recordOptions(opts); err != nil {
+			return err
+		}
+	}
+	if err := writeHeader(w, image.Pt(width, height), header.Metadata); err != nil {
+		return err
+	}
+
+	timer := newStageTimer(opts.OnStage)
+	blocksPerRow := width / 4
+	band := make([]byte, blocksPerRow*tileSize*4)
+	for ty := 0; ty < height; ty += tileSize {
+		region := traceRegion("compress")
+		for tx := 0; tx < width; tx += tileSize {
+			rect := image.Rect(tx, ty, tx+tileSize, ty+tileSize)
+			tile, err := provider(rect)
+			if err != nil {
+				return err
+			}
+			if tile == nil || tile.Rect.Size() != rect.Size() {
+				return errors.New("tile size does not match requested rectangle")
+			}
+			timer.mark(StageSplit)
+			compressTile(tile, band, tx/4, ty/4, blocksPerRow, quality, opts, timer)
+		}
+		region.End()
+
+		region = traceRegion(StageSerialize.String())
+		_, err := w.Write(band)
+		region.End()
+		if err != nil {
+			return err
+		}
+		timer.mark(StageSerialize)
+	}
+	timer.report()
+	return nil
+}
+
+// compresses tile into band, which holds rows of blocksPerRow blocks, with the first block of the
+// tile in column col of the first row. row is the block row of the image the band starts at.
+func compressTile(tile *image.RGBA, band []byte, col, row, blocksPerRow int, quality Quality, opts *EncodeOptions, timer *stageTimer) {
+
+	block := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	size := tile.Rect.Size()
+	for by := 0; by < size.Y/4; by++ {
+		for bx := 0; bx < size.X/4; bx++ {
+			loadBlock(block, tile, tile.Rect.Min.X+bx*4, tile.Rect.Min.Y+by*4)
+			pos := (by*blocksPerRow + col + bx) * 16
+			copy(band[pos:pos+16], encodeBlock(block, (row+by)*blocksPerRow+col+bx, quality, opts, timer))
+			if opts.RDO > 0 {
+				rdoBlock(band, pos, block, opts.RDO, opts.rdoWindow())
+				timer.mark(StageIndices)
+			}
+		}
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"errors"
+	"image"
+	"math"
+)
+
+// SeamError measures how far the step between opposite edges of a compressed tiling texture, where
+// one repeat meets the next, is from the step in its source.
+type SeamError struct {
+	Max int     //Largest error of a red or green value, 0 to 255.
+	RMS float64 //Root mean squared error of the red and green values.
+}
+
+// TilingReport holds the seam errors of a tiling texture.
+type TilingReport struct {
+	Horizontal SeamError //Seam between the right edge and the left edge of the next repeat.
+	Vertical   SeamError //Seam between the bottom edge and the top edge of the next repeat.
+}
+
+// ValidateTiling measures the seams b shows when repeated, which can appear even for a source that
+// tiles perfectly as the edge blocks are compressed independently. The step from each last column to
+// the first, and last row to the first, is compared with the same step in src. If src is nil the
+// edges are taken to be meant to match, so any step counts as error.
+func (b BC5) ValidateTiling(src *image.RGBA) (*TilingReport, error) {
+
+	size := b.Rect.Size()
+	if src != nil && src.Rect.Size() != size {
+		return nil, errors.New("source and compressed sizes do not match")
+	}
+	if size.X == 0 || size.Y == 0 {
+		return &TilingReport{}, nil
+	}
+
+	dec := b.decompressRect(image.Rect(0, 0, size.X, size.Y))
+	step := func(img *image.RGBA, x0, y0, x1, y1 int) (int, int) {
+		if img == nil {
+			return 0, 0
+		}
+		p := img.Rect.Min
+		a, c := img.RGBAAt(p.X+x0, p.Y+y0), img.RGBAAt(p.X+x1, p.Y+y1)
+		return int(c.R) - int(a.R), int(c.G) - int(a.G)
+	}
+	seam := func(n int, at func(i int) (int, int, int, int)) SeamError {
+		var e SeamError
+		var sum float64
+		for i := 0; i < n; i++ {
+			x0, y0, x1, y1 := at(i)
+			dr, dg := step(dec, x0, y0, x1, y1)
+			sr, sg := step(src, x0, y0, x1, y1)
+			for _, d := range []int{dr - sr, dg - sg} {
+				if d < 0 {
+					d = -d
+				}
+				if d > e.Max {
+					e.Max = d
+				}
+				sum += float64(d * d)
+			}
+		}
+		e.RMS = math.Sqrt(sum / float64(2*n))
+		return e
+	}
+
+	return &TilingReport{
+		Horizontal: seam(size.Y, func(y int) (int, int, int, int) { return size.X - 1, y, 0, y }),
+		Vertical:   seam(size.X, func(x int) (int, int, int, int) { return x, size.Y - 1, x, 0 }),
+	}, nil
+}
+
+// recompresses the right and bottom edge blocks of data, compressed from rgba, so that the step across
+// each seam to the opposite edge decodes as close as possible to the step in rgba. The seam pixels are
+// retargeted to the decoded opposite edge plus the source step, and the result kept if the seam error
+// of the block falls.
+func fixTiling(data []byte, rgba *image.RGBA, quality Quality, opts *EncodeOptions) {
+
+	bw, bh := rgba.Rect.Dx()/4, rgba.Rect.Dy()/4
+	if bw < 2 || bh < 2 {
+		return
+	}
+	origin := rgba.Rect.Min
+	src := func(x, y int) [2]int {
+		c := rgba.RGBAAt(origin.X+x, origin.Y+y)
+		return [2]int{int(c.R), int(c.G)}
+	}
+
+	block := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for by := 0; by < bh; by++ {
+		for bx := 0; bx < bw; bx++ {
+			right, bottom := bx == bw-1, by == bh-1
+			if !right && !bottom {
+				continue
+			}
+			i := by*bw + bx
+			loadBlock(block, rgba, origin.X+bx*4, origin.Y+by*4)
+
+			//targets and counts of the seam pixels, indexed by position in the block
+			var target [16][2]int
+			var count [16]int
+			if right {
+				left := decompressBlock(data[by*bw*16:by*bw*16+16], Zero, Swizzle{})
+				for y := 0; y < 4; y++ {
+					d, s0, s1 := left.RGBAAt(0, y), src(0, by*4+y), src(bw*4-1, by*4+y)
+					target[y*4+3][0] += int(d.R) + s1[0] - s0[0]
+					target[y*4+3][1] += int(d.G) + s1[1] - s0[1]
+					count[y*4+3]++
+				}
+			}
+			if bottom {
+				top := decompressBlock(data[bx*16:bx*16+16], Zero, Swizzle{})
+				for x := 0; x < 4; x++ {
+					d, s0, s1 := top.RGBAAt(x, 0), src(bx*4+x, 0), src(bx*4+x, bh*4-1)
+					target[12+x][0] += int(d.R) + s1[0] - s0[0]
+					target[12+x][1] += int(d.G) + s1[1] - s0[1]
+					count[12+x]++
+				}
+			}
+
+			seamError := func(compressed []byte) int {
+				dec := decompressBlock(compressed, Zero, Swizzle{})
+				e := 0
+				for p, n := range count {
+					if n == 0 {
+						continue
+					}
+					c := dec.RGBAAt(p%4, p/4)
+					r, g := int(c.R)-target[p][0]/n, int(c.G)-target[p][1]/n
+					e += r*r + g*g
+				}
+				return e
+			}
+
+			for p, n := range count {
+				if n > 0 {
+					off := block.PixOffset(p%4, p/4)
+					block.Pix[off] = uint8(clampInt(target[p][0]/n, 0, 255))
+					block.Pix[off+1] = uint8(clampInt(target[p][1]/n, 0, 255))
+				}
+			}
+			fixed := encodeBlock(block, i, quality, opts, nil)
+			if seamError(fixed) < seamError(data[i*16:i*16+16]) {
+				copy(data[i*16:i*16+16], fixed)
+			}
+		}
+	}
+}
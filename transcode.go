@@ -0,0 +1,205 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"sync"
+)
+
+// Alias for GPU texture format constants.
+type GPUFormat int
+
+const (
+	FormatBC5     GPUFormat = iota //BC5 / RGTC2 blocks, the data held in a BC5.
+	FormatEACRG11                  //ETC2 EAC RG11 unsigned blocks, 16 bytes per 4x4 block.
+	FormatRG8                      //Two bytes per pixel, red then green.
+	FormatRGBA8                    //Four bytes per pixel.
+)
+
+// order in which formats are preferred, from best to worst
+var formatPreference = []GPUFormat{FormatBC5, FormatEACRG11, FormatRG8, FormatRGBA8}
+
+// ChooseFormat returns the best of the formats the GPU supports for uploading BC5 data, preferring
+// compressed formats. It returns an error if none of them can be produced.
+func ChooseFormat(supported []GPUFormat) (GPUFormat, error) {
+
+	for _, f := range formatPreference {
+		for _, s := range supported {
+			if f == s {
+				return f, nil
+			}
+		}
+	}
+	return 0, errors.New("no supported format available")
+}
+
+// Transcoder returns BC5 data in whichever format the GPU supports, transcoding when needed and
+// caching the results. It is safe for concurrent use.
+type Transcoder struct {
+	mu    sync.Mutex
+	cache map[transcodeKey][]byte
+}
+
+type transcodeKey struct {
+	img    *BC5
+	format GPUFormat
+}
+
+// Transcode returns the data of b in the best supported format, along with that format. For
+// FormatBC5 the block data of b is returned without copying. Transcoded data is cached by b and
+// format until Forget is called, so b must not be modified while it is cached.
+func (t *Transcoder) Transcode(b *BC5, supported []GPUFormat) (GPUFormat, []byte, error) {
+
+	format, err := ChooseFormat(supported)
+	if err != nil {
+		return 0, nil, err
+	}
+	if format == FormatBC5 {
+		return format, b.blockData(), nil
+	}
+
+	key := transcodeKey{b, format}
+	t.mu.Lock()
+	data, ok := t.cache[key]
+	t.mu.Unlock()
+	if ok {
+		return format, data, nil
+	}
+
+	switch format {
+	case FormatEACRG11:
+		data = transcodeEAC(b)
+	case FormatRG8:
+		data = NewUncompressed(b).RG()
+	case FormatRGBA8:
+		data = NewUncompressed(b).Pix()
+	}
+
+	t.mu.Lock()
+	if t.cache == nil {
+		t.cache = make(map[transcodeKey][]byte)
+	}
+	t.cache[key] = data
+	t.mu.Unlock()
+	return format, data, nil
+}
+
+// Forget drops any cached data for b.
+func (t *Transcoder) Forget(b *BC5) {
+
+	t.mu.Lock()
+	for _, f := range formatPreference {
+		delete(t.cache, transcodeKey{b, f})
+	}
+	t.mu.Unlock()
+}
+
+// EAC modifier tables from the ETC2 specification
+var eacModifiers = [16][8]int{
+	{-3, -6, -9, -15, 2, 5, 8, 14},
+	{-3, -7, -10, -13, 2, 6, 9, 12},
+	{-2, -5, -8, -13, 1, 4, 7, 12},
+	{-2, -4, -6, -13, 1, 3, 5, 12},
+	{-3, -6, -8, -12, 2, 5, 7, 11},
+	{-3, -7, -9, -11, 2, 6, 8, 10},
+	{-4, -7, -8, -11, 3, 6, 7, 10},
+	{-3, -5, -8, -11, 2, 4, 7, 10},
+	{-2, -6, -8, -10, 1, 5, 7, 9},
+	{-2, -5, -8, -10, 1, 4, 7, 9},
+	{-2, -4, -8, -10, 1, 3, 7, 9},
+	{-2, -5, -7, -10, 1, 4, 6, 9},
+	{-3, -4, -7, -10, 2, 3, 6, 9},
+	{-1, -2, -3, -10, 0, 1, 2, 9},
+	{-4, -6, -8, -9, 3, 5, 7, 8},
+	{-3, -5, -7, -9, 2, 4, 6, 8},
+}
+
+// returns the decompressed contents of b encoded as EAC RG11 blocks
+func transcodeEAC(b *BC5) []byte {
+
+	size := b.Rect.Size()
+	rgba := b.decompressRect(image.Rect(0, 0, size.X, size.Y))
+	out := make([]byte, 0, len(b.blockData()))
+	for y := 0; y < size.Y; y += 4 {
+		for x := 0; x < size.X; x += 4 {
+			var r, g [16]int
+			for i := 0; i < 16; i++ {
+				//EAC orders pixels down each column
+				c := rgba.RGBAAt(x+i/4, y+i%4)
+				r[i], g[i] = int(c.R)*2047/255, int(c.G)*2047/255
+			}
+			out = append(out, compressEAC(r)...)
+			out = append(out, compressEAC(g)...)
+		}
+	}
+	return out
+}
+
+// returns the 8 byte EAC R11 block best matching the 16 11-bit values, ordered down each column
+func compressEAC(values [16]int) []byte {
+
+	min, max := values[0], values[0]
+	for _, v := range values {
+		if v < min {
+			min = v
+		}
+		if v > max {
+			max = v
+		}
+	}
+
+	var best uint64
+	bestErr := -1
+	for t, mods := range eacModifiers {
+		span := (mods[7] - mods[3]) * 8
+		m := (max - min + span - 1) / span
+		for mul := m - 1; mul <= m+1; mul++ {
+			if mul < 1 || mul > 15 {
+				continue
+			}
+			center := (min+max)/2 - (mods[7]+mods[3])*mul*4
+			for base := center/8 - 1; base <= center/8+1; base++ {
+				if base < 0 || base > 255 {
+					continue
+				}
+
+				bits := uint64(base)<<56 | uint64(mul)<<52 | uint64(t)<<48
+				sum := 0
+				for i, v := range values {
+					bestIx, bestDiff := 0, -1
+					for ix, mod := range mods {
+						d := clampInt(base*8+4+mod*mul*8, 0, 2047) - v
+						if d*d < bestDiff || bestDiff < 0 {
+							bestIx, bestDiff = ix, d*d
+						}
+					}
+					sum += bestDiff
+					bits |= uint64(bestIx) << uint(45-i*3)
+				}
+				if sum < bestErr || bestErr < 0 {
+					best, bestErr = bits, sum
+				}
+			}
+		}
+	}
+
+	block := make([]byte, 8)
+	binary.BigEndian.PutUint64(block, best)
+	return block
+}
+
+// clamps v to [lo,hi]
+func clampInt(v, lo, hi int) int {
+
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
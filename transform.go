@@ -0,0 +1,221 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+)
+
+// InvertGreen flips the green channel of b between the OpenGL and DirectX conventions by rewriting
+// the reference values and indices of each block, without decompressing. Every green value v decodes
+// as 255-v afterwards, up to the rounding of interpolated palette entries. The convention recorded in
+// the metadata is switched if one is present.
+func (b *BC5) InvertGreen() {
+
+	b.eachBlock(func(x, y int, block []byte) {
+		invertChannel(block[8:])
+	})
+
+	switch b.Convention() {
+	case OpenGL:
+		b.SetConvention(DirectX)
+	case DirectX:
+		b.SetConvention(OpenGL)
+	}
+}
+
+// SwapChannels exchanges the red and green channels of b by swapping the halves of each block, without
+// decompressing, so it is lossless. Distance ranges recorded for two signed distance fields are
+// exchanged to match. See DetectSwappedChannels for finding assets that need it.
+func (b *BC5) SwapChannels() {
+
+	b.eachBlock(func(x, y int, block []byte) {
+		swapBlockHalves(block)
+	})
+
+	red, okRed := b.SDFRange(RedChannel)
+	green, okGreen := b.SDFRange(GreenChannel)
+	if okRed && okGreen {
+		b.setMeta(MetaSDFRange, fmt.Sprintf("%g,%g,%g,%g", green.Min, green.Max, red.Min, red.Max))
+	}
+}
+
+// inverts the 8 byte channel half of a block in place. Swapping the inverted reference values keeps
+// the block in the same palette mode, so each index is remapped to the entry holding the inverted value.
+func invertChannel(half []byte) {
+
+	c0, c1 := half[0], half[1]
+	half[0], half[1] = 255-c1, 255-c0
+
+	ix := getIndices(half[2:8])
+	for i := range ix {
+		switch {
+		case ix[i] < 2:
+			if c0 != c1 {
+				ix[i] ^= 1
+			}
+		case c0 > c1:
+			//Eight value palette, entries 2-7 run from c0 towards c1
+			ix[i] = 9 - ix[i]
+		case ix[i] < 6:
+			//Six value palette, entries 2-5 run from c0 towards c1
+			ix[i] = 7 - ix[i]
+		default:
+			//Six value palette, entries 6 and 7 are 0 and 1
+			ix[i] ^= 1
+		}
+	}
+	putIndices(ix, half[2:8])
+}
+
+// Transpose swaps the X and Y axes of b by reordering its blocks and permuting the indices within
+// each block, without decompressing. The pixel at (x,y) is found at (y,x) afterwards.
+func (b *BC5) Transpose() {
+
+	h := b.Rect.Size().Y / 4
+	data := make([]byte, len(b.blockData()))
+	b.eachBlock(func(x, y int, block []byte) {
+		pos := ((x/4)*h + y/4) * 16
+		copy(data[pos:pos+16], block)
+		transposeIndices(data[pos+2 : pos+8])
+		transposeIndices(data[pos+10 : pos+16])
+	})
+
+	b.Data = data
+	b.Rect = image.Rect(b.Rect.Min.Y, b.Rect.Min.X, b.Rect.Max.Y, b.Rect.Max.X)
+	b.stride = 0
+}
+
+// transposes the 4x4 grid of indices packed in b
+func transposeIndices(b []byte) {
+
+	ix := getIndices(b)
+	t := [16]int{}
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			t[y*4+x] = ix[x*4+y]
+		}
+	}
+	putIndices(t, b)
+}
+
+// RemapRange linearly maps the red or green channel of b so that oldMin becomes newMin and oldMax
+// becomes newMax, by rewriting only the reference values of each block, leaving the structure of the
+// indices intact. Results are clamped to 0-255. Blocks using the six value palette keep their fixed
+// 0 and 255 entries, which are not remapped.
+func (b *BC5) RemapRange(channel Channel, oldMin, oldMax, newMin, newMax byte) error {
+
+	pos, err := channelOffset(channel)
+	if err != nil {
+		return err
+	}
+	if oldMin == oldMax {
+		return errors.New("old range must not be empty")
+	}
+
+	scale := (float64(newMax) - float64(newMin)) / (float64(oldMax) - float64(oldMin))
+	remap := func(v byte) byte {
+		return byte(clampInt(int(math.Floor((float64(v)-float64(oldMin))*scale+float64(newMin)+0.5)), 0, 255))
+	}
+	b.eachBlock(func(x, y int, block []byte) {
+		remapChannel(block[pos:pos+8], remap)
+	})
+	return nil
+}
+
+// rewrites the reference values of the 8 byte channel half of a block with remap, swapping them and
+// remapping the indices where needed to keep the palette mode
+func remapChannel(half []byte, remap func(byte) byte) {
+
+	c0, c1 := half[0], half[1]
+	n0, n1 := remap(c0), remap(c1)
+	half[0], half[1] = n0, n1
+	if c0 == c1 || (c0 > c1) == (n0 > n1) && (c0 < c1) == (n0 < n1) {
+		return
+	}
+
+	ix := getIndices(half[2:8])
+	switch {
+	case n0 == n1:
+		//Every interpolated entry collapsed to the same value
+		if c0 > c1 {
+			ix = [16]int{}
+		}
+	case c0 > c1:
+		//Eight value palette reversed, swap to keep it
+		half[0], half[1] = n1, n0
+		for i := range ix {
+			if ix[i] < 2 {
+				ix[i] ^= 1
+			} else {
+				ix[i] = 9 - ix[i]
+			}
+		}
+	default:
+		//Six value palette reversed, swap to keep it
+		half[0], half[1] = n1, n0
+		for i := range ix {
+			if ix[i] < 2 {
+				ix[i] ^= 1
+			} else if ix[i] < 6 {
+				ix[i] = 7 - ix[i]
+			}
+		}
+	}
+	putIndices(ix, half[2:8])
+}
+
+// returns the offset of the half of a block holding channel, which must be RedChannel or GreenChannel
+func channelOffset(channel Channel) (int, error) {
+
+	switch channel {
+	case RedChannel:
+		return 0, nil
+	case GreenChannel:
+		return 8, nil
+	default:
+		return 0, errors.New("channel must be red or green")
+	}
+}
+
+// Fill sets every pixel of the red or green channel of b to value by writing constant blocks, without
+// decompressing.
+func (b *BC5) Fill(channel Channel, value byte) error {
+
+	pos, err := channelOffset(channel)
+	if err != nil {
+		return err
+	}
+	b.eachBlock(func(x, y int, block []byte) {
+		fillChannel(block[pos:pos+8], value)
+	})
+	return nil
+}
+
+// ClearRect sets the red and green channels of every pixel of b within r to value by writing constant
+// blocks, without decompressing. The rectangle must lie within b and be aligned to 4x4 blocks.
+func (b *BC5) ClearRect(r image.Rectangle, value byte) error {
+
+	view, err := b.SubImage(r)
+	if err != nil {
+		return err
+	}
+	view.eachBlock(func(x, y int, block []byte) {
+		fillChannel(block[:8], value)
+		fillChannel(block[8:], value)
+	})
+	return nil
+}
+
+// makes the 8 byte channel half of a block decode to value everywhere
+func fillChannel(half []byte, value byte) {
+
+	half[0], half[1] = value, value
+	for i := 2; i < 8; i++ {
+		half[i] = 0
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"image"
+	"sync"
+)
+
+// Uncompressed adapts a BC5 for game libraries that can only upload uncompressed pixels, such as
+// Ebitengine (ebiten.NewImageFromImage), raylib-go (rl.NewImageFromImage) and g3n
+// (texture.NewTexture2DFromRGBA). The decompressed image is created on first use and cached until
+// Invalidate is called. It is safe for concurrent use.
+type Uncompressed struct {
+	src  *BC5
+	mu   sync.Mutex
+	rgba *image.RGBA
+	rg   []byte
+}
+
+// NewUncompressed returns an adapter decompressing b on demand.
+func NewUncompressed(b *BC5) *Uncompressed {
+
+	return &Uncompressed{src: b}
+}
+
+// Image returns the decompressed image. It must not be modified.
+func (u *Uncompressed) Image() *image.RGBA {
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.image()
+}
+
+// Pix returns the decompressed pixels as tightly packed RGBA8 rows, the layout expected by raw
+// texture uploads. It must not be modified.
+func (u *Uncompressed) Pix() []byte {
+
+	return u.Image().Pix
+}
+
+// RG returns the decompressed red and green values as tightly packed RG8 rows, for uploading as a
+// two channel texture. It must not be modified.
+func (u *Uncompressed) RG() []byte {
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.rg == nil {
+		pix := u.image().Pix
+		u.rg = make([]byte, len(pix)/2)
+		for i := 0; i < len(pix)/4; i++ {
+			u.rg[i*2], u.rg[i*2+1] = pix[i*4], pix[i*4+1]
+		}
+	}
+	return u.rg
+}
+
+// Invalidate drops the cached pixels, so they are decompressed again on next use. It must be
+// called after the BC5 is modified.
+func (u *Uncompressed) Invalidate() {
+
+	u.mu.Lock()
+	u.rgba, u.rg = nil, nil
+	u.mu.Unlock()
+}
+
+// returns the cached image, decompressing it if needed. u.mu must be held.
+func (u *Uncompressed) image() *image.RGBA {
+
+	if u.rgba == nil {
+		u.rgba = u.src.decompressRect(image.Rectangle{Max: u.src.ContentSize()})
+	}
+	return u.rgba
+}
@@ -0,0 +1,89 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+)
+
+// Alias for decode warning kind constants.
+type WarningKind int
+
+const (
+	WarnOldContainer     WarningKind = iota //The data uses the original "BC5 " container, which cannot hold metadata.
+	WarnV1Indices                           //The indices were detected with DetectV1 as written by the first release and were repaired.
+	WarnBigEndianIndices                    //The indices were detected with DetectV1 as stored in BigEndianOrder and were repaired.
+)
+
+// String returns a short name for k.
+func (k WarningKind) String() string {
+
+	switch k {
+	case WarnOldContainer:
+		return "old-container"
+	case WarnV1Indices:
+		return "v1-indices"
+	case WarnBigEndianIndices:
+		return "big-endian-indices"
+	default:
+		return "unknown"
+	}
+}
+
+// DecodeWarning describes a deprecated feature found by DecodeWithWarnings.
+type DecodeWarning struct {
+	Kind    WarningKind
+	Message string //What was found and how to upgrade the data so the warning goes away.
+}
+
+// String returns the kind and message of w.
+func (w DecodeWarning) String() string {
+
+	return w.Kind.String() + ": " + w.Message
+}
+
+// DecodeWithWarnings reads a BC5 like Decode, upgrading data in deprecated formats as it is loaded and
+// passing a DecodeWarning to warn for each one found, so stale assets can be tracked down without
+// failing to load them. The data is checked with DetectV1 and repaired with MigrateIndices if its
+// indices appear to be stored in BigEndianOrder, or in V1Order for data in the original "BC5 "
+// container, as only that container could hold data from the first release. Warn may be nil, and CollectWarnings returns one that gathers the
+// warnings into a slice. Rewriting the data with Encode, or MigrateFiles, upgrades it for good.
+func DecodeWithWarnings(r io.Reader, warn func(DecodeWarning)) (*BC5, error) {
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	img, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if warn == nil {
+		warn = func(DecodeWarning) {}
+	}
+
+	oldContainer := string(data[:4]) == "BC5 "
+	if oldContainer {
+		warn(DecodeWarning{Kind: WarnOldContainer, Message: "the \"BC5 \" container is deprecated, re-encode with metadata or use MigrateFiles to store it as \"BC52\""})
+	}
+	switch order := img.DetectV1().Order; {
+	case order == V1Order && oldContainer:
+		img.MigrateIndices(order)
+		warn(DecodeWarning{Kind: WarnV1Indices, Message: "indices were written by the first release and have been repaired, use MigrateFiles with RepairV1 to store the repair"})
+	case order == BigEndianOrder:
+		img.MigrateIndices(order)
+		warn(DecodeWarning{Kind: WarnBigEndianIndices, Message: "indices were stored big endian by an earlier release and have been repaired, use MigrateFiles with RepairV1 to store the repair"})
+	}
+	return img, nil
+}
+
+// CollectWarnings returns a function for DecodeWithWarnings that appends each warning to list.
+func CollectWarnings(list *[]DecodeWarning) func(DecodeWarning) {
+
+	return func(w DecodeWarning) {
+		*list = append(*list, w)
+	}
+}
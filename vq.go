@@ -0,0 +1,157 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import (
+	"errors"
+	"math"
+)
+
+// number of refinement passes made by Quantize
+const quantizeIterations = 8
+
+// Quantize replaces every block of b with the closest of at most size shared blocks and sets the
+// Codebook layout, so the encoded file holds each shared block once plus a small index per block.
+// This trades quality for a large reduction in size and suits stylized or low frequency content. The
+// shared blocks are found by k-means clustering of the decoded red and green values of the blocks,
+// which takes time proportional to the number of blocks multiplied by size. seed chooses the starting
+// clusters, the same seed always giving the same result. Decoding yields standard BC5 blocks, so
+// nothing else needs to know the image was quantized.
+func (b *BC5) Quantize(size int, seed int64) error {
+
+	if size <= 0 {
+		return errors.New("codebook size must be positive")
+	}
+
+	var blocks [][]byte
+	b.eachBlock(func(x, y int, block []byte) {
+		blocks = append(blocks, block)
+	})
+	vectors := make([][32]float64, len(blocks))
+	for i, block := range blocks {
+		vectors[i] = blockVector(block)
+	}
+	if size > len(vectors) {
+		size = len(vectors)
+	}
+
+	centroids := seedCentroids(vectors, size, newSplitMix(seed, 0))
+	assign := make([]int, len(vectors))
+	for iter := 0; iter < quantizeIterations; iter++ {
+		for i, v := range vectors {
+			assign[i] = nearestVector(v, centroids)
+		}
+		sums := make([][32]float64, size)
+		counts := make([]int, size)
+		for i, v := range vectors {
+			c := assign[i]
+			counts[c]++
+			for j := range v {
+				sums[c][j] += v[j]
+			}
+		}
+		for c := range centroids {
+			if counts[c] == 0 {
+				continue
+			}
+			for j := range sums[c] {
+				centroids[c][j] = sums[c][j] / float64(counts[c])
+			}
+		}
+	}
+
+	//Compress the centroids and match each block against what they actually decode to
+	book := make([][]byte, size)
+	decoded := make([][32]float64, size)
+	for c, centroid := range centroids {
+		var r, g [16]byte
+		for p := 0; p < 16; p++ {
+			r[p] = byte(clampInt(int(math.Floor(centroid[p]+0.5)), 0, 255))
+			g[p] = byte(clampInt(int(math.Floor(centroid[16+p]+0.5)), 0, 255))
+		}
+		book[c] = make([]byte, 16)
+		compressChannel(r, book[c][:8], QualityHigh)
+		compressChannel(g, book[c][8:], QualityHigh)
+		decoded[c] = blockVector(book[c])
+	}
+	for i, v := range vectors {
+		copy(blocks[i], book[nearestVector(v, decoded)])
+	}
+
+	b.SetLayout(Codebook)
+	return nil
+}
+
+// picks size starting centroids from vectors by k-means++, each chosen with a probability proportional
+// to its squared distance from the closest centroid already chosen
+func seedCentroids(vectors [][32]float64, size int, rng *splitMix) [][32]float64 {
+
+	centroids := [][32]float64{vectors[rng.intn(len(vectors))]}
+	dist := make([]float64, len(vectors))
+	for i := range dist {
+		dist[i] = math.Inf(1)
+	}
+	for len(centroids) < size {
+		last := centroids[len(centroids)-1]
+		total := 0.0
+		for i, v := range vectors {
+			var d float64
+			for j := range v {
+				x := v[j] - last[j]
+				d += x * x
+			}
+			if d < dist[i] {
+				dist[i] = d
+			}
+			total += dist[i]
+		}
+		if total == 0 {
+			//Fewer distinct blocks than centroids
+			break
+		}
+
+		target := rng.float() * total
+		pick := len(vectors) - 1
+		for i := range dist {
+			if target -= dist[i]; target < 0 {
+				pick = i
+				break
+			}
+		}
+		centroids = append(centroids, vectors[pick])
+	}
+	return centroids
+}
+
+// returns the decoded red values of block followed by its green values
+func blockVector(block []byte) [32]float64 {
+
+	var v [32]float64
+	for ch := 0; ch < 2; ch++ {
+		half := block[ch*8 : ch*8+8]
+		pal := generatePalette(normalize(half[0]), normalize(half[1]))
+		ix := getIndices(half[2:8])
+		for p := 0; p < 16; p++ {
+			v[ch*16+p] = pal[ix[p]] * 255
+		}
+	}
+	return v
+}
+
+// returns the index of the vector in set closest to v
+func nearestVector(v [32]float64, set [][32]float64) int {
+
+	best, bestErr := 0, math.Inf(1)
+	for i := range set {
+		var e float64
+		for j := range v {
+			d := v[j] - set[i][j]
+			e += d * d
+		}
+		if e < bestErr {
+			best, bestErr = i, e
+		}
+	}
+	return best
+}
@@ -0,0 +1,122 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import "errors"
+
+// Vulkan values for BC5 data.
+const (
+	VulkanFormat           = 141 //VK_FORMAT_BC5_UNORM_BLOCK
+	VulkanAspectColor      = 1   //VK_IMAGE_ASPECT_COLOR_BIT
+	vulkanBlockSize        = 16  //Bytes per block, which buffer offsets must be a multiple of.
+	vulkanDefaultAlignment = vulkanBlockSize
+)
+
+// VulkanUpload holds the values needed to create a Vulkan image for a BC5 mip chain or array of them
+// and copy it from a staging buffer with vkCmdCopyBufferToImage. The fields mirror the Vulkan structs
+// without depending on any binding, so they can be copied into whichever one is in use.
+type VulkanUpload struct {
+	Format           int                     //Image format, always VulkanFormat.
+	Width, Height    uint32                  //Size of the base level in texels.
+	MipLevels        uint32                  //Number of mip levels.
+	ArrayLayers      uint32                  //Number of array layers.
+	SubresourceRange VulkanSubresourceRange  //Range covering every level and layer, for image views and barriers.
+	Regions          []VulkanBufferImageCopy //One copy region per level, covering every layer.
+	Data             []byte                  //Staging buffer contents, len(Data) bytes are needed.
+}
+
+// VulkanBufferImageCopy holds the values of a VkBufferImageCopy.
+type VulkanBufferImageCopy struct {
+	BufferOffset      uint64
+	BufferRowLength   uint32 //Zero, as rows are tightly packed.
+	BufferImageHeight uint32 //Zero, as layers are tightly packed.
+	ImageSubresource  VulkanSubresourceLayers
+	ImageOffset       [3]int32
+	ImageExtent       [3]uint32
+}
+
+// VulkanSubresourceLayers holds the values of a VkImageSubresourceLayers.
+type VulkanSubresourceLayers struct {
+	AspectMask     uint32
+	MipLevel       uint32
+	BaseArrayLayer uint32
+	LayerCount     uint32
+}
+
+// VulkanSubresourceRange holds the values of a VkImageSubresourceRange.
+type VulkanSubresourceRange struct {
+	AspectMask     uint32
+	BaseMipLevel   uint32
+	LevelCount     uint32
+	BaseArrayLayer uint32
+	LayerCount     uint32
+}
+
+// NewVulkanUpload lays out layers, each a mip chain ordered from the base level down, for copying from
+// a staging buffer into a Vulkan array image, or a plain 2D image given a single layer. Every layer
+// must have the same sizes and each level must be half the size of the last, as Vulkan requires. The
+// layers of a level are stored one after another so a single region copies them all, and each level
+// starts at a multiple of offsetAlignment, which should be the device's
+// optimalBufferCopyOffsetAlignment, or zero for the 16 bytes Vulkan requires for BC5.
+func NewVulkanUpload(offsetAlignment int, layers ...[]*BC5) (*VulkanUpload, error) {
+
+	if len(layers) == 0 || len(layers[0]) == 0 {
+		return nil, errors.New("no levels given")
+	}
+	if offsetAlignment < 0 {
+		return nil, errors.New("alignment must not be negative")
+	}
+	align := vulkanDefaultAlignment
+	if offsetAlignment > 0 {
+		//Offsets must be a multiple of both the block size and the requested alignment
+		align = offsetAlignment
+		for align%vulkanBlockSize != 0 {
+			align += offsetAlignment
+		}
+	}
+
+	base := layers[0][0].Rect.Size()
+	levels := len(layers[0])
+	up := &VulkanUpload{
+		Format:      VulkanFormat,
+		Width:       uint32(base.X),
+		Height:      uint32(base.Y),
+		MipLevels:   uint32(levels),
+		ArrayLayers: uint32(len(layers)),
+		SubresourceRange: VulkanSubresourceRange{
+			AspectMask: VulkanAspectColor,
+			LevelCount: uint32(levels),
+			LayerCount: uint32(len(layers)),
+		},
+	}
+	for _, chain := range layers {
+		if len(chain) != levels {
+			return nil, errors.New("every layer must have the same number of levels")
+		}
+	}
+
+	for i := 0; i < levels; i++ {
+		w, h := base.X>>uint(i), base.Y>>uint(i)
+		offset := alignUp(len(up.Data), align)
+		data := make([]byte, offset, offset+len(layers)*w*h)
+		copy(data, up.Data)
+		for _, chain := range layers {
+			if size := chain[i].Rect.Size(); size.X != w || size.Y != h {
+				return nil, errors.New("each level must be half the size of the last in every layer")
+			}
+			data = append(data, chain[i].blockData()...)
+		}
+		up.Data = data
+		up.Regions = append(up.Regions, VulkanBufferImageCopy{
+			BufferOffset: uint64(offset),
+			ImageSubresource: VulkanSubresourceLayers{
+				AspectMask: VulkanAspectColor,
+				MipLevel:   uint32(i),
+				LayerCount: uint32(len(layers)),
+			},
+			ImageExtent: [3]uint32{uint32(w), uint32(h), 1},
+		})
+	}
+	return up, nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2019 Adam Leyland
+// Use of this source code is governed by a BSD-2 style license that can be found in the LICENSE file.
+
+package bc5
+
+import "errors"
+
+// WebGPU texture format name for BC5 data.
+const WebGPUFormat = "bc5-rg-unorm"
+
+// Alignment WebGPU requires for bytesPerRow in buffer to texture copies.
+const webGPURowAlignment = 256
+
+// WebGPUUpload holds the descriptor values and staging buffer for uploading a BC5 mip chain with
+// WebGPU (wgpu, Dawn or the browser API).
+type WebGPUUpload struct {
+	Format        string        //Texture format, always WebGPUFormat.
+	Width, Height int           //Size of the base level in texels.
+	MipLevelCount int           //Number of mip levels.
+	Levels        []WebGPULevel //Copy layout of each level.
+	Data          []byte        //Staging buffer holding every level at its offset with padded rows.
+}
+
+// WebGPULevel holds the GPUImageCopyBuffer layout values for a single mip level.
+type WebGPULevel struct {
+	Width, Height int //Size of the level in texels, the copy size.
+	Offset        int //Byte offset of the level in the staging buffer.
+	BytesPerRow   int //Bytes between block rows, a multiple of 256.
+	RowsPerImage  int //Number of block rows.
+}
+
+// NewWebGPUUpload lays out levels, ordered from the base level down, for copying from a buffer into
+// a WebGPU texture. Each block row is padded to the 256 byte alignment WebGPU requires for
+// bytesPerRow, and each level starts at a 256 byte aligned offset.
+func NewWebGPUUpload(levels ...*BC5) (*WebGPUUpload, error) {
+
+	if len(levels) == 0 {
+		return nil, errors.New("no levels given")
+	}
+
+	up := &WebGPUUpload{
+		Format:        WebGPUFormat,
+		Width:         levels[0].Rect.Size().X,
+		Height:        levels[0].Rect.Size().Y,
+		MipLevelCount: len(levels),
+	}
+	for i, l := range levels {
+		size := l.Rect.Size()
+		if i > 0 && (size.X > levels[i-1].Rect.Size().X || size.Y > levels[i-1].Rect.Size().Y) {
+			return nil, errors.New("levels must be ordered from largest to smallest")
+		}
+
+		rowBytes := (size.X / 4) * 16
+		level := WebGPULevel{
+			Width:        size.X,
+			Height:       size.Y,
+			Offset:       alignUp(len(up.Data), webGPURowAlignment),
+			BytesPerRow:  alignUp(rowBytes, webGPURowAlignment),
+			RowsPerImage: size.Y / 4,
+		}
+
+		data := l.blockData()
+		buf := make([]byte, level.Offset+level.BytesPerRow*level.RowsPerImage)
+		copy(buf, up.Data)
+		for row := 0; row < level.RowsPerImage; row++ {
+			copy(buf[level.Offset+row*level.BytesPerRow:], data[row*rowBytes:(row+1)*rowBytes])
+		}
+		up.Data = buf
+		up.Levels = append(up.Levels, level)
+	}
+	return up, nil
+}
+
+// rounds v up to a multiple of n
+func alignUp(v, n int) int {
+
+	return (v + n - 1) / n * n
+}